@@ -0,0 +1,417 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.2.0
+// - protoc             (unknown)
+// source: agentpb/agent.proto
+
+package agentpb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.32.0 or later.
+const _ = grpc.SupportPackageIsVersion7
+
+// AgentClient is the client API for Agent service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type AgentClient interface {
+	// GetContainer returns a running container by its name.
+	GetContainer(ctx context.Context, in *GetContainerRequest, opts ...grpc.CallOption) (*Container, error)
+	// ListContainers returns both running and stopped containers.
+	ListContainers(ctx context.Context, in *ListContainersRequest, opts ...grpc.CallOption) (*ListContainersResponse, error)
+	// UpdateContainer replaces a container with a new one running another image.
+	UpdateContainer(ctx context.Context, in *UpdateContainerRequest, opts ...grpc.CallOption) (*ContainerChange, error)
+	// RollbackContainer replaces a container with its rollback container.
+	RollbackContainer(ctx context.Context, in *RollbackContainerRequest, opts ...grpc.CallOption) (*ContainerChange, error)
+	// PullImage pulls an image, streaming the progress reported by the docker daemon.
+	PullImage(ctx context.Context, in *PullImageRequest, opts ...grpc.CallOption) (Agent_PullImageClient, error)
+	// ContainerLogs streams the stdout and stderr of a container.
+	ContainerLogs(ctx context.Context, in *ContainerLogsRequest, opts ...grpc.CallOption) (Agent_ContainerLogsClient, error)
+	// Events streams container and image events until the client cancels the call.
+	Events(ctx context.Context, in *EventsRequest, opts ...grpc.CallOption) (Agent_EventsClient, error)
+}
+
+type agentClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewAgentClient(cc grpc.ClientConnInterface) AgentClient {
+	return &agentClient{cc}
+}
+
+func (c *agentClient) GetContainer(ctx context.Context, in *GetContainerRequest, opts ...grpc.CallOption) (*Container, error) {
+	out := new(Container)
+	err := c.cc.Invoke(ctx, "/dokkup.agent.v1.Agent/GetContainer", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *agentClient) ListContainers(ctx context.Context, in *ListContainersRequest, opts ...grpc.CallOption) (*ListContainersResponse, error) {
+	out := new(ListContainersResponse)
+	err := c.cc.Invoke(ctx, "/dokkup.agent.v1.Agent/ListContainers", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *agentClient) UpdateContainer(ctx context.Context, in *UpdateContainerRequest, opts ...grpc.CallOption) (*ContainerChange, error) {
+	out := new(ContainerChange)
+	err := c.cc.Invoke(ctx, "/dokkup.agent.v1.Agent/UpdateContainer", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *agentClient) RollbackContainer(ctx context.Context, in *RollbackContainerRequest, opts ...grpc.CallOption) (*ContainerChange, error) {
+	out := new(ContainerChange)
+	err := c.cc.Invoke(ctx, "/dokkup.agent.v1.Agent/RollbackContainer", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *agentClient) PullImage(ctx context.Context, in *PullImageRequest, opts ...grpc.CallOption) (Agent_PullImageClient, error) {
+	stream, err := c.cc.NewStream(ctx, &Agent_ServiceDesc.Streams[0], "/dokkup.agent.v1.Agent/PullImage", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &agentPullImageClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type Agent_PullImageClient interface {
+	Recv() (*PullProgress, error)
+	grpc.ClientStream
+}
+
+type agentPullImageClient struct {
+	grpc.ClientStream
+}
+
+func (x *agentPullImageClient) Recv() (*PullProgress, error) {
+	m := new(PullProgress)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *agentClient) ContainerLogs(ctx context.Context, in *ContainerLogsRequest, opts ...grpc.CallOption) (Agent_ContainerLogsClient, error) {
+	stream, err := c.cc.NewStream(ctx, &Agent_ServiceDesc.Streams[1], "/dokkup.agent.v1.Agent/ContainerLogs", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &agentContainerLogsClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type Agent_ContainerLogsClient interface {
+	Recv() (*LogChunk, error)
+	grpc.ClientStream
+}
+
+type agentContainerLogsClient struct {
+	grpc.ClientStream
+}
+
+func (x *agentContainerLogsClient) Recv() (*LogChunk, error) {
+	m := new(LogChunk)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *agentClient) Events(ctx context.Context, in *EventsRequest, opts ...grpc.CallOption) (Agent_EventsClient, error) {
+	stream, err := c.cc.NewStream(ctx, &Agent_ServiceDesc.Streams[2], "/dokkup.agent.v1.Agent/Events", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &agentEventsClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type Agent_EventsClient interface {
+	Recv() (*Event, error)
+	grpc.ClientStream
+}
+
+type agentEventsClient struct {
+	grpc.ClientStream
+}
+
+func (x *agentEventsClient) Recv() (*Event, error) {
+	m := new(Event)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// AgentServer is the server API for Agent service.
+// All implementations must embed UnimplementedAgentServer
+// for forward compatibility
+type AgentServer interface {
+	// GetContainer returns a running container by its name.
+	GetContainer(context.Context, *GetContainerRequest) (*Container, error)
+	// ListContainers returns both running and stopped containers.
+	ListContainers(context.Context, *ListContainersRequest) (*ListContainersResponse, error)
+	// UpdateContainer replaces a container with a new one running another image.
+	UpdateContainer(context.Context, *UpdateContainerRequest) (*ContainerChange, error)
+	// RollbackContainer replaces a container with its rollback container.
+	RollbackContainer(context.Context, *RollbackContainerRequest) (*ContainerChange, error)
+	// PullImage pulls an image, streaming the progress reported by the docker daemon.
+	PullImage(*PullImageRequest, Agent_PullImageServer) error
+	// ContainerLogs streams the stdout and stderr of a container.
+	ContainerLogs(*ContainerLogsRequest, Agent_ContainerLogsServer) error
+	// Events streams container and image events until the client cancels the call.
+	Events(*EventsRequest, Agent_EventsServer) error
+	mustEmbedUnimplementedAgentServer()
+}
+
+// UnimplementedAgentServer must be embedded to have forward compatible implementations.
+type UnimplementedAgentServer struct {
+}
+
+func (UnimplementedAgentServer) GetContainer(context.Context, *GetContainerRequest) (*Container, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetContainer not implemented")
+}
+func (UnimplementedAgentServer) ListContainers(context.Context, *ListContainersRequest) (*ListContainersResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListContainers not implemented")
+}
+func (UnimplementedAgentServer) UpdateContainer(context.Context, *UpdateContainerRequest) (*ContainerChange, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method UpdateContainer not implemented")
+}
+func (UnimplementedAgentServer) RollbackContainer(context.Context, *RollbackContainerRequest) (*ContainerChange, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method RollbackContainer not implemented")
+}
+func (UnimplementedAgentServer) PullImage(*PullImageRequest, Agent_PullImageServer) error {
+	return status.Errorf(codes.Unimplemented, "method PullImage not implemented")
+}
+func (UnimplementedAgentServer) ContainerLogs(*ContainerLogsRequest, Agent_ContainerLogsServer) error {
+	return status.Errorf(codes.Unimplemented, "method ContainerLogs not implemented")
+}
+func (UnimplementedAgentServer) Events(*EventsRequest, Agent_EventsServer) error {
+	return status.Errorf(codes.Unimplemented, "method Events not implemented")
+}
+func (UnimplementedAgentServer) mustEmbedUnimplementedAgentServer() {}
+
+// UnsafeAgentServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to AgentServer will
+// result in compilation errors.
+type UnsafeAgentServer interface {
+	mustEmbedUnimplementedAgentServer()
+}
+
+func RegisterAgentServer(s grpc.ServiceRegistrar, srv AgentServer) {
+	s.RegisterService(&Agent_ServiceDesc, srv)
+}
+
+func _Agent_GetContainer_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetContainerRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AgentServer).GetContainer(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/dokkup.agent.v1.Agent/GetContainer",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AgentServer).GetContainer(ctx, req.(*GetContainerRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Agent_ListContainers_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListContainersRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AgentServer).ListContainers(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/dokkup.agent.v1.Agent/ListContainers",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AgentServer).ListContainers(ctx, req.(*ListContainersRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Agent_UpdateContainer_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(UpdateContainerRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AgentServer).UpdateContainer(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/dokkup.agent.v1.Agent/UpdateContainer",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AgentServer).UpdateContainer(ctx, req.(*UpdateContainerRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Agent_RollbackContainer_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RollbackContainerRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AgentServer).RollbackContainer(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/dokkup.agent.v1.Agent/RollbackContainer",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AgentServer).RollbackContainer(ctx, req.(*RollbackContainerRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Agent_PullImage_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(PullImageRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(AgentServer).PullImage(m, &agentPullImageServer{stream})
+}
+
+type Agent_PullImageServer interface {
+	Send(*PullProgress) error
+	grpc.ServerStream
+}
+
+type agentPullImageServer struct {
+	grpc.ServerStream
+}
+
+func (x *agentPullImageServer) Send(m *PullProgress) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _Agent_ContainerLogs_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(ContainerLogsRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(AgentServer).ContainerLogs(m, &agentContainerLogsServer{stream})
+}
+
+type Agent_ContainerLogsServer interface {
+	Send(*LogChunk) error
+	grpc.ServerStream
+}
+
+type agentContainerLogsServer struct {
+	grpc.ServerStream
+}
+
+func (x *agentContainerLogsServer) Send(m *LogChunk) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _Agent_Events_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(EventsRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(AgentServer).Events(m, &agentEventsServer{stream})
+}
+
+type Agent_EventsServer interface {
+	Send(*Event) error
+	grpc.ServerStream
+}
+
+type agentEventsServer struct {
+	grpc.ServerStream
+}
+
+func (x *agentEventsServer) Send(m *Event) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+// Agent_ServiceDesc is the grpc.ServiceDesc for Agent service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var Agent_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "dokkup.agent.v1.Agent",
+	HandlerType: (*AgentServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "GetContainer",
+			Handler:    _Agent_GetContainer_Handler,
+		},
+		{
+			MethodName: "ListContainers",
+			Handler:    _Agent_ListContainers_Handler,
+		},
+		{
+			MethodName: "UpdateContainer",
+			Handler:    _Agent_UpdateContainer_Handler,
+		},
+		{
+			MethodName: "RollbackContainer",
+			Handler:    _Agent_RollbackContainer_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "PullImage",
+			Handler:       _Agent_PullImage_Handler,
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "ContainerLogs",
+			Handler:       _Agent_ContainerLogs_Handler,
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "Events",
+			Handler:       _Agent_Events_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "agentpb/agent.proto",
+}
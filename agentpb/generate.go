@@ -0,0 +1,4 @@
+// Package agentpb contains the gRPC API of the agent, generated from agent.proto.
+package agentpb
+
+//go:generate protoc -I .. --go_out=.. --go_opt=paths=source_relative --go-grpc_out=.. --go-grpc_opt=paths=source_relative agentpb/agent.proto
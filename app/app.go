@@ -1,15 +1,42 @@
 package app
 
 import (
+	"github.com/XiovV/dokkup-agent/audit"
+	"github.com/XiovV/dokkup-agent/auth"
 	"github.com/XiovV/dokkup-agent/config"
 	"github.com/XiovV/dokkup-agent/controller"
+	"github.com/sirupsen/logrus"
+	"sync"
+	"sync/atomic"
 )
 
 type App struct {
 	controller controller.ContainerController
-	config     *config.Config
+	auditLog   *audit.Log
+	operations *operations
+	bootstrap  bootstrap
+	reconciler *controller.Reconciler
+	log        logrus.FieldLogger
+
+	// current holds the *settings requests are handled with, which are swapped by Reload.
+	current  atomic.Value
+	reloadMu sync.Mutex
 }
 
-func New(controller controller.ContainerController, config *config.Config) *App {
-	return &App{controller: controller, config: config}
+// New returns a pointer to App. jwtVerifier may be nil, in which case
+// only static API keys will be accepted, and auditLog may be nil to disable auditing.
+func New(controller controller.ContainerController, cfg *config.Config, jwtVerifier *auth.JWTVerifier, auditLog *audit.Log, logger logrus.FieldLogger) *App {
+	app := &App{
+		controller: controller,
+		auditLog:   auditLog,
+		operations: newOperations(),
+		log:        logger,
+	}
+
+	// the networks have already been validated when the config was loaded
+	s, _ := newSettings(cfg, jwtVerifier, nil)
+	app.current.Store(s)
+	controller.SetRegistryCredentials(s.registries)
+
+	return app
 }
@@ -0,0 +1,134 @@
+package app
+
+import (
+	"github.com/XiovV/dokkup-agent/audit"
+	"github.com/XiovV/dokkup-agent/controller"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	auditActionCreate    = "create"
+	auditActionUpdate    = "update"
+	auditActionRecreate  = "recreate"
+	auditActionRollback  = "rollback"
+	auditActionPull      = "pull"
+	auditActionBootstrap = "bootstrap"
+)
+
+const (
+	containerChangeContextKey = "container_change"
+	auditContainerContextKey  = "audit_container"
+	auditImageContextKey      = "audit_image"
+)
+
+const defaultAuditQueryLimit = 100
+
+// Audit records the outcome of a mutating call to the audit log once the handler has finished.
+// Handlers pass details to it by setting the container and image under auditContainerContextKey and
+// auditImageContextKey, a controller.ContainerChange under containerChangeContextKey, and by attaching
+// errors through c.Error. Dry runs don't change anything, so they aren't recorded.
+func (app *App) Audit(action string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if app.auditLog == nil {
+			c.Next()
+			return
+		}
+
+		start := time.Now()
+
+		c.Next()
+
+		if c.GetBool(dryRunContextKey) {
+			return
+		}
+
+		principal, _ := principalFromContext(c)
+
+		entry := audit.Entry{
+			Time:       start,
+			Action:     action,
+			KeyName:    principal.Name,
+			AuthMethod: principal.Method,
+			ClientIP:   clientIP(c),
+			Container:  c.GetString(auditContainerContextKey),
+			Image:      c.GetString(auditImageContextKey),
+			Outcome:    audit.OutcomeSuccess,
+			Status:     c.Writer.Status(),
+			DurationMs: time.Since(start).Milliseconds(),
+		}
+
+		if value, ok := c.Get(containerChangeContextKey); ok {
+			change := value.(controller.ContainerChange)
+
+			entry.OldImage = change.OldImage
+			entry.OldDigest = change.OldImageID
+			entry.NewImage = change.NewImage
+			entry.NewDigest = change.NewImageID
+		}
+
+		if err := c.Errors.Last(); err != nil {
+			entry.Error = err.Error()
+		}
+
+		if entry.Status >= http.StatusBadRequest {
+			entry.Outcome = audit.OutcomeFailure
+		}
+
+		if err := app.auditLog.Record(entry); err != nil {
+			app.requestLogger(c).WithError(err).Error("couldn't write to the audit log")
+		}
+	}
+}
+
+func (app *App) GetAuditLog(c *gin.Context) {
+	if app.auditLog == nil {
+		app.notFoundErrorResponse(c, codeNotFound, "the audit log is disabled")
+		return
+	}
+
+	filter := audit.Filter{
+		Action:    c.Query("action"),
+		Container: c.Query("container"),
+		KeyName:   c.Query("key"),
+		Outcome:   c.Query("outcome"),
+		Limit:     defaultAuditQueryLimit,
+	}
+
+	var err error
+
+	if since := c.Query("since"); since != "" {
+		if filter.Since, err = time.Parse(time.RFC3339, since); err != nil {
+			app.badRequestResponse(c, codeBadRequest, "since value must be an RFC 3339 timestamp")
+			return
+		}
+	}
+
+	if until := c.Query("until"); until != "" {
+		if filter.Until, err = time.Parse(time.RFC3339, until); err != nil {
+			app.badRequestResponse(c, codeBadRequest, "until value must be an RFC 3339 timestamp")
+			return
+		}
+	}
+
+	if limit := c.Query("limit"); limit != "" {
+		if filter.Limit, err = strconv.Atoi(limit); err != nil || filter.Limit <= 0 {
+			app.badRequestResponse(c, codeBadRequest, "limit value must be a positive number")
+			return
+		}
+	}
+
+	entries, err := app.auditLog.Query(filter)
+	if err != nil {
+		app.internalErrorResponse(c, codeInternal, "couldn't read the audit log")
+		return
+	}
+
+	if entries == nil {
+		entries = []audit.Entry{}
+	}
+
+	c.JSON(http.StatusOK, gin.H{"entries": entries})
+}
@@ -0,0 +1,100 @@
+package app
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/XiovV/dokkup-agent/audit"
+	"github.com/XiovV/dokkup-agent/auth"
+	"github.com/XiovV/dokkup-agent/config"
+	"github.com/XiovV/dokkup-agent/controller"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"path/filepath"
+	"testing"
+)
+
+func TestAudit(t *testing.T) {
+	defer removeConfig(t)
+	cfg, apiKey, err := config.New(testConfigFilename)
+	assert.Nil(t, err)
+
+	auditLog, err := audit.Open(filepath.Join(t.TempDir(), "audit.jsonl"))
+	assert.Nil(t, err)
+	defer auditLog.Close()
+
+	mockController := new(mockDockerController)
+
+	router := New(mockController, cfg, nil, auditLog, testLogger()).Router()
+
+	var response struct {
+		Entries []audit.Entry `json:"entries"`
+	}
+
+	change := controller.ContainerChange{
+		ContainerName: "web",
+		OldImage:      "web:1.0",
+		OldImageID:    "sha256:old",
+		NewImage:      "web:1.1",
+		NewImageID:    "sha256:new",
+	}
+
+	mockController.On("UpdateContainer", "web", "web:1.1", false).Return(change, nil).Once()
+	mockController.On("RollbackContainer", "web").Return(controller.ContainerChange{ContainerName: "web"}, errors.New("some unknown error")).Once()
+	mockController.On("PullImage", "db:13").Return(nil).Once()
+
+	sendRequest(router, "PUT", "/v1/containers/update?container=web&image=web:1.1&keep=false", apiKey)
+	sendRequest(router, "PUT", "/v1/containers/rollback?container=web", apiKey)
+	sendRequest(router, "PUT", "/v1/images/pull?image=db:13", apiKey)
+
+	t.Run("All mutating calls are recorded", func(t *testing.T) {
+		w := sendRequest(router, "GET", "/v1/audit", apiKey)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		err = json.NewDecoder(w.Body).Decode(&response)
+		assert.Nil(t, err)
+
+		assert.Len(t, response.Entries, 3)
+		assert.Equal(t, "pull", response.Entries[0].Action)
+		assert.Equal(t, "db:13", response.Entries[0].Image)
+	})
+
+	t.Run("Filter by container", func(t *testing.T) {
+		w := sendRequest(router, "GET", "/v1/audit?container=web&action=update", apiKey)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		err = json.NewDecoder(w.Body).Decode(&response)
+		assert.Nil(t, err)
+
+		assert.Len(t, response.Entries, 1)
+
+		entry := response.Entries[0]
+		assert.Equal(t, auth.MethodAPIKey, entry.KeyName)
+		assert.Equal(t, audit.OutcomeSuccess, entry.Outcome)
+		assert.Equal(t, "web:1.0", entry.OldImage)
+		assert.Equal(t, "sha256:old", entry.OldDigest)
+		assert.Equal(t, "web:1.1", entry.NewImage)
+		assert.Equal(t, "sha256:new", entry.NewDigest)
+	})
+
+	t.Run("Failed calls record the error", func(t *testing.T) {
+		w := sendRequest(router, "GET", "/v1/audit?outcome=failure", apiKey)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		err = json.NewDecoder(w.Body).Decode(&response)
+		assert.Nil(t, err)
+
+		assert.Len(t, response.Entries, 1)
+		assert.Equal(t, "rollback", response.Entries[0].Action)
+		assert.Equal(t, "some unknown error", response.Entries[0].Error)
+		assert.Equal(t, http.StatusInternalServerError, response.Entries[0].Status)
+	})
+
+	t.Run("Invalid since value", func(t *testing.T) {
+		w := sendRequest(router, "GET", "/v1/audit?since=yesterday", apiKey)
+
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	})
+}
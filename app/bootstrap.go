@@ -0,0 +1,63 @@
+package app
+
+import (
+	"crypto/subtle"
+	"github.com/XiovV/dokkup-agent/auth"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"sync"
+)
+
+// bootstrap holds the API key generated at first start until it's claimed with the bootstrap token.
+type bootstrap struct {
+	mu      sync.Mutex
+	token   string
+	apiKey  string
+	claimed func()
+}
+
+// EnableBootstrap lets the API key generated at first start be claimed once through POST /v1/bootstrap
+// by presenting token as a bearer token, so it doesn't have to be read from the logs. claimed is called
+// once the key has been claimed, e.g. to remove it from disk.
+func (app *App) EnableBootstrap(token, apiKey string, claimed func()) {
+	app.bootstrap.mu.Lock()
+	defer app.bootstrap.mu.Unlock()
+
+	app.bootstrap.token = token
+	app.bootstrap.apiKey = apiKey
+	app.bootstrap.claimed = claimed
+}
+
+// Bootstrap handles POST /v1/bootstrap. It responds with the API key generated at first start if the
+// request carries the bootstrap token, and forgets both, so the key can only be claimed once.
+// Invalid tokens count towards locking out the client, like invalid API keys.
+func (app *App) Bootstrap(c *gin.Context) {
+	token := bearerToken(c.GetHeader("Authorization"))
+	lockout := app.settings().limiters.lockout
+
+	app.bootstrap.mu.Lock()
+	defer app.bootstrap.mu.Unlock()
+
+	if app.bootstrap.apiKey == "" {
+		app.notFoundErrorResponse(c, codeNotFound, "there is no api key to claim")
+		return
+	}
+
+	if subtle.ConstantTimeCompare([]byte(token), []byte(app.bootstrap.token)) != 1 {
+		lockout.Fail(clientIP(c))
+		app.forbiddenResponse(c, codeInvalidCredentials, "invalid bootstrap token")
+		return
+	}
+
+	lockout.Succeed(clientIP(c))
+	c.Set(principalContextKey, auth.Principal{Name: auditActionBootstrap, Method: auth.MethodBootstrapToken})
+
+	apiKey := app.bootstrap.apiKey
+	app.bootstrap.token, app.bootstrap.apiKey = "", ""
+	if app.bootstrap.claimed != nil {
+		app.bootstrap.claimed()
+	}
+
+	app.requestLogger(c).Warn("the api key was claimed with the bootstrap token")
+	c.JSON(http.StatusOK, gin.H{"api_key": apiKey})
+}
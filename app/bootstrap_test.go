@@ -0,0 +1,62 @@
+package app
+
+import (
+	"encoding/json"
+	"github.com/XiovV/dokkup-agent/config"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestBootstrap(t *testing.T) {
+	defer removeConfig(t)
+	cfg, apiKey, err := config.New(testConfigFilename)
+	assert.Nil(t, err)
+
+	app := New(new(mockDockerController), cfg, nil, nil, testLogger())
+	router := app.Router()
+
+	sendBootstrap := func(token string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/v1/bootstrap", nil)
+		req.Header.Add("Authorization", "Bearer "+token)
+		router.ServeHTTP(w, req)
+
+		return w
+	}
+
+	t.Run("Not enabled", func(t *testing.T) {
+		w := sendBootstrap("")
+		assert.Equal(t, http.StatusNotFound, w.Code)
+	})
+
+	claimed := 0
+	app.EnableBootstrap("bootstrap-token-0123456789", apiKey, func() { claimed++ })
+
+	t.Run("Invalid token", func(t *testing.T) {
+		w := sendBootstrap("invalid")
+		assert.Equal(t, http.StatusForbidden, w.Code)
+	})
+
+	t.Run("Claim the api key", func(t *testing.T) {
+		w := sendBootstrap("bootstrap-token-0123456789")
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		var response struct {
+			APIKey string `json:"api_key"`
+		}
+		assert.Nil(t, json.NewDecoder(w.Body).Decode(&response))
+		assert.Equal(t, apiKey, response.APIKey)
+		assert.Equal(t, 1, claimed)
+
+		w = sendRequest(router, "GET", "/v1/containers/image/", response.APIKey)
+		assert.NotEqual(t, http.StatusForbidden, w.Code)
+	})
+
+	t.Run("The api key can only be claimed once", func(t *testing.T) {
+		w := sendBootstrap("bootstrap-token-0123456789")
+		assert.Equal(t, http.StatusNotFound, w.Code)
+		assert.Equal(t, 1, claimed)
+	})
+}
@@ -1,75 +1,295 @@
 package app
 
 import (
-	"errors"
 	"github.com/XiovV/dokkup-agent/controller"
 	"github.com/gin-gonic/gin"
+	"io"
 	"net/http"
 	"strconv"
+	"strings"
 )
 
+// CreateContainer handles POST /v1/containers with a createRequest body. It pulls the image and
+// creates and starts a new container; containers which already exist are left alone.
+// With dry_run=true it only responds with the plan of the create.
+func (app *App) CreateContainer(c *gin.Context) {
+	dryRun, ok := app.dryRun(c)
+	if !ok {
+		return
+	}
+
+	var request createRequest
+	if !app.bindJSON(c, &request) {
+		return
+	}
+
+	if dryRun {
+		plan, err := app.controller.PlanCreate(c.Request.Context(), request.spec())
+		app.planResponse(c, plan, err)
+		return
+	}
+
+	c.Set(auditContainerContextKey, request.Name)
+	c.Set(auditImageContextKey, request.Image)
+
+	ctx := app.operationContext(c)
+	defer app.operations.track(ctx, auditActionCreate, request.Name)()
+
+	change, err := app.controller.CreateContainer(ctx, request.spec())
+	c.Set(containerChangeContextKey, change)
+	if err != nil {
+		app.operationErrorResponse(c, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"message": "container created successfully"})
+}
+
+// UpdateContainer is the v1 update handler, which takes its parameters from the query string.
 func (app *App) UpdateContainer(c *gin.Context) {
 	containerName := c.Query("container")
 	image := c.Query("image")
 
 	if containerName == "" {
-		app.badRequestResponse(c, "container value must not be empty")
+		app.badRequestResponse(c, codeBadRequest, "container value must not be empty")
 		return
 	}
 
 	if image == "" {
-		app.badRequestResponse(c, "image value must not be empty")
+		app.badRequestResponse(c, codeBadRequest, "image value must not be empty")
 		return
 	}
 
 	keepContainer, err := strconv.ParseBool(c.Query("keep"))
 	if err != nil {
-		app.badRequestResponse(c, "keep value must be either true or false")
+		app.badRequestResponse(c, codeBadRequest, "keep value must be either true or false")
 		return
 	}
 
-	err = app.controller.UpdateContainer(containerName, image, keepContainer)
-	if err != nil {
-		switch {
-		case errors.Is(err, controller.ErrImageFormatInvalid):
-			app.badRequestResponse(c, "image format is invalid")
-		case errors.Is(err, controller.ErrContainerNotFound):
-			app.notFoundErrorResponse(c, "the requested container could not be found")
-		default:
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	app.updateContainer(c, containerName, updateRequest{Image: image, Keep: keepContainer})
+}
+
+// UpdateContainerV2 handles POST /v2/containers/:name/update with an updateRequest body.
+func (app *App) UpdateContainerV2(c *gin.Context) {
+	var request updateRequest
+	if !app.bindJSON(c, &request) {
+		return
+	}
+
+	app.updateContainer(c, c.Param("name"), request)
+}
+
+// updateContainer updates the container, or with dry_run=true only responds with the plan of the update.
+func (app *App) updateContainer(c *gin.Context, containerName string, request updateRequest) {
+	dryRun, ok := app.dryRun(c)
+	if !ok {
+		return
+	}
+
+	if dryRun {
+		var patch controller.ConfigPatch
+		if request.Patch != nil {
+			patch = request.Patch.patch()
 		}
+
+		plan, err := app.controller.PlanUpdate(c.Request.Context(), containerName, request.Image, request.Keep, patch)
+		app.planResponse(c, plan, err)
+		return
+	}
+
+	c.Set(auditContainerContextKey, containerName)
+	c.Set(auditImageContextKey, request.Image)
+
+	ctx := app.operationContext(c)
+	defer app.operations.track(ctx, auditActionUpdate, containerName)()
+
+	var change controller.ContainerChange
+	var err error
+	if request.Patch != nil {
+		change, err = app.controller.UpdateContainerWithPatch(ctx, containerName, request.Image, request.Keep, request.Patch.patch())
+	} else {
+		change, err = app.controller.UpdateContainer(ctx, containerName, request.Image, request.Keep)
+	}
+
+	c.Set(containerChangeContextKey, change)
+	if err != nil {
+		app.operationErrorResponse(c, err)
 		return
 	}
 
-	app.successResponse(c, "container updated successfully")
+	c.JSON(http.StatusOK, gin.H{"message": "container updated successfully", "changes": settingChanges(change)})
+}
+
+// settingChanges lists the effective changes of an update: the image, if it changed, followed by
+// the settings changed by the patch. Values which are empty (e.g. of a variable which was added) are left out.
+func settingChanges(change controller.ContainerChange) []gin.H {
+	changes := make([]gin.H, 0, len(change.Settings)+1)
+
+	if change.OldImage != change.NewImage {
+		changes = append(changes, gin.H{"setting": "image", "old": change.OldImage, "new": change.NewImage})
+	}
+
+	for _, setting := range change.Settings {
+		response := gin.H{"setting": setting.Setting}
+		if setting.Old != "" {
+			response["old"] = setting.Old
+		}
+		if setting.New != "" {
+			response["new"] = setting.New
+		}
+
+		changes = append(changes, response)
+	}
+
+	return changes
 }
 
+// RollbackContainer is the v1 rollback handler, which takes the container name from the query string.
 func (app *App) RollbackContainer(c *gin.Context) {
 	containerName := c.Query("container")
 
 	if containerName == "" {
-		app.badRequestResponse(c, "container value must not be empty")
+		app.badRequestResponse(c, codeBadRequest, "container value must not be empty")
 		return
 	}
 
-	err := app.controller.RollbackContainer(containerName)
-	if err != nil {
-		var containerStartFailedErr controller.ErrContainerStartFailed
-
-		switch {
-		case errors.Is(err, controller.ErrContainerNotFound):
-			app.notFoundErrorResponse(c, "the requested container does not exist")
-		case errors.Is(err, controller.ErrRollbackContainerNotFound):
-			app.notFoundErrorResponse(c, "the requested container does not have a rollback container")
-		case errors.Is(err, controller.ErrContainerNotRunning):
-			app.internalErrorResponse(c, "the container failed to start")
-		case errors.As(err, &containerStartFailedErr):
-			app.internalErrorResponse(c, containerStartFailedErr.Reason.Error())
-		default:
-			app.internalErrorResponse(c, err.Error())
+	app.rollbackContainer(c, containerName)
+}
+
+// RollbackContainerV2 handles POST /v2/containers/:name/rollback.
+func (app *App) RollbackContainerV2(c *gin.Context) {
+	app.rollbackContainer(c, c.Param("name"))
+}
+
+// rollbackContainer rolls the container back, or with dry_run=true only responds with the plan of the rollback.
+func (app *App) rollbackContainer(c *gin.Context, containerName string) {
+	dryRun, ok := app.dryRun(c)
+	if !ok {
+		return
+	}
+
+	if dryRun {
+		plan, err := app.controller.PlanRollback(c.Request.Context(), containerName)
+		if err != nil {
+			app.rollbackErrorResponse(c, err)
+			return
 		}
+
+		app.planResponse(c, plan, nil)
+		return
+	}
+
+	c.Set(auditContainerContextKey, containerName)
+
+	ctx := app.operationContext(c)
+	defer app.operations.track(ctx, auditActionRollback, containerName)()
+
+	change, err := app.controller.RollbackContainer(ctx, containerName)
+	c.Set(containerChangeContextKey, change)
+	if err != nil {
+		app.rollbackErrorResponse(c, err)
 		return
 	}
 
 	app.successResponse(c, "successfully restored container")
 }
+
+// GetContainerV2 handles GET /v2/containers/:name.
+func (app *App) GetContainerV2(c *gin.Context) {
+	containerName := c.Param("name")
+
+	container, ok := app.controller.FindContainerByName(c.Request.Context(), containerName)
+	if !ok {
+		app.notFoundErrorResponse(c, codeContainerNotFound, "the requested container could not be found")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"name":     containerName,
+		"id":       container.ID,
+		"image":    container.Image,
+		"image_id": container.ImageID,
+		"state":    container.State,
+		"status":   container.Status,
+	})
+}
+
+// ListContainersV2 handles GET /v2/containers. It lists both running and stopped containers.
+func (app *App) ListContainersV2(c *gin.Context) {
+	containers, err := app.controller.ListContainers(c.Request.Context())
+	if err != nil {
+		app.operationErrorResponse(c, err)
+		return
+	}
+
+	response := make([]gin.H, 0, len(containers))
+	for _, container := range containers {
+		response = append(response, gin.H{
+			"name":     strings.TrimPrefix(container.Names[0], "/"),
+			"id":       container.ID,
+			"image":    container.Image,
+			"image_id": container.ImageID,
+			"state":    container.State,
+			"status":   container.Status,
+		})
+	}
+
+	c.JSON(http.StatusOK, gin.H{"containers": response})
+}
+
+// ContainerLogsV2 handles GET /v2/containers/:name/logs. It streams the container's stdout and
+// stderr as plain text; with follow=true the response stays open until the client disconnects.
+func (app *App) ContainerLogsV2(c *gin.Context) {
+	opts := controller.LogsOptions{Tail: c.DefaultQuery("tail", "all")}
+
+	if opts.Tail != "all" {
+		if lines, err := strconv.Atoi(opts.Tail); err != nil || lines < 0 {
+			app.badRequestResponse(c, codeBadRequest, "tail value must be a positive number or all")
+			return
+		}
+	}
+
+	for name, value := range map[string]*bool{"follow": &opts.Follow, "timestamps": &opts.Timestamps} {
+		if query := c.Query(name); query != "" {
+			parsed, err := strconv.ParseBool(query)
+			if err != nil {
+				app.badRequestResponse(c, codeBadRequest, name+" value must be either true or false")
+				return
+			}
+
+			*value = parsed
+		}
+	}
+
+	// followed logs end when the agent stops, instead of holding up the shutdown
+	ctx, cancel := app.streamContext(c.Request.Context())
+	defer cancel()
+
+	logs, err := app.controller.ContainerLogs(ctx, c.Param("name"), opts)
+	if err != nil {
+		app.operationErrorResponse(c, err)
+		return
+	}
+	defer logs.Close()
+
+	c.Header("Content-Type", "text/plain; charset=utf-8")
+	c.Status(http.StatusOK)
+
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := logs.Read(buf)
+		if n > 0 {
+			if _, writeErr := c.Writer.Write(buf[:n]); writeErr != nil {
+				return
+			}
+			c.Writer.Flush()
+		}
+
+		if err != nil {
+			if err != io.EOF && ctx.Err() == nil {
+				app.requestLogger(c).WithError(err).Warn("container log stream failed")
+			}
+			return
+		}
+	}
+}
@@ -1,6 +1,7 @@
 package app
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"github.com/XiovV/dokkup-agent/config"
@@ -10,22 +11,64 @@ import (
 	"testing"
 )
 
-func (m *mockDockerController) FindContainerIDByName(containerName string) (string, bool) {
+func (m *mockDockerController) FindContainerIDByName(ctx context.Context, containerName string) (string, bool) {
 	args := m.Called(containerName)
 
 	return args.String(0), args.Bool(1)
 }
 
-func (m *mockDockerController) UpdateContainer(containerName, image string, keep bool) error {
+func (m *mockDockerController) CreateContainer(ctx context.Context, spec controller.ContainerSpec) (controller.ContainerChange, error) {
+	args := m.Called(spec)
+
+	return args.Get(0).(controller.ContainerChange), args.Error(1)
+}
+
+func (m *mockDockerController) RecreateContainer(ctx context.Context, spec controller.ContainerSpec) (controller.ContainerChange, error) {
+	args := m.Called(spec)
+
+	return args.Get(0).(controller.ContainerChange), args.Error(1)
+}
+
+func (m *mockDockerController) UpdateContainer(ctx context.Context, containerName, image string, keep bool) (controller.ContainerChange, error) {
 	args := m.Called(containerName, image, keep)
 
-	return args.Error(0)
+	return args.Get(0).(controller.ContainerChange), args.Error(1)
+}
+
+func (m *mockDockerController) UpdateContainerWithPatch(ctx context.Context, containerName, image string, keep bool, patch controller.ConfigPatch) (controller.ContainerChange, error) {
+	args := m.Called(containerName, image, keep, patch)
+
+	return args.Get(0).(controller.ContainerChange), args.Error(1)
+}
+
+func (m *mockDockerController) RollbackContainer(ctx context.Context, containerName string) (controller.ContainerChange, error) {
+	args := m.Called(containerName)
+
+	return args.Get(0).(controller.ContainerChange), args.Error(1)
+}
+
+func (m *mockDockerController) PlanCreate(ctx context.Context, spec controller.ContainerSpec) (controller.Plan, error) {
+	args := m.Called(spec)
+
+	return args.Get(0).(controller.Plan), args.Error(1)
 }
 
-func (m *mockDockerController) RollbackContainer(containerName string) error {
+func (m *mockDockerController) PlanUpdate(ctx context.Context, containerName, image string, keep bool, patch controller.ConfigPatch) (controller.Plan, error) {
+	args := m.Called(containerName, image, keep, patch)
+
+	return args.Get(0).(controller.Plan), args.Error(1)
+}
+
+func (m *mockDockerController) PlanRollback(ctx context.Context, containerName string) (controller.Plan, error) {
 	args := m.Called(containerName)
 
-	return args.Error(0)
+	return args.Get(0).(controller.Plan), args.Error(1)
+}
+
+func (m *mockDockerController) PlanPull(ctx context.Context, image string) (controller.Plan, error) {
+	args := m.Called(image)
+
+	return args.Get(0).(controller.Plan), args.Error(1)
 }
 
 func TestUpdateContainer(t *testing.T) {
@@ -35,7 +78,7 @@ func TestUpdateContainer(t *testing.T) {
 
 	mockController := new(mockDockerController)
 
-	app := New(mockController, cfg)
+	app := New(mockController, cfg, nil, nil, testLogger())
 
 	router := app.Router()
 
@@ -44,12 +87,15 @@ func TestUpdateContainer(t *testing.T) {
 	}
 
 	var errorResponse struct {
-		Error string `json:"error"`
+		Error       string                 `json:"error"`
+		Code        string                 `json:"code"`
+		OperationID string                 `json:"operation_id"`
+		Details     map[string]interface{} `json:"details"`
 	}
 
 	t.Run("Valid update request", func(t *testing.T) {
 		mockController.On("UpdateContainer", "validContainer", "imageName:latest", true).
-			Return(nil).Once()
+			Return(controller.ContainerChange{}, nil).Once()
 
 		w := sendRequest(router, "PUT", "/v1/containers/update?container=validContainer&image=imageName:latest&keep=true", apiKey)
 
@@ -140,7 +186,7 @@ func TestUpdateContainer(t *testing.T) {
 
 	t.Run("Image without name", func(t *testing.T) {
 		mockController.On("UpdateContainer", "validContainer", ":latest", true).
-			Return(controller.ErrImageFormatInvalid).Once()
+			Return(controller.ContainerChange{}, controller.ErrImageFormatInvalid).Once()
 
 		w := sendRequest(router, "PUT", "/v1/containers/update?container=validContainer&image=:latest&keep=true", apiKey)
 
@@ -154,7 +200,7 @@ func TestUpdateContainer(t *testing.T) {
 
 	t.Run("Image without tag", func(t *testing.T) {
 		mockController.On("UpdateContainer", "validContainer", "imageName:", true).
-			Return(controller.ErrImageFormatInvalid).Once()
+			Return(controller.ContainerChange{}, controller.ErrImageFormatInvalid).Once()
 
 		w := sendRequest(router, "PUT", "/v1/containers/update?container=validContainer&image=imageName:&keep=true", apiKey)
 
@@ -168,7 +214,7 @@ func TestUpdateContainer(t *testing.T) {
 
 	t.Run("Non-existent container name", func(t *testing.T) {
 		mockController.On("UpdateContainer", "invalidContainer", "imageName:latest", true).
-			Return(controller.ErrContainerNotFound).Once()
+			Return(controller.ContainerChange{}, controller.ErrContainerNotFound).Once()
 
 		w := sendRequest(router, "PUT", "/v1/containers/update?container=invalidContainer&image=imageName:latest&keep=true", apiKey)
 
@@ -182,7 +228,7 @@ func TestUpdateContainer(t *testing.T) {
 
 	t.Run("Internal server error", func(t *testing.T) {
 		mockController.On("UpdateContainer", "validContainer", "imageName:latest", true).
-			Return(errors.New("some unknown error")).Once()
+			Return(controller.ContainerChange{}, errors.New("some unknown error")).Once()
 
 		w := sendRequest(router, "PUT", "/v1/containers/update?container=validContainer&image=imageName:latest&keep=true", apiKey)
 
@@ -191,7 +237,34 @@ func TestUpdateContainer(t *testing.T) {
 		err = json.NewDecoder(w.Body).Decode(&errorResponse)
 		assert.Nil(t, err)
 
-		assert.Equal(t, "some unknown error", errorResponse.Error)
+		assert.Equal(t, "internal server error", errorResponse.Error)
+		assert.Equal(t, "INTERNAL_ERROR", errorResponse.Code)
+		assert.NotEmpty(t, errorResponse.OperationID)
+	})
+
+	t.Run("Operation timed out", func(t *testing.T) {
+		mockController.On("UpdateContainer", "validContainer", "imageName:latest", true).
+			Return(controller.ContainerChange{}, controller.ErrOperationAborted{Step: "start", Reason: context.DeadlineExceeded}).Once()
+
+		w := sendRequest(router, "PUT", "/v1/containers/update?container=validContainer&image=imageName:latest&keep=true", apiKey)
+
+		assert.Equal(t, http.StatusGatewayTimeout, w.Code)
+
+		err = json.NewDecoder(w.Body).Decode(&errorResponse)
+		assert.Nil(t, err)
+
+		assert.Equal(t, "the operation timed out", errorResponse.Error)
+		assert.Equal(t, "TIMEOUT", errorResponse.Code)
+		assert.Equal(t, "start", errorResponse.Details["step"])
+	})
+
+	t.Run("Restore failed after timeout", func(t *testing.T) {
+		mockController.On("UpdateContainer", "validContainer", "imageName:latest", true).
+			Return(controller.ContainerChange{}, controller.ErrContainerRestoreFailed).Once()
+
+		w := sendRequest(router, "PUT", "/v1/containers/update?container=validContainer&image=imageName:latest&keep=true", apiKey)
+
+		assert.Equal(t, http.StatusInternalServerError, w.Code)
 	})
 }
 
@@ -202,7 +275,7 @@ func TestRollbackContainer(t *testing.T) {
 
 	mockController := new(mockDockerController)
 
-	app := New(mockController, cfg)
+	app := New(mockController, cfg, nil, nil, testLogger())
 
 	router := app.Router()
 
@@ -211,12 +284,15 @@ func TestRollbackContainer(t *testing.T) {
 	}
 
 	var errorResponse struct {
-		Error string `json:"error"`
+		Error       string                 `json:"error"`
+		Code        string                 `json:"code"`
+		OperationID string                 `json:"operation_id"`
+		Details     map[string]interface{} `json:"details"`
 	}
 
 	t.Run("Valid rollback request", func(t *testing.T) {
 		mockController.On("RollbackContainer", "containerName").
-			Return(nil).Once()
+			Return(controller.ContainerChange{}, nil).Once()
 
 		w := sendRequest(router, "PUT", "/v1/containers/rollback?container=containerName", apiKey)
 
@@ -252,7 +328,7 @@ func TestRollbackContainer(t *testing.T) {
 
 	t.Run("Non-existent container", func(t *testing.T) {
 		mockController.On("RollbackContainer", "invalidContainer").
-			Return(controller.ErrContainerNotFound).Once()
+			Return(controller.ContainerChange{}, controller.ErrContainerNotFound).Once()
 
 		w := sendRequest(router, "PUT", "/v1/containers/rollback?container=invalidContainer", apiKey)
 
@@ -262,11 +338,12 @@ func TestRollbackContainer(t *testing.T) {
 		assert.Nil(t, err)
 
 		assert.Equal(t, "the requested container does not exist", errorResponse.Error)
+		assert.Equal(t, "CONTAINER_NOT_FOUND", errorResponse.Code)
 	})
 
 	t.Run("Non-existent rollback container", func(t *testing.T) {
 		mockController.On("RollbackContainer", "invalidContainer").
-			Return(controller.ErrRollbackContainerNotFound).Once()
+			Return(controller.ContainerChange{}, controller.ErrRollbackContainerNotFound).Once()
 
 		w := sendRequest(router, "PUT", "/v1/containers/rollback?container=invalidContainer", apiKey)
 
@@ -276,11 +353,12 @@ func TestRollbackContainer(t *testing.T) {
 		assert.Nil(t, err)
 
 		assert.Equal(t, "the requested container does not have a rollback container", errorResponse.Error)
+		assert.Equal(t, "ROLLBACK_NOT_FOUND", errorResponse.Code)
 	})
 
 	t.Run("Container not running", func(t *testing.T) {
 		mockController.On("RollbackContainer", "containerName").
-			Return(controller.ErrContainerNotRunning).Once()
+			Return(controller.ContainerChange{}, controller.ErrContainerNotRunning).Once()
 
 		w := sendRequest(router, "PUT", "/v1/containers/rollback?container=containerName", apiKey)
 
@@ -290,11 +368,12 @@ func TestRollbackContainer(t *testing.T) {
 		assert.Nil(t, err)
 
 		assert.Equal(t, "the container failed to start", errorResponse.Error)
+		assert.Equal(t, "CONTAINER_NOT_RUNNING", errorResponse.Code)
 	})
 
 	t.Run("Container failed to start", func(t *testing.T) {
 		mockController.On("RollbackContainer", "containerName").
-			Return(controller.ErrContainerStartFailed{Reason: errors.New("some random reason")}).Once()
+			Return(controller.ContainerChange{}, controller.ErrContainerStartFailed{Reason: errors.New("some random reason")}).Once()
 
 		w := sendRequest(router, "PUT", "/v1/containers/rollback?container=containerName", apiKey)
 
@@ -304,11 +383,13 @@ func TestRollbackContainer(t *testing.T) {
 		assert.Nil(t, err)
 
 		assert.Equal(t, "some random reason", errorResponse.Error)
+		assert.Equal(t, "START_FAILED", errorResponse.Code)
+		assert.Equal(t, "some random reason", errorResponse.Details["reason"])
 	})
 
 	t.Run("Internal server error", func(t *testing.T) {
 		mockController.On("RollbackContainer", "containerName").
-			Return(errors.New("some unknown error")).Once()
+			Return(controller.ContainerChange{}, errors.New("some unknown error")).Once()
 
 		w := sendRequest(router, "PUT", "/v1/containers/rollback?container=containerName", apiKey)
 
@@ -317,6 +398,94 @@ func TestRollbackContainer(t *testing.T) {
 		err = json.NewDecoder(w.Body).Decode(&errorResponse)
 		assert.Nil(t, err)
 
-		assert.Equal(t, "some unknown error", errorResponse.Error)
+		assert.Equal(t, "internal server error", errorResponse.Error)
+		assert.Equal(t, "INTERNAL_ERROR", errorResponse.Code)
+	})
+}
+
+func TestCreateContainer(t *testing.T) {
+	defer removeConfig(t)
+	cfg, apiKey, err := config.New(testConfigFilename)
+	assert.Nil(t, err)
+
+	mockController := new(mockDockerController)
+	app := New(mockController, cfg, nil, nil, testLogger())
+	router := app.Router()
+
+	var errorResponse struct {
+		Error   string                 `json:"error"`
+		Code    string                 `json:"code"`
+		Details map[string]interface{} `json:"details"`
+	}
+
+	t.Run("Valid create request", func(t *testing.T) {
+		spec := controller.ContainerSpec{
+			Name:          "web",
+			Image:         "nginx:1.21",
+			Env:           map[string]string{"MODE": "production"},
+			Ports:         []string{"8080:80"},
+			Volumes:       []string{"/srv/www:/usr/share/nginx/html:ro"},
+			Networks:      []string{"frontend"},
+			Labels:        map[string]string{"team": "web"},
+			RestartPolicy: "unless-stopped",
+			Resources:     controller.Resources{MemoryBytes: 268435456, CPUs: 0.5},
+		}
+		mockController.On("CreateContainer", spec).Return(controller.ContainerChange{ContainerName: "web", NewImage: "nginx:1.21"}, nil).Once()
+
+		w := sendJSONRequest(router, "POST", "/v1/containers", apiKey, `{
+			"name": "web",
+			"image": "nginx:1.21",
+			"env": {"MODE": "production"},
+			"ports": ["8080:80"],
+			"volumes": ["/srv/www:/usr/share/nginx/html:ro"],
+			"networks": ["frontend"],
+			"labels": {"team": "web"},
+			"restart_policy": "unless-stopped",
+			"resources": {"memory_bytes": 268435456, "cpus": 0.5}
+		}`)
+
+		assert.Equal(t, http.StatusCreated, w.Code)
+		mockController.AssertExpectations(t)
+	})
+
+	t.Run("Missing image", func(t *testing.T) {
+		w := sendJSONRequest(router, "POST", "/v1/containers", apiKey, `{"name": "web"}`)
+
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+
+		err = json.NewDecoder(w.Body).Decode(&errorResponse)
+		assert.Nil(t, err)
+
+		assert.Equal(t, "BAD_REQUEST", errorResponse.Code)
+		assert.Equal(t, map[string]interface{}{"image": "required"}, errorResponse.Details["fields"])
+	})
+
+	t.Run("Invalid spec", func(t *testing.T) {
+		mockController.On("CreateContainer", controller.ContainerSpec{Name: "web", Image: "nginx:1.21", RestartPolicy: "sometimes"}).
+			Return(controller.ContainerChange{}, controller.ErrSpecInvalid{Setting: "restart_policy", Reason: "invalid policy"}).Once()
+
+		w := sendJSONRequest(router, "POST", "/v1/containers", apiKey, `{"name": "web", "image": "nginx:1.21", "restart_policy": "sometimes"}`)
+
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+
+		err = json.NewDecoder(w.Body).Decode(&errorResponse)
+		assert.Nil(t, err)
+
+		assert.Equal(t, "SPEC_INVALID", errorResponse.Code)
+		assert.Equal(t, "restart_policy", errorResponse.Details["setting"])
+	})
+
+	t.Run("Container exists", func(t *testing.T) {
+		mockController.On("CreateContainer", controller.ContainerSpec{Name: "web", Image: "nginx:1.21"}).
+			Return(controller.ContainerChange{}, controller.ErrContainerExists).Once()
+
+		w := sendJSONRequest(router, "POST", "/v1/containers", apiKey, `{"name": "web", "image": "nginx:1.21"}`)
+
+		assert.Equal(t, http.StatusConflict, w.Code)
+
+		err = json.NewDecoder(w.Body).Decode(&errorResponse)
+		assert.Nil(t, err)
+
+		assert.Equal(t, "CONTAINER_EXISTS", errorResponse.Code)
 	})
 }
\ No newline at end of file
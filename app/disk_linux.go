@@ -0,0 +1,18 @@
+//go:build linux
+// +build linux
+
+package app
+
+import "syscall"
+
+// diskUsage returns the free and total bytes of the filesystem containing path.
+func diskUsage(path string) (uint64, uint64, error) {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(path, &stat); err != nil {
+		return 0, 0, err
+	}
+
+	blockSize := uint64(stat.Bsize)
+
+	return stat.Bavail * blockSize, stat.Blocks * blockSize, nil
+}
@@ -0,0 +1,11 @@
+//go:build !linux
+// +build !linux
+
+package app
+
+import "errors"
+
+// diskUsage isn't implemented outside of linux.
+func diskUsage(path string) (uint64, uint64, error) {
+	return 0, 0, errors.New("disk usage is not supported on this platform")
+}
@@ -0,0 +1,83 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"github.com/XiovV/dokkup-agent/controller"
+	"github.com/gin-gonic/gin"
+	"net/http"
+)
+
+// operationError is how an error returned by the controller is reported to clients.
+type operationError struct {
+	status  int
+	code    string
+	message string
+	details gin.H
+}
+
+// classifyOperationError maps the controller's typed errors to error codes; any other error is
+// reported as INTERNAL_ERROR without its message, which could leak details of the docker daemon.
+func classifyOperationError(err error) operationError {
+	var startFailedErr controller.ErrContainerStartFailed
+	var abortedErr controller.ErrOperationAborted
+	var specErr controller.ErrSpecInvalid
+
+	switch {
+	case errors.As(err, &specErr):
+		return operationError{http.StatusBadRequest, codeSpecInvalid, "container spec is invalid", gin.H{
+			"setting": specErr.Setting,
+			"reason":  specErr.Reason,
+		}}
+	case errors.Is(err, controller.ErrImageFormatInvalid):
+		return operationError{http.StatusBadRequest, codeImageInvalid, "image format is invalid", nil}
+	case errors.Is(err, controller.ErrContainerNotFound):
+		return operationError{http.StatusNotFound, codeContainerNotFound, "the requested container could not be found", nil}
+	case errors.Is(err, controller.ErrContainerExists):
+		return operationError{http.StatusConflict, codeContainerExists, "a container with the same name already exists", nil}
+	case errors.Is(err, controller.ErrRollbackContainerNotFound):
+		return operationError{http.StatusNotFound, codeRollbackNotFound, "the requested container does not have a rollback container", nil}
+	case errors.Is(err, controller.ErrContainerRestoreFailed):
+		return operationError{http.StatusInternalServerError, codeRestoreFailed, "the operation failed and the old container couldn't be restored", nil}
+	case errors.Is(err, controller.ErrDockerUnavailable):
+		return operationError{http.StatusServiceUnavailable, codeDockerUnavailable, "the docker daemon is unreachable", nil}
+	case errors.As(err, &abortedErr) && errors.Is(err, context.DeadlineExceeded):
+		return operationError{http.StatusGatewayTimeout, codeTimeout, "the operation timed out", gin.H{"step": abortedErr.Step}}
+	case errors.Is(err, context.DeadlineExceeded):
+		return operationError{http.StatusGatewayTimeout, codeTimeout, "the operation timed out", nil}
+	case errors.Is(err, context.Canceled):
+		return operationError{statusClientClosedRequest, codeCancelled, "the operation was cancelled", nil}
+	case errors.Is(err, controller.ErrContainerNotRunning):
+		return operationError{http.StatusInternalServerError, codeContainerNotRunning, "the container failed to start", nil}
+	case errors.As(err, &startFailedErr):
+		// the reason has always been the message of this error, so clients matching on it keep working
+		return operationError{http.StatusInternalServerError, codeStartFailed, startFailedErr.Reason.Error(), gin.H{
+			"container_id": startFailedErr.ContainerId,
+			"reason":       startFailedErr.Reason.Error(),
+		}}
+	default:
+		return operationError{http.StatusInternalServerError, codeInternal, "internal server error", nil}
+	}
+}
+
+// operationErrorResponse responds with the error returned by a create, update, rollback or pull,
+// as classified by classifyOperationError. The full error is logged.
+func (app *App) operationErrorResponse(c *gin.Context, err error) {
+	_ = c.Error(err)
+
+	opErr := classifyOperationError(err)
+	app.errorResponse(c, opErr.status, opErr.code, opErr.message, opErr.details)
+}
+
+// rollbackErrorResponse is operationErrorResponse for rollbacks, which have always reported a missing
+// container with a message of their own.
+func (app *App) rollbackErrorResponse(c *gin.Context, err error) {
+	_ = c.Error(err)
+
+	opErr := classifyOperationError(err)
+	if opErr.code == codeContainerNotFound {
+		opErr.message = "the requested container does not exist"
+	}
+
+	app.errorResponse(c, opErr.status, opErr.code, opErr.message, opErr.details)
+}
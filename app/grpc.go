@@ -0,0 +1,286 @@
+package app
+
+import (
+	"context"
+	"github.com/XiovV/dokkup-agent/agentpb"
+	"github.com/XiovV/dokkup-agent/audit"
+	"github.com/XiovV/dokkup-agent/controller"
+	"github.com/XiovV/dokkup-agent/logging"
+	"github.com/docker/docker/api/types"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// GRPCServer returns the gRPC API. It shares authentication, permissions, rate limits
+// and the audit log with the REST API. opts are passed on to grpc.NewServer, e.g. for TLS.
+func (app *App) GRPCServer(opts ...grpc.ServerOption) *grpc.Server {
+	opts = append(opts,
+		grpc.ChainUnaryInterceptor(app.unaryInterceptor),
+		grpc.ChainStreamInterceptor(app.streamInterceptor),
+	)
+
+	server := grpc.NewServer(opts...)
+	agentpb.RegisterAgentServer(server, &agentServer{app: app})
+
+	return server
+}
+
+// agentServer implements the Agent service on top of the controller.
+type agentServer struct {
+	agentpb.UnimplementedAgentServer
+
+	app *App
+}
+
+func (s *agentServer) GetContainer(ctx context.Context, request *agentpb.GetContainerRequest) (*agentpb.Container, error) {
+	container, ok := s.app.controller.FindContainerByName(ctx, request.Name)
+	if !ok {
+		return nil, grpcError(codeContainerNotFound, "the requested container could not be found", nil)
+	}
+
+	response := containerMessage(container)
+	response.Name = request.Name
+
+	return response, nil
+}
+
+func (s *agentServer) ListContainers(ctx context.Context, _ *agentpb.ListContainersRequest) (*agentpb.ListContainersResponse, error) {
+	containers, err := s.app.controller.ListContainers(ctx)
+	if err != nil {
+		return nil, grpcOperationError(ctx, err)
+	}
+
+	response := &agentpb.ListContainersResponse{Containers: make([]*agentpb.Container, 0, len(containers))}
+	for _, container := range containers {
+		response.Containers = append(response.Containers, containerMessage(container))
+	}
+
+	return response, nil
+}
+
+func (s *agentServer) UpdateContainer(ctx context.Context, request *agentpb.UpdateContainerRequest) (*agentpb.ContainerChange, error) {
+	if request.Name == "" || request.Image == "" {
+		return nil, grpcError(codeBadRequest, "name and image must not be empty", nil)
+	}
+
+	ctx = logging.WithOperationID(ctx, logging.NewID())
+	defer s.app.operations.track(ctx, auditActionUpdate, request.Name)()
+	start := time.Now()
+
+	change, err := s.app.controller.UpdateContainer(ctx, request.Name, request.Image, request.Keep)
+	s.app.auditGRPC(ctx, start, auditActionUpdate, audit.Entry{Container: request.Name, Image: request.Image}, change, err)
+	if err != nil {
+		return nil, grpcOperationError(ctx, err)
+	}
+
+	return changeMessage(change), nil
+}
+
+func (s *agentServer) RollbackContainer(ctx context.Context, request *agentpb.RollbackContainerRequest) (*agentpb.ContainerChange, error) {
+	if request.Name == "" {
+		return nil, grpcError(codeBadRequest, "name must not be empty", nil)
+	}
+
+	ctx = logging.WithOperationID(ctx, logging.NewID())
+	defer s.app.operations.track(ctx, auditActionRollback, request.Name)()
+	start := time.Now()
+
+	change, err := s.app.controller.RollbackContainer(ctx, request.Name)
+	s.app.auditGRPC(ctx, start, auditActionRollback, audit.Entry{Container: request.Name}, change, err)
+	if err != nil {
+		return nil, grpcOperationError(ctx, err)
+	}
+
+	return changeMessage(change), nil
+}
+
+func (s *agentServer) PullImage(request *agentpb.PullImageRequest, stream agentpb.Agent_PullImageServer) error {
+	if request.Image == "" {
+		return grpcError(codeBadRequest, "image must not be empty", nil)
+	}
+
+	ctx := logging.WithOperationID(stream.Context(), logging.NewID())
+	defer s.app.operations.track(ctx, auditActionPull, request.Image)()
+	start := time.Now()
+
+	// a client which stops reading doesn't stop the pull, so send errors are ignored
+	err := s.app.controller.PullImageWithProgress(ctx, request.Image, func(progress controller.PullProgress) {
+		_ = stream.Send(&agentpb.PullProgress{
+			LayerId: progress.LayerID,
+			Status:  progress.Status,
+			Current: progress.Current,
+			Total:   progress.Total,
+		})
+	})
+	s.app.auditGRPC(ctx, start, auditActionPull, audit.Entry{Image: request.Image}, controller.ContainerChange{}, err)
+	if err != nil {
+		return grpcOperationError(ctx, err)
+	}
+
+	return nil
+}
+
+func (s *agentServer) ContainerLogs(request *agentpb.ContainerLogsRequest, stream agentpb.Agent_ContainerLogsServer) error {
+	opts := controller.LogsOptions{Follow: request.Follow, Tail: request.Tail, Timestamps: request.Timestamps}
+	if opts.Tail == "" {
+		opts.Tail = "all"
+	}
+
+	if opts.Tail != "all" {
+		if lines, err := strconv.Atoi(opts.Tail); err != nil || lines < 0 {
+			return grpcError(codeBadRequest, "tail value must be a positive number or all", nil)
+		}
+	}
+
+	ctx, cancel := s.app.streamContext(stream.Context())
+	defer cancel()
+
+	logs, err := s.app.controller.ContainerLogs(ctx, request.Name, opts)
+	if err != nil {
+		return grpcOperationError(ctx, err)
+	}
+	defer logs.Close()
+
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := logs.Read(buf)
+		if n > 0 {
+			if sendErr := stream.Send(&agentpb.LogChunk{Data: append([]byte(nil), buf[:n]...)}); sendErr != nil {
+				return sendErr
+			}
+		}
+
+		if err == io.EOF {
+			return nil
+		}
+
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+
+			return grpcOperationError(ctx, err)
+		}
+	}
+}
+
+func (s *agentServer) Events(_ *agentpb.EventsRequest, stream agentpb.Agent_EventsServer) error {
+	ctx, cancel := s.app.streamContext(stream.Context())
+	defer cancel()
+
+	events, errs := s.app.controller.Events(ctx)
+
+	for {
+		select {
+		case event, ok := <-events:
+			if !ok {
+				select {
+				case err := <-errs:
+					return grpcOperationError(ctx, err)
+				default:
+					return nil
+				}
+			}
+
+			if err := stream.Send(&agentpb.Event{
+				Time:   timestamppb.New(event.Time),
+				Type:   event.Type,
+				Action: event.Action,
+				Id:     event.ID,
+				Name:   event.Name,
+				Image:  event.Image,
+			}); err != nil {
+				return err
+			}
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+// auditGRPC records the outcome of an update, rollback or pull made over gRPC. The status is the
+// one the REST API would have responded with, so both APIs' entries can be queried the same way.
+func (app *App) auditGRPC(ctx context.Context, start time.Time, action string, entry audit.Entry, change controller.ContainerChange, err error) {
+	if app.auditLog == nil {
+		return
+	}
+
+	principal, _ := ctx.Value(principalKey{}).(grpcPrincipal)
+
+	entry.Time = start
+	entry.Action = action
+	entry.KeyName = principal.Name
+	entry.AuthMethod = principal.Method
+	entry.ClientIP = principal.clientIP
+	entry.OldImage = change.OldImage
+	entry.OldDigest = change.OldImageID
+	entry.NewImage = change.NewImage
+	entry.NewDigest = change.NewImageID
+	entry.Outcome = audit.OutcomeSuccess
+	entry.Status = http.StatusOK
+	entry.DurationMs = time.Since(start).Milliseconds()
+
+	if err != nil {
+		entry.Outcome = audit.OutcomeFailure
+		entry.Status = classifyOperationError(err).status
+		entry.Error = err.Error()
+	}
+
+	if err := app.auditLog.Record(entry); err != nil {
+		app.grpcLogger(ctx).WithError(err).Error("couldn't write to the audit log")
+	}
+}
+
+func containerMessage(container types.Container) *agentpb.Container {
+	message := &agentpb.Container{
+		Id:      container.ID,
+		Image:   container.Image,
+		ImageId: container.ImageID,
+		State:   container.State,
+		Status:  container.Status,
+	}
+
+	if len(container.Names) > 0 {
+		message.Name = strings.TrimPrefix(container.Names[0], "/")
+	}
+
+	return message
+}
+
+func changeMessage(change controller.ContainerChange) *agentpb.ContainerChange {
+	return &agentpb.ContainerChange{
+		ContainerName: change.ContainerName,
+		OldImage:      change.OldImage,
+		OldImageId:    change.OldImageID,
+		NewImage:      change.NewImage,
+		NewImageId:    change.NewImageID,
+	}
+}
+
+// grpcCodes maps the error codes of the REST API to gRPC status codes. The REST
+// code itself is sent as the reason of an ErrorInfo detail.
+var grpcCodes = map[string]codes.Code{
+	codeBadRequest:          codes.InvalidArgument,
+	codeImageInvalid:        codes.InvalidArgument,
+	codeNotFound:            codes.NotFound,
+	codeContainerNotFound:   codes.NotFound,
+	codeRollbackNotFound:    codes.NotFound,
+	codeStartFailed:         codes.Aborted,
+	codeContainerNotRunning: codes.Aborted,
+	codeRestoreFailed:       codes.Internal,
+	codeDockerUnavailable:   codes.Unavailable,
+	codeTimeout:             codes.DeadlineExceeded,
+	codeCancelled:           codes.Canceled,
+	codeInvalidCredentials:  codes.Unauthenticated,
+	codePermissionDenied:    codes.PermissionDenied,
+	codeAddressNotAllowed:   codes.PermissionDenied,
+	codeRateLimited:         codes.ResourceExhausted,
+	codeLockedOut:           codes.ResourceExhausted,
+	codeInternal:            codes.Internal,
+}
@@ -0,0 +1,269 @@
+package app
+
+import (
+	"context"
+	"github.com/XiovV/dokkup-agent/auth"
+	"github.com/XiovV/dokkup-agent/logging"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	grpccredentials "google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"net"
+	"runtime/debug"
+	"time"
+)
+
+// grpcMethod is the permission and route class of a gRPC method.
+type grpcMethod struct {
+	permission string
+	class      string
+}
+
+var grpcMethods = map[string]grpcMethod{
+	"/dokkup.agent.v1.Agent/GetContainer":      {auth.PermissionContainersRead, routeClassRead},
+	"/dokkup.agent.v1.Agent/ListContainers":    {auth.PermissionContainersRead, routeClassRead},
+	"/dokkup.agent.v1.Agent/UpdateContainer":   {auth.PermissionContainersUpdate, routeClassMutate},
+	"/dokkup.agent.v1.Agent/RollbackContainer": {auth.PermissionContainersRollback, routeClassMutate},
+	"/dokkup.agent.v1.Agent/PullImage":         {auth.PermissionImagesPull, routeClassMutate},
+	"/dokkup.agent.v1.Agent/ContainerLogs":     {auth.PermissionContainersLogs, routeClassRead},
+	"/dokkup.agent.v1.Agent/Events":            {auth.PermissionContainersRead, routeClassRead},
+}
+
+type principalKey struct{}
+
+// grpcPrincipal is the authenticated caller of a gRPC method.
+type grpcPrincipal struct {
+	auth.Principal
+
+	clientIP string
+}
+
+func (app *App) unaryInterceptor(ctx context.Context, request interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (response interface{}, err error) {
+	ctx, err = app.guard(ctx, info.FullMethod)
+	if err != nil {
+		app.logGRPCCall(ctx, info.FullMethod, time.Now(), err)
+		return nil, err
+	}
+
+	start := time.Now()
+	defer func() { app.logGRPCCall(ctx, info.FullMethod, start, err) }()
+	defer app.recoverGRPC(ctx, info.FullMethod, &err)
+
+	return handler(ctx, request)
+}
+
+func (app *App) streamInterceptor(server interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) (err error) {
+	ctx, err := app.guard(stream.Context(), info.FullMethod)
+	if err != nil {
+		app.logGRPCCall(ctx, info.FullMethod, time.Now(), err)
+		return err
+	}
+
+	start := time.Now()
+	defer func() { app.logGRPCCall(ctx, info.FullMethod, start, err) }()
+	defer app.recoverGRPC(ctx, info.FullMethod, &err)
+
+	return handler(server, &guardedStream{ServerStream: stream, ctx: ctx})
+}
+
+// guardedStream replaces the context of a stream with the one returned by guard.
+type guardedStream struct {
+	grpc.ServerStream
+
+	ctx context.Context
+}
+
+func (s *guardedStream) Context() context.Context {
+	return s.ctx
+}
+
+// guard applies the checks the REST API's middleware applies to every request: the client allowlist,
+// the lockout and rate limits, authentication, permissions and the docker daemon's availability.
+// It returns the call's context carrying its request id and the authenticated principal.
+func (app *App) guard(ctx context.Context, fullMethod string) (context.Context, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+
+	requestID := firstMetadata(md, "x-request-id")
+	if !validRequestID.MatchString(requestID) {
+		requestID = logging.NewID()
+	}
+	ctx = logging.WithRequestID(ctx, requestID)
+	_ = grpc.SetHeader(ctx, metadata.Pairs("x-request-id", requestID))
+
+	principal := grpcPrincipal{}
+	var creds credentials
+
+	if p, ok := peer.FromContext(ctx); ok {
+		principal.clientIP = p.Addr.String()
+		if host, _, err := net.SplitHostPort(principal.clientIP); err == nil {
+			principal.clientIP = host
+		}
+
+		if p.Addr.Network() == "unix" {
+			principal.clientIP = unixClient
+		}
+
+		if tlsInfo, ok := p.AuthInfo.(grpccredentials.TLSInfo); ok {
+			creds.clientCertificate = verifiedClientCertificate(tlsInfo.State)
+		}
+	}
+	ctx = context.WithValue(ctx, principalKey{}, principal)
+
+	method, ok := grpcMethods[fullMethod]
+	if !ok {
+		return ctx, grpcError(codeNotFound, "unknown method", nil)
+	}
+
+	s := app.settings()
+
+	if len(s.allowedNetworks) > 0 && principal.clientIP != unixClient {
+		ip := net.ParseIP(principal.clientIP)
+		if ip == nil || !containsIP(s.allowedNetworks, ip) {
+			app.grpcLogger(ctx).WithField("method", fullMethod).Warn("denied request from an address which is not allowed")
+			return ctx, grpcError(codeAddressNotAllowed, "address is not allowed", nil)
+		}
+	}
+
+	if lockedFor := s.limiters.lockout.LockedFor(principal.clientIP); lockedFor > 0 {
+		return ctx, grpcRetryError(codeLockedOut, "too many failed authentication attempts", lockedFor)
+	}
+
+	if ok, retryAfter := s.limiters.client[method.class].Allow(principal.clientIP); !ok {
+		return ctx, grpcRetryError(codeRateLimited, "rate limit exceeded", retryAfter)
+	}
+
+	creds.apiKey = firstMetadata(md, "key")
+	creds.bearerToken = bearerToken(firstMetadata(md, "authorization"))
+
+	authenticated, message, ok := app.authenticate(principal.clientIP, creds)
+	if !ok {
+		return ctx, grpcError(codeInvalidCredentials, message, nil)
+	}
+
+	principal.Principal = authenticated
+	ctx = context.WithValue(ctx, principalKey{}, principal)
+
+	if !principal.HasPermission(method.permission) {
+		return ctx, grpcError(codePermissionDenied, "insufficient permissions", nil)
+	}
+
+	if ok, retryAfter := s.limiters.key[method.class].Allow(principal.Method + ":" + principal.Name); !ok {
+		return ctx, grpcRetryError(codeRateLimited, "rate limit exceeded", retryAfter)
+	}
+
+	if !app.controller.Available() {
+		return ctx, grpcError(codeDockerUnavailable, "the docker daemon is unreachable", nil)
+	}
+
+	return ctx, nil
+}
+
+// recoverGRPC turns a panic in a handler into an INTERNAL_ERROR and logs the panic along with its stack trace.
+func (app *App) recoverGRPC(ctx context.Context, fullMethod string, err *error) {
+	recovered := recover()
+	if recovered == nil {
+		return
+	}
+
+	app.grpcLogger(ctx).
+		WithField("method", fullMethod).
+		WithField("panic", recovered).
+		WithField("stack", string(debug.Stack())).
+		Error("recovered from panic")
+
+	*err = grpcError(codeInternal, "internal server error", nil)
+}
+
+// grpcLogger returns a logger whose lines carry the call's correlation id and client IP.
+func (app *App) grpcLogger(ctx context.Context) logrus.FieldLogger {
+	principal, _ := ctx.Value(principalKey{}).(grpcPrincipal)
+
+	return app.log.WithFields(logrus.Fields{
+		"request_id": logging.RequestID(ctx),
+		"client_ip":  principal.clientIP,
+	})
+}
+
+// logGRPCCall writes an access log line for a gRPC call once it has been handled.
+func (app *App) logGRPCCall(ctx context.Context, fullMethod string, start time.Time, err error) {
+	code := status.Code(err)
+
+	log := app.grpcLogger(ctx).WithFields(logrus.Fields{
+		"method":     fullMethod,
+		"grpc_code":  code.String(),
+		"latency_ms": time.Since(start).Milliseconds(),
+	})
+
+	if err != nil {
+		log = log.WithError(err)
+	}
+
+	switch code {
+	case codes.OK:
+		log.Info("call handled")
+	case codes.Internal, codes.Unknown, codes.DataLoss:
+		log.Error("call failed")
+	default:
+		log.Warn("call rejected")
+	}
+}
+
+func firstMetadata(md metadata.MD, key string) string {
+	if values := md.Get(key); len(values) > 0 {
+		return values[0]
+	}
+
+	return ""
+}
+
+// grpcError returns a status error with the gRPC code matching code. The code itself, the operation id
+// and any details are sent in an ErrorInfo, so clients can branch on the same codes as REST clients.
+func grpcError(code, message string, details map[string]string) error {
+	st := status.New(grpcCodes[code], message)
+
+	detailed, err := st.WithDetails(&errdetails.ErrorInfo{Reason: code, Domain: "dokkup-agent", Metadata: details})
+	if err != nil {
+		return st.Err()
+	}
+
+	return detailed.Err()
+}
+
+// grpcRetryError is like grpcError, but also tells the client how long it should wait before retrying.
+func grpcRetryError(code, message string, retryAfter time.Duration) error {
+	st := status.New(grpcCodes[code], message)
+
+	detailed, err := st.WithDetails(
+		&errdetails.ErrorInfo{Reason: code, Domain: "dokkup-agent"},
+		&errdetails.RetryInfo{RetryDelay: durationpb.New(retryAfter)},
+	)
+	if err != nil {
+		return st.Err()
+	}
+
+	return detailed.Err()
+}
+
+// grpcOperationError returns the status error for an error returned by the controller, classified
+// the same way as in the REST API.
+func grpcOperationError(ctx context.Context, err error) error {
+	opErr := classifyOperationError(err)
+
+	details := map[string]string{}
+	if operationID := logging.OperationID(ctx); operationID != "" {
+		details["operation_id"] = operationID
+	}
+
+	for key, value := range opErr.details {
+		if s, ok := value.(string); ok {
+			details[key] = s
+		}
+	}
+
+	return grpcError(opErr.code, opErr.message, details)
+}
@@ -0,0 +1,278 @@
+package app
+
+import (
+	"context"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"github.com/XiovV/dokkup-agent/agentpb"
+	"github.com/XiovV/dokkup-agent/audit"
+	"github.com/XiovV/dokkup-agent/auth"
+	"github.com/XiovV/dokkup-agent/config"
+	"github.com/XiovV/dokkup-agent/controller"
+	"github.com/docker/docker/api/types"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"io"
+	"io/ioutil"
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newGRPCTestClient serves app's gRPC API over an in-memory connection and returns a client for it.
+func newGRPCTestClient(t *testing.T, app *App) agentpb.AgentClient {
+	listener := bufconn.Listen(1024 * 1024)
+
+	server := app.GRPCServer()
+	go func() {
+		_ = server.Serve(listener)
+	}()
+	t.Cleanup(server.Stop)
+
+	conn, err := grpc.Dial("bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return listener.DialContext(ctx) }),
+		grpc.WithInsecure(),
+	)
+	assert.Nil(t, err)
+	t.Cleanup(func() { _ = conn.Close() })
+
+	return agentpb.NewAgentClient(conn)
+}
+
+// errorInfo returns the gRPC code of err and the ErrorInfo detail attached to it.
+func errorInfo(t *testing.T, err error) (codes.Code, *errdetails.ErrorInfo) {
+	st := status.Convert(err)
+
+	for _, detail := range st.Details() {
+		if info, ok := detail.(*errdetails.ErrorInfo); ok {
+			return st.Code(), info
+		}
+	}
+
+	t.Errorf("%v has no ErrorInfo", err)
+	return st.Code(), &errdetails.ErrorInfo{}
+}
+
+func TestGRPC(t *testing.T) {
+	defer removeConfig(t)
+	cfg, apiKey, err := config.New(testConfigFilename)
+	assert.Nil(t, err)
+
+	auditLog, err := audit.Open(filepath.Join(t.TempDir(), "audit.jsonl"))
+	assert.Nil(t, err)
+
+	mockController := new(mockDockerController)
+	client := newGRPCTestClient(t, New(mockController, cfg, nil, auditLog, testLogger()))
+
+	ctx := metadata.AppendToOutgoingContext(context.Background(), "key", apiKey)
+
+	t.Run("Get container", func(t *testing.T) {
+		mockController.On("FindContainerByName", "web").Return(types.Container{ID: "abc", Image: "web:1.0", State: "running"}, true).Once()
+
+		container, err := client.GetContainer(ctx, &agentpb.GetContainerRequest{Name: "web"})
+		assert.Nil(t, err)
+
+		assert.Equal(t, "web", container.Name)
+		assert.Equal(t, "abc", container.Id)
+		assert.Equal(t, "web:1.0", container.Image)
+	})
+
+	t.Run("Get a non-existent container", func(t *testing.T) {
+		mockController.On("FindContainerByName", "db").Return(types.Container{}, false).Once()
+
+		_, err := client.GetContainer(ctx, &agentpb.GetContainerRequest{Name: "db"})
+
+		code, info := errorInfo(t, err)
+		assert.Equal(t, codes.NotFound, code)
+		assert.Equal(t, codeContainerNotFound, info.Reason)
+	})
+
+	t.Run("List containers", func(t *testing.T) {
+		mockController.On("ListContainers").Return([]types.Container{{Names: []string{"/web"}, ID: "abc"}, {Names: []string{"/db"}, ID: "def"}}, nil).Once()
+
+		response, err := client.ListContainers(ctx, &agentpb.ListContainersRequest{})
+		assert.Nil(t, err)
+
+		assert.Len(t, response.Containers, 2)
+		assert.Equal(t, "db", response.Containers[1].Name)
+	})
+
+	t.Run("Update container", func(t *testing.T) {
+		mockController.On("UpdateContainer", "web", "web:1.1", true).
+			Return(controller.ContainerChange{ContainerName: "web", OldImage: "web:1.0", NewImage: "web:1.1"}, nil).Once()
+
+		change, err := client.UpdateContainer(ctx, &agentpb.UpdateContainerRequest{Name: "web", Image: "web:1.1", Keep: true})
+		assert.Nil(t, err)
+
+		assert.Equal(t, "web:1.0", change.OldImage)
+		assert.Equal(t, "web:1.1", change.NewImage)
+	})
+
+	t.Run("Update fails to start the container", func(t *testing.T) {
+		mockController.On("UpdateContainer", "web", "web:1.2", false).
+			Return(controller.ContainerChange{}, controller.ErrContainerStartFailed{ContainerId: "abc", Reason: errors.New("port is already allocated")}).Once()
+
+		_, err := client.UpdateContainer(ctx, &agentpb.UpdateContainerRequest{Name: "web", Image: "web:1.2"})
+
+		code, info := errorInfo(t, err)
+		assert.Equal(t, codes.Aborted, code)
+		assert.Equal(t, codeStartFailed, info.Reason)
+		assert.Equal(t, "port is already allocated", info.Metadata["reason"])
+		assert.NotEmpty(t, info.Metadata["operation_id"])
+	})
+
+	t.Run("Update without an image", func(t *testing.T) {
+		_, err := client.UpdateContainer(ctx, &agentpb.UpdateContainerRequest{Name: "web"})
+
+		code, info := errorInfo(t, err)
+		assert.Equal(t, codes.InvalidArgument, code)
+		assert.Equal(t, codeBadRequest, info.Reason)
+	})
+
+	t.Run("Pull image", func(t *testing.T) {
+		mockController.On("PullImageWithProgress", "web:1.3").Return([]controller.PullProgress{
+			{LayerID: "a1", Status: "Downloading", Current: 512, Total: 1024},
+			{LayerID: "a1", Status: "Pull complete"},
+		}, nil).Once()
+
+		stream, err := client.PullImage(ctx, &agentpb.PullImageRequest{Image: "web:1.3"})
+		assert.Nil(t, err)
+
+		var progress []*agentpb.PullProgress
+		for {
+			message, err := stream.Recv()
+			if err == io.EOF {
+				break
+			}
+			if !assert.Nil(t, err) {
+				break
+			}
+
+			progress = append(progress, message)
+		}
+
+		if !assert.Len(t, progress, 2) {
+			return
+		}
+		assert.Equal(t, int64(512), progress[0].Current)
+		assert.Equal(t, "Pull complete", progress[1].Status)
+	})
+
+	t.Run("Container logs", func(t *testing.T) {
+		mockController.On("ContainerLogs", "web", controller.LogsOptions{Tail: "all", Follow: true}).
+			Return(ioutil.NopCloser(strings.NewReader("line 1\nline 2\n")), nil).Once()
+
+		stream, err := client.ContainerLogs(ctx, &agentpb.ContainerLogsRequest{Name: "web", Follow: true})
+		assert.Nil(t, err)
+
+		var logs []byte
+		for {
+			chunk, err := stream.Recv()
+			if err == io.EOF {
+				break
+			}
+			if !assert.Nil(t, err) {
+				break
+			}
+
+			logs = append(logs, chunk.Data...)
+		}
+
+		assert.Equal(t, "line 1\nline 2\n", string(logs))
+	})
+
+	t.Run("Events", func(t *testing.T) {
+		events := make(chan controller.Event, 1)
+		events <- controller.Event{Time: time.Unix(1600000000, 0), Type: "container", Action: "start", ID: "abc", Name: "web"}
+		close(events)
+
+		mockController.On("Events").Return((<-chan controller.Event)(events), (<-chan error)(make(chan error))).Once()
+
+		stream, err := client.Events(ctx, &agentpb.EventsRequest{})
+		assert.Nil(t, err)
+
+		event, err := stream.Recv()
+		assert.Nil(t, err)
+		assert.Equal(t, "start", event.Action)
+		assert.Equal(t, int64(1600000000), event.Time.Seconds)
+
+		_, err = stream.Recv()
+		assert.Equal(t, io.EOF, err)
+	})
+
+	t.Run("Invalid api key", func(t *testing.T) {
+		ctx := metadata.AppendToOutgoingContext(context.Background(), "key", "invalid")
+
+		_, err := client.GetContainer(ctx, &agentpb.GetContainerRequest{Name: "web"})
+
+		code, info := errorInfo(t, err)
+		assert.Equal(t, codes.Unauthenticated, code)
+		assert.Equal(t, codeInvalidCredentials, info.Reason)
+	})
+
+	t.Run("Docker unavailable", func(t *testing.T) {
+		mockController.dockerUnavailable = true
+		defer func() { mockController.dockerUnavailable = false }()
+
+		_, err := client.RollbackContainer(ctx, &agentpb.RollbackContainerRequest{Name: "web"})
+		assert.Equal(t, codes.Unavailable, status.Code(err))
+	})
+
+	t.Run("Mutations are audited", func(t *testing.T) {
+		entries, err := auditLog.Query(audit.Filter{Action: auditActionUpdate})
+		assert.Nil(t, err)
+
+		assert.Len(t, entries, 2)
+		assert.Equal(t, audit.OutcomeFailure, entries[0].Outcome)
+		assert.Equal(t, 500, entries[0].Status)
+		assert.Equal(t, auth.MethodAPIKey, entries[1].AuthMethod)
+		assert.Equal(t, "web:1.0", entries[1].OldImage)
+	})
+}
+
+func TestAuthenticateClientCertificate(t *testing.T) {
+	defer removeConfig(t)
+	cfg, _, err := config.New(testConfigFilename)
+	assert.Nil(t, err)
+
+	certificate := &x509.Certificate{Subject: pkix.Name{CommonName: "deployer"}}
+
+	t.Run("All permissions by default", func(t *testing.T) {
+		app := New(new(mockDockerController), cfg, nil, nil, testLogger())
+
+		principal, _, ok := app.authenticate("10.0.0.2", credentials{clientCertificate: certificate})
+		assert.True(t, ok)
+
+		assert.Equal(t, "deployer", principal.Name)
+		assert.Equal(t, auth.MethodMTLS, principal.Method)
+		assert.True(t, principal.HasPermission(auth.PermissionContainersUpdate))
+	})
+
+	t.Run("Configured permissions", func(t *testing.T) {
+		cfg.TLS = &config.TLSConfig{ClientPermissions: map[string][]string{"deployer": {auth.PermissionContainersRead}}}
+		defer func() { cfg.TLS = nil }()
+
+		app := New(new(mockDockerController), cfg, nil, nil, testLogger())
+
+		principal, _, ok := app.authenticate("10.0.0.2", credentials{clientCertificate: certificate})
+		assert.True(t, ok)
+
+		assert.True(t, principal.HasPermission(auth.PermissionContainersRead))
+		assert.False(t, principal.HasPermission(auth.PermissionContainersUpdate))
+	})
+
+	t.Run("An invalid api key isn't overridden by the certificate", func(t *testing.T) {
+		app := New(new(mockDockerController), cfg, nil, nil, testLogger())
+
+		_, _, ok := app.authenticate("10.0.0.2", credentials{apiKey: "invalid", clientCertificate: certificate})
+		assert.False(t, ok)
+	})
+}
@@ -0,0 +1,89 @@
+package app
+
+import (
+	"github.com/XiovV/dokkup-agent/version"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"path/filepath"
+	"runtime"
+)
+
+const checkOK = "ok"
+
+// Healthz reports that the process is up. It doesn't check any dependencies.
+func (app *App) Healthz(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"status": checkOK})
+}
+
+// Readyz reports whether the agent can serve requests: the docker daemon must be
+// reachable, the config must be loaded and the audit log must be writable.
+func (app *App) Readyz(c *gin.Context) {
+	checks := gin.H{"docker": checkOK, "config": checkOK, "journal": checkOK}
+	ready := true
+
+	if !app.controller.Available() {
+		checks["docker"] = "docker daemon is unreachable"
+		ready = false
+	}
+
+	if app.settings().config == nil {
+		checks["config"] = "config is not loaded"
+		ready = false
+	}
+
+	if app.auditLog != nil {
+		if err := app.auditLog.Healthy(); err != nil {
+			checks["journal"] = err.Error()
+			ready = false
+		}
+	}
+
+	if !ready {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"status": "not ready", "checks": checks})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"status": "ready", "checks": checks})
+}
+
+// GetInfo describes the agent, the docker engine and the host, for fleet inventories.
+// The docker section is replaced by an error while the daemon is unreachable.
+func (app *App) GetInfo(c *gin.Context) {
+	info := gin.H{
+		"version":    version.Version,
+		"commit":     version.Commit,
+		"go_version": runtime.Version(),
+		"host": gin.H{
+			"os":   runtime.GOOS,
+			"arch": runtime.GOARCH,
+			"cpus": runtime.NumCPU(),
+		},
+	}
+
+	engine, err := app.controller.EngineInfo(c.Request.Context())
+	if err != nil {
+		app.requestLogger(c).WithError(err).Warn("couldn't get docker engine info")
+		info["docker"] = gin.H{"error": "docker daemon is unreachable"}
+	} else {
+		info["docker"] = gin.H{
+			"version":          engine.Version,
+			"api_version":      engine.APIVersion,
+			"operating_system": engine.OperatingSystem,
+			"os_type":          engine.OSType,
+			"architecture":     engine.Architecture,
+			"kernel_version":   engine.KernelVersion,
+			"cpus":             engine.CPUs,
+			"memory_bytes":     engine.MemoryBytes,
+		}
+	}
+
+	dataDir := filepath.Dir(app.settings().config.AuditLog)
+	free, total, err := diskUsage(dataDir)
+	if err != nil {
+		info["disk"] = gin.H{"path": dataDir, "error": err.Error()}
+	} else {
+		info["disk"] = gin.H{"path": dataDir, "free_bytes": free, "total_bytes": total}
+	}
+
+	c.JSON(http.StatusOK, info)
+}
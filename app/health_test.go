@@ -0,0 +1,151 @@
+package app
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/XiovV/dokkup-agent/audit"
+	"github.com/XiovV/dokkup-agent/config"
+	"github.com/XiovV/dokkup-agent/controller"
+	"github.com/XiovV/dokkup-agent/version"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+)
+
+func TestHealthz(t *testing.T) {
+	defer removeConfig(t)
+	cfg, _, err := config.New(testConfigFilename)
+	assert.Nil(t, err)
+
+	app := New(&mockDockerController{dockerUnavailable: true}, cfg, nil, nil, testLogger())
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/healthz", nil)
+	app.Router().ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+}
+
+func TestReadyz(t *testing.T) {
+	defer removeConfig(t)
+	cfg, _, err := config.New(testConfigFilename)
+	assert.Nil(t, err)
+
+	auditLog, err := audit.Open(filepath.Join(t.TempDir(), "audit.jsonl"))
+	assert.Nil(t, err)
+
+	mockController := new(mockDockerController)
+
+	router := New(mockController, cfg, nil, auditLog, testLogger()).Router()
+
+	var response struct {
+		Status string            `json:"status"`
+		Checks map[string]string `json:"checks"`
+	}
+
+	sendReadyz := func() *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/readyz", nil)
+		router.ServeHTTP(w, req)
+
+		return w
+	}
+
+	t.Run("Ready", func(t *testing.T) {
+		w := sendReadyz()
+
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		err = json.NewDecoder(w.Body).Decode(&response)
+		assert.Nil(t, err)
+
+		assert.Equal(t, "ready", response.Status)
+		assert.Equal(t, map[string]string{"docker": "ok", "config": "ok", "journal": "ok"}, response.Checks)
+	})
+
+	t.Run("Docker unreachable", func(t *testing.T) {
+		mockController.dockerUnavailable = true
+		defer func() { mockController.dockerUnavailable = false }()
+
+		w := sendReadyz()
+
+		assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+
+		err = json.NewDecoder(w.Body).Decode(&response)
+		assert.Nil(t, err)
+
+		assert.Equal(t, "not ready", response.Status)
+		assert.Equal(t, "docker daemon is unreachable", response.Checks["docker"])
+	})
+
+	t.Run("Audit log not writable", func(t *testing.T) {
+		assert.Nil(t, auditLog.Close())
+		assert.NotNil(t, auditLog.Record(audit.Entry{Action: "pull"}))
+
+		w := sendReadyz()
+
+		assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+
+		err = json.NewDecoder(w.Body).Decode(&response)
+		assert.Nil(t, err)
+
+		assert.NotEqual(t, "ok", response.Checks["journal"])
+	})
+}
+
+func TestGetInfo(t *testing.T) {
+	defer removeConfig(t)
+	cfg, apiKey, err := config.New(testConfigFilename)
+	assert.Nil(t, err)
+
+	mockController := new(mockDockerController)
+
+	router := New(mockController, cfg, nil, nil, testLogger()).Router()
+
+	var response struct {
+		Version string                 `json:"version"`
+		Commit  string                 `json:"commit"`
+		Docker  map[string]interface{} `json:"docker"`
+		Host    map[string]interface{} `json:"host"`
+		Disk    map[string]interface{} `json:"disk"`
+	}
+
+	t.Run("Docker reachable", func(t *testing.T) {
+		mockController.On("EngineInfo").Return(controller.EngineInfo{Version: "20.10.8", APIVersion: "1.41", CPUs: 4}, nil).Once()
+
+		w := sendRequest(router, "GET", "/v1/info", apiKey)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		err = json.NewDecoder(w.Body).Decode(&response)
+		assert.Nil(t, err)
+
+		assert.Equal(t, version.Version, response.Version)
+		assert.Equal(t, version.Commit, response.Commit)
+		assert.Equal(t, "20.10.8", response.Docker["version"])
+		assert.Equal(t, "1.41", response.Docker["api_version"])
+		assert.NotEmpty(t, response.Host["os"])
+		assert.NotEmpty(t, response.Disk["path"])
+	})
+
+	t.Run("Docker unreachable", func(t *testing.T) {
+		mockController.On("EngineInfo").Return(controller.EngineInfo{}, errors.New("connection refused")).Once()
+
+		w := sendRequest(router, "GET", "/v1/info", apiKey)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		err = json.NewDecoder(w.Body).Decode(&response)
+		assert.Nil(t, err)
+
+		assert.Equal(t, "docker daemon is unreachable", response.Docker["error"])
+	})
+
+	t.Run("Without api key", func(t *testing.T) {
+		w := sendRequest(router, "GET", "/v1/info", "")
+
+		assert.Equal(t, http.StatusForbidden, w.Code)
+	})
+}
@@ -1,45 +1,71 @@
 package app
 
 import (
-	"errors"
-	"github.com/XiovV/dokkup-agent/controller"
 	"github.com/gin-gonic/gin"
 	"net/http"
+	"strings"
 )
 
 func (app *App) GetContainerImage(c *gin.Context) {
-	containerName := c.Param("containerName")
+	containerName := strings.TrimPrefix(c.Param("containerName"), "/")
 
 	if containerName == "" {
-		app.notFoundErrorResponse(c, "container not found")
+		app.notFoundErrorResponse(c, codeContainerNotFound, "container not found")
 		return
 	}
 
-	container, ok := app.controller.FindContainerByName(containerName)
+	container, ok := app.controller.FindContainerByName(c.Request.Context(), containerName)
 	if !ok {
-		app.notFoundErrorResponse(c, "container not found")
+		app.notFoundErrorResponse(c, codeContainerNotFound, "container not found")
 		return
 	}
 
 	c.JSON(http.StatusOK, gin.H{"image": container.Image})
 }
 
+// PullImage is the v1 pull handler, which takes the image from the query string.
 func (app *App) PullImage(c *gin.Context) {
 	image := c.Query("image")
 
 	if image == "" {
-		app.badRequestResponse(c, "image value must not be empty")
+		app.badRequestResponse(c, codeBadRequest, "image value must not be empty")
 		return
 	}
 
-	err := app.controller.PullImage(image)
+	app.pullImage(c, image)
+}
+
+// PullImageV2 handles POST /v2/images/pull with a pullRequest body.
+func (app *App) PullImageV2(c *gin.Context) {
+	var request pullRequest
+	if !app.bindJSON(c, &request) {
+		return
+	}
+
+	app.pullImage(c, request.Image)
+}
+
+// pullImage pulls the image, or with dry_run=true only responds with the plan of the pull.
+func (app *App) pullImage(c *gin.Context, image string) {
+	dryRun, ok := app.dryRun(c)
+	if !ok {
+		return
+	}
+
+	if dryRun {
+		plan, err := app.controller.PlanPull(c.Request.Context(), image)
+		app.planResponse(c, plan, err)
+		return
+	}
+
+	c.Set(auditImageContextKey, image)
+
+	ctx := app.operationContext(c)
+	defer app.operations.track(ctx, auditActionPull, image)()
+
+	err := app.controller.PullImage(ctx, image)
 	if err != nil {
-		switch {
-		case errors.Is(err, controller.ErrImageFormatInvalid):
-			app.badRequestResponse(c, "image format is invalid")
-		default:
-			app.internalErrorResponse(c, err.Error())
-		}
+		app.operationErrorResponse(c, err)
 		return
 	}
 
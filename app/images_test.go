@@ -1,14 +1,18 @@
 package app
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"github.com/XiovV/dokkup-agent/config"
 	"github.com/XiovV/dokkup-agent/controller"
 	"github.com/docker/docker/api/types"
 	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
+	"io"
+	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
 	"os"
@@ -19,21 +23,78 @@ const testConfigFilename = "../config_test.json"
 
 type mockDockerController struct {
 	mock.Mock
+
+	dockerUnavailable bool
+
+	// registries are the credentials passed to SetRegistryCredentials.
+	registries map[string]controller.RegistryCredentials
+}
+
+func (m *mockDockerController) Available() bool {
+	return !m.dockerUnavailable
+}
+
+func (m *mockDockerController) SetRegistryCredentials(credentials map[string]controller.RegistryCredentials) {
+	m.registries = credentials
+}
+
+func (m *mockDockerController) ListContainers(ctx context.Context) ([]types.Container, error) {
+	args := m.Called()
+
+	return args.Get(0).([]types.Container), args.Error(1)
+}
+
+func (m *mockDockerController) ContainerLogs(ctx context.Context, containerName string, opts controller.LogsOptions) (io.ReadCloser, error) {
+	args := m.Called(containerName, opts)
+
+	logs, _ := args.Get(0).(io.ReadCloser)
+	return logs, args.Error(1)
+}
+
+func (m *mockDockerController) PullImageWithProgress(ctx context.Context, image string, progress func(controller.PullProgress)) error {
+	args := m.Called(image)
+
+	if updates, ok := args.Get(0).([]controller.PullProgress); ok {
+		for _, update := range updates {
+			progress(update)
+		}
+	}
+
+	return args.Error(1)
 }
 
-func (m *mockDockerController) PullImage(image string) error {
+func (m *mockDockerController) Events(ctx context.Context) (<-chan controller.Event, <-chan error) {
+	args := m.Called()
+
+	return args.Get(0).(<-chan controller.Event), args.Get(1).(<-chan error)
+}
+
+func (m *mockDockerController) EngineInfo(ctx context.Context) (controller.EngineInfo, error) {
+	args := m.Called()
+
+	return args.Get(0).(controller.EngineInfo), args.Error(1)
+}
+
+func (m *mockDockerController) PullImage(ctx context.Context, image string) error {
 	args := m.Called(image)
 
 	return args.Error(0)
 }
 
-func (m *mockDockerController) FindContainerByName(containerName string) (types.Container, bool) {
+func (m *mockDockerController) FindContainerByName(ctx context.Context, containerName string) (types.Container, bool) {
 	args := m.Called(containerName)
 
 	container := args.Get(0)
 	return container.(types.Container), args.Bool(1)
 }
 
+func testLogger() *logrus.Logger {
+	logger := logrus.New()
+	logger.SetOutput(ioutil.Discard)
+
+	return logger
+}
+
 func sendRequest(router *gin.Engine, method, location, apiKey string) *httptest.ResponseRecorder {
 	w := httptest.NewRecorder()
 	req, _ := http.NewRequest(method, location, nil)
@@ -50,7 +111,7 @@ func TestPullImage(t *testing.T) {
 
 	mockController := new(mockDockerController)
 
-	app := New(mockController, cfg)
+	app := New(mockController, cfg, nil, nil, testLogger())
 
 	router := app.Router()
 
@@ -60,6 +121,7 @@ func TestPullImage(t *testing.T) {
 
 	var errorResponse struct {
 		Error string `json:"error"`
+		Code  string `json:"code"`
 	}
 
 	t.Run("Valid image name", func(t *testing.T) {
@@ -86,6 +148,7 @@ func TestPullImage(t *testing.T) {
 		assert.Nil(t, err)
 
 		assert.Equal(t, "image format is invalid", errorResponse.Error)
+		assert.Equal(t, "IMAGE_INVALID", errorResponse.Code)
 	})
 
 	t.Run("Image without tag", func(t *testing.T) {
@@ -99,6 +162,7 @@ func TestPullImage(t *testing.T) {
 		assert.Nil(t, err)
 
 		assert.Equal(t, "image format is invalid", errorResponse.Error)
+		assert.Equal(t, "IMAGE_INVALID", errorResponse.Code)
 	})
 
 
@@ -123,7 +187,21 @@ func TestPullImage(t *testing.T) {
 		err = json.NewDecoder(w.Body).Decode(&errorResponse)
 		assert.Nil(t, err)
 
-		assert.Equal(t, "some unknown error", errorResponse.Error)
+		assert.Equal(t, "internal server error", errorResponse.Error)
+		assert.Equal(t, "INTERNAL_ERROR", errorResponse.Code)
+	})
+
+	t.Run("Pull timed out", func(t *testing.T) {
+		mockController.On("PullImage", "imageName:latest").Return(controller.ErrOperationAborted{Step: "pull", Reason: context.DeadlineExceeded}).Once()
+
+		w := sendRequest(router, "PUT", "/v1/images/pull?image=imageName:latest", apiKey)
+
+		assert.Equal(t, http.StatusGatewayTimeout, w.Code)
+
+		err = json.NewDecoder(w.Body).Decode(&errorResponse)
+		assert.Nil(t, err)
+
+		assert.Equal(t, "the operation timed out", errorResponse.Error)
 	})
 
 	t.Run("Without image query parameter", func(t *testing.T) {
@@ -145,7 +223,7 @@ func TestGetContainerImage(t *testing.T) {
 
 	mockController := new(mockDockerController)
 
-	app := New(mockController, cfg)
+	app := New(mockController, cfg, nil, nil, testLogger())
 
 	router := app.Router()
 
@@ -155,6 +233,7 @@ func TestGetContainerImage(t *testing.T) {
 
 	var errorResponse struct {
 		Error string `json:"error"`
+		Code  string `json:"code"`
 	}
 
 	t.Run("Valid container name", func(t *testing.T) {
@@ -0,0 +1,84 @@
+package app
+
+import (
+	"context"
+	"github.com/XiovV/dokkup-agent/logging"
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+	"regexp"
+	"time"
+)
+
+const (
+	requestIDHeader       = "X-Request-ID"
+	requestIDContextKey   = "request_id"
+	operationIDContextKey = "operation_id"
+)
+
+// validRequestID limits which client supplied request ids are accepted, so they can be
+// safely written to logs and response headers.
+var validRequestID = regexp.MustCompile(`^[A-Za-z0-9._:-]{1,128}$`)
+
+// RequestID takes the correlation id from the X-Request-ID header, or generates a new one
+// if the header is missing or invalid, and echoes it back in the response.
+func (app *App) RequestID() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		requestID := c.GetHeader(requestIDHeader)
+		if !validRequestID.MatchString(requestID) {
+			requestID = logging.NewID()
+		}
+
+		c.Set(requestIDContextKey, requestID)
+		c.Header(requestIDHeader, requestID)
+		c.Request = c.Request.WithContext(logging.WithRequestID(c.Request.Context(), requestID))
+
+		c.Next()
+	}
+}
+
+// operationContext assigns a new operation id to the request and returns the request's context
+// carrying it, so the id the controller logs with can be returned in error responses.
+func (app *App) operationContext(c *gin.Context) context.Context {
+	operationID := logging.NewID()
+	c.Set(operationIDContextKey, operationID)
+
+	return logging.WithOperationID(c.Request.Context(), operationID)
+}
+
+// requestLogger returns a logger whose lines carry the request's correlation id and client IP.
+func (app *App) requestLogger(c *gin.Context) logrus.FieldLogger {
+	return app.log.WithFields(logrus.Fields{
+		"request_id": c.GetString(requestIDContextKey),
+		"client_ip":  clientIP(c),
+	})
+}
+
+// Logger writes an access log line for every request once it has been handled.
+func (app *App) Logger() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+
+		c.Next()
+
+		log := app.requestLogger(c).WithFields(logrus.Fields{
+			"method":     c.Request.Method,
+			"path":       c.Request.URL.Path,
+			"route":      c.FullPath(),
+			"status":     c.Writer.Status(),
+			"latency_ms": time.Since(start).Milliseconds(),
+		})
+
+		if err := c.Errors.Last(); err != nil {
+			log = log.WithError(err)
+		}
+
+		switch status := c.Writer.Status(); {
+		case status >= 500:
+			log.Error("request failed")
+		case status >= 400:
+			log.Warn("request rejected")
+		default:
+			log.Info("request handled")
+		}
+	}
+}
@@ -0,0 +1,70 @@
+package app
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/XiovV/dokkup-agent/config"
+	"github.com/XiovV/dokkup-agent/logging"
+	"github.com/docker/docker/api/types"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRequestID(t *testing.T) {
+	defer removeConfig(t)
+	cfg, apiKey, err := config.New(testConfigFilename)
+	assert.Nil(t, err)
+
+	var out bytes.Buffer
+	logger, err := logging.New(&out, logging.FormatJSON, "info")
+	assert.Nil(t, err)
+
+	mockController := new(mockDockerController)
+
+	router := New(mockController, cfg, nil, nil, logger).Router()
+
+	sendWithRequestID := func(requestID string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/v1/containers/image/containerName", nil)
+		req.Header.Add("key", apiKey)
+		req.Header.Add("X-Request-ID", requestID)
+		router.ServeHTTP(w, req)
+
+		return w
+	}
+
+	t.Run("Request id is propagated", func(t *testing.T) {
+		out.Reset()
+		mockController.On("FindContainerByName", "containerName").Return(types.Container{Image: "imageName:latest"}, true).Once()
+
+		w := sendWithRequestID("deploy-1234")
+
+		assert.Equal(t, "deploy-1234", w.Header().Get("X-Request-ID"))
+
+		var line map[string]interface{}
+		err = json.Unmarshal(out.Bytes(), &line)
+		assert.Nil(t, err)
+
+		assert.Equal(t, "deploy-1234", line["request_id"])
+		assert.Equal(t, "/v1/containers/image/*containerName", line["route"])
+		assert.Equal(t, float64(http.StatusOK), line["status"])
+	})
+
+	t.Run("Request id is generated", func(t *testing.T) {
+		mockController.On("FindContainerByName", "containerName").Return(types.Container{Image: "imageName:latest"}, true).Once()
+
+		w := sendWithRequestID("")
+
+		assert.Len(t, w.Header().Get("X-Request-ID"), 16)
+	})
+
+	t.Run("Invalid request id is replaced", func(t *testing.T) {
+		mockController.On("FindContainerByName", "containerName").Return(types.Container{Image: "imageName:latest"}, true).Once()
+
+		w := sendWithRequestID("bad id\twith spaces")
+
+		assert.Len(t, w.Header().Get("X-Request-ID"), 16)
+	})
+}
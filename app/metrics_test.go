@@ -0,0 +1,33 @@
+package app
+
+import (
+	"github.com/XiovV/dokkup-agent/config"
+	"github.com/docker/docker/api/types"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestMetrics(t *testing.T) {
+	defer removeConfig(t)
+	cfg, apiKey, err := config.New(testConfigFilename)
+	assert.Nil(t, err)
+
+	mockController := new(mockDockerController)
+
+	router := New(mockController, cfg, nil, nil, testLogger()).Router()
+
+	mockController.On("FindContainerByName", "containerName").Return(types.Container{Image: "imageName:latest"}, true).Once()
+	sendRequest(router, "GET", "/v1/containers/image/containerName", apiKey)
+
+	w := sendRequest(router, "GET", "/metrics", "")
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	w = sendRequest(router, "GET", "/metrics", apiKey)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	body := w.Body.String()
+	assert.True(t, strings.Contains(body, `dokkup_http_request_duration_seconds_count{method="GET",route="/v1/containers/image/*containerName",status="200"}`))
+	assert.True(t, strings.Contains(body, "go_goroutines"))
+}
@@ -1,16 +1,141 @@
 package app
 
 import (
+	"crypto/tls"
+	"crypto/x509"
+	"github.com/XiovV/dokkup-agent/auth"
+	"github.com/XiovV/dokkup-agent/config"
+	"github.com/XiovV/dokkup-agent/controller"
 	"github.com/gin-gonic/gin"
-	"net/http"
+	"strings"
 )
 
+const principalContextKey = "principal"
+
+// Authenticate accepts either a static API key passed through the key header, a bearer token
+// in the Authorization header if JWT authentication is configured, or a verified client certificate
+// if mTLS is configured.
 func (app *App) Authenticate() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		apiKey := c.GetHeader("key")
+		creds := credentials{apiKey: c.GetHeader("key"), bearerToken: bearerToken(c.GetHeader("Authorization"))}
+		if c.Request.TLS != nil {
+			creds.clientCertificate = verifiedClientCertificate(*c.Request.TLS)
+		}
+
+		principal, message, ok := app.authenticate(clientIP(c), creds)
+		if !ok {
+			app.forbiddenResponse(c, codeInvalidCredentials, message)
+			return
+		}
+
+		c.Set(principalContextKey, principal)
+		c.Next()
+	}
+}
+
+// credentials are what a client presented to authenticate a request, over either HTTP or gRPC.
+type credentials struct {
+	apiKey      string
+	bearerToken string
+
+	// clientCertificate is set if the client sent a certificate which was verified against the configured CAs.
+	clientCertificate *x509.Certificate
+}
+
+// authenticate returns the principal the credentials belong to, or the reason they were rejected.
+// Failed attempts count towards locking out clientIP.
+func (app *App) authenticate(clientIP string, creds credentials) (auth.Principal, string, bool) {
+	s := app.settings()
 
-		if !app.config.CompareHash(apiKey) {
-			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "invalid api key"})
+	if creds.bearerToken != "" && s.jwtVerifier != nil {
+		principal, err := s.jwtVerifier.Verify(creds.bearerToken)
+		if err != nil {
+			s.limiters.lockout.Fail(clientIP)
+			return auth.Principal{}, "invalid bearer token", false
+		}
+
+		s.limiters.lockout.Succeed(clientIP)
+		return principal, "", true
+	}
+
+	if creds.clientCertificate != nil && creds.apiKey == "" {
+		return certificatePrincipal(s.config, creds.clientCertificate), "", true
+	}
+
+	if !s.config.CompareHash(creds.apiKey) {
+		s.limiters.lockout.Fail(clientIP)
+		return auth.Principal{}, "invalid api key", false
+	}
+
+	s.limiters.lockout.Succeed(clientIP)
+	return auth.Principal{
+		Name:        auth.MethodAPIKey,
+		Method:      auth.MethodAPIKey,
+		Permissions: []string{auth.PermissionAll},
+	}, "", true
+}
+
+// certificatePrincipal returns the principal of a client which authenticated with a certificate.
+// It's named after the certificate's common name, and granted the permissions configured for it.
+func certificatePrincipal(cfg *config.Config, certificate *x509.Certificate) auth.Principal {
+	name := certificate.Subject.CommonName
+
+	permissions := []string{auth.PermissionAll}
+	if cfg.TLS != nil && len(cfg.TLS.ClientPermissions) > 0 {
+		permissions = cfg.TLS.ClientPermissions[name]
+	}
+
+	return auth.Principal{Name: name, Method: auth.MethodMTLS, Permissions: permissions}
+}
+
+// verifiedClientCertificate returns the client's certificate if it was verified during the handshake.
+func verifiedClientCertificate(state tls.ConnectionState) *x509.Certificate {
+	if len(state.VerifiedChains) == 0 || len(state.VerifiedChains[0]) == 0 {
+		return nil
+	}
+
+	return state.VerifiedChains[0][0]
+}
+
+// RequirePermission aborts the request if the authenticated principal
+// hasn't been granted the requested permission. It must run after Authenticate.
+func (app *App) RequirePermission(permission string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		principal, ok := principalFromContext(c)
+		if !ok || !principal.HasPermission(permission) {
+			app.forbiddenResponse(c, codePermissionDenied, "insufficient permissions")
+			return
+		}
+
+		c.Next()
+	}
+}
+
+func principalFromContext(c *gin.Context) (auth.Principal, bool) {
+	value, ok := c.Get(principalContextKey)
+	if !ok {
+		return auth.Principal{}, false
+	}
+
+	principal, ok := value.(auth.Principal)
+	return principal, ok
+}
+
+func bearerToken(header string) string {
+	if len(header) < 7 || !strings.EqualFold(header[:7], "bearer ") {
+		return ""
+	}
+
+	return strings.TrimSpace(header[7:])
+}
+
+// RequireDocker rejects requests with 503 while the docker daemon is unreachable,
+// instead of letting them fail halfway through.
+func (app *App) RequireDocker() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !app.controller.Available() {
+			_ = c.Error(controller.ErrDockerUnavailable)
+			app.serviceUnavailableResponse(c, "the docker daemon is unreachable")
 			return
 		}
 
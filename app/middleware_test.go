@@ -0,0 +1,201 @@
+package app
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"github.com/XiovV/dokkup-agent/auth"
+	"github.com/XiovV/dokkup-agent/config"
+	"github.com/XiovV/dokkup-agent/controller"
+	"github.com/docker/docker/api/types"
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestAuthenticate(t *testing.T) {
+	defer removeConfig(t)
+	cfg, apiKey, err := config.New(testConfigFilename)
+	assert.Nil(t, err)
+
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.Nil(t, err)
+
+	der, err := x509.MarshalPKIXPublicKey(&privateKey.PublicKey)
+	assert.Nil(t, err)
+
+	keyFile := filepath.Join(t.TempDir(), "key.pem")
+	err = ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), 0600)
+	assert.Nil(t, err)
+
+	verifier, err := auth.NewJWTVerifier(config.JWTConfig{PublicKeyFiles: []string{keyFile}, Audience: "dokkup-agent"})
+	assert.Nil(t, err)
+
+	mockController := new(mockDockerController)
+
+	app := New(mockController, cfg, verifier, nil, testLogger())
+
+	router := app.Router()
+
+	var errorResponse struct {
+		Error string `json:"error"`
+		Code  string `json:"code"`
+	}
+
+	sendBearerRequest := func(method, location string, permissions []string) *httptest.ResponseRecorder {
+		token, err := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{
+			"sub":         "ci",
+			"aud":         "dokkup-agent",
+			"exp":         time.Now().Add(time.Hour).Unix(),
+			"permissions": permissions,
+		}).SignedString(privateKey)
+		assert.Nil(t, err)
+
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(method, location, nil)
+		req.Header.Add("Authorization", "Bearer "+token)
+		router.ServeHTTP(w, req)
+
+		return w
+	}
+
+	t.Run("Valid api key", func(t *testing.T) {
+		mockController.On("FindContainerByName", "containerName").Return(types.Container{Image: "imageName:latest"}, true).Once()
+
+		w := sendRequest(router, "GET", "/v1/containers/image/containerName", apiKey)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+	})
+
+	t.Run("Invalid api key", func(t *testing.T) {
+		w := sendRequest(router, "GET", "/v1/containers/image/containerName", "invalid")
+
+		assert.Equal(t, http.StatusForbidden, w.Code)
+
+		err = json.NewDecoder(w.Body).Decode(&errorResponse)
+		assert.Nil(t, err)
+
+		assert.Equal(t, "invalid api key", errorResponse.Error)
+		assert.Equal(t, "INVALID_CREDENTIALS", errorResponse.Code)
+	})
+
+	t.Run("Valid bearer token", func(t *testing.T) {
+		mockController.On("FindContainerByName", "containerName").Return(types.Container{Image: "imageName:latest"}, true).Once()
+
+		w := sendBearerRequest("GET", "/v1/containers/image/containerName", []string{auth.PermissionContainersRead})
+
+		assert.Equal(t, http.StatusOK, w.Code)
+	})
+
+	t.Run("Bearer token without required permission", func(t *testing.T) {
+		w := sendBearerRequest("PUT", "/v1/containers/rollback?container=containerName", []string{auth.PermissionContainersRead})
+
+		assert.Equal(t, http.StatusForbidden, w.Code)
+
+		err = json.NewDecoder(w.Body).Decode(&errorResponse)
+		assert.Nil(t, err)
+
+		assert.Equal(t, "insufficient permissions", errorResponse.Error)
+		assert.Equal(t, "PERMISSION_DENIED", errorResponse.Code)
+	})
+
+	t.Run("Info requires its own permission", func(t *testing.T) {
+		w := sendBearerRequest("GET", "/v2/info", []string{auth.PermissionContainersRead})
+
+		assert.Equal(t, http.StatusForbidden, w.Code)
+
+		mockController.On("EngineInfo").Return(controller.EngineInfo{Version: "20.10.8"}, nil).Once()
+
+		w = sendBearerRequest("GET", "/v2/info", []string{auth.PermissionInfoRead})
+
+		assert.Equal(t, http.StatusOK, w.Code)
+	})
+
+	t.Run("Invalid bearer token", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/v1/containers/image/containerName", nil)
+		req.Header.Add("Authorization", "Bearer invalid")
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusForbidden, w.Code)
+
+		err = json.NewDecoder(w.Body).Decode(&errorResponse)
+		assert.Nil(t, err)
+
+		assert.Equal(t, "invalid bearer token", errorResponse.Error)
+	})
+}
+
+func TestRequireDocker(t *testing.T) {
+	defer removeConfig(t)
+	cfg, apiKey, err := config.New(testConfigFilename)
+	assert.Nil(t, err)
+
+	mockController := &mockDockerController{dockerUnavailable: true}
+
+	app := New(mockController, cfg, nil, nil, testLogger())
+
+	router := app.Router()
+
+	var errorResponse struct {
+		Error string `json:"error"`
+	}
+
+	t.Run("Docker route while docker is unreachable", func(t *testing.T) {
+		w := sendRequest(router, "PUT", "/v1/containers/update?container=validContainer&image=imageName:latest&keep=true", apiKey)
+
+		assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+
+		err = json.NewDecoder(w.Body).Decode(&errorResponse)
+		assert.Nil(t, err)
+
+		assert.Equal(t, "the docker daemon is unreachable", errorResponse.Error)
+		mockController.AssertNotCalled(t, "UpdateContainer", "validContainer", "imageName:latest", true)
+	})
+
+	t.Run("Docker became unreachable during an operation", func(t *testing.T) {
+		mockController.dockerUnavailable = false
+		mockController.On("PullImage", "imageName:latest").Return(fmt.Errorf("%w: connection refused", controller.ErrDockerUnavailable)).Once()
+
+		w := sendRequest(router, "PUT", "/v1/images/pull?image=imageName:latest", apiKey)
+
+		assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	})
+}
+
+func TestRecovery(t *testing.T) {
+	defer removeConfig(t)
+	cfg, _, err := config.New(testConfigFilename)
+	assert.Nil(t, err)
+
+	app := New(new(mockDockerController), cfg, nil, nil, testLogger())
+
+	router := app.Router()
+	router.GET("/panic", func(c *gin.Context) {
+		panic("something went wrong")
+	})
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/panic", nil)
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+
+	var errorResponse struct {
+		Error string `json:"error"`
+	}
+
+	err = json.NewDecoder(w.Body).Decode(&errorResponse)
+	assert.Nil(t, err)
+
+	assert.Equal(t, "internal server error", errorResponse.Error)
+}
@@ -0,0 +1,127 @@
+package app
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+	"net"
+	"net/http"
+	"strings"
+)
+
+const clientIPContextKey = "client_ip"
+
+// unixClient is the client IP of requests made over a Unix socket. Only local users with
+// access to the socket can make them, so they aren't subject to the allowlist.
+const unixClient = "unix"
+
+// ResolveClientIP finds out the real address of the client. The X-Forwarded-For header is
+// only taken into account if the request came from a trusted proxy, in which case the header
+// is walked from right to left and the first address which isn't a trusted proxy is used.
+// Requests whose header holds an invalid address before such an address is found are rejected,
+// since they would otherwise be attributed to the proxy, sharing its rate limits and lockouts.
+func (app *App) ResolveClientIP() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ip, ok := app.resolveClientIP(c.Request)
+		if !ok {
+			app.log.WithFields(logrus.Fields{
+				"path":            c.Request.URL.Path,
+				"remote_addr":     c.Request.RemoteAddr,
+				"x_forwarded_for": c.Request.Header.Values("X-Forwarded-For"),
+			}).Warn("rejected request with an invalid X-Forwarded-For header")
+			app.badRequestResponse(c, codeBadRequest, "X-Forwarded-For header is invalid")
+			return
+		}
+
+		c.Set(clientIPContextKey, ip)
+		c.Next()
+	}
+}
+
+// resolveClientIP returns the address of the client, and false if it can't be determined.
+func (app *App) resolveClientIP(r *http.Request) (string, bool) {
+	if localAddr, ok := r.Context().Value(http.LocalAddrContextKey).(net.Addr); ok && localAddr.Network() == "unix" {
+		return unixClient, true
+	}
+
+	remoteAddr, _, err := net.SplitHostPort(strings.TrimSpace(r.RemoteAddr))
+	if err != nil {
+		remoteAddr = strings.TrimSpace(r.RemoteAddr)
+	}
+
+	remoteIP := net.ParseIP(remoteAddr)
+	trustedProxies := app.settings().trustedProxies
+	if remoteIP == nil || !containsIP(trustedProxies, remoteIP) {
+		return remoteAddr, true
+	}
+
+	// requests made by the proxy itself don't carry the header
+	values := r.Header.Values("X-Forwarded-For")
+	if len(values) == 0 {
+		return remoteIP.String(), true
+	}
+
+	forwardedFor := strings.Split(strings.Join(values, ","), ",")
+
+	clientIP := remoteIP
+	for i := len(forwardedFor) - 1; i >= 0; i-- {
+		ip := net.ParseIP(strings.TrimSpace(forwardedFor[i]))
+		if ip == nil {
+			return "", false
+		}
+
+		clientIP = ip
+		if !containsIP(trustedProxies, ip) {
+			break
+		}
+	}
+
+	return clientIP.String(), true
+}
+
+// clientIP returns the address found by ResolveClientIP. Outside of the router, it falls back to the
+// address the request came from, ignoring any forwarding headers.
+func clientIP(c *gin.Context) string {
+	if ip := c.GetString(clientIPContextKey); ip != "" {
+		return ip
+	}
+
+	if remoteAddr, _, err := net.SplitHostPort(strings.TrimSpace(c.Request.RemoteAddr)); err == nil {
+		return remoteAddr
+	}
+
+	return strings.TrimSpace(c.Request.RemoteAddr)
+}
+
+// AllowClients rejects requests from addresses outside of the configured allowlist.
+// Requests made over a Unix socket are always allowed. It must run after ResolveClientIP and before Authenticate.
+func (app *App) AllowClients() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		allowedNetworks := app.settings().allowedNetworks
+		if len(allowedNetworks) == 0 || clientIP(c) == unixClient {
+			c.Next()
+			return
+		}
+
+		ip := net.ParseIP(clientIP(c))
+		if ip == nil || !containsIP(allowedNetworks, ip) {
+			app.requestLogger(c).WithFields(logrus.Fields{
+				"path":        c.Request.URL.Path,
+				"remote_addr": c.Request.RemoteAddr,
+			}).Warn("denied request from an address which is not allowed")
+			app.forbiddenResponse(c, codeAddressNotAllowed, "address is not allowed")
+			return
+		}
+
+		c.Next()
+	}
+}
+
+func containsIP(networks []*net.IPNet, ip net.IP) bool {
+	for _, network := range networks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
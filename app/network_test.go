@@ -0,0 +1,130 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/XiovV/dokkup-agent/config"
+	"github.com/docker/docker/api/types"
+	"github.com/stretchr/testify/assert"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestResolveClientIP(t *testing.T) {
+	defer removeConfig(t)
+	cfg, _, err := config.New(testConfigFilename)
+	assert.Nil(t, err)
+
+	cfg.TrustedProxies = []string{"10.0.0.0/8"}
+
+	app := New(new(mockDockerController), cfg, nil, nil, testLogger())
+
+	tests := []struct {
+		name         string
+		remoteAddr   string
+		forwardedFor string
+		expectedIP   string
+	}{
+		{"Direct request", "203.0.113.7:5000", "", "203.0.113.7"},
+		{"Untrusted proxy", "203.0.113.7:5000", "198.51.100.1", "203.0.113.7"},
+		{"Trusted proxy", "10.0.0.2:5000", "198.51.100.1", "198.51.100.1"},
+		{"Request by the trusted proxy itself", "10.0.0.2:5000", "", "10.0.0.2"},
+		{"Spoofed header behind trusted proxy", "10.0.0.2:5000", "1.1.1.1, 198.51.100.1", "198.51.100.1"},
+		{"Chain of trusted proxies", "10.0.0.2:5000", "198.51.100.1, 10.0.0.3", "198.51.100.1"},
+		{"Invalid value before the client", "10.0.0.2:5000", "1.1.1.1, not-an-ip", ""},
+		{"Invalid value between trusted proxies", "10.0.0.2:5000", "198.51.100.1, not-an-ip, 10.0.0.3", ""},
+		{"Invalid value spoofed by the client", "10.0.0.2:5000", "not-an-ip, 198.51.100.1", "198.51.100.1"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req, _ := http.NewRequest("GET", "/", nil)
+			req.RemoteAddr = test.remoteAddr
+			if test.forwardedFor != "" {
+				req.Header.Set("X-Forwarded-For", test.forwardedFor)
+			}
+
+			ip, ok := app.resolveClientIP(req)
+			assert.Equal(t, test.expectedIP != "", ok)
+			assert.Equal(t, test.expectedIP, ip)
+		})
+	}
+}
+
+func TestAllowClients(t *testing.T) {
+	defer removeConfig(t)
+	cfg, apiKey, err := config.New(testConfigFilename)
+	assert.Nil(t, err)
+
+	cfg.AllowedCIDRs = []string{"192.168.1.0/24"}
+	cfg.TrustedProxies = []string{"10.0.0.1"}
+
+	mockController := new(mockDockerController)
+
+	router := New(mockController, cfg, nil, nil, testLogger()).Router()
+
+	sendFrom := func(remoteAddr, forwardedFor string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/v1/containers/image/containerName", nil)
+		req.RemoteAddr = remoteAddr
+		req.Header.Add("key", apiKey)
+		if forwardedFor != "" {
+			req.Header.Add("X-Forwarded-For", forwardedFor)
+		}
+		router.ServeHTTP(w, req)
+
+		return w
+	}
+
+	var errorResponse struct {
+		Error string `json:"error"`
+	}
+
+	t.Run("Allowed address", func(t *testing.T) {
+		mockController.On("FindContainerByName", "containerName").Return(types.Container{Image: "imageName:latest"}, true).Once()
+
+		w := sendFrom("192.168.1.20:4000", "")
+
+		assert.Equal(t, http.StatusOK, w.Code)
+	})
+
+	t.Run("Allowed address behind trusted proxy", func(t *testing.T) {
+		mockController.On("FindContainerByName", "containerName").Return(types.Container{Image: "imageName:latest"}, true).Once()
+
+		w := sendFrom("10.0.0.1:4000", "192.168.1.20")
+
+		assert.Equal(t, http.StatusOK, w.Code)
+	})
+
+	t.Run("Denied address", func(t *testing.T) {
+		w := sendFrom("172.16.0.5:4000", "")
+
+		assert.Equal(t, http.StatusForbidden, w.Code)
+
+		err = json.NewDecoder(w.Body).Decode(&errorResponse)
+		assert.Nil(t, err)
+
+		assert.Equal(t, "address is not allowed", errorResponse.Error)
+	})
+
+	t.Run("Spoofed header from untrusted address", func(t *testing.T) {
+		w := sendFrom("172.16.0.5:4000", "192.168.1.20")
+
+		assert.Equal(t, http.StatusForbidden, w.Code)
+	})
+
+	t.Run("Unix socket", func(t *testing.T) {
+		mockController.On("FindContainerByName", "containerName").Return(types.Container{Image: "imageName:latest"}, true).Once()
+
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/v1/containers/image/containerName", nil)
+		req.RemoteAddr = "@"
+		req.Header.Add("key", apiKey)
+		req = req.WithContext(context.WithValue(req.Context(), http.LocalAddrContextKey, &net.UnixAddr{Name: "/run/dokkup.sock", Net: "unix"}))
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+	})
+}
@@ -0,0 +1,16 @@
+package app
+
+import (
+	_ "embed"
+	"github.com/gin-gonic/gin"
+	"net/http"
+)
+
+//go:embed openapi.json
+var openAPISpec []byte
+
+// OpenAPI serves the OpenAPI 3 document describing every route registered by Router.
+// TestOpenAPISpec fails if a route is missing from it, or if a response doesn't match its documented schema.
+func (app *App) OpenAPI(c *gin.Context) {
+	c.Data(http.StatusOK, "application/json", openAPISpec)
+}
@@ -0,0 +1,327 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/XiovV/dokkup-agent/audit"
+	"github.com/XiovV/dokkup-agent/config"
+	"github.com/XiovV/dokkup-agent/controller"
+	"github.com/docker/docker/api/types"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+type openAPIDocument struct {
+	Paths      map[string]map[string]openAPIOperation `json:"paths"`
+	Components struct {
+		Schemas map[string]*jsonSchema `json:"schemas"`
+	} `json:"components"`
+}
+
+type openAPIOperation struct {
+	Responses map[string]struct {
+		Content map[string]struct {
+			Schema *jsonSchema `json:"schema"`
+		} `json:"content"`
+	} `json:"responses"`
+}
+
+// jsonSchema is the subset of JSON schema used by openapi.json.
+type jsonSchema struct {
+	Ref                  string                 `json:"$ref"`
+	Type                 string                 `json:"type"`
+	Enum                 []interface{}          `json:"enum"`
+	Required             []string               `json:"required"`
+	Properties           map[string]*jsonSchema `json:"properties"`
+	AdditionalProperties interface{}            `json:"additionalProperties"`
+	Items                *jsonSchema            `json:"items"`
+	OneOf                []*jsonSchema          `json:"oneOf"`
+}
+
+// validate returns a description of every way value doesn't match the schema.
+func (s *jsonSchema) validate(doc *openAPIDocument, path string, value interface{}) []string {
+	if s.Ref != "" {
+		return doc.Components.Schemas[strings.TrimPrefix(s.Ref, "#/components/schemas/")].validate(doc, path, value)
+	}
+
+	if len(s.OneOf) > 0 {
+		matches := 0
+		for _, schema := range s.OneOf {
+			if len(schema.validate(doc, path, value)) == 0 {
+				matches++
+			}
+		}
+
+		if matches != 1 {
+			return []string{fmt.Sprintf("%s: matches %d of the oneOf schemas instead of exactly one", path, matches)}
+		}
+
+		return nil
+	}
+
+	var problems []string
+
+	switch s.Type {
+	case "object":
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return []string{fmt.Sprintf("%s: expected an object, got %T", path, value)}
+		}
+
+		for _, name := range s.Required {
+			if _, ok := object[name]; !ok {
+				problems = append(problems, fmt.Sprintf("%s: missing required property %q", path, name))
+			}
+		}
+
+		for name, property := range object {
+			schema, ok := s.Properties[name]
+			if !ok {
+				if s.AdditionalProperties == false {
+					problems = append(problems, fmt.Sprintf("%s: undocumented property %q", path, name))
+				}
+				continue
+			}
+
+			problems = append(problems, schema.validate(doc, path+"."+name, property)...)
+		}
+	case "array":
+		array, ok := value.([]interface{})
+		if !ok {
+			return []string{fmt.Sprintf("%s: expected an array, got %T", path, value)}
+		}
+
+		for i, item := range array {
+			problems = append(problems, s.Items.validate(doc, fmt.Sprintf("%s[%d]", path, i), item)...)
+		}
+	case "string":
+		if _, ok := value.(string); !ok {
+			return []string{fmt.Sprintf("%s: expected a string, got %T", path, value)}
+		}
+	case "integer":
+		number, ok := value.(float64)
+		if !ok || number != float64(int64(number)) {
+			return []string{fmt.Sprintf("%s: expected an integer, got %v", path, value)}
+		}
+	case "number":
+		if _, ok := value.(float64); !ok {
+			return []string{fmt.Sprintf("%s: expected a number, got %T", path, value)}
+		}
+	case "boolean":
+		if _, ok := value.(bool); !ok {
+			return []string{fmt.Sprintf("%s: expected a boolean, got %T", path, value)}
+		}
+	}
+
+	if len(s.Enum) > 0 {
+		found := false
+		for _, allowed := range s.Enum {
+			if allowed == value {
+				found = true
+			}
+		}
+
+		if !found {
+			problems = append(problems, fmt.Sprintf("%s: %v is not one of %v", path, value, s.Enum))
+		}
+	}
+
+	return problems
+}
+
+var routeParam = regexp.MustCompile(`[:*]([A-Za-z0-9_]+)`)
+
+func loadOpenAPIDocument(t *testing.T) *openAPIDocument {
+	var doc openAPIDocument
+	assert.Nil(t, json.Unmarshal(openAPISpec, &doc))
+
+	return &doc
+}
+
+func TestOpenAPIRoutes(t *testing.T) {
+	defer removeConfig(t)
+	cfg, _, err := config.New(testConfigFilename)
+	assert.Nil(t, err)
+
+	doc := loadOpenAPIDocument(t)
+	routes := New(new(mockDockerController), cfg, nil, nil, testLogger()).Router().Routes()
+
+	registered := map[string]bool{}
+	for _, route := range routes {
+		path := routeParam.ReplaceAllString(route.Path, "{$1}")
+		method := strings.ToLower(route.Method)
+		registered[method+" "+path] = true
+
+		_, ok := doc.Paths[path][method]
+		assert.True(t, ok, "%s %s is not documented in openapi.json", route.Method, path)
+	}
+
+	for path, operations := range doc.Paths {
+		for method := range operations {
+			assert.True(t, registered[method+" "+path], "%s %s is documented, but not registered", strings.ToUpper(method), path)
+		}
+	}
+}
+
+func TestOpenAPIResponses(t *testing.T) {
+	defer removeConfig(t)
+	cfg, apiKey, err := config.New(testConfigFilename)
+	assert.Nil(t, err)
+
+	auditLog, err := audit.Open(filepath.Join(t.TempDir(), "audit.jsonl"))
+	assert.Nil(t, err)
+
+	mockController := new(mockDockerController)
+	router := New(mockController, cfg, nil, auditLog, testLogger()).Router()
+
+	doc := loadOpenAPIDocument(t)
+
+	cases := []struct {
+		method string
+		path   string
+		url    string
+		body   string
+		apiKey string
+		setup  func()
+	}{
+		{method: "GET", path: "/healthz", url: "/healthz"},
+		{method: "GET", path: "/readyz", url: "/readyz"},
+		{method: "GET", path: "/metrics", url: "/metrics"},
+		{method: "GET", path: "/openapi.json", url: "/openapi.json"},
+		{method: "POST", path: "/v1/bootstrap", url: "/v1/bootstrap"},
+		{method: "GET", path: "/v1/info", url: "/v1/info", apiKey: apiKey, setup: func() {
+			mockController.On("EngineInfo").Return(controller.EngineInfo{Version: "20.10.8", APIVersion: "1.41"}, nil).Once()
+		}},
+		{method: "GET", path: "/v2/info", url: "/v2/info", apiKey: "invalid"},
+		{method: "GET", path: "/v1/reconcile/status", url: "/v1/reconcile/status", apiKey: apiKey},
+		{method: "GET", path: "/v1/containers/image/{containerName}", url: "/v1/containers/image/web", apiKey: apiKey, setup: func() {
+			mockController.On("FindContainerByName", "web").Return(types.Container{Image: "web:1.0"}, true).Once()
+		}},
+		{method: "GET", path: "/v1/containers/image/{containerName}", url: "/v1/containers/image/", apiKey: apiKey},
+		{method: "PUT", path: "/v1/images/pull", url: "/v1/images/pull?image=web:1.1", apiKey: apiKey, setup: func() {
+			mockController.On("PullImage", "web:1.1").Return(nil).Once()
+		}},
+		{method: "POST", path: "/v1/containers", url: "/v1/containers", body: `{"name": "web", "image": "web:1.0", "ports": ["8080:80"]}`, apiKey: apiKey, setup: func() {
+			mockController.On("CreateContainer", controller.ContainerSpec{Name: "web", Image: "web:1.0", Ports: []string{"8080:80"}}).
+				Return(controller.ContainerChange{ContainerName: "web", NewImage: "web:1.0"}, nil).Once()
+		}},
+		{method: "POST", path: "/v1/containers", url: "/v1/containers", body: `{"name": "web", "image": "web:1.0"}`, apiKey: apiKey, setup: func() {
+			mockController.On("CreateContainer", controller.ContainerSpec{Name: "web", Image: "web:1.0"}).
+				Return(controller.ContainerChange{}, controller.ErrContainerExists).Once()
+		}},
+		{method: "PUT", path: "/v1/containers/update", url: "/v1/containers/update?container=web&image=web:1.1&keep=true", apiKey: apiKey, setup: func() {
+			mockController.On("UpdateContainer", "web", "web:1.1", true).
+				Return(controller.ContainerChange{ContainerName: "web", OldImage: "web:1.0", NewImage: "web:1.1"}, nil).Once()
+		}},
+		{method: "POST", path: "/v1/containers", url: "/v1/containers?dry_run=true", body: `{"name": "web", "image": "web:1.0", "ports": ["8080:80"]}`, apiKey: apiKey, setup: func() {
+			mockController.On("PlanCreate", controller.ContainerSpec{Name: "web", Image: "web:1.0", Ports: []string{"8080:80"}}).Return(testCreatePlan, nil).Once()
+		}},
+		{method: "PUT", path: "/v1/containers/update", url: "/v1/containers/update?container=web&image=web:1.1&keep=true&dry_run=true", apiKey: apiKey, setup: func() {
+			mockController.On("PlanUpdate", "web", "web:1.1", true, controller.ConfigPatch{}).Return(testUpdatePlan, nil).Once()
+		}},
+		{method: "PUT", path: "/v1/containers/rollback", url: "/v1/containers/rollback?container=web&dry_run=true", apiKey: apiKey, setup: func() {
+			mockController.On("PlanRollback", "web").Return(controller.Plan{Operation: "rollback", ContainerName: "web", Image: controller.PlannedImage{Reference: "web:1.0", ID: "sha256:a"}}, nil).Once()
+		}},
+		{method: "PUT", path: "/v1/images/pull", url: "/v1/images/pull?image=web:1.1&dry_run=true", apiKey: apiKey, setup: func() {
+			mockController.On("PlanPull", "web:1.1").Return(controller.Plan{Operation: "pull", Image: controller.PlannedImage{Reference: "web:1.1", Pull: true}}, nil).Once()
+		}},
+		{method: "PUT", path: "/v1/containers/rollback", url: "/v1/containers/rollback?container=web", apiKey: apiKey, setup: func() {
+			mockController.On("RollbackContainer", "web").Return(controller.ContainerChange{}, controller.ErrRollbackContainerNotFound).Once()
+		}},
+		{method: "GET", path: "/v2/containers", url: "/v2/containers", apiKey: apiKey, setup: func() {
+			mockController.On("ListContainers").Return([]types.Container{{Names: []string{"/web"}, ID: "abc", Image: "web:1.0", State: "running"}}, nil).Once()
+		}},
+		{method: "GET", path: "/v2/containers/{name}/logs", url: "/v2/containers/web/logs?tail=5", apiKey: apiKey, setup: func() {
+			mockController.On("ContainerLogs", "web", controller.LogsOptions{Tail: "5"}).Return(ioutil.NopCloser(strings.NewReader("started\n")), nil).Once()
+		}},
+		{method: "GET", path: "/v2/containers/{name}/logs", url: "/v2/containers/web/logs?follow=maybe", apiKey: apiKey},
+		{method: "GET", path: "/v2/containers/{name}", url: "/v2/containers/web", apiKey: apiKey, setup: func() {
+			mockController.On("FindContainerByName", "web").Return(types.Container{ID: "abc", Image: "web:1.0", State: "running"}, true).Once()
+		}},
+		{method: "POST", path: "/v2/containers/{name}/update", url: "/v2/containers/web/update", body: `{"keep": true}`, apiKey: apiKey},
+		{method: "POST", path: "/v2/containers/{name}/update", url: "/v2/containers/web/update", body: `{"image": "web:1.1", "patch": {"env": {"MODE": "production"}}}`, apiKey: apiKey, setup: func() {
+			mockController.On("UpdateContainerWithPatch", "web", "web:1.1", false, controller.ConfigPatch{Env: map[string]string{"MODE": "production"}}).
+				Return(controller.ContainerChange{ContainerName: "web", OldImage: "web:1.0", NewImage: "web:1.1", Settings: []controller.SettingChange{{Setting: "env.MODE", New: "<redacted>"}}}, nil).Once()
+		}},
+		{method: "POST", path: "/v2/containers/{name}/update", url: "/v2/containers/web/update", body: `{"image": "web:1.1"}`, apiKey: apiKey, setup: func() {
+			mockController.On("UpdateContainer", "web", "web:1.1", false).
+				Return(controller.ContainerChange{}, controller.ErrContainerStartFailed{ContainerId: "abc", Reason: fmt.Errorf("port is already allocated")}).Once()
+		}},
+		{method: "POST", path: "/v2/containers/{name}/rollback", url: "/v2/containers/web/rollback", apiKey: apiKey, setup: func() {
+			mockController.On("RollbackContainer", "web").Return(controller.ContainerChange{}, nil).Once()
+		}},
+		{method: "POST", path: "/v2/images/pull", url: "/v2/images/pull", body: `{"image": "web:1.1"}`, apiKey: apiKey, setup: func() {
+			mockController.On("PullImage", "web:1.1").Return(controller.ErrOperationAborted{Step: "pull", Reason: context.DeadlineExceeded}).Once()
+		}},
+		// the audit queries run last, so the log contains the entries recorded above
+		{method: "GET", path: "/v1/audit", url: "/v1/audit?limit=2", apiKey: apiKey},
+		{method: "GET", path: "/v2/audit", url: "/v2/audit?since=invalid", apiKey: apiKey},
+	}
+
+	tested := map[string]bool{}
+
+	for _, tc := range cases {
+		t.Run(tc.method+" "+tc.url, func(t *testing.T) {
+			if tc.setup != nil {
+				tc.setup()
+			}
+
+			w := sendJSONRequest(router, tc.method, tc.url, tc.apiKey, tc.body)
+			tested[strings.ToLower(tc.method)+" "+tc.path] = true
+
+			operation, ok := doc.Paths[tc.path][strings.ToLower(tc.method)]
+			if !assert.True(t, ok, "operation is not documented") {
+				return
+			}
+
+			response, ok := operation.Responses[strconv.Itoa(w.Code)]
+			if !assert.True(t, ok, "status %d is not documented", w.Code) {
+				return
+			}
+
+			contentType := strings.SplitN(w.Header().Get("Content-Type"), ";", 2)[0]
+			content, ok := response.Content[contentType]
+			if !assert.True(t, ok, "content type %q is not documented for status %d", contentType, w.Code) {
+				return
+			}
+
+			if contentType != "application/json" {
+				return
+			}
+
+			var body interface{}
+			assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &body))
+
+			for _, problem := range content.Schema.validate(doc, "body", body) {
+				t.Error(problem)
+			}
+		})
+	}
+
+	for path, operations := range doc.Paths {
+		for method := range operations {
+			assert.True(t, tested[method+" "+path], "%s %s has no response test", strings.ToUpper(method), path)
+		}
+	}
+}
+
+func TestOpenAPIEndpoint(t *testing.T) {
+	defer removeConfig(t)
+	cfg, _, err := config.New(testConfigFilename)
+	assert.Nil(t, err)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/openapi.json", nil)
+	New(new(mockDockerController), cfg, nil, nil, testLogger()).Router().ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, openAPISpec, w.Body.Bytes())
+}
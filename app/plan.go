@@ -0,0 +1,109 @@
+package app
+
+import (
+	"github.com/XiovV/dokkup-agent/controller"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"strconv"
+)
+
+// dryRunContextKey marks requests which only plan an operation, so Audit leaves them out of the audit log.
+const dryRunContextKey = "dry_run"
+
+// dryRun parses the dry_run query parameter of a mutating call. It responds with an error and returns
+// false as its second value if the parameter is invalid.
+func (app *App) dryRun(c *gin.Context) (bool, bool) {
+	query := c.Query("dry_run")
+	if query == "" {
+		return false, true
+	}
+
+	dryRun, err := strconv.ParseBool(query)
+	if err != nil {
+		app.badRequestResponse(c, codeBadRequest, "dry_run value must be either true or false")
+		return false, false
+	}
+
+	c.Set(dryRunContextKey, dryRun)
+
+	return dryRun, true
+}
+
+// planResponse responds with the plan of a dry run, or with the error which the operation would have failed with.
+func (app *App) planResponse(c *gin.Context, plan controller.Plan, err error) {
+	if err != nil {
+		app.operationErrorResponse(c, err)
+		return
+	}
+
+	image := gin.H{"reference": plan.Image.Reference, "pull": plan.Image.Pull}
+	if plan.Image.ID != "" {
+		image["id"] = plan.Image.ID
+	}
+	if plan.Image.Digest != "" {
+		image["digest"] = plan.Image.Digest
+	}
+
+	steps := make([]gin.H, 0, len(plan.Steps))
+	for _, step := range plan.Steps {
+		steps = append(steps, gin.H{"step": step.Step, "description": step.Description})
+	}
+
+	problems := make([]gin.H, 0, len(plan.Problems))
+	for _, problem := range plan.Problems {
+		problems = append(problems, gin.H{"kind": problem.Kind, "message": problem.Message})
+	}
+
+	response := gin.H{
+		"operation": plan.Operation,
+		"image":     image,
+		"steps":     steps,
+		"problems":  problems,
+	}
+
+	// pulls don't create a container
+	if plan.Operation != auditActionPull {
+		response["container"] = plan.ContainerName
+		response["config"] = plannedConfig(plan.Config)
+	}
+
+	if plan.Operation == auditActionUpdate || plan.Operation == auditActionRollback {
+		response["changes"] = settingChanges(plan.Change)
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+func plannedConfig(config controller.PlannedConfig) gin.H {
+	return gin.H{
+		"image":          config.Image,
+		"cmd":            stringsOrEmpty(config.Cmd),
+		"entrypoint":     stringsOrEmpty(config.Entrypoint),
+		"env":            stringsOrEmpty(config.EnvNames),
+		"labels":         labelsOrEmpty(config.Labels),
+		"ports":          stringsOrEmpty(config.Ports),
+		"volumes":        stringsOrEmpty(config.Volumes),
+		"networks":       stringsOrEmpty(config.Networks),
+		"restart_policy": config.RestartPolicy,
+		"resources": gin.H{
+			"memory_bytes": config.Resources.MemoryBytes,
+			"cpus":         config.Resources.CPUs,
+		},
+	}
+}
+
+func stringsOrEmpty(values []string) []string {
+	if values == nil {
+		return []string{}
+	}
+
+	return values
+}
+
+func labelsOrEmpty(labels map[string]string) map[string]string {
+	if labels == nil {
+		return map[string]string{}
+	}
+
+	return labels
+}
@@ -0,0 +1,176 @@
+package app
+
+import (
+	"encoding/json"
+	"github.com/XiovV/dokkup-agent/audit"
+	"github.com/XiovV/dokkup-agent/config"
+	"github.com/XiovV/dokkup-agent/controller"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"path/filepath"
+	"testing"
+)
+
+var testCreatePlan = controller.Plan{
+	Operation:     "create",
+	ContainerName: "web",
+	Image:         controller.PlannedImage{Reference: "web:1.0", Digest: "sha256:remote", Pull: true},
+	Config: controller.PlannedConfig{
+		Image:         "web:1.0",
+		EnvNames:      []string{"MODE"},
+		Ports:         []string{"8080:80/tcp"},
+		RestartPolicy: "no",
+		Resources:     controller.Resources{MemoryBytes: 268435456, CPUs: 0.5},
+	},
+	Steps: []controller.PlanStep{
+		{Step: "pull", Description: "pull web:1.0"},
+		{Step: "create", Description: "create web from web:1.0"},
+		{Step: "start", Description: "start web"},
+		{Step: "verify", Description: "check that web is running, and remove it otherwise"},
+	},
+	Problems: []controller.PlanProblem{{Kind: controller.ProblemPortConflict, Message: "host port 8080/tcp is already published by api"}},
+}
+
+var testUpdatePlan = controller.Plan{
+	Operation:     "update",
+	ContainerName: "web",
+	Image:         controller.PlannedImage{Reference: "web:1.1", ID: "sha256:new", Digest: "sha256:digest"},
+	Config:        controller.PlannedConfig{Image: "web:1.1", RestartPolicy: "unless-stopped"},
+	Change: controller.ContainerChange{
+		ContainerName: "web",
+		OldImage:      "web:1.0",
+		NewImage:      "web:1.1",
+		Settings:      []controller.SettingChange{{Setting: "env.MODE", Old: "<redacted>", New: "<redacted>"}},
+	},
+	Steps: []controller.PlanStep{
+		{Step: "rename", Description: "rename web (abc) to web-rollback"},
+		{Step: "create", Description: "create web from web:1.1"},
+		{Step: "stop", Description: "stop web-rollback (abc)"},
+		{Step: "start", Description: "start the new web"},
+		{Step: "verify", Description: "check that the new web is running, and restore the old container otherwise"},
+	},
+}
+
+func TestDryRun(t *testing.T) {
+	defer removeConfig(t)
+	cfg, apiKey, err := config.New(testConfigFilename)
+	assert.Nil(t, err)
+
+	auditLog, err := audit.Open(filepath.Join(t.TempDir(), "audit.jsonl"))
+	assert.Nil(t, err)
+	defer auditLog.Close()
+
+	mockController := new(mockDockerController)
+
+	router := New(mockController, cfg, nil, auditLog, testLogger()).Router()
+
+	type plan struct {
+		Operation string `json:"operation"`
+		Container string `json:"container"`
+		Image     struct {
+			Reference string `json:"reference"`
+			ID        string `json:"id"`
+			Digest    string `json:"digest"`
+			Pull      bool   `json:"pull"`
+		} `json:"image"`
+		Config   map[string]interface{} `json:"config"`
+		Changes  []map[string]string    `json:"changes"`
+		Steps    []map[string]string    `json:"steps"`
+		Problems []map[string]string    `json:"problems"`
+	}
+
+	t.Run("Update", func(t *testing.T) {
+		mockController.On("PlanUpdate", "web", "web:1.1", false, controller.ConfigPatch{}).Return(testUpdatePlan, nil).Once()
+
+		w := sendRequest(router, "PUT", "/v1/containers/update?container=web&image=web:1.1&keep=false&dry_run=true", apiKey)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		var response plan
+		assert.Nil(t, json.NewDecoder(w.Body).Decode(&response))
+
+		assert.Equal(t, "update", response.Operation)
+		assert.Equal(t, "web", response.Container)
+		assert.Equal(t, "sha256:digest", response.Image.Digest)
+		assert.Equal(t, "unless-stopped", response.Config["restart_policy"])
+		assert.Equal(t, []interface{}{}, response.Config["env"])
+		assert.Equal(t, []map[string]string{
+			{"setting": "image", "old": "web:1.0", "new": "web:1.1"},
+			{"setting": "env.MODE", "old": "<redacted>", "new": "<redacted>"},
+		}, response.Changes)
+		assert.Len(t, response.Steps, 5)
+		assert.Equal(t, map[string]string{"step": "rename", "description": "rename web (abc) to web-rollback"}, response.Steps[0])
+		assert.Equal(t, []map[string]string{}, response.Problems)
+	})
+
+	t.Run("Update with a patch", func(t *testing.T) {
+		patch := controller.ConfigPatch{Env: map[string]string{"MODE": "production"}}
+		mockController.On("PlanUpdate", "web", "web:1.1", true, patch).Return(testUpdatePlan, nil).Once()
+
+		w := sendJSONRequest(router, "POST", "/v2/containers/web/update?dry_run=true", apiKey, `{"image": "web:1.1", "keep": true, "patch": {"env": {"MODE": "production"}}}`)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+	})
+
+	t.Run("Create", func(t *testing.T) {
+		mockController.On("PlanCreate", controller.ContainerSpec{Name: "web", Image: "web:1.0"}).Return(testCreatePlan, nil).Once()
+
+		w := sendJSONRequest(router, "POST", "/v1/containers?dry_run=true", apiKey, `{"name": "web", "image": "web:1.0"}`)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		var response plan
+		assert.Nil(t, json.NewDecoder(w.Body).Decode(&response))
+
+		assert.Equal(t, "create", response.Operation)
+		assert.True(t, response.Image.Pull)
+		assert.Equal(t, []interface{}{"MODE"}, response.Config["env"])
+		assert.Nil(t, response.Changes)
+		assert.Equal(t, []map[string]string{{"kind": "port_conflict", "message": "host port 8080/tcp is already published by api"}}, response.Problems)
+	})
+
+	t.Run("Pull", func(t *testing.T) {
+		mockController.On("PlanPull", "db:13").Return(controller.Plan{Operation: "pull", Image: controller.PlannedImage{Reference: "db:13", ID: "sha256:db"}}, nil).Once()
+
+		w := sendJSONRequest(router, "POST", "/v2/images/pull?dry_run=true", apiKey, `{"image": "db:13"}`)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		var response plan
+		assert.Nil(t, json.NewDecoder(w.Body).Decode(&response))
+
+		assert.Equal(t, "pull", response.Operation)
+		assert.Empty(t, response.Container)
+		assert.Nil(t, response.Config)
+		assert.Equal(t, []map[string]string{}, response.Steps)
+	})
+
+	t.Run("Errors are returned like the operation's", func(t *testing.T) {
+		mockController.On("PlanRollback", "web").Return(controller.Plan{}, controller.ErrRollbackContainerNotFound).Once()
+
+		w := sendJSONRequest(router, "POST", "/v2/containers/web/rollback?dry_run=true", apiKey, "")
+
+		assert.Equal(t, http.StatusNotFound, w.Code)
+	})
+
+	t.Run("Invalid dry_run value", func(t *testing.T) {
+		w := sendRequest(router, "PUT", "/v1/containers/rollback?container=web&dry_run=maybe", apiKey)
+
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	})
+
+	t.Run("Dry runs aren't audited", func(t *testing.T) {
+		w := sendRequest(router, "GET", "/v1/audit", apiKey)
+
+		var response struct {
+			Entries []audit.Entry `json:"entries"`
+		}
+		assert.Nil(t, json.NewDecoder(w.Body).Decode(&response))
+
+		// only the request with the invalid dry_run value is recorded
+		assert.Len(t, response.Entries, 1)
+		assert.Equal(t, audit.OutcomeFailure, response.Entries[0].Outcome)
+	})
+
+	mockController.AssertExpectations(t)
+}
@@ -0,0 +1,89 @@
+package app
+
+import (
+	"github.com/XiovV/dokkup-agent/config"
+	"github.com/XiovV/dokkup-agent/ratelimit"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"time"
+)
+
+const (
+	routeClassRead   = "read"
+	routeClassMutate = "mutate"
+)
+
+// rateLimiters holds separate limiters for client IPs and API keys for every route class.
+type rateLimiters struct {
+	client  map[string]*ratelimit.Limiter
+	key     map[string]*ratelimit.Limiter
+	lockout *ratelimit.Lockout
+}
+
+func newRateLimiters(cfg *config.RateLimitConfig) *rateLimiters {
+	if cfg == nil {
+		cfg = &config.DefaultRateLimitConfig
+	}
+
+	return &rateLimiters{
+		client: map[string]*ratelimit.Limiter{
+			routeClassRead:   ratelimit.NewLimiter(cfg.Read.RequestsPerMinute, cfg.Read.Burst),
+			routeClassMutate: ratelimit.NewLimiter(cfg.Mutate.RequestsPerMinute, cfg.Mutate.Burst),
+		},
+		key: map[string]*ratelimit.Limiter{
+			routeClassRead:   ratelimit.NewLimiter(cfg.Read.RequestsPerMinute, cfg.Read.Burst),
+			routeClassMutate: ratelimit.NewLimiter(cfg.Mutate.RequestsPerMinute, cfg.Mutate.Burst),
+		},
+		lockout: ratelimit.NewLockout(
+			cfg.Lockout.Threshold,
+			time.Duration(cfg.Lockout.BaseSeconds)*time.Second,
+			time.Duration(cfg.Lockout.MaxSeconds)*time.Second,
+		),
+	}
+}
+
+// routeClass returns whether the request only reads state or if it mutates containers or images.
+func routeClass(c *gin.Context) string {
+	switch c.Request.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return routeClassRead
+	}
+
+	return routeClassMutate
+}
+
+// RateLimitClient rejects requests from client IPs which are locked out or
+// which have exceeded the limit of their route class. It must run before Authenticate.
+func (app *App) RateLimitClient() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		clientIP := clientIP(c)
+		limiters := app.settings().limiters
+
+		if lockedFor := limiters.lockout.LockedFor(clientIP); lockedFor > 0 {
+			app.tooManyRequestsResponse(c, codeLockedOut, "too many failed authentication attempts", lockedFor)
+			return
+		}
+
+		if ok, retryAfter := limiters.client[routeClass(c)].Allow(clientIP); !ok {
+			app.tooManyRequestsResponse(c, codeRateLimited, "rate limit exceeded", retryAfter)
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// RateLimitKey rejects requests made by principals which have exceeded the
+// limit of the route class. It must run after Authenticate.
+func (app *App) RateLimitKey() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		principal, _ := principalFromContext(c)
+
+		if ok, retryAfter := app.settings().limiters.key[routeClass(c)].Allow(principal.Method + ":" + principal.Name); !ok {
+			app.tooManyRequestsResponse(c, codeRateLimited, "rate limit exceeded", retryAfter)
+			return
+		}
+
+		c.Next()
+	}
+}
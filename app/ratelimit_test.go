@@ -0,0 +1,79 @@
+package app
+
+import (
+	"encoding/json"
+	"github.com/XiovV/dokkup-agent/config"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRateLimit(t *testing.T) {
+	defer removeConfig(t)
+	cfg, apiKey, err := config.New(testConfigFilename)
+	assert.Nil(t, err)
+
+	cfg.RateLimit = &config.RateLimitConfig{
+		Read:    config.RateLimit{RequestsPerMinute: 60, Burst: 10},
+		Mutate:  config.RateLimit{RequestsPerMinute: 1, Burst: 1},
+		Lockout: config.LockoutConfig{Threshold: 2, BaseSeconds: 30, MaxSeconds: 60},
+	}
+
+	mockController := new(mockDockerController)
+
+	var errorResponse struct {
+		Error string `json:"error"`
+	}
+
+	t.Run("Mutating requests over the limit", func(t *testing.T) {
+		router := New(mockController, cfg, nil, nil, testLogger()).Router()
+
+		mockController.On("PullImage", "imageName:latest").Return(nil).Once()
+
+		w := sendRequest(router, "PUT", "/v1/images/pull?image=imageName:latest", apiKey)
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		w = sendRequest(router, "PUT", "/v1/images/pull?image=imageName:latest", apiKey)
+		assert.Equal(t, http.StatusTooManyRequests, w.Code)
+		assert.Equal(t, "60", w.Header().Get("Retry-After"))
+
+		err = json.NewDecoder(w.Body).Decode(&errorResponse)
+		assert.Nil(t, err)
+
+		assert.Equal(t, "rate limit exceeded", errorResponse.Error)
+	})
+
+	t.Run("Lockout after invalid api keys", func(t *testing.T) {
+		router := New(mockController, cfg, nil, nil, testLogger()).Router()
+
+		w := sendRequest(router, "GET", "/v1/containers/image/containerName", "invalid")
+		assert.Equal(t, http.StatusForbidden, w.Code)
+
+		w = sendRequest(router, "GET", "/v1/containers/image/containerName", "invalid")
+		assert.Equal(t, http.StatusForbidden, w.Code)
+
+		w = sendRequest(router, "GET", "/v1/containers/image/containerName", apiKey)
+		assert.Equal(t, http.StatusTooManyRequests, w.Code)
+		assert.Equal(t, "30", w.Header().Get("Retry-After"))
+
+		err = json.NewDecoder(w.Body).Decode(&errorResponse)
+		assert.Nil(t, err)
+
+		assert.Equal(t, "too many failed authentication attempts", errorResponse.Error)
+	})
+	t.Run("Lockout can't be avoided by rotating X-Forwarded-For", func(t *testing.T) {
+		router := New(mockController, cfg, nil, nil, testLogger()).Router()
+
+		for _, forwardedFor := range []string{"203.0.113.1", "203.0.113.2", "203.0.113.3"} {
+			w := httptest.NewRecorder()
+			req, _ := http.NewRequest("GET", "/v1/containers/image/containerName", nil)
+			req.Header.Add("key", "invalid")
+			req.Header.Add("X-Forwarded-For", forwardedFor)
+			router.ServeHTTP(w, req)
+		}
+
+		w := sendRequest(router, "GET", "/v1/containers/image/containerName", apiKey)
+		assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	})
+}
@@ -0,0 +1,118 @@
+package app
+
+import (
+	"context"
+	"github.com/XiovV/dokkup-agent/audit"
+	"github.com/XiovV/dokkup-agent/auth"
+	"github.com/XiovV/dokkup-agent/controller"
+	"github.com/XiovV/dokkup-agent/logging"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"time"
+)
+
+// reconcilerPrincipal is who the changes made by the reconciler are attributed to in the audit log.
+var reconcilerPrincipal = auth.Principal{Name: "reconciler", Method: auth.MethodReconciler}
+
+// EnableReconciler makes GET /v1/reconcile/status report the status of reconciler.
+// It must be called before the router serves requests.
+func (app *App) EnableReconciler(reconciler *controller.Reconciler) {
+	app.reconciler = reconciler
+}
+
+// RecordReconcilerOperation is the controller.OperationRecorder of the reconciler. It tracks the operation
+// like those started through the API, so shutdown waits for it, and records its outcome to the audit log.
+func (app *App) RecordReconcilerOperation(ctx context.Context, action, containerName, image string) func(controller.ContainerChange, error) {
+	start := time.Now()
+	done := app.operations.track(ctx, action, containerName)
+
+	return func(change controller.ContainerChange, err error) {
+		done()
+
+		if app.auditLog == nil {
+			return
+		}
+
+		entry := audit.Entry{
+			Time:       start,
+			Action:     action,
+			KeyName:    reconcilerPrincipal.Name,
+			AuthMethod: reconcilerPrincipal.Method,
+			Container:  containerName,
+			Image:      image,
+			OldImage:   change.OldImage,
+			OldDigest:  change.OldImageID,
+			NewImage:   change.NewImage,
+			NewDigest:  change.NewImageID,
+			Outcome:    audit.OutcomeSuccess,
+			Status:     http.StatusOK,
+			DurationMs: time.Since(start).Milliseconds(),
+		}
+
+		if err != nil {
+			entry.Outcome = audit.OutcomeFailure
+			entry.Status = classifyOperationError(err).status
+			entry.Error = err.Error()
+		}
+
+		if err := app.auditLog.Record(entry); err != nil {
+			app.log.WithError(err).WithField("operation_id", logging.OperationID(ctx)).Error("couldn't write to the audit log")
+		}
+	}
+}
+
+// GetReconcileStatus handles GET /v1/reconcile/status. It reports the outcome of the reconciler's
+// last pass: the state of every container which has a manifest, and the settings which drifted.
+func (app *App) GetReconcileStatus(c *gin.Context) {
+	if app.reconciler == nil {
+		app.notFoundErrorResponse(c, codeNotFound, "reconciliation is not enabled")
+		return
+	}
+
+	status := app.reconciler.Status()
+
+	containers := make([]gin.H, 0, len(status.Containers))
+	for _, container := range status.Containers {
+		drift := make([]gin.H, 0, len(container.Drift))
+		for _, d := range container.Drift {
+			setting := gin.H{"setting": d.Setting}
+			if d.Desired != "" {
+				setting["desired"] = d.Desired
+			}
+			if d.Actual != "" {
+				setting["actual"] = d.Actual
+			}
+
+			drift = append(drift, setting)
+		}
+
+		response := gin.H{
+			"name":     container.Name,
+			"manifest": container.File,
+			"state":    container.State,
+			"drift":    drift,
+		}
+
+		if container.Action != "" {
+			response["action"] = container.Action
+		}
+
+		if container.Error != nil {
+			response["error"] = container.Error.Error()
+		}
+
+		containers = append(containers, response)
+	}
+
+	// last_run is left out until the first pass has finished
+	response := gin.H{"containers": containers}
+	if !status.LastRun.IsZero() {
+		response["last_run"] = status.LastRun
+	}
+
+	if status.Error != nil {
+		response["error"] = status.Error.Error()
+	}
+
+	c.JSON(http.StatusOK, response)
+}
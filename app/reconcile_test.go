@@ -0,0 +1,286 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/XiovV/dokkup-agent/audit"
+	"github.com/XiovV/dokkup-agent/auth"
+	"github.com/XiovV/dokkup-agent/config"
+	"github.com/XiovV/dokkup-agent/controller"
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/network"
+	"github.com/docker/go-connections/nat"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func (m *mockDockerController) InspectContainer(ctx context.Context, containerName string) (types.ContainerJSON, error) {
+	args := m.Called(containerName)
+
+	return args.Get(0).(types.ContainerJSON), args.Error(1)
+}
+
+func inspectedContainer(image string, labels map[string]string) types.ContainerJSON {
+	return types.ContainerJSON{
+		ContainerJSONBase: &types.ContainerJSONBase{HostConfig: &container.HostConfig{}},
+		Config:            &container.Config{Image: image, Labels: labels},
+	}
+}
+
+func TestGetReconcileStatus(t *testing.T) {
+	defer removeConfig(t)
+	cfg, apiKey, err := config.New(testConfigFilename)
+	assert.Nil(t, err)
+
+	mockController := new(mockDockerController)
+	app := New(mockController, cfg, nil, nil, testLogger())
+	router := app.Router()
+
+	t.Run("Not enabled", func(t *testing.T) {
+		w := sendRequest(router, "GET", "/v1/reconcile/status", apiKey)
+		assert.Equal(t, http.StatusNotFound, w.Code)
+	})
+
+	manifests := []controller.Manifest{
+		{File: "manifests/web.yaml", Spec: controller.ContainerSpec{Name: "web", Image: "nginx:1.22", Labels: map[string]string{"team": "web"}, Networks: []string{"frontend"}}},
+		{File: "manifests/api.yaml", Spec: controller.ContainerSpec{Name: "api", Image: "api:2.0"}},
+	}
+	reconciler := controller.NewReconciler(mockController, func() ([]controller.Manifest, error) { return manifests, nil }, testLogger())
+	app.EnableReconciler(reconciler)
+
+	// an update doesn't change the networks, so the container is recreated
+	converged := inspectedContainer("nginx:1.22", map[string]string{"team": "web"})
+	converged.NetworkSettings = &types.NetworkSettings{Networks: map[string]*network.EndpointSettings{"frontend": {}}}
+
+	mockController.On("InspectContainer", "web").Return(inspectedContainer("nginx:1.21", nil), nil).Once()
+	mockController.On("PullImage", "nginx:1.22").Return(nil).Once()
+	mockController.On("RecreateContainer", manifests[0].Spec).Return(controller.ContainerChange{}, nil).Once()
+	mockController.On("InspectContainer", "web").Return(converged, nil).Once()
+	mockController.On("InspectContainer", "api").Return(types.ContainerJSON{}, controller.ErrContainerNotFound).Once()
+	mockController.On("CreateContainer", manifests[1].Spec).Return(controller.ContainerChange{ContainerName: "api", NewImage: "api:2.0"}, nil).Once()
+	mockController.On("InspectContainer", "api").Return(inspectedContainer("api:2.0", nil), nil).Once()
+
+	reconciler.Reconcile(context.Background())
+	mockController.AssertExpectations(t)
+
+	w := sendRequest(router, "GET", "/v1/reconcile/status", apiKey)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var response struct {
+		LastRun    *string `json:"last_run"`
+		Containers []struct {
+			Name     string `json:"name"`
+			Manifest string `json:"manifest"`
+			State    string `json:"state"`
+			Action   string `json:"action"`
+			Drift    []struct {
+				Setting string `json:"setting"`
+				Desired string `json:"desired"`
+				Actual  string `json:"actual"`
+			} `json:"drift"`
+		} `json:"containers"`
+	}
+	assert.Nil(t, json.NewDecoder(w.Body).Decode(&response))
+
+	assert.NotNil(t, response.LastRun)
+	if !assert.Len(t, response.Containers, 2) {
+		return
+	}
+
+	web := response.Containers[0]
+	assert.Equal(t, "manifests/web.yaml", web.Manifest)
+	assert.Equal(t, controller.ReconcileInSync, web.State)
+	assert.Equal(t, controller.ReconcileActionRecreated, web.Action)
+	assert.Empty(t, web.Drift)
+
+	api := response.Containers[1]
+	assert.Equal(t, controller.ReconcileInSync, api.State)
+	assert.Equal(t, controller.ReconcileActionCreated, api.Action)
+	assert.Empty(t, api.Drift)
+
+	t.Run("Failed create", func(t *testing.T) {
+		mockController.On("InspectContainer", "web").Return(converged, nil).Once()
+		mockController.On("InspectContainer", "api").Return(types.ContainerJSON{}, controller.ErrContainerNotFound).Twice()
+		mockController.On("CreateContainer", manifests[1].Spec).Return(controller.ContainerChange{}, controller.ErrContainerNotRunning).Once()
+
+		status := reconciler.Reconcile(context.Background())
+		assert.Equal(t, controller.ReconcileInSync, status.Containers[0].State)
+		assert.Equal(t, controller.ReconcileMissing, status.Containers[1].State)
+		assert.ErrorIs(t, status.Containers[1].Error, controller.ErrContainerNotRunning)
+
+		// the create isn't retried until the manifest changes
+		mockController.On("InspectContainer", "web").Return(converged, nil).Once()
+
+		status = reconciler.Reconcile(context.Background())
+		assert.Equal(t, controller.ReconcileMissing, status.Containers[1].State)
+		assert.Contains(t, status.Containers[1].Error.Error(), "failed before")
+		mockController.AssertExpectations(t)
+	})
+}
+
+func TestReconcilerOperationsAreAudited(t *testing.T) {
+	defer removeConfig(t)
+	cfg, _, err := config.New(testConfigFilename)
+	assert.Nil(t, err)
+
+	auditLog, err := audit.Open(filepath.Join(t.TempDir(), "audit.jsonl"))
+	assert.Nil(t, err)
+	defer auditLog.Close()
+
+	mockController := new(mockDockerController)
+	app := New(mockController, cfg, nil, auditLog, testLogger())
+
+	unhealthy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer unhealthy.Close()
+
+	manifests := []controller.Manifest{
+		{File: "manifests/web.yaml", Spec: controller.ContainerSpec{Name: "web", Image: "nginx:1.22"}, Probe: &controller.Probe{HTTP: unhealthy.URL, Timeout: time.Second}},
+		{File: "manifests/api.yaml", Spec: controller.ContainerSpec{Name: "api", Image: "api:2.0"}},
+	}
+	reconciler := controller.NewReconciler(mockController, func() ([]controller.Manifest, error) { return manifests, nil }, testLogger())
+	reconciler.RecordOperations(app.RecordReconcilerOperation)
+
+	// shutdown waits for the reconciler's operations like for those started through the API
+	tracked := func(mock.Arguments) { assert.Len(t, app.operations.list(), 1) }
+
+	mockController.On("InspectContainer", "web").Return(inspectedContainer("nginx:1.21", nil), nil).Once()
+	mockController.On("PullImage", "nginx:1.22").Return(nil).Once()
+	mockController.On("UpdateContainerWithPatch", "web", "nginx:1.22", true, controller.ConfigPatch{}).Run(tracked).
+		Return(controller.ContainerChange{OldImage: "nginx:1.21", NewImage: "nginx:1.22"}, nil).Once()
+	mockController.On("RollbackContainer", "web").Run(tracked).
+		Return(controller.ContainerChange{OldImage: "nginx:1.22", NewImage: "nginx:1.21"}, nil).Once()
+	mockController.On("InspectContainer", "api").Return(types.ContainerJSON{}, controller.ErrContainerNotFound).Once()
+	mockController.On("CreateContainer", manifests[1].Spec).Run(tracked).Return(controller.ContainerChange{}, controller.ErrContainerNotRunning).Once()
+
+	reconciler.Reconcile(context.Background())
+	mockController.AssertExpectations(t)
+	assert.Empty(t, app.operations.list())
+
+	entries, err := auditLog.Query(audit.Filter{KeyName: "reconciler", Limit: 10})
+	assert.Nil(t, err)
+	if !assert.Len(t, entries, 3) {
+		return
+	}
+
+	// newest first
+	assert.Equal(t, auditActionCreate, entries[0].Action)
+	assert.Equal(t, "api", entries[0].Container)
+	assert.Equal(t, audit.OutcomeFailure, entries[0].Outcome)
+	assert.Equal(t, http.StatusInternalServerError, entries[0].Status)
+
+	assert.Equal(t, auditActionRollback, entries[1].Action)
+	assert.Equal(t, "nginx:1.21", entries[1].NewImage)
+
+	assert.Equal(t, auditActionUpdate, entries[2].Action)
+	assert.Equal(t, "web", entries[2].Container)
+	assert.Equal(t, "nginx:1.22", entries[2].Image)
+	assert.Equal(t, "nginx:1.21", entries[2].OldImage)
+	assert.Equal(t, audit.OutcomeSuccess, entries[2].Outcome)
+	assert.Equal(t, auth.MethodReconciler, entries[2].AuthMethod)
+}
+
+func TestReconcileSettingsDrift(t *testing.T) {
+	mockController := new(mockDockerController)
+
+	manifests := []controller.Manifest{{File: "manifests/web.yaml", Spec: controller.ContainerSpec{
+		Name:   "web",
+		Image:  "nginx:1.22",
+		Env:    map[string]string{"MODE": "production"},
+		Labels: map[string]string{"team": "web"},
+	}}}
+	reconciler := controller.NewReconciler(mockController, func() ([]controller.Manifest, error) { return manifests, nil }, testLogger())
+
+	drifted := inspectedContainer("nginx:1.22", map[string]string{"team": "web"})
+	drifted.Config.Env = []string{"MODE=debug"}
+	converged := inspectedContainer("nginx:1.22", map[string]string{"team": "web"})
+	converged.Config.Env = []string{"MODE=production"}
+
+	// the image is the same, so only the environment is updated
+	mockController.On("InspectContainer", "web").Return(drifted, nil).Once()
+	mockController.On("PullImage", "nginx:1.22").Return(nil).Once()
+	mockController.On("UpdateContainerWithPatch", "web", "nginx:1.22", true, controller.ConfigPatch{
+		Env:    map[string]string{"MODE": "production"},
+		Labels: map[string]string{"team": "web"},
+	}).Return(controller.ContainerChange{}, nil).Once()
+	mockController.On("InspectContainer", "web").Return(converged, nil).Once()
+
+	status := reconciler.Reconcile(context.Background())
+	mockController.AssertExpectations(t)
+
+	assert.Equal(t, controller.ReconcileInSync, status.Containers[0].State)
+	assert.Equal(t, controller.ReconcileActionUpdated, status.Containers[0].Action)
+	assert.Empty(t, status.Containers[0].Drift)
+}
+
+func TestReconcileRecreate(t *testing.T) {
+	defer removeConfig(t)
+	cfg, _, err := config.New(testConfigFilename)
+	assert.Nil(t, err)
+
+	auditLog, err := audit.Open(filepath.Join(t.TempDir(), "audit.jsonl"))
+	assert.Nil(t, err)
+	defer auditLog.Close()
+
+	mockController := new(mockDockerController)
+	app := New(mockController, cfg, nil, auditLog, testLogger())
+
+	manifests := []controller.Manifest{{File: "manifests/web.yaml", Spec: controller.ContainerSpec{
+		Name:  "web",
+		Image: "nginx:1.22",
+		Ports: []string{"8080:80"},
+	}}}
+	reconciler := controller.NewReconciler(mockController, func() ([]controller.Manifest, error) { return manifests, nil }, testLogger())
+	reconciler.RecordOperations(app.RecordReconcilerOperation)
+
+	published := func(hostPort string) types.ContainerJSON {
+		container := inspectedContainer("nginx:1.22", nil)
+		container.HostConfig.PortBindings = nat.PortMap{"80/tcp": {{HostPort: hostPort}}}
+		return container
+	}
+
+	t.Run("Ports are converged by recreating the container", func(t *testing.T) {
+		mockController.On("InspectContainer", "web").Return(published("8081"), nil).Once()
+		mockController.On("PullImage", "nginx:1.22").Return(nil).Once()
+		mockController.On("RecreateContainer", manifests[0].Spec).
+			Return(controller.ContainerChange{ContainerName: "web", OldImage: "nginx:1.22", NewImage: "nginx:1.22"}, nil).Once()
+		mockController.On("InspectContainer", "web").Return(published("8080"), nil).Once()
+
+		status := reconciler.Reconcile(context.Background())
+		mockController.AssertExpectations(t)
+
+		assert.Equal(t, controller.ReconcileInSync, status.Containers[0].State)
+		assert.Equal(t, controller.ReconcileActionRecreated, status.Containers[0].Action)
+		assert.Empty(t, status.Containers[0].Drift)
+
+		entries, err := auditLog.Query(audit.Filter{KeyName: "reconciler", Limit: 10})
+		assert.Nil(t, err)
+		if assert.Len(t, entries, 1) {
+			assert.Equal(t, auditActionRecreate, entries[0].Action)
+			assert.Equal(t, "web", entries[0].Container)
+			assert.Equal(t, audit.OutcomeSuccess, entries[0].Outcome)
+		}
+	})
+
+	t.Run("Failed recreate isn't retried", func(t *testing.T) {
+		mockController.On("InspectContainer", "web").Return(published("8081"), nil).Twice()
+		mockController.On("PullImage", "nginx:1.22").Return(nil).Once()
+		mockController.On("RecreateContainer", manifests[0].Spec).Return(controller.ContainerChange{}, controller.ErrContainerNotRunning).Once()
+
+		status := reconciler.Reconcile(context.Background())
+		assert.Equal(t, controller.ReconcileFailed, status.Containers[0].State)
+		assert.ErrorIs(t, status.Containers[0].Error, controller.ErrContainerNotRunning)
+
+		status = reconciler.Reconcile(context.Background())
+		assert.Equal(t, controller.ReconcileFailed, status.Containers[0].State)
+		assert.Contains(t, status.Containers[0].Error.Error(), "failed before")
+		mockController.AssertExpectations(t)
+	})
+}
@@ -0,0 +1,32 @@
+package app
+
+import (
+	"github.com/gin-gonic/gin"
+	"runtime/debug"
+)
+
+// Recovery turns a panic in a handler into a JSON 500 response and logs the panic along with its stack trace.
+func (app *App) Recovery() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		defer func() {
+			recovered := recover()
+			if recovered == nil {
+				return
+			}
+
+			app.requestLogger(c).
+				WithField("panic", recovered).
+				WithField("stack", string(debug.Stack())).
+				Error("recovered from panic")
+
+			if c.Writer.Written() {
+				c.Abort()
+				return
+			}
+
+			app.internalErrorResponse(c, codeInternal, "internal server error")
+		}()
+
+		c.Next()
+	}
+}
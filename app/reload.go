@@ -0,0 +1,88 @@
+package app
+
+import (
+	"fmt"
+	"github.com/XiovV/dokkup-agent/auth"
+	"github.com/XiovV/dokkup-agent/config"
+	"github.com/XiovV/dokkup-agent/controller"
+	"net"
+	"reflect"
+)
+
+// settings are the parts of the config which can be swapped while the agent is running.
+// A request reads them once, so it's handled with either the old or the new settings, never a mix.
+type settings struct {
+	config      *config.Config
+	jwtVerifier *auth.JWTVerifier
+	limiters    *rateLimiters
+
+	allowedNetworks []*net.IPNet
+	trustedProxies  []*net.IPNet
+
+	// registries are handed to the controller, which pulls images with them.
+	registries map[string]controller.RegistryCredentials
+}
+
+// newSettings builds the settings for cfg. The rate limiters of previous are kept if their
+// limits didn't change, so reloading doesn't reset the limits and lockouts of clients.
+func newSettings(cfg *config.Config, jwtVerifier *auth.JWTVerifier, previous *settings) (*settings, error) {
+	allowedNetworks, err := config.ParseCIDRs(cfg.AllowedCIDRs)
+	if err != nil {
+		return nil, fmt.Errorf("allowed_cidrs: %w", err)
+	}
+
+	trustedProxies, err := config.ParseCIDRs(cfg.TrustedProxies)
+	if err != nil {
+		return nil, fmt.Errorf("trusted_proxies: %w", err)
+	}
+
+	limiters := newRateLimiters(cfg.RateLimit)
+	if previous != nil && reflect.DeepEqual(previous.config.RateLimit, cfg.RateLimit) {
+		limiters = previous.limiters
+	}
+
+	registries := make(map[string]controller.RegistryCredentials, len(cfg.Registries))
+	for registry, auth := range cfg.Registries {
+		registries[registry] = controller.RegistryCredentials{Username: auth.Username, Password: auth.Password, IdentityToken: auth.IdentityToken}
+	}
+
+	return &settings{
+		config:          cfg,
+		jwtVerifier:     jwtVerifier,
+		limiters:        limiters,
+		allowedNetworks: allowedNetworks,
+		trustedProxies:  trustedProxies,
+		registries:      registries,
+	}, nil
+}
+
+// settings returns the settings currently in effect.
+func (app *App) settings() *settings {
+	return app.current.Load().(*settings)
+}
+
+// Reload swaps in a new config: the API key, the JWT settings and keys, the allowlist, the trusted proxies,
+// the rate limits, the permissions of client certificates and the registry credentials. Requests in progress finish with the old config.
+// If the new config can't be applied, an error is returned and the old config stays in effect.
+func (app *App) Reload(cfg *config.Config) error {
+	app.reloadMu.Lock()
+	defer app.reloadMu.Unlock()
+
+	var jwtVerifier *auth.JWTVerifier
+	if cfg.JWT != nil {
+		var err error
+		if jwtVerifier, err = auth.NewJWTVerifier(*cfg.JWT); err != nil {
+			return fmt.Errorf("jwt: %w", err)
+		}
+	}
+
+	s, err := newSettings(cfg, jwtVerifier, app.settings())
+	if err != nil {
+		return err
+	}
+
+	app.current.Store(s)
+	app.controller.SetRegistryCredentials(s.registries)
+
+	return nil
+}
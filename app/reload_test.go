@@ -0,0 +1,69 @@
+package app
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"github.com/XiovV/dokkup-agent/config"
+	"github.com/XiovV/dokkup-agent/controller"
+	"github.com/docker/docker/api/types"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"testing"
+)
+
+func TestReload(t *testing.T) {
+	defer removeConfig(t)
+	cfg, apiKey, err := config.New(testConfigFilename)
+	assert.Nil(t, err)
+
+	mockController := new(mockDockerController)
+	app := New(mockController, cfg, nil, nil, testLogger())
+	router := app.Router()
+
+	limiters := app.settings().limiters
+
+	t.Run("New api key", func(t *testing.T) {
+		next := *cfg
+		next.APIKey = fmt.Sprintf("%x", sha256.Sum256([]byte("rotated")))
+		assert.Nil(t, app.Reload(&next))
+
+		mockController.On("FindContainerByName", "web").Return(types.Container{Image: "web:1.0"}, true).Once()
+
+		w := sendRequest(router, "GET", "/v1/containers/image/web", "rotated")
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		w = sendRequest(router, "GET", "/v1/containers/image/web", apiKey)
+		assert.Equal(t, http.StatusForbidden, w.Code)
+
+		// the rate limits didn't change, so clients keep their limits and lockouts
+		assert.Same(t, limiters, app.settings().limiters)
+	})
+
+	t.Run("New allowlist", func(t *testing.T) {
+		next := *app.settings().config
+		next.AllowedCIDRs = []string{"10.0.0.0/8"}
+		assert.Nil(t, app.Reload(&next))
+
+		w := sendRequest(router, "GET", "/v1/containers/image/web", "rotated")
+		assert.Equal(t, http.StatusForbidden, w.Code)
+	})
+
+	t.Run("New registry credentials", func(t *testing.T) {
+		next := *app.settings().config
+		next.Registries = map[string]config.RegistryAuth{"ghcr.io": {Username: "deploy", Password: "secret"}}
+		assert.Nil(t, app.Reload(&next))
+
+		assert.Equal(t, map[string]controller.RegistryCredentials{"ghcr.io": {Username: "deploy", Password: "secret"}}, mockController.registries)
+	})
+
+	t.Run("Invalid config is rejected", func(t *testing.T) {
+		current := app.settings()
+
+		next := *current.config
+		next.JWT = &config.JWTConfig{JWKSFile: "missing.json"}
+		assert.NotNil(t, app.Reload(&next))
+
+		assert.Same(t, current, app.settings())
+		assert.Equal(t, current.registries, mockController.registries)
+	})
+}
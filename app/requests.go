@@ -0,0 +1,142 @@
+package app
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/XiovV/dokkup-agent/controller"
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/go-playground/validator/v10"
+	"net/http"
+	"reflect"
+	"strings"
+)
+
+// maxRequestBodySize limits the size of JSON request bodies.
+const maxRequestBodySize = 1 << 20
+
+// createRequest is the body of POST /v1/containers. Its settings are documented on controller.ContainerSpec.
+type createRequest struct {
+	Name          string            `json:"name" binding:"required"`
+	Image         string            `json:"image" binding:"required"`
+	Env           map[string]string `json:"env"`
+	Ports         []string          `json:"ports"`
+	Volumes       []string          `json:"volumes"`
+	Networks      []string          `json:"networks"`
+	Labels        map[string]string `json:"labels"`
+	RestartPolicy string            `json:"restart_policy"`
+	Resources     resourcesRequest  `json:"resources"`
+}
+
+type resourcesRequest struct {
+	MemoryBytes int64   `json:"memory_bytes"`
+	CPUs        float64 `json:"cpus"`
+}
+
+func (r createRequest) spec() controller.ContainerSpec {
+	return controller.ContainerSpec{
+		Name:          r.Name,
+		Image:         r.Image,
+		Env:           r.Env,
+		Ports:         r.Ports,
+		Volumes:       r.Volumes,
+		Networks:      r.Networks,
+		Labels:        r.Labels,
+		RestartPolicy: r.RestartPolicy,
+		Resources:     controller.Resources{MemoryBytes: r.Resources.MemoryBytes, CPUs: r.Resources.CPUs},
+	}
+}
+
+// updateRequest is the body of POST /v2/containers/:name/update.
+type updateRequest struct {
+	Image string        `json:"image" binding:"required"`
+	Keep  bool          `json:"keep"`
+	Patch *patchRequest `json:"patch"`
+}
+
+// patchRequest changes settings of the container during an update, see controller.ConfigPatch.
+type patchRequest struct {
+	Env           map[string]string      `json:"env"`
+	UnsetEnv      []string               `json:"unset_env"`
+	Labels        map[string]string      `json:"labels"`
+	UnsetLabels   []string               `json:"unset_labels"`
+	Cmd           []string               `json:"cmd"`
+	Entrypoint    []string               `json:"entrypoint"`
+	RestartPolicy string                 `json:"restart_policy"`
+	Resources     *patchResourcesRequest `json:"resources"`
+}
+
+// patchResourcesRequest leaves the limits which are left out as they are; 0 removes a limit.
+type patchResourcesRequest struct {
+	MemoryBytes *int64   `json:"memory_bytes"`
+	CPUs        *float64 `json:"cpus"`
+}
+
+func (r patchRequest) patch() controller.ConfigPatch {
+	patch := controller.ConfigPatch{
+		Env:           r.Env,
+		UnsetEnv:      r.UnsetEnv,
+		Labels:        r.Labels,
+		UnsetLabels:   r.UnsetLabels,
+		Cmd:           r.Cmd,
+		Entrypoint:    r.Entrypoint,
+		RestartPolicy: r.RestartPolicy,
+	}
+
+	if r.Resources != nil {
+		patch.MemoryBytes = r.Resources.MemoryBytes
+		patch.CPUs = r.Resources.CPUs
+	}
+
+	return patch
+}
+
+// pullRequest is the body of POST /v2/images/pull.
+type pullRequest struct {
+	Image string `json:"image" binding:"required"`
+}
+
+func init() {
+	// report fields by their JSON names in validation errors
+	if engine, ok := binding.Validator.Engine().(*validator.Validate); ok {
+		engine.RegisterTagNameFunc(func(field reflect.StructField) string {
+			name := strings.SplitN(field.Tag.Get("json"), ",", 2)[0]
+			if name == "-" {
+				return ""
+			}
+
+			return name
+		})
+	}
+}
+
+// bindJSON decodes the request body into obj and validates it with the binding tags.
+// Unknown fields are rejected, so typos in option names don't go unnoticed. If the body
+// is invalid, a 400 response is sent and false is returned.
+func (app *App) bindJSON(c *gin.Context, obj interface{}) bool {
+	decoder := json.NewDecoder(http.MaxBytesReader(c.Writer, c.Request.Body, maxRequestBodySize))
+	decoder.DisallowUnknownFields()
+
+	if err := decoder.Decode(obj); err != nil {
+		app.errorResponse(c, http.StatusBadRequest, codeBadRequest, "request body is not valid JSON", gin.H{"reason": err.Error()})
+		return false
+	}
+
+	if err := binding.Validator.ValidateStruct(obj); err != nil {
+		var validationErrors validator.ValidationErrors
+		if !errors.As(err, &validationErrors) {
+			app.badRequestResponse(c, codeBadRequest, err.Error())
+			return false
+		}
+
+		fields := gin.H{}
+		for _, fieldError := range validationErrors {
+			fields[fieldError.Field()] = fieldError.Tag()
+		}
+
+		app.errorResponse(c, http.StatusBadRequest, codeBadRequest, "request body is invalid", gin.H{"fields": fields})
+		return false
+	}
+
+	return true
+}
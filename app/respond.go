@@ -2,21 +2,83 @@ package app
 
 import (
 	"github.com/gin-gonic/gin"
+	"math"
 	"net/http"
+	"strconv"
+	"time"
 )
 
+// Error codes returned in the code field of error responses. Unlike the messages,
+// they never change, so clients can branch on them.
+const (
+	codeBadRequest          = "BAD_REQUEST"
+	codeNotFound            = "NOT_FOUND"
+	codeImageInvalid        = "IMAGE_INVALID"
+	codeContainerNotFound   = "CONTAINER_NOT_FOUND"
+	codeContainerExists     = "CONTAINER_EXISTS"
+	codeSpecInvalid         = "SPEC_INVALID"
+	codeRollbackNotFound    = "ROLLBACK_NOT_FOUND"
+	codeStartFailed         = "START_FAILED"
+	codeContainerNotRunning = "CONTAINER_NOT_RUNNING"
+	codeRestoreFailed       = "RESTORE_FAILED"
+	codeDockerUnavailable   = "DOCKER_UNAVAILABLE"
+	codeTimeout             = "TIMEOUT"
+	codeCancelled           = "CANCELLED"
+	codeInvalidCredentials  = "INVALID_CREDENTIALS"
+	codePermissionDenied    = "PERMISSION_DENIED"
+	codeAddressNotAllowed   = "ADDRESS_NOT_ALLOWED"
+	codeRateLimited         = "RATE_LIMITED"
+	codeLockedOut           = "LOCKED_OUT"
+	codeInternal            = "INTERNAL_ERROR"
+)
+
+// statusClientClosedRequest is the non-standard status used when the client went away before the operation finished.
+const statusClientClosedRequest = 499
+
 func (app *App) successResponse(c *gin.Context, message string) {
 	c.JSON(http.StatusOK, gin.H{"message": message})
 }
 
-func (app *App) badRequestResponse(c *gin.Context, message string) {
-	c.JSON(http.StatusBadRequest, gin.H{"error": message})
+// errorResponse aborts the request with the error envelope shared by all endpoints: a human readable
+// message, a stable code, the id of the operation if the request started one, and optional details.
+func (app *App) errorResponse(c *gin.Context, status int, code, message string, details gin.H) {
+	body := gin.H{"error": message, "code": code}
+
+	if operationID := c.GetString(operationIDContextKey); operationID != "" {
+		body["operation_id"] = operationID
+	}
+
+	if len(details) > 0 {
+		body["details"] = details
+	}
+
+	c.AbortWithStatusJSON(status, body)
+}
+
+func (app *App) badRequestResponse(c *gin.Context, code, message string) {
+	app.errorResponse(c, http.StatusBadRequest, code, message, nil)
 }
 
-func (app *App) notFoundErrorResponse(c *gin.Context, message string) {
-	c.JSON(http.StatusNotFound, gin.H{"error": message})
+func (app *App) forbiddenResponse(c *gin.Context, code, message string) {
+	app.errorResponse(c, http.StatusForbidden, code, message, nil)
 }
 
-func (app *App) internalErrorResponse(c *gin.Context, message string) {
-	c.JSON(http.StatusInternalServerError, gin.H{"error": message})
+func (app *App) notFoundErrorResponse(c *gin.Context, code, message string) {
+	app.errorResponse(c, http.StatusNotFound, code, message, nil)
+}
+
+func (app *App) internalErrorResponse(c *gin.Context, code, message string) {
+	app.errorResponse(c, http.StatusInternalServerError, code, message, nil)
+}
+
+func (app *App) serviceUnavailableResponse(c *gin.Context, message string) {
+	app.errorResponse(c, http.StatusServiceUnavailable, codeDockerUnavailable, message, nil)
+}
+
+// tooManyRequestsResponse aborts the request and tells the client how many seconds it should wait before retrying.
+func (app *App) tooManyRequestsResponse(c *gin.Context, code, message string, retryAfter time.Duration) {
+	seconds := int(math.Ceil(retryAfter.Seconds()))
+
+	c.Header("Retry-After", strconv.Itoa(seconds))
+	app.errorResponse(c, http.StatusTooManyRequests, code, message, gin.H{"retry_after_seconds": seconds})
 }
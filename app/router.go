@@ -1,19 +1,74 @@
 package app
 
-import "github.com/gin-gonic/gin"
+import (
+	"github.com/XiovV/dokkup-agent/auth"
+	"github.com/XiovV/dokkup-agent/metrics"
+	"github.com/gin-gonic/gin"
+)
 
 func (app *App) Router() *gin.Engine {
-	router := gin.Default()
+	router := gin.New()
+	// client addresses are resolved by ResolveClientIP, which only trusts the configured proxies. gin trusts
+	// X-Forwarded-For from anyone by default, which would let clients pick the address they're rate limited under.
+	router.ForwardedByClientIP = false
+	router.TrustedProxies = nil
+	router.Use(app.RequestID(), app.ResolveClientIP(), app.Logger(), app.Metrics(), app.Recovery())
 
-	v1 := router.Group("/v1")
-	v1.Use(app.Authenticate())
+	router.NoRoute(func(c *gin.Context) {
+		app.notFoundErrorResponse(c, codeNotFound, "the requested route does not exist")
+	})
+
+	// metrics name every container and image, so they need the same authentication as the API
+	router.GET("/metrics", app.AllowClients(), app.RateLimitClient(), app.Authenticate(), app.RateLimitKey(),
+		app.RequirePermission(auth.PermissionMetricsRead), gin.WrapH(metrics.Handler()))
+	router.GET("/openapi.json", app.AllowClients(), app.OpenAPI)
+	router.GET("/healthz", app.Healthz)
+	router.GET("/readyz", app.Readyz)
+
+	// the bootstrap token is checked by the handler, since the caller has no api key yet
+	router.POST("/v1/bootstrap", app.Tracing(), app.AllowClients(), app.RateLimitClient(), app.Audit(auditActionBootstrap), app.Bootstrap)
+
+	// v1 takes its parameters from query strings, and is kept for compatibility with existing clients.
+	// Only POST /v1/containers, which was added later, takes a JSON body.
+	v1 := app.apiGroup(router, "/v1")
 	{
-		v1.GET("/containers/image/:containerName", app.GetContainerImage)
+		v1.GET("/audit", app.RequirePermission(auth.PermissionAuditRead), app.GetAuditLog)
+		v1.GET("/info", app.RequirePermission(auth.PermissionInfoRead), app.GetInfo)
+		v1.GET("/reconcile/status", app.RequirePermission(auth.PermissionContainersRead), app.GetReconcileStatus)
 
-		v1.PUT("/images/pull", app.PullImage)
-		v1.PUT("/containers/update", app.UpdateContainer)
-		v1.PUT("/containers/rollback", app.RollbackContainer)
+		// routes which talk to the docker daemon are rejected while it's unreachable
+		docker := v1.Group("", app.RequireDocker())
+		// a catch-all, so that an empty name still reaches the handler, which answers it with "container not found"
+		docker.GET("/containers/image/*containerName", app.RequirePermission(auth.PermissionContainersRead), app.GetContainerImage)
+
+		docker.POST("/containers", app.RequirePermission(auth.PermissionContainersCreate), app.Audit(auditActionCreate), app.CreateContainer)
+		docker.PUT("/images/pull", app.RequirePermission(auth.PermissionImagesPull), app.Audit(auditActionPull), app.PullImage)
+		docker.PUT("/containers/update", app.RequirePermission(auth.PermissionContainersUpdate), app.Audit(auditActionUpdate), app.UpdateContainer)
+		docker.PUT("/containers/rollback", app.RequirePermission(auth.PermissionContainersRollback), app.Audit(auditActionRollback), app.RollbackContainer)
+	}
+
+	v2 := app.apiGroup(router, "/v2")
+	{
+		v2.GET("/audit", app.RequirePermission(auth.PermissionAuditRead), app.GetAuditLog)
+		v2.GET("/info", app.RequirePermission(auth.PermissionInfoRead), app.GetInfo)
+
+		docker := v2.Group("", app.RequireDocker())
+		docker.GET("/containers", app.RequirePermission(auth.PermissionContainersRead), app.ListContainersV2)
+		docker.GET("/containers/:name", app.RequirePermission(auth.PermissionContainersRead), app.GetContainerV2)
+		docker.GET("/containers/:name/logs", app.RequirePermission(auth.PermissionContainersLogs), app.ContainerLogsV2)
+		docker.POST("/containers/:name/update", app.RequirePermission(auth.PermissionContainersUpdate), app.Audit(auditActionUpdate), app.UpdateContainerV2)
+		docker.POST("/containers/:name/rollback", app.RequirePermission(auth.PermissionContainersRollback), app.Audit(auditActionRollback), app.RollbackContainerV2)
+		docker.POST("/images/pull", app.RequirePermission(auth.PermissionImagesPull), app.Audit(auditActionPull), app.PullImageV2)
 	}
 
 	return router
 }
+
+// apiGroup returns a route group with the middleware shared by all API versions: tracing,
+// the IP allowlist, rate limiting and authentication.
+func (app *App) apiGroup(router *gin.Engine, path string) *gin.RouterGroup {
+	group := router.Group(path)
+	group.Use(app.Tracing(), app.AllowClients(), app.RateLimitClient(), app.Authenticate(), app.RateLimitKey())
+
+	return group
+}
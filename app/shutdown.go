@@ -0,0 +1,115 @@
+package app
+
+import (
+	"context"
+	"github.com/XiovV/dokkup-agent/logging"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Operation is an update, rollback or pull which is in progress.
+type Operation struct {
+	ID     string
+	Action string
+
+	// Target is the container of an update or rollback, or the image of a pull.
+	Target string
+	Start  time.Time
+}
+
+// operations keeps track of the operations in progress, so shutdown can wait for them to finish.
+type operations struct {
+	mu      sync.Mutex
+	running map[*Operation]struct{}
+	idle    chan struct{}
+
+	stopping chan struct{}
+	stopOnce sync.Once
+}
+
+func newOperations() *operations {
+	idle := make(chan struct{})
+	close(idle)
+
+	return &operations{running: map[*Operation]struct{}{}, idle: idle, stopping: make(chan struct{})}
+}
+
+// track records an operation of the request in ctx and returns the function which marks it as finished.
+func (o *operations) track(ctx context.Context, action, target string) func() {
+	operation := &Operation{ID: logging.OperationID(ctx), Action: action, Target: target, Start: time.Now()}
+
+	o.mu.Lock()
+	if len(o.running) == 0 {
+		o.idle = make(chan struct{})
+	}
+	o.running[operation] = struct{}{}
+	o.mu.Unlock()
+
+	return func() {
+		o.mu.Lock()
+		defer o.mu.Unlock()
+
+		delete(o.running, operation)
+		if len(o.running) == 0 {
+			close(o.idle)
+		}
+	}
+}
+
+// list returns the operations in progress, oldest first.
+func (o *operations) list() []Operation {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	list := make([]Operation, 0, len(o.running))
+	for operation := range o.running {
+		list = append(list, *operation)
+	}
+
+	sort.Slice(list, func(i, j int) bool { return list[i].Start.Before(list[j].Start) })
+
+	return list
+}
+
+// Stop ends the streams the agent is serving, such as followed logs and events, so they don't
+// hold up shutting down. Operations in progress aren't affected, see WaitForOperations.
+func (app *App) Stop() {
+	app.operations.stopOnce.Do(func() { close(app.operations.stopping) })
+}
+
+// WaitForOperations waits until no updates, rollbacks or pulls are in progress, or until ctx is done.
+// It returns the operations which were still in progress when ctx was done.
+func (app *App) WaitForOperations(ctx context.Context) []Operation {
+	for {
+		app.operations.mu.Lock()
+		idle := app.operations.idle
+		app.operations.mu.Unlock()
+
+		select {
+		case <-idle:
+			// another operation may have started in the meantime
+			if running := app.operations.list(); len(running) == 0 {
+				return nil
+			}
+		case <-ctx.Done():
+			return app.operations.list()
+		}
+	}
+}
+
+// streamContext returns a context which is cancelled along with ctx, or once the agent stops.
+// Streams which only end when the client goes away use it.
+func (app *App) streamContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(ctx)
+
+	go func() {
+		select {
+		case <-app.operations.stopping:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	return ctx, cancel
+}
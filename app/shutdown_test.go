@@ -0,0 +1,70 @@
+package app
+
+import (
+	"context"
+	"github.com/XiovV/dokkup-agent/config"
+	"github.com/XiovV/dokkup-agent/logging"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestWaitForOperations(t *testing.T) {
+	defer removeConfig(t)
+	cfg, _, err := config.New(testConfigFilename)
+	assert.Nil(t, err)
+
+	app := New(new(mockDockerController), cfg, nil, nil, testLogger())
+
+	t.Run("Nothing in progress", func(t *testing.T) {
+		assert.Empty(t, app.WaitForOperations(context.Background()))
+	})
+
+	t.Run("Operations finish", func(t *testing.T) {
+		doneUpdate := app.operations.track(logging.WithOperationID(context.Background(), "abc"), auditActionUpdate, "web")
+		donePull := app.operations.track(context.Background(), auditActionPull, "web:1.1")
+
+		go func() {
+			time.Sleep(10 * time.Millisecond)
+			doneUpdate()
+			donePull()
+		}()
+
+		assert.Empty(t, app.WaitForOperations(context.Background()))
+	})
+
+	t.Run("Deadline is reached", func(t *testing.T) {
+		done := app.operations.track(logging.WithOperationID(context.Background(), "abc"), auditActionUpdate, "web")
+		defer done()
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+
+		running := app.WaitForOperations(ctx)
+		if assert.Len(t, running, 1) {
+			assert.Equal(t, "abc", running[0].ID)
+			assert.Equal(t, auditActionUpdate, running[0].Action)
+			assert.Equal(t, "web", running[0].Target)
+		}
+	})
+}
+
+func TestStop(t *testing.T) {
+	defer removeConfig(t)
+	cfg, _, err := config.New(testConfigFilename)
+	assert.Nil(t, err)
+
+	app := New(new(mockDockerController), cfg, nil, nil, testLogger())
+
+	ctx, cancel := app.streamContext(context.Background())
+	defer cancel()
+
+	app.Stop()
+	app.Stop()
+
+	select {
+	case <-ctx.Done():
+	case <-time.After(time.Second):
+		t.Error("stream wasn't stopped")
+	}
+}
@@ -0,0 +1,253 @@
+package app
+
+import (
+	"encoding/json"
+	"github.com/XiovV/dokkup-agent/config"
+	"github.com/XiovV/dokkup-agent/controller"
+	"github.com/docker/docker/api/types"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func sendJSONRequest(router *gin.Engine, method, location, apiKey, body string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest(method, location, strings.NewReader(body))
+	req.Header.Add("key", apiKey)
+	req.Header.Add("Content-Type", "application/json")
+	router.ServeHTTP(w, req)
+
+	return w
+}
+
+func TestV2(t *testing.T) {
+	defer removeConfig(t)
+	cfg, apiKey, err := config.New(testConfigFilename)
+	assert.Nil(t, err)
+
+	mockController := new(mockDockerController)
+
+	router := New(mockController, cfg, nil, nil, testLogger()).Router()
+
+	var errorResponse struct {
+		Error   string `json:"error"`
+		Code    string `json:"code"`
+		Details struct {
+			Fields map[string]string `json:"fields"`
+		} `json:"details"`
+	}
+
+	t.Run("Update container", func(t *testing.T) {
+		mockController.On("UpdateContainer", "web", "web:1.1", true).Return(controller.ContainerChange{}, nil).Once()
+
+		w := sendJSONRequest(router, "POST", "/v2/containers/web/update", apiKey, `{"image": "web:1.1", "keep": true}`)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+	})
+
+	t.Run("Update without keep", func(t *testing.T) {
+		mockController.On("UpdateContainer", "web", "web:1.1", false).Return(controller.ContainerChange{}, nil).Once()
+
+		w := sendJSONRequest(router, "POST", "/v2/containers/web/update", apiKey, `{"image": "web:1.1"}`)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+	})
+
+	t.Run("Update with patch", func(t *testing.T) {
+		memory := int64(536870912)
+		patch := controller.ConfigPatch{
+			Env:           map[string]string{"MODE": "production"},
+			UnsetEnv:      []string{"DEBUG"},
+			Cmd:           []string{"nginx", "-g", "daemon off;"},
+			RestartPolicy: "always",
+			MemoryBytes:   &memory,
+		}
+		mockController.On("UpdateContainerWithPatch", "web", "web:1.1", true, patch).Return(controller.ContainerChange{
+			ContainerName: "web",
+			OldImage:      "web:1.0",
+			NewImage:      "web:1.1",
+			Settings: []controller.SettingChange{
+				{Setting: "env.DEBUG", Old: "<redacted>"},
+				{Setting: "env.MODE", New: "<redacted>"},
+				{Setting: "resources.memory_bytes", Old: "268435456", New: "536870912"},
+			},
+		}, nil).Once()
+
+		w := sendJSONRequest(router, "POST", "/v2/containers/web/update", apiKey, `{
+			"image": "web:1.1",
+			"keep": true,
+			"patch": {
+				"env": {"MODE": "production"},
+				"unset_env": ["DEBUG"],
+				"cmd": ["nginx", "-g", "daemon off;"],
+				"restart_policy": "always",
+				"resources": {"memory_bytes": 536870912}
+			}
+		}`)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		var response struct {
+			Changes []map[string]string `json:"changes"`
+		}
+		assert.Nil(t, json.NewDecoder(w.Body).Decode(&response))
+
+		assert.Equal(t, []map[string]string{
+			{"setting": "image", "old": "web:1.0", "new": "web:1.1"},
+			{"setting": "env.DEBUG", "old": "<redacted>"},
+			{"setting": "env.MODE", "new": "<redacted>"},
+			{"setting": "resources.memory_bytes", "old": "268435456", "new": "536870912"},
+		}, response.Changes)
+	})
+
+	t.Run("Update with invalid patch", func(t *testing.T) {
+		patch := controller.ConfigPatch{Env: map[string]string{"MODE": "production"}, UnsetEnv: []string{"MODE"}}
+		mockController.On("UpdateContainerWithPatch", "web", "web:1.1", false, patch).
+			Return(controller.ContainerChange{}, controller.ErrSpecInvalid{Setting: "unset_env", Reason: "MODE is also set in env"}).Once()
+
+		w := sendJSONRequest(router, "POST", "/v2/containers/web/update", apiKey, `{"image": "web:1.1", "patch": {"env": {"MODE": "production"}, "unset_env": ["MODE"]}}`)
+
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+
+		err = json.NewDecoder(w.Body).Decode(&errorResponse)
+		assert.Nil(t, err)
+
+		assert.Equal(t, "SPEC_INVALID", errorResponse.Code)
+	})
+
+	t.Run("Update without image", func(t *testing.T) {
+		w := sendJSONRequest(router, "POST", "/v2/containers/web/update", apiKey, `{"keep": true}`)
+
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+
+		err = json.NewDecoder(w.Body).Decode(&errorResponse)
+		assert.Nil(t, err)
+
+		assert.Equal(t, "BAD_REQUEST", errorResponse.Code)
+		assert.Equal(t, map[string]string{"image": "required"}, errorResponse.Details.Fields)
+	})
+
+	t.Run("Update with unknown field", func(t *testing.T) {
+		w := sendJSONRequest(router, "POST", "/v2/containers/web/update", apiKey, `{"image": "web:1.1", "keep_container": true}`)
+
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	})
+
+	t.Run("Update with malformed body", func(t *testing.T) {
+		w := sendJSONRequest(router, "POST", "/v2/containers/web/update", apiKey, `{"image": `)
+
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+
+		err = json.NewDecoder(w.Body).Decode(&errorResponse)
+		assert.Nil(t, err)
+
+		assert.Equal(t, "request body is not valid JSON", errorResponse.Error)
+	})
+
+	t.Run("Rollback container", func(t *testing.T) {
+		mockController.On("RollbackContainer", "web").Return(controller.ContainerChange{}, controller.ErrRollbackContainerNotFound).Once()
+
+		w := sendJSONRequest(router, "POST", "/v2/containers/web/rollback", apiKey, "")
+
+		assert.Equal(t, http.StatusNotFound, w.Code)
+
+		err = json.NewDecoder(w.Body).Decode(&errorResponse)
+		assert.Nil(t, err)
+
+		assert.Equal(t, "ROLLBACK_NOT_FOUND", errorResponse.Code)
+	})
+
+	t.Run("Pull image", func(t *testing.T) {
+		mockController.On("PullImage", "web:1.1").Return(nil).Once()
+
+		w := sendJSONRequest(router, "POST", "/v2/images/pull", apiKey, `{"image": "web:1.1"}`)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+	})
+
+	t.Run("Get container", func(t *testing.T) {
+		mockController.On("FindContainerByName", "web").Return(types.Container{ID: "abc", Image: "web:1.1", State: "running"}, true).Once()
+
+		w := sendJSONRequest(router, "GET", "/v2/containers/web", apiKey, "")
+
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		var container struct {
+			ID    string `json:"id"`
+			Image string `json:"image"`
+			State string `json:"state"`
+		}
+
+		err = json.NewDecoder(w.Body).Decode(&container)
+		assert.Nil(t, err)
+
+		assert.Equal(t, "abc", container.ID)
+		assert.Equal(t, "web:1.1", container.Image)
+		assert.Equal(t, "running", container.State)
+	})
+
+	t.Run("List containers", func(t *testing.T) {
+		mockController.On("ListContainers").Return([]types.Container{
+			{Names: []string{"/web"}, ID: "abc", Image: "web:1.1", State: "running"},
+			{Names: []string{"/db"}, ID: "def", Image: "postgres:13", State: "exited"},
+		}, nil).Once()
+
+		w := sendJSONRequest(router, "GET", "/v2/containers", apiKey, "")
+
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		var list struct {
+			Containers []struct {
+				Name  string `json:"name"`
+				State string `json:"state"`
+			} `json:"containers"`
+		}
+
+		err = json.NewDecoder(w.Body).Decode(&list)
+		assert.Nil(t, err)
+
+		assert.Len(t, list.Containers, 2)
+		assert.Equal(t, "web", list.Containers[0].Name)
+		assert.Equal(t, "exited", list.Containers[1].State)
+	})
+
+	t.Run("Container logs", func(t *testing.T) {
+		opts := controller.LogsOptions{Tail: "10", Timestamps: true}
+		mockController.On("ContainerLogs", "web", opts).Return(ioutil.NopCloser(strings.NewReader("line 1\nline 2\n")), nil).Once()
+
+		w := sendJSONRequest(router, "GET", "/v2/containers/web/logs?tail=10&timestamps=true", apiKey, "")
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "text/plain; charset=utf-8", w.Header().Get("Content-Type"))
+		assert.Equal(t, "line 1\nline 2\n", w.Body.String())
+	})
+
+	t.Run("Container logs with invalid tail", func(t *testing.T) {
+		w := sendJSONRequest(router, "GET", "/v2/containers/web/logs?tail=-1", apiKey, "")
+
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	})
+
+	t.Run("Logs of a missing container", func(t *testing.T) {
+		mockController.On("ContainerLogs", "missing", controller.LogsOptions{Tail: "all"}).Return(nil, controller.ErrContainerNotFound).Once()
+
+		w := sendJSONRequest(router, "GET", "/v2/containers/missing/logs", apiKey, "")
+
+		assert.Equal(t, http.StatusNotFound, w.Code)
+
+		err = json.NewDecoder(w.Body).Decode(&errorResponse)
+		assert.Nil(t, err)
+
+		assert.Equal(t, codeContainerNotFound, errorResponse.Code)
+	})
+
+	t.Run("Without api key", func(t *testing.T) {
+		w := sendJSONRequest(router, "POST", "/v2/images/pull", "invalid", `{"image": "web:1.1"}`)
+
+		assert.Equal(t, http.StatusForbidden, w.Code)
+	})
+}
@@ -0,0 +1,301 @@
+// Package audit implements an append-only, tamper-evident log of mutating API calls.
+// Every entry is stored as a line of JSON and contains the hash of the previous entry,
+// so removing or modifying an entry breaks the chain. The chain isn't keyed or anchored
+// anywhere else though, so removing entries from the end of the log, or rewriting all of
+// it, keeps it valid. That can only be detected by comparing the log with the head hash
+// and entry count reported after every write, see Log.OnRecord.
+package audit
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/XiovV/dokkup-agent/metrics"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	OutcomeSuccess = "success"
+	OutcomeFailure = "failure"
+)
+
+// maxLineSize is the largest entry the log reader accepts.
+const maxLineSize = 1024 * 1024
+
+var ErrChainBroken = errors.New("audit log hash chain is broken")
+
+type Entry struct {
+	Time       time.Time `json:"time"`
+	Action     string    `json:"action"`
+	KeyName    string    `json:"key_name"`
+	AuthMethod string    `json:"auth_method"`
+	ClientIP   string    `json:"client_ip"`
+	Container  string    `json:"container,omitempty"`
+	Image      string    `json:"image,omitempty"`
+	OldImage   string    `json:"old_image,omitempty"`
+	OldDigest  string    `json:"old_digest,omitempty"`
+	NewImage   string    `json:"new_image,omitempty"`
+	NewDigest  string    `json:"new_digest,omitempty"`
+	Outcome    string    `json:"outcome"`
+	Status     int       `json:"status"`
+	DurationMs int64     `json:"duration_ms"`
+	Error      string    `json:"error,omitempty"`
+	PrevHash   string    `json:"prev_hash"`
+	Hash       string    `json:"hash"`
+}
+
+// computeHash returns the hash of the entry with its Hash field left empty.
+func (e Entry) computeHash() (string, error) {
+	e.Hash = ""
+
+	data, err := json.Marshal(e)
+	if err != nil {
+		return "", err
+	}
+
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// Filter narrows down the entries returned by Log.Query. Zero values match everything.
+type Filter struct {
+	Action    string
+	Container string
+	KeyName   string
+	Outcome   string
+	Since     time.Time
+	Until     time.Time
+	Limit     int
+}
+
+func (f Filter) matches(e Entry) bool {
+	switch {
+	case f.Action != "" && e.Action != f.Action:
+		return false
+	case f.Container != "" && e.Container != f.Container:
+		return false
+	case f.KeyName != "" && e.KeyName != f.KeyName:
+		return false
+	case f.Outcome != "" && e.Outcome != f.Outcome:
+		return false
+	case !f.Since.IsZero() && e.Time.Before(f.Since):
+		return false
+	case !f.Until.IsZero() && e.Time.After(f.Until):
+		return false
+	}
+
+	return true
+}
+
+type Log struct {
+	mu       sync.Mutex
+	file     *os.File
+	filename string
+	lastHash string
+	entries  int
+	onRecord func(hash string, entries int)
+
+	// writeErr is the first error which occurred while appending to the file. Once it's set,
+	// the file may end with a partial entry, so the log is no longer considered clean.
+	writeErr error
+}
+
+// Open opens the audit log at filename for appending, creating it if it doesn't exist.
+// It returns ErrChainBroken if the existing entries don't form a valid chain.
+func Open(filename string) (*Log, error) {
+	lastHash, entries, err := Verify(filename)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+
+	file, err := os.OpenFile(filename, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	metrics.AuditEntries.Set(float64(entries))
+
+	return &Log{file: file, filename: filename, lastHash: lastHash, entries: entries}, nil
+}
+
+// Head returns the hash of the last entry and the number of entries in the log.
+func (l *Log) Head() (string, int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.lastHash, l.entries
+}
+
+// OnRecord makes the log call fn with the new head hash and entry count after every entry it has written.
+// Keeping those somewhere else, like the agent's logs, allows detecting that the log was truncated or
+// rewritten, which Verify can't. It must be called before the log is used.
+func (l *Log) OnRecord(fn func(hash string, entries int)) {
+	l.onRecord = fn
+}
+
+// Record chains the entry to the previous one and appends it to the log.
+func (l *Log) Record(entry Entry) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if entry.Time.IsZero() {
+		entry.Time = time.Now()
+	}
+	entry.Time = entry.Time.UTC()
+
+	entry.PrevHash = l.lastHash
+
+	hash, err := entry.computeHash()
+	if err != nil {
+		return err
+	}
+	entry.Hash = hash
+
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	if l.writeErr != nil {
+		return fmt.Errorf("audit log is unhealthy: %w", l.writeErr)
+	}
+
+	if _, err := l.file.Write(append(data, '\n')); err != nil {
+		l.writeErr = err
+		return err
+	}
+
+	if err := l.file.Sync(); err != nil {
+		l.writeErr = err
+		return err
+	}
+
+	l.lastHash = hash
+	l.entries++
+	metrics.AuditEntries.Set(float64(l.entries))
+
+	if l.onRecord != nil {
+		l.onRecord(l.lastHash, l.entries)
+	}
+
+	return nil
+}
+
+// Query returns the entries matching the filter, newest first.
+func (l *Log) Query(filter Filter) ([]Entry, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	file, err := os.Open(l.filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var entries []Entry
+	err = readEntries(file, func(_ int, entry Entry) error {
+		if filter.matches(entry) {
+			entries = append(entries, entry)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for i, j := 0, len(entries)-1; i < j; i, j = i+1, j-1 {
+		entries[i], entries[j] = entries[j], entries[i]
+	}
+
+	if filter.Limit > 0 && len(entries) > filter.Limit {
+		entries = entries[:filter.Limit]
+	}
+
+	return entries, nil
+}
+
+// Healthy returns the error which made the log stop accepting entries, or nil if all
+// entries have been written successfully.
+func (l *Log) Healthy() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.writeErr
+}
+
+func (l *Log) Close() error {
+	return l.file.Close()
+}
+
+// Verify checks the hash chain of the audit log at filename. It returns the hash of the
+// last entry and the number of entries. If the chain is broken, the returned error
+// wraps ErrChainBroken and names the first offending line. A log whose last entries were
+// removed, or which was rewritten as a whole, is still valid; compare the returned hash
+// and count with those passed to Log.OnRecord to detect that.
+func Verify(filename string) (string, int, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return "", 0, err
+	}
+	defer file.Close()
+
+	var lastHash string
+	var count int
+
+	err = readEntries(file, func(line int, entry Entry) error {
+		if entry.PrevHash != lastHash {
+			return fmt.Errorf("%w: line %d does not reference the previous entry", ErrChainBroken, line)
+		}
+
+		hash, err := entry.computeHash()
+		if err != nil {
+			return err
+		}
+
+		if hash != entry.Hash {
+			return fmt.Errorf("%w: line %d has been modified", ErrChainBroken, line)
+		}
+
+		lastHash = entry.Hash
+		count++
+
+		return nil
+	})
+	if err != nil {
+		return "", count, err
+	}
+
+	return lastHash, count, nil
+}
+
+func readEntries(r io.Reader, fn func(line int, entry Entry) error) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), maxLineSize)
+
+	line := 0
+	for scanner.Scan() {
+		line++
+
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+
+		var entry Entry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			return fmt.Errorf("%w: line %d is not a valid entry", ErrChainBroken, line)
+		}
+
+		if err := fn(line, entry); err != nil {
+			return err
+		}
+	}
+
+	return scanner.Err()
+}
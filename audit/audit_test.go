@@ -0,0 +1,164 @@
+package audit
+
+import (
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLog(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "audit.jsonl")
+
+	log, err := Open(filename)
+	assert.Nil(t, err)
+
+	start := time.Date(2021, 9, 1, 12, 0, 0, 0, time.UTC)
+
+	entries := []Entry{
+		{Time: start, Action: "update", KeyName: "api_key", Container: "web", OldImage: "web:1.0", NewImage: "web:1.1", Outcome: OutcomeSuccess},
+		{Time: start.Add(time.Minute), Action: "pull", KeyName: "ci", Image: "db:13", Outcome: OutcomeSuccess},
+		{Time: start.Add(2 * time.Minute), Action: "rollback", KeyName: "api_key", Container: "web", Outcome: OutcomeFailure, Error: "container is not running"},
+	}
+
+	for _, entry := range entries {
+		assert.Nil(t, log.Record(entry))
+	}
+
+	t.Run("Entries are chained", func(t *testing.T) {
+		found, err := log.Query(Filter{})
+		assert.Nil(t, err)
+
+		assert.Len(t, found, 3)
+		assert.Equal(t, "", found[2].PrevHash)
+		assert.Equal(t, found[2].Hash, found[1].PrevHash)
+		assert.Equal(t, found[1].Hash, found[0].PrevHash)
+	})
+
+	t.Run("Filter by container", func(t *testing.T) {
+		found, err := log.Query(Filter{Container: "web"})
+		assert.Nil(t, err)
+
+		assert.Len(t, found, 2)
+		assert.Equal(t, "rollback", found[0].Action)
+		assert.Equal(t, "update", found[1].Action)
+	})
+
+	t.Run("Filter by time and limit", func(t *testing.T) {
+		found, err := log.Query(Filter{Since: start.Add(time.Minute), Limit: 1})
+		assert.Nil(t, err)
+
+		assert.Len(t, found, 1)
+		assert.Equal(t, "rollback", found[0].Action)
+	})
+
+	t.Run("Valid chain", func(t *testing.T) {
+		_, count, err := Verify(filename)
+		assert.Nil(t, err)
+
+		assert.Equal(t, 3, count)
+	})
+
+	t.Run("Reopening continues the chain", func(t *testing.T) {
+		assert.Nil(t, log.Close())
+
+		log, err = Open(filename)
+		assert.Nil(t, err)
+
+		assert.Nil(t, log.Record(Entry{Action: "pull", Image: "db:14", Outcome: OutcomeSuccess}))
+
+		_, count, err := Verify(filename)
+		assert.Nil(t, err)
+
+		assert.Equal(t, 4, count)
+	})
+
+	t.Run("Modified entry", func(t *testing.T) {
+		data, err := ioutil.ReadFile(filename)
+		assert.Nil(t, err)
+
+		tampered := filepath.Join(t.TempDir(), "tampered.jsonl")
+		err = ioutil.WriteFile(tampered, []byte(strings.Replace(string(data), "web:1.1", "web:6.6", 1)), 0600)
+		assert.Nil(t, err)
+
+		_, _, err = Verify(tampered)
+		assert.True(t, errors.Is(err, ErrChainBroken))
+
+		_, err = Open(tampered)
+		assert.True(t, errors.Is(err, ErrChainBroken))
+	})
+
+	t.Run("Removed entry", func(t *testing.T) {
+		data, err := ioutil.ReadFile(filename)
+		assert.Nil(t, err)
+
+		lines := strings.SplitAfter(string(data), "\n")
+
+		tampered := filepath.Join(t.TempDir(), "tampered.jsonl")
+		err = ioutil.WriteFile(tampered, []byte(lines[0]+strings.Join(lines[2:], "")), 0600)
+		assert.Nil(t, err)
+
+		_, count, err := Verify(tampered)
+		assert.True(t, errors.Is(err, ErrChainBroken))
+		assert.Equal(t, 1, count)
+	})
+}
+
+func TestLogHealthy(t *testing.T) {
+	log, err := Open(filepath.Join(t.TempDir(), "audit.jsonl"))
+	assert.Nil(t, err)
+
+	assert.Nil(t, log.Record(Entry{Action: "pull", Outcome: OutcomeSuccess}))
+	assert.Nil(t, log.Healthy())
+
+	// writing to a closed file fails, which leaves the log unhealthy
+	assert.Nil(t, log.Close())
+	assert.NotNil(t, log.Record(Entry{Action: "pull", Outcome: OutcomeSuccess}))
+	assert.NotNil(t, log.Healthy())
+}
+
+func TestLogHead(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "audit.jsonl")
+
+	log, err := Open(filename)
+	assert.Nil(t, err)
+
+	var heads []string
+	log.OnRecord(func(hash string, entries int) {
+		assert.Equal(t, len(heads)+1, entries)
+		heads = append(heads, hash)
+	})
+
+	for i := 0; i < 3; i++ {
+		assert.Nil(t, log.Record(Entry{Action: "pull", Image: "db:13", Outcome: OutcomeSuccess}))
+	}
+	assert.Nil(t, log.Close())
+
+	hash, count, err := Verify(filename)
+	assert.Nil(t, err)
+	assert.Equal(t, heads[2], hash)
+	assert.Equal(t, 3, count)
+
+	// cutting off the last entry keeps the chain valid, but its head no longer matches the reported one
+	data, err := ioutil.ReadFile(filename)
+	assert.Nil(t, err)
+
+	lines := strings.SplitAfter(string(data), "\n")
+	assert.Nil(t, ioutil.WriteFile(filename, []byte(lines[0]+lines[1]), 0600))
+
+	hash, count, err = Verify(filename)
+	assert.Nil(t, err)
+	assert.NotEqual(t, heads[2], hash)
+	assert.Equal(t, 2, count)
+
+	log, err = Open(filename)
+	assert.Nil(t, err)
+	defer log.Close()
+
+	hash, count = log.Head()
+	assert.Equal(t, heads[1], hash)
+	assert.Equal(t, 2, count)
+}
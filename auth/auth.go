@@ -0,0 +1,47 @@
+// Package auth contains the types used for authenticating and authorizing
+// requests made to the agent
+package auth
+
+const (
+	PermissionAll                = "*"
+	PermissionContainersRead     = "containers:read"
+	PermissionContainersCreate   = "containers:create"
+	PermissionContainersUpdate   = "containers:update"
+	PermissionContainersRollback = "containers:rollback"
+	PermissionImagesPull         = "images:pull"
+	PermissionAuditRead          = "audit:read"
+	PermissionContainersLogs     = "containers:logs"
+	PermissionMetricsRead        = "metrics:read"
+	PermissionInfoRead           = "info:read"
+)
+
+const (
+	MethodAPIKey = "api_key"
+	MethodJWT    = "jwt"
+	MethodMTLS   = "mtls"
+
+	// MethodBootstrapToken is only used for claiming the API key generated at first start.
+	MethodBootstrapToken = "bootstrap_token"
+
+	// MethodReconciler attributes the changes made by the reconciler, which doesn't authenticate.
+	MethodReconciler = "reconciler"
+)
+
+// Principal describes who made a request and what they are allowed to do
+type Principal struct {
+	Name        string
+	Method      string
+	Permissions []string
+}
+
+// HasPermission reports whether the principal has been granted the requested
+// permission, either directly or through PermissionAll.
+func (p Principal) HasPermission(permission string) bool {
+	for _, granted := range p.Permissions {
+		if granted == PermissionAll || granted == permission {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,203 @@
+package auth
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"errors"
+	"fmt"
+	"github.com/XiovV/dokkup-agent/config"
+	"github.com/golang-jwt/jwt/v4"
+	"strings"
+	"time"
+)
+
+const defaultPermissionsClaim = "permissions"
+
+var (
+	ErrTokenInvalid       = errors.New("token is invalid")
+	ErrTokenExpired       = errors.New("token has expired")
+	ErrTokenNotValidYet   = errors.New("token is not valid yet")
+	ErrTokenIssuerInvalid = errors.New("token issuer is invalid")
+	ErrTokenAudience      = errors.New("token audience is invalid")
+	ErrSigningKeyNotFound = errors.New("no matching signing key found")
+)
+
+var validMethods = []string{"RS256", "RS384", "RS512", "PS256", "PS384", "PS512", "ES256", "ES384", "ES512", "EdDSA"}
+
+// JWTVerifier validates bearer tokens against a set of public keys loaded from
+// a JWKS file and/or PEM encoded public keys.
+type JWTVerifier struct {
+	keys   []publicKey
+	config config.JWTConfig
+	now    func() time.Time
+}
+
+// NewJWTVerifier loads all keys referenced by cfg and returns a verifier.
+// It will return an error if a key file can't be read or if no keys were found.
+func NewJWTVerifier(cfg config.JWTConfig) (*JWTVerifier, error) {
+	var keys []publicKey
+
+	if cfg.JWKSFile != "" {
+		jwksKeys, err := loadJWKSFile(cfg.JWKSFile)
+		if err != nil {
+			return nil, fmt.Errorf("couldn't load jwks file: %w", err)
+		}
+
+		keys = append(keys, jwksKeys...)
+	}
+
+	for _, filename := range cfg.PublicKeyFiles {
+		key, err := loadPEMFile(filename)
+		if err != nil {
+			return nil, fmt.Errorf("couldn't load public key %s: %w", filename, err)
+		}
+
+		keys = append(keys, key)
+	}
+
+	if len(keys) == 0 {
+		return nil, errors.New("no public keys configured")
+	}
+
+	if cfg.PermissionsClaim == "" {
+		cfg.PermissionsClaim = defaultPermissionsClaim
+	}
+
+	return &JWTVerifier{keys: keys, config: cfg, now: time.Now}, nil
+}
+
+// Verify checks the token's signature, exp, nbf, iss and aud claims and returns
+// the Principal described by the token.
+func (v *JWTVerifier) Verify(tokenString string) (Principal, error) {
+	parser := &jwt.Parser{ValidMethods: validMethods, SkipClaimsValidation: true}
+
+	unverified, _, err := parser.ParseUnverified(tokenString, jwt.MapClaims{})
+	if err != nil {
+		return Principal{}, ErrTokenInvalid
+	}
+
+	candidates := v.candidateKeys(unverified)
+	if len(candidates) == 0 {
+		return Principal{}, ErrSigningKeyNotFound
+	}
+
+	var token *jwt.Token
+	for _, candidate := range candidates {
+		token, err = parser.Parse(tokenString, func(*jwt.Token) (interface{}, error) {
+			return candidate.key, nil
+		})
+		if err == nil {
+			break
+		}
+	}
+
+	if err != nil || !token.Valid {
+		return Principal{}, ErrTokenInvalid
+	}
+
+	claims := token.Claims.(jwt.MapClaims)
+	if err := v.validateClaims(claims); err != nil {
+		return Principal{}, err
+	}
+
+	subject, _ := claims["sub"].(string)
+
+	return Principal{
+		Name:        subject,
+		Method:      MethodJWT,
+		Permissions: v.permissions(claims),
+	}, nil
+}
+
+// candidateKeys returns the keys which could have been used to sign the token.
+// If the token has a kid header which is present in the key set, only that key is returned.
+func (v *JWTVerifier) candidateKeys(token *jwt.Token) []publicKey {
+	kid, _ := token.Header["kid"].(string)
+
+	var candidates []publicKey
+	for _, key := range v.keys {
+		if !key.supports(token.Method.Alg()) {
+			continue
+		}
+
+		if kid != "" && key.id == kid {
+			return []publicKey{key}
+		}
+
+		if key.id == "" || kid == "" {
+			candidates = append(candidates, key)
+		}
+	}
+
+	return candidates
+}
+
+func (v *JWTVerifier) validateClaims(claims jwt.MapClaims) error {
+	now := v.now().Unix()
+	leeway := v.config.LeewaySeconds
+
+	if !claims.VerifyExpiresAt(now-leeway, true) {
+		return ErrTokenExpired
+	}
+
+	if !claims.VerifyNotBefore(now+leeway, false) {
+		return ErrTokenNotValidYet
+	}
+
+	if v.config.Issuer != "" && !claims.VerifyIssuer(v.config.Issuer, true) {
+		return ErrTokenIssuerInvalid
+	}
+
+	if v.config.Audience != "" && !claims.VerifyAudience(v.config.Audience, true) {
+		return ErrTokenAudience
+	}
+
+	return nil
+}
+
+// permissions maps the values of the configured permissions claim to agent permissions.
+func (v *JWTVerifier) permissions(claims jwt.MapClaims) []string {
+	var values []string
+
+	switch claim := claims[v.config.PermissionsClaim].(type) {
+	case string:
+		values = strings.Fields(claim)
+	case []interface{}:
+		for _, value := range claim {
+			if s, ok := value.(string); ok {
+				values = append(values, s)
+			}
+		}
+	}
+
+	if len(v.config.PermissionMap) == 0 {
+		return values
+	}
+
+	var permissions []string
+	for _, value := range values {
+		permissions = append(permissions, v.config.PermissionMap[value]...)
+	}
+
+	return permissions
+}
+
+type publicKey struct {
+	id  string
+	key interface{}
+}
+
+// supports reports whether the key can be used to verify a signature made with alg.
+func (k publicKey) supports(alg string) bool {
+	switch k.key.(type) {
+	case *rsa.PublicKey:
+		return strings.HasPrefix(alg, "RS") || strings.HasPrefix(alg, "PS")
+	case *ecdsa.PublicKey:
+		return strings.HasPrefix(alg, "ES")
+	case ed25519.PublicKey:
+		return alg == "EdDSA"
+	}
+
+	return false
+}
@@ -0,0 +1,214 @@
+package auth
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"github.com/XiovV/dokkup-agent/config"
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"math/big"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func writeJWKS(t *testing.T, dir, kid string, key *rsa.PublicKey) string {
+	set := map[string]interface{}{
+		"keys": []map[string]string{{
+			"kty": "RSA",
+			"kid": kid,
+			"use": "sig",
+			"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}},
+	}
+
+	data, err := json.Marshal(set)
+	assert.Nil(t, err)
+
+	filename := filepath.Join(dir, "jwks.json")
+	assert.Nil(t, ioutil.WriteFile(filename, data, 0600))
+
+	return filename
+}
+
+func writePEM(t *testing.T, dir string, key *rsa.PublicKey) string {
+	der, err := x509.MarshalPKIXPublicKey(key)
+	assert.Nil(t, err)
+
+	filename := filepath.Join(dir, "key.pem")
+	assert.Nil(t, ioutil.WriteFile(filename, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), 0600))
+
+	return filename
+}
+
+func signToken(t *testing.T, key *rsa.PrivateKey, kid string, claims jwt.MapClaims) string {
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	if kid != "" {
+		token.Header["kid"] = kid
+	}
+
+	signed, err := token.SignedString(key)
+	assert.Nil(t, err)
+
+	return signed
+}
+
+func TestJWTVerifier(t *testing.T) {
+	dir := t.TempDir()
+
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.Nil(t, err)
+
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.Nil(t, err)
+
+	verifier, err := NewJWTVerifier(config.JWTConfig{
+		JWKSFile:         writeJWKS(t, dir, "key-1", &privateKey.PublicKey),
+		Issuer:           "https://sso.example.com",
+		Audience:         "dokkup-agent",
+		PermissionsClaim: "groups",
+		PermissionMap: map[string][]string{
+			"deployers": {PermissionContainersUpdate, PermissionImagesPull},
+		},
+	})
+	assert.Nil(t, err)
+
+	now := time.Now()
+
+	validClaims := func() jwt.MapClaims {
+		return jwt.MapClaims{
+			"sub":    "ci-pipeline",
+			"iss":    "https://sso.example.com",
+			"aud":    "dokkup-agent",
+			"exp":    now.Add(time.Hour).Unix(),
+			"nbf":    now.Add(-time.Minute).Unix(),
+			"groups": []string{"deployers", "unknown"},
+		}
+	}
+
+	t.Run("Valid token", func(t *testing.T) {
+		principal, err := verifier.Verify(signToken(t, privateKey, "key-1", validClaims()))
+		assert.Nil(t, err)
+
+		assert.Equal(t, "ci-pipeline", principal.Name)
+		assert.Equal(t, MethodJWT, principal.Method)
+		assert.True(t, principal.HasPermission(PermissionContainersUpdate))
+		assert.True(t, principal.HasPermission(PermissionImagesPull))
+		assert.False(t, principal.HasPermission(PermissionContainersRollback))
+	})
+
+	t.Run("Expired token", func(t *testing.T) {
+		claims := validClaims()
+		claims["exp"] = now.Add(-time.Minute).Unix()
+
+		_, err := verifier.Verify(signToken(t, privateKey, "key-1", claims))
+		assert.ErrorIs(t, err, ErrTokenExpired)
+	})
+
+	t.Run("Token without exp", func(t *testing.T) {
+		claims := validClaims()
+		delete(claims, "exp")
+
+		_, err := verifier.Verify(signToken(t, privateKey, "key-1", claims))
+		assert.ErrorIs(t, err, ErrTokenExpired)
+	})
+
+	t.Run("Token not valid yet", func(t *testing.T) {
+		claims := validClaims()
+		claims["nbf"] = now.Add(time.Hour).Unix()
+
+		_, err := verifier.Verify(signToken(t, privateKey, "key-1", claims))
+		assert.ErrorIs(t, err, ErrTokenNotValidYet)
+	})
+
+	t.Run("Invalid issuer", func(t *testing.T) {
+		claims := validClaims()
+		claims["iss"] = "https://evil.example.com"
+
+		_, err := verifier.Verify(signToken(t, privateKey, "key-1", claims))
+		assert.ErrorIs(t, err, ErrTokenIssuerInvalid)
+	})
+
+	t.Run("Invalid audience", func(t *testing.T) {
+		claims := validClaims()
+		claims["aud"] = []string{"another-service"}
+
+		_, err := verifier.Verify(signToken(t, privateKey, "key-1", claims))
+		assert.ErrorIs(t, err, ErrTokenAudience)
+	})
+
+	t.Run("Unknown key id", func(t *testing.T) {
+		_, err := verifier.Verify(signToken(t, privateKey, "key-2", validClaims()))
+		assert.ErrorIs(t, err, ErrSigningKeyNotFound)
+	})
+
+	t.Run("Signed with a different key", func(t *testing.T) {
+		_, err := verifier.Verify(signToken(t, otherKey, "key-1", validClaims()))
+		assert.ErrorIs(t, err, ErrTokenInvalid)
+	})
+
+	t.Run("Malformed token", func(t *testing.T) {
+		_, err := verifier.Verify("not.a.token")
+		assert.ErrorIs(t, err, ErrTokenInvalid)
+	})
+}
+
+func TestJWTVerifierPEM(t *testing.T) {
+	dir := t.TempDir()
+
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.Nil(t, err)
+
+	verifier, err := NewJWTVerifier(config.JWTConfig{
+		PublicKeyFiles: []string{writePEM(t, dir, &privateKey.PublicKey)},
+	})
+	assert.Nil(t, err)
+
+	principal, err := verifier.Verify(signToken(t, privateKey, "", jwt.MapClaims{
+		"sub":         "developer",
+		"exp":         time.Now().Add(time.Hour).Unix(),
+		"permissions": "containers:read images:pull",
+	}))
+	assert.Nil(t, err)
+
+	assert.Equal(t, []string{PermissionContainersRead, PermissionImagesPull}, principal.Permissions)
+}
+
+func TestNewJWTVerifierWithoutKeys(t *testing.T) {
+	_, err := NewJWTVerifier(config.JWTConfig{})
+	assert.NotNil(t, err)
+}
+
+func TestJWKPublicKeyEC(t *testing.T) {
+	privateKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.Nil(t, err)
+
+	jwk := jsonWebKey{
+		Kty: "EC",
+		Crv: "P-256",
+		X:   base64.RawURLEncoding.EncodeToString(privateKey.X.Bytes()),
+		Y:   base64.RawURLEncoding.EncodeToString(privateKey.Y.Bytes()),
+	}
+
+	t.Run("Valid point", func(t *testing.T) {
+		key, err := jwk.publicKey()
+		assert.Nil(t, err)
+		assert.True(t, privateKey.PublicKey.Equal(key))
+	})
+
+	t.Run("Point which isn't on the curve", func(t *testing.T) {
+		invalid := jwk
+		invalid.Y = base64.RawURLEncoding.EncodeToString(new(big.Int).Add(privateKey.Y, big.NewInt(1)).Bytes())
+
+		_, err := invalid.publicKey()
+		assert.NotNil(t, err)
+	})
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/XiovV/dokkup-agent/config"
+	"github.com/sirupsen/logrus"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+// minBootstrapTokenLength keeps bootstrap tokens from being guessed before the API key is claimed.
+const minBootstrapTokenLength = 16
+
+// bootstrapKeyFile returns where the API key generated at first start is kept until it's claimed with the
+// bootstrap token, so the key survives restarts of the agent until then.
+func bootstrapKeyFile(configFile string) string {
+	return configFile + ".bootstrap"
+}
+
+// handOverAPIKey hands the API key generated at first start over to the operator. It's written to keyFile
+// if one is given, and to pendingFile if a bootstrap token is given, from where it can be claimed with the
+// token. It's only printed to stdout if neither is given, so it doesn't end up in the logs of containers.
+// The key is handed over before the rest of the agent is set up, since it can't be recovered if that fails.
+func handOverAPIKey(apiKey, keyFile, pendingFile, bootstrapToken string, logger logrus.FieldLogger) error {
+	if keyFile != "" {
+		if err := config.WriteFileAtomic(keyFile, []byte(apiKey+"\n")); err != nil {
+			return fmt.Errorf("couldn't write the api key to %s: %w", keyFile, err)
+		}
+
+		logger.WithField("file", keyFile).Info("wrote the new api key")
+	}
+
+	if bootstrapToken != "" {
+		if err := config.WriteFileAtomic(pendingFile, []byte(apiKey+"\n")); err != nil {
+			return fmt.Errorf("couldn't write the api key to %s: %w", pendingFile, err)
+		}
+	}
+
+	if keyFile == "" && bootstrapToken == "" {
+		fmt.Println("Your new api key is:", apiKey)
+	}
+
+	return nil
+}
+
+// pendingAPIKey returns the API key which is waiting in pendingFile to be claimed with the bootstrap token,
+// or an empty string if there is none. It fails if there is a key but no token, instead of starting with
+// a key nobody can claim.
+func pendingAPIKey(pendingFile, bootstrapToken string) (string, error) {
+	data, err := ioutil.ReadFile(pendingFile)
+	if errors.Is(err, os.ErrNotExist) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+
+	if bootstrapToken == "" {
+		return "", fmt.Errorf("the api key in %s hasn't been claimed yet: set %sBOOTSTRAP_TOKEN to claim it, or delete the file", pendingFile, config.EnvPrefix)
+	}
+
+	return strings.TrimSpace(string(data)), nil
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func TestHandOverAPIKey(t *testing.T) {
+	logger := logrus.New()
+	logger.SetOutput(ioutil.Discard)
+
+	t.Run("Bootstrap token", func(t *testing.T) {
+		pendingFile := bootstrapKeyFile(filepath.Join(t.TempDir(), "config.json"))
+
+		assert.Nil(t, handOverAPIKey("abc", "", pendingFile, "bootstrap-token-0123456789", logger))
+
+		// the key can still be claimed after a restart
+		apiKey, err := pendingAPIKey(pendingFile, "bootstrap-token-0123456789")
+		assert.Nil(t, err)
+		assert.Equal(t, "abc", apiKey)
+
+		_, err = pendingAPIKey(pendingFile, "")
+		assert.NotNil(t, err)
+	})
+
+	t.Run("Key file", func(t *testing.T) {
+		dir := t.TempDir()
+		keyFile := filepath.Join(dir, "api-key")
+		pendingFile := bootstrapKeyFile(filepath.Join(dir, "config.json"))
+
+		assert.Nil(t, handOverAPIKey("abc", keyFile, pendingFile, "", logger))
+
+		data, err := ioutil.ReadFile(keyFile)
+		assert.Nil(t, err)
+		assert.Equal(t, "abc\n", string(data))
+
+		apiKey, err := pendingAPIKey(pendingFile, "")
+		assert.Nil(t, err)
+		assert.Empty(t, apiKey)
+	})
+}
@@ -0,0 +1,387 @@
+// Package client is a Go client for the dokkup agent's API.
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/XiovV/dokkup-agent/audit"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultMaxRetries   = 3
+	defaultRetryBackoff = 250 * time.Millisecond
+	maxRetryBackoff     = 10 * time.Second
+)
+
+// Client calls a single agent. Its fields may be changed before it's used.
+type Client struct {
+	BaseURL string
+
+	// APIKey is sent in the key header. If BearerToken is set, it's sent instead.
+	APIKey      string
+	BearerToken string
+
+	HTTPClient *http.Client
+
+	// MaxRetries is how many times idempotent calls are retried after a network error,
+	// or a 429, 502, 503 or 504 response. The delay starts at RetryBackoff and doubles
+	// with every attempt, unless the agent sends a Retry-After header.
+	MaxRetries   int
+	RetryBackoff time.Duration
+}
+
+// unixPrefix is the prefix of base URLs which are paths of the agent's Unix socket.
+const unixPrefix = "unix:"
+
+// New returns a client for the agent at baseURL (e.g. http://10.0.0.2:8080), authenticating with apiKey.
+// baseURL may also be the agent's Unix socket, e.g. unix:/run/dokkup/agent.sock.
+func New(baseURL, apiKey string) *Client {
+	httpClient := http.DefaultClient
+
+	if strings.HasPrefix(baseURL, unixPrefix) {
+		httpClient = unixHTTPClient(strings.TrimPrefix(baseURL, unixPrefix))
+		baseURL = "http://unix"
+	}
+
+	return &Client{
+		BaseURL:      strings.TrimSuffix(baseURL, "/"),
+		APIKey:       apiKey,
+		HTTPClient:   httpClient,
+		MaxRetries:   defaultMaxRetries,
+		RetryBackoff: defaultRetryBackoff,
+	}
+}
+
+// unixHTTPClient returns an HTTP client which sends every request to the Unix socket at path.
+func unixHTTPClient(path string) *http.Client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = func(ctx context.Context, _, _ string) (net.Conn, error) {
+		var dialer net.Dialer
+		return dialer.DialContext(ctx, "unix", path)
+	}
+
+	return &http.Client{Transport: transport}
+}
+
+// GetContainer returns a running container by its name.
+func (c *Client) GetContainer(ctx context.Context, name string) (Container, error) {
+	var container Container
+	err := c.do(ctx, http.MethodGet, "/v2/containers/"+url.PathEscape(name), nil, true, &container)
+
+	return container, err
+}
+
+// GetContainerImage returns the image of a running container.
+func (c *Client) GetContainerImage(ctx context.Context, name string) (string, error) {
+	container, err := c.GetContainer(ctx, name)
+
+	return container.Image, err
+}
+
+// ListContainers returns both running and stopped containers.
+func (c *Client) ListContainers(ctx context.Context) ([]Container, error) {
+	var response struct {
+		Containers []Container `json:"containers"`
+	}
+	err := c.do(ctx, http.MethodGet, "/v2/containers", nil, true, &response)
+
+	return response.Containers, err
+}
+
+// ContainerLogs streams the stdout and stderr of a container as plain text. The caller must
+// close the returned stream. With options.Follow, it stays open until ctx is cancelled, so the
+// client's HTTPClient shouldn't have a timeout. It isn't retried.
+func (c *Client) ContainerLogs(ctx context.Context, name string, options LogsOptions) (io.ReadCloser, error) {
+	query := url.Values{}
+	if options.Tail != "" {
+		query.Set("tail", options.Tail)
+	}
+
+	if options.Follow {
+		query.Set("follow", "true")
+	}
+
+	if options.Timestamps {
+		query.Set("timestamps", "true")
+	}
+
+	path := "/v2/containers/" + url.PathEscape(name) + "/logs"
+	if len(query) > 0 {
+		path += "?" + query.Encode()
+	}
+
+	req, err := c.newRequest(ctx, http.MethodGet, path, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode >= http.StatusBadRequest {
+		defer resp.Body.Close()
+		return nil, decodeError(resp)
+	}
+
+	return resp.Body, nil
+}
+
+// CreateContainer pulls the image of spec and creates and starts a new container. Like UpdateContainer, it isn't
+// retried. It returns an error wrapping ErrContainerExists if a container with the same name already exists.
+func (c *Client) CreateContainer(ctx context.Context, spec ContainerSpec) error {
+	return c.do(ctx, http.MethodPost, "/v1/containers", spec, false, nil)
+}
+
+// UpdateContainer replaces a container with a new one using options.Image. It isn't retried,
+// since an update which timed out on the client may still have finished on the agent.
+func (c *Client) UpdateContainer(ctx context.Context, name string, options UpdateOptions) error {
+	_, err := c.UpdateContainerChanges(ctx, name, options)
+	return err
+}
+
+// UpdateContainerChanges works like UpdateContainer, but also returns the settings the update changed, starting with the image.
+func (c *Client) UpdateContainerChanges(ctx context.Context, name string, options UpdateOptions) ([]SettingChange, error) {
+	var response struct {
+		Changes []SettingChange `json:"changes"`
+	}
+	err := c.do(ctx, http.MethodPost, "/v2/containers/"+url.PathEscape(name)+"/update", options, false, &response)
+
+	return response.Changes, err
+}
+
+// RollbackContainer replaces a container with its rollback container. Like UpdateContainer, it isn't retried.
+func (c *Client) RollbackContainer(ctx context.Context, name string) error {
+	return c.do(ctx, http.MethodPost, "/v2/containers/"+url.PathEscape(name)+"/rollback", nil, false, nil)
+}
+
+// PullImage pulls an image. Pulling an image twice is harmless, so it's retried.
+func (c *Client) PullImage(ctx context.Context, image string) error {
+	return c.do(ctx, http.MethodPost, "/v2/images/pull", map[string]string{"image": image}, true, nil)
+}
+
+// PlanCreate returns what CreateContainer would do with spec. Planning doesn't change anything, so it's retried.
+func (c *Client) PlanCreate(ctx context.Context, spec ContainerSpec) (Plan, error) {
+	var plan Plan
+	err := c.do(ctx, http.MethodPost, "/v1/containers?dry_run=true", spec, true, &plan)
+
+	return plan, err
+}
+
+// PlanUpdate returns what UpdateContainer would do with options.
+func (c *Client) PlanUpdate(ctx context.Context, name string, options UpdateOptions) (Plan, error) {
+	var plan Plan
+	err := c.do(ctx, http.MethodPost, "/v2/containers/"+url.PathEscape(name)+"/update?dry_run=true", options, true, &plan)
+
+	return plan, err
+}
+
+// PlanRollback returns what RollbackContainer would do.
+func (c *Client) PlanRollback(ctx context.Context, name string) (Plan, error) {
+	var plan Plan
+	err := c.do(ctx, http.MethodPost, "/v2/containers/"+url.PathEscape(name)+"/rollback?dry_run=true", nil, true, &plan)
+
+	return plan, err
+}
+
+// PlanPull returns whether PullImage would pull image, and which digest it would get.
+func (c *Client) PlanPull(ctx context.Context, image string) (Plan, error) {
+	var plan Plan
+	err := c.do(ctx, http.MethodPost, "/v2/images/pull?dry_run=true", map[string]string{"image": image}, true, &plan)
+
+	return plan, err
+}
+
+// Info describes the agent, its docker engine and its host.
+func (c *Client) Info(ctx context.Context) (Info, error) {
+	var info Info
+	err := c.do(ctx, http.MethodGet, "/v2/info", nil, true, &info)
+
+	return info, err
+}
+
+// AuditLog returns the audit log entries matching filter, newest first.
+func (c *Client) AuditLog(ctx context.Context, filter audit.Filter) ([]audit.Entry, error) {
+	query := url.Values{}
+	for key, value := range map[string]string{
+		"action":    filter.Action,
+		"container": filter.Container,
+		"key":       filter.KeyName,
+		"outcome":   filter.Outcome,
+	} {
+		if value != "" {
+			query.Set(key, value)
+		}
+	}
+
+	if !filter.Since.IsZero() {
+		query.Set("since", filter.Since.Format(time.RFC3339))
+	}
+
+	if !filter.Until.IsZero() {
+		query.Set("until", filter.Until.Format(time.RFC3339))
+	}
+
+	if filter.Limit > 0 {
+		query.Set("limit", strconv.Itoa(filter.Limit))
+	}
+
+	path := "/v2/audit"
+	if len(query) > 0 {
+		path += "?" + query.Encode()
+	}
+
+	var response struct {
+		Entries []audit.Entry `json:"entries"`
+	}
+	err := c.do(ctx, http.MethodGet, path, nil, true, &response)
+
+	return response.Entries, err
+}
+
+// ReconcileStatus returns the outcome of the agent's last reconciliation pass. It returns an error
+// wrapping ErrNotFound if reconciliation isn't enabled.
+func (c *Client) ReconcileStatus(ctx context.Context) (ReconcileStatus, error) {
+	var status ReconcileStatus
+	err := c.do(ctx, http.MethodGet, "/v1/reconcile/status", nil, true, &status)
+
+	return status, err
+}
+
+// do sends a request with body encoded as JSON and decodes the response into out, if it's not nil.
+// Requests are retried only if idempotent is true.
+func (c *Client) do(ctx context.Context, method, path string, body interface{}, idempotent bool, out interface{}) error {
+	var payload []byte
+	if body != nil {
+		var err error
+		if payload, err = json.Marshal(body); err != nil {
+			return err
+		}
+	}
+
+	attempts := 1
+	if idempotent {
+		attempts += c.MaxRetries
+	}
+
+	backoff := c.RetryBackoff
+
+	for attempt := 1; ; attempt++ {
+		retryAfter, err := c.send(ctx, method, path, payload, out)
+		if err == nil || attempt >= attempts || !retryable(err) {
+			return err
+		}
+
+		delay := backoff
+		if retryAfter > 0 {
+			delay = retryAfter
+		}
+
+		// don't wait out long lockouts
+		if delay > maxRetryBackoff {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(delay):
+		}
+
+		if backoff *= 2; backoff > maxRetryBackoff {
+			backoff = maxRetryBackoff
+		}
+	}
+}
+
+// send makes a single attempt of a request. It returns the delay requested by a Retry-After header, if any.
+func (c *Client) send(ctx context.Context, method, path string, payload []byte, out interface{}) (time.Duration, error) {
+	req, err := c.newRequest(ctx, method, path, payload)
+	if err != nil {
+		return 0, err
+	}
+
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= http.StatusBadRequest {
+		retryAfter, _ := strconv.Atoi(resp.Header.Get("Retry-After"))
+
+		return time.Duration(retryAfter) * time.Second, decodeError(resp)
+	}
+
+	if out == nil {
+		_, _ = io.Copy(ioutil.Discard, resp.Body)
+		return 0, nil
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return 0, fmt.Errorf("couldn't decode response: %w", err)
+	}
+
+	return 0, nil
+}
+
+// newRequest returns an authenticated request to the agent, with payload as its JSON body.
+func (c *Client) newRequest(ctx context.Context, method, path string, payload []byte) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, method, c.BaseURL+path, bytes.NewReader(payload))
+	if err != nil {
+		return nil, err
+	}
+
+	if payload != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	if c.BearerToken != "" {
+		req.Header.Set("Authorization", "Bearer "+c.BearerToken)
+	} else {
+		req.Header.Set("key", c.APIKey)
+	}
+
+	return req, nil
+}
+
+func decodeError(resp *http.Response) error {
+	apiErr := &APIError{StatusCode: resp.StatusCode}
+
+	data, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err := json.Unmarshal(data, apiErr); err != nil || apiErr.Code == "" {
+		apiErr.Code = CodeInternal
+		apiErr.Message = fmt.Sprintf("unexpected response: %s", resp.Status)
+	}
+
+	return apiErr
+}
+
+// retryable reports whether a request which failed with err may succeed if it's sent again.
+func retryable(err error) bool {
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) {
+		// the request didn't reach the agent, or the connection broke
+		return !errors.Is(err, context.Canceled) && !errors.Is(err, context.DeadlineExceeded)
+	}
+
+	switch apiErr.StatusCode {
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+
+	return false
+}
@@ -0,0 +1,450 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"github.com/XiovV/dokkup-agent/app"
+	"github.com/XiovV/dokkup-agent/audit"
+	"github.com/XiovV/dokkup-agent/config"
+	"github.com/XiovV/dokkup-agent/controller"
+	"github.com/docker/docker/api/types"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type mockDockerController struct {
+	mock.Mock
+
+	// unavailableFor is the number of Available calls which report docker as unreachable.
+	unavailableFor int32
+}
+
+func (m *mockDockerController) FindContainerByName(ctx context.Context, containerName string) (types.Container, bool) {
+	args := m.Called(containerName)
+
+	return args.Get(0).(types.Container), args.Bool(1)
+}
+
+func (m *mockDockerController) FindContainerIDByName(ctx context.Context, containerName string) (string, bool) {
+	args := m.Called(containerName)
+
+	return args.String(0), args.Bool(1)
+}
+
+func (m *mockDockerController) PullImage(ctx context.Context, image string) error {
+	args := m.Called(image)
+
+	return args.Error(0)
+}
+
+func (m *mockDockerController) CreateContainer(ctx context.Context, spec controller.ContainerSpec) (controller.ContainerChange, error) {
+	args := m.Called(spec)
+
+	return args.Get(0).(controller.ContainerChange), args.Error(1)
+}
+
+func (m *mockDockerController) RecreateContainer(ctx context.Context, spec controller.ContainerSpec) (controller.ContainerChange, error) {
+	args := m.Called(spec)
+
+	return args.Get(0).(controller.ContainerChange), args.Error(1)
+}
+
+func (m *mockDockerController) UpdateContainer(ctx context.Context, containerName, image string, keep bool) (controller.ContainerChange, error) {
+	args := m.Called(containerName, image, keep)
+
+	return args.Get(0).(controller.ContainerChange), args.Error(1)
+}
+
+func (m *mockDockerController) UpdateContainerWithPatch(ctx context.Context, containerName, image string, keep bool, patch controller.ConfigPatch) (controller.ContainerChange, error) {
+	args := m.Called(containerName, image, keep, patch)
+
+	return args.Get(0).(controller.ContainerChange), args.Error(1)
+}
+
+func (m *mockDockerController) RollbackContainer(ctx context.Context, containerName string) (controller.ContainerChange, error) {
+	args := m.Called(containerName)
+
+	return args.Get(0).(controller.ContainerChange), args.Error(1)
+}
+
+func (m *mockDockerController) PlanCreate(ctx context.Context, spec controller.ContainerSpec) (controller.Plan, error) {
+	args := m.Called(spec)
+
+	return args.Get(0).(controller.Plan), args.Error(1)
+}
+
+func (m *mockDockerController) PlanUpdate(ctx context.Context, containerName, image string, keep bool, patch controller.ConfigPatch) (controller.Plan, error) {
+	args := m.Called(containerName, image, keep, patch)
+
+	return args.Get(0).(controller.Plan), args.Error(1)
+}
+
+func (m *mockDockerController) PlanRollback(ctx context.Context, containerName string) (controller.Plan, error) {
+	args := m.Called(containerName)
+
+	return args.Get(0).(controller.Plan), args.Error(1)
+}
+
+func (m *mockDockerController) PlanPull(ctx context.Context, image string) (controller.Plan, error) {
+	args := m.Called(image)
+
+	return args.Get(0).(controller.Plan), args.Error(1)
+}
+
+func (m *mockDockerController) SetRegistryCredentials(map[string]controller.RegistryCredentials) {}
+
+func (m *mockDockerController) Available() bool {
+	return atomic.AddInt32(&m.unavailableFor, -1) < 0
+}
+
+func (m *mockDockerController) ListContainers(ctx context.Context) ([]types.Container, error) {
+	args := m.Called()
+
+	return args.Get(0).([]types.Container), args.Error(1)
+}
+
+func (m *mockDockerController) InspectContainer(ctx context.Context, containerName string) (types.ContainerJSON, error) {
+	args := m.Called(containerName)
+
+	return args.Get(0).(types.ContainerJSON), args.Error(1)
+}
+
+func (m *mockDockerController) ContainerLogs(ctx context.Context, containerName string, opts controller.LogsOptions) (io.ReadCloser, error) {
+	args := m.Called(containerName, opts)
+
+	logs, _ := args.Get(0).(io.ReadCloser)
+	return logs, args.Error(1)
+}
+
+func (m *mockDockerController) PullImageWithProgress(ctx context.Context, image string, progress func(controller.PullProgress)) error {
+	args := m.Called(image)
+
+	if updates, ok := args.Get(0).([]controller.PullProgress); ok {
+		for _, update := range updates {
+			progress(update)
+		}
+	}
+
+	return args.Error(1)
+}
+
+func (m *mockDockerController) Events(ctx context.Context) (<-chan controller.Event, <-chan error) {
+	args := m.Called()
+
+	return args.Get(0).(<-chan controller.Event), args.Get(1).(<-chan error)
+}
+
+func (m *mockDockerController) EngineInfo(ctx context.Context) (controller.EngineInfo, error) {
+	args := m.Called()
+
+	return args.Get(0).(controller.EngineInfo), args.Error(1)
+}
+
+// newTestAgent starts the agent's router with a mock controller and returns a client for it.
+func newTestAgent(t *testing.T) (*Client, *mockDockerController) {
+	dir := t.TempDir()
+
+	cfg, apiKey, err := config.New(filepath.Join(dir, "config.json"))
+	assert.Nil(t, err)
+
+	auditLog, err := audit.Open(filepath.Join(dir, "audit.jsonl"))
+	assert.Nil(t, err)
+	t.Cleanup(func() { _ = auditLog.Close() })
+
+	logger := logrus.New()
+	logger.SetOutput(ioutil.Discard)
+
+	mockController := new(mockDockerController)
+
+	server := httptest.NewServer(app.New(mockController, cfg, nil, auditLog, logger).Router())
+	t.Cleanup(server.Close)
+
+	c := New(server.URL, apiKey)
+	c.RetryBackoff = time.Millisecond
+
+	return c, mockController
+}
+
+func TestClient(t *testing.T) {
+	c, mockController := newTestAgent(t)
+	ctx := context.Background()
+
+	t.Run("Get container", func(t *testing.T) {
+		mockController.On("FindContainerByName", "web").Return(types.Container{ID: "abc", Image: "web:1.0", State: "running"}, true).Once()
+
+		container, err := c.GetContainer(ctx, "web")
+		assert.Nil(t, err)
+
+		assert.Equal(t, "abc", container.ID)
+		assert.Equal(t, "web:1.0", container.Image)
+	})
+
+	t.Run("Get image of a non-existent container", func(t *testing.T) {
+		mockController.On("FindContainerByName", "db").Return(types.Container{}, false).Once()
+
+		_, err := c.GetContainerImage(ctx, "db")
+		assert.ErrorIs(t, err, ErrContainerNotFound)
+
+		var apiErr *APIError
+		assert.True(t, errors.As(err, &apiErr))
+		assert.Equal(t, 404, apiErr.StatusCode)
+		assert.Equal(t, CodeContainerNotFound, apiErr.Code)
+	})
+
+	t.Run("List containers", func(t *testing.T) {
+		mockController.On("ListContainers").Return([]types.Container{{Names: []string{"/web"}, ID: "abc", Image: "web:1.0", State: "running"}}, nil).Once()
+
+		containers, err := c.ListContainers(ctx)
+		assert.Nil(t, err)
+
+		assert.Len(t, containers, 1)
+		assert.Equal(t, "web", containers[0].Name)
+	})
+
+	t.Run("Container logs", func(t *testing.T) {
+		mockController.On("ContainerLogs", "web", controller.LogsOptions{Tail: "2", Follow: true}).
+			Return(ioutil.NopCloser(strings.NewReader("line 1\nline 2\n")), nil).Once()
+
+		logs, err := c.ContainerLogs(ctx, "web", LogsOptions{Tail: "2", Follow: true})
+		assert.Nil(t, err)
+		defer logs.Close()
+
+		data, err := ioutil.ReadAll(logs)
+		assert.Nil(t, err)
+		assert.Equal(t, "line 1\nline 2\n", string(data))
+	})
+
+	t.Run("Logs of a non-existent container", func(t *testing.T) {
+		mockController.On("ContainerLogs", "db", controller.LogsOptions{Tail: "all"}).Return(nil, controller.ErrContainerNotFound).Once()
+
+		_, err := c.ContainerLogs(ctx, "db", LogsOptions{})
+		assert.ErrorIs(t, err, ErrContainerNotFound)
+	})
+
+	t.Run("Create container", func(t *testing.T) {
+		spec := controller.ContainerSpec{Name: "web", Image: "web:1.0", Ports: []string{"8080:80"}, RestartPolicy: "always"}
+		mockController.On("CreateContainer", spec).Return(controller.ContainerChange{ContainerName: "web", NewImage: "web:1.0"}, nil).Once()
+
+		err := c.CreateContainer(ctx, ContainerSpec{Name: "web", Image: "web:1.0", Ports: []string{"8080:80"}, RestartPolicy: "always"})
+		assert.Nil(t, err)
+	})
+
+	t.Run("Create an existing container", func(t *testing.T) {
+		mockController.On("CreateContainer", controller.ContainerSpec{Name: "web", Image: "web:1.0"}).
+			Return(controller.ContainerChange{}, controller.ErrContainerExists).Once()
+
+		err := c.CreateContainer(ctx, ContainerSpec{Name: "web", Image: "web:1.0"})
+		assert.ErrorIs(t, err, ErrContainerExists)
+	})
+
+	t.Run("Update container", func(t *testing.T) {
+		mockController.On("UpdateContainer", "web", "web:1.1", true).Return(controller.ContainerChange{}, nil).Once()
+
+		err := c.UpdateContainer(ctx, "web", UpdateOptions{Image: "web:1.1", Keep: true})
+		assert.Nil(t, err)
+	})
+
+	t.Run("Update container with patch", func(t *testing.T) {
+		cpus := 1.5
+		mockController.On("UpdateContainerWithPatch", "web", "web:1.1", true, controller.ConfigPatch{UnsetLabels: []string{"beta"}, CPUs: &cpus}).
+			Return(controller.ContainerChange{
+				OldImage: "web:1.0",
+				NewImage: "web:1.1",
+				Settings: []controller.SettingChange{{Setting: "labels.beta", Old: "true"}, {Setting: "resources.cpus", Old: "1", New: "1.5"}},
+			}, nil).Once()
+
+		changes, err := c.UpdateContainerChanges(ctx, "web", UpdateOptions{
+			Image: "web:1.1",
+			Keep:  true,
+			Patch: &Patch{UnsetLabels: []string{"beta"}, Resources: &PatchResources{CPUs: &cpus}},
+		})
+		assert.Nil(t, err)
+
+		assert.Equal(t, []SettingChange{
+			{Setting: "image", Old: "web:1.0", New: "web:1.1"},
+			{Setting: "labels.beta", Old: "true"},
+			{Setting: "resources.cpus", Old: "1", New: "1.5"},
+		}, changes)
+	})
+
+	t.Run("Update fails to start the container", func(t *testing.T) {
+		mockController.On("UpdateContainer", "web", "web:1.1", false).
+			Return(controller.ContainerChange{}, controller.ErrContainerStartFailed{ContainerId: "abc", Reason: errors.New("port is already allocated")}).Once()
+
+		err := c.UpdateContainer(ctx, "web", UpdateOptions{Image: "web:1.1"})
+		assert.ErrorIs(t, err, ErrContainerStartFailed)
+
+		var apiErr *APIError
+		assert.True(t, errors.As(err, &apiErr))
+		assert.NotEmpty(t, apiErr.OperationID)
+		assert.Equal(t, "port is already allocated", apiErr.Details["reason"])
+	})
+
+	t.Run("Update with an invalid image", func(t *testing.T) {
+		mockController.On("UpdateContainer", "web", "web", false).Return(controller.ContainerChange{}, controller.ErrImageFormatInvalid).Once()
+
+		err := c.UpdateContainer(ctx, "web", UpdateOptions{Image: "web"})
+		assert.ErrorIs(t, err, ErrImageFormatInvalid)
+	})
+
+	t.Run("Rollback without a rollback container", func(t *testing.T) {
+		mockController.On("RollbackContainer", "web").Return(controller.ContainerChange{}, controller.ErrRollbackContainerNotFound).Once()
+
+		err := c.RollbackContainer(ctx, "web")
+		assert.ErrorIs(t, err, ErrRollbackContainerNotFound)
+	})
+
+	t.Run("Pull image", func(t *testing.T) {
+		mockController.On("PullImage", "web:1.1").Return(nil).Once()
+
+		assert.Nil(t, c.PullImage(ctx, "web:1.1"))
+	})
+
+	t.Run("Plan update", func(t *testing.T) {
+		mockController.On("PlanUpdate", "web", "web:1.1", false, controller.ConfigPatch{}).Return(controller.Plan{
+			Operation:     "update",
+			ContainerName: "web",
+			Image:         controller.PlannedImage{Reference: "web:1.1", ID: "sha256:new"},
+			Config:        controller.PlannedConfig{Image: "web:1.1", EnvNames: []string{"MODE"}},
+			Change:        controller.ContainerChange{OldImage: "web:1.0", NewImage: "web:1.1"},
+			Steps:         []controller.PlanStep{{Step: "rename", Description: "rename web to web-rollback"}},
+			Problems:      []controller.PlanProblem{{Kind: controller.ProblemPortConflict, Message: "host port 8080/tcp is already published by api"}},
+		}, nil).Once()
+
+		plan, err := c.PlanUpdate(ctx, "web", UpdateOptions{Image: "web:1.1"})
+		assert.Nil(t, err)
+
+		assert.Equal(t, "update", plan.Operation)
+		assert.Equal(t, PlannedImage{Reference: "web:1.1", ID: "sha256:new"}, plan.Image)
+		assert.Equal(t, []string{"MODE"}, plan.Config.Env)
+		assert.Equal(t, []SettingChange{{Setting: "image", Old: "web:1.0", New: "web:1.1"}}, plan.Changes)
+		assert.Equal(t, []PlanStep{{Step: "rename", Description: "rename web to web-rollback"}}, plan.Steps)
+		assert.Equal(t, "port_conflict", plan.Problems[0].Kind)
+	})
+
+	t.Run("Plan pull", func(t *testing.T) {
+		mockController.On("PlanPull", "web:1.1").Return(controller.Plan{Operation: "pull", Image: controller.PlannedImage{Reference: "web:1.1", Pull: true}}, nil).Once()
+
+		plan, err := c.PlanPull(ctx, "web:1.1")
+		assert.Nil(t, err)
+
+		assert.True(t, plan.Image.Pull)
+		assert.Nil(t, plan.Config)
+	})
+
+	t.Run("Info", func(t *testing.T) {
+		mockController.On("EngineInfo").Return(controller.EngineInfo{Version: "20.10.8", APIVersion: "1.41"}, nil).Once()
+
+		info, err := c.Info(ctx)
+		assert.Nil(t, err)
+
+		assert.Equal(t, "20.10.8", info.Docker.Version)
+		assert.Equal(t, "1.41", info.Docker.APIVersion)
+	})
+
+	t.Run("Audit log", func(t *testing.T) {
+		entries, err := c.AuditLog(ctx, audit.Filter{Action: "update", Limit: 10})
+		assert.Nil(t, err)
+
+		assert.Len(t, entries, 4)
+		assert.Equal(t, "web", entries[0].Container)
+	})
+
+	t.Run("Reconcile status without reconciliation", func(t *testing.T) {
+		_, err := c.ReconcileStatus(ctx)
+		assert.ErrorIs(t, err, ErrNotFound)
+	})
+
+	t.Run("Invalid api key", func(t *testing.T) {
+		unauthorized := New(c.BaseURL, "invalid")
+
+		_, err := unauthorized.Info(ctx)
+		assert.ErrorIs(t, err, ErrInvalidCredentials)
+	})
+}
+
+func TestClientRetries(t *testing.T) {
+	c, mockController := newTestAgent(t)
+	ctx := context.Background()
+
+	t.Run("Idempotent calls are retried", func(t *testing.T) {
+		atomic.StoreInt32(&mockController.unavailableFor, 2)
+		mockController.On("FindContainerByName", "web").Return(types.Container{Image: "web:1.0"}, true).Once()
+
+		image, err := c.GetContainerImage(ctx, "web")
+		assert.Nil(t, err)
+
+		assert.Equal(t, "web:1.0", image)
+	})
+
+	t.Run("Retries give up", func(t *testing.T) {
+		atomic.StoreInt32(&mockController.unavailableFor, 10)
+		defer atomic.StoreInt32(&mockController.unavailableFor, 0)
+
+		err := c.PullImage(ctx, "web:1.1")
+		assert.ErrorIs(t, err, ErrDockerUnavailable)
+		assert.Equal(t, int32(10-1-defaultMaxRetries), atomic.LoadInt32(&mockController.unavailableFor))
+	})
+
+	t.Run("Updates are not retried", func(t *testing.T) {
+		atomic.StoreInt32(&mockController.unavailableFor, 1)
+
+		err := c.UpdateContainer(ctx, "web", UpdateOptions{Image: "web:1.1"})
+		assert.ErrorIs(t, err, ErrDockerUnavailable)
+		mockController.AssertNotCalled(t, "UpdateContainer", "web", "web:1.1", false)
+	})
+
+	t.Run("Network errors are retried", func(t *testing.T) {
+		unreachable := New("http://127.0.0.1:1", "key")
+		unreachable.RetryBackoff = time.Millisecond
+
+		_, err := unreachable.Info(ctx)
+		assert.NotNil(t, err)
+
+		var apiErr *APIError
+		assert.False(t, errors.As(err, &apiErr))
+	})
+}
+
+func TestClientUnixSocket(t *testing.T) {
+	dir := t.TempDir()
+
+	cfg, apiKey, err := config.New(filepath.Join(dir, "config.json"))
+	assert.Nil(t, err)
+
+	// requests over the socket aren't subject to the allowlist
+	cfg.AllowedCIDRs = []string{"192.0.2.0/24"}
+
+	logger := logrus.New()
+	logger.SetOutput(ioutil.Discard)
+
+	mockController := new(mockDockerController)
+
+	path := filepath.Join(dir, "agent.sock")
+	listener, err := net.Listen("unix", path)
+	assert.Nil(t, err)
+
+	server := &http.Server{Handler: app.New(mockController, cfg, nil, nil, logger).Router()}
+	go func() {
+		_ = server.Serve(listener)
+	}()
+	t.Cleanup(func() { _ = server.Close() })
+
+	mockController.On("FindContainerByName", "web").Return(types.Container{Image: "web:1.0"}, true).Once()
+
+	image, err := New("unix:"+path, apiKey).GetContainerImage(context.Background(), "web")
+	assert.Nil(t, err)
+
+	assert.Equal(t, "web:1.0", image)
+}
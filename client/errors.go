@@ -0,0 +1,96 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+)
+
+// The errors returned by the agent, mirroring the ones in the controller package.
+// An *APIError wraps the one matching its code, so they can be checked with errors.Is.
+var (
+	ErrContainerNotRunning       = errors.New("container is not running")
+	ErrContainerRestoreFailed    = errors.New("couldn't restore container")
+	ErrContainerNotFound         = errors.New("container does not exist")
+	ErrContainerExists           = errors.New("container already exists")
+	ErrSpecInvalid               = errors.New("container spec is invalid")
+	ErrRollbackContainerNotFound = errors.New("rollback container does not exist")
+	ErrImageFormatInvalid        = errors.New("image format is invalid")
+	ErrContainerStartFailed      = errors.New("container could not be started")
+	ErrDockerUnavailable         = errors.New("docker daemon is unreachable")
+	ErrOperationTimedOut         = errors.New("operation timed out")
+	ErrOperationCancelled        = errors.New("operation was cancelled")
+	ErrInvalidCredentials        = errors.New("invalid credentials")
+	ErrPermissionDenied          = errors.New("permission denied")
+	ErrAddressNotAllowed         = errors.New("address is not allowed")
+	ErrRateLimited               = errors.New("rate limit exceeded")
+	ErrBadRequest                = errors.New("bad request")
+	ErrNotFound                  = errors.New("not found")
+	ErrInternal                  = errors.New("internal server error")
+)
+
+// Error codes returned by the agent.
+const (
+	CodeBadRequest          = "BAD_REQUEST"
+	CodeNotFound            = "NOT_FOUND"
+	CodeImageInvalid        = "IMAGE_INVALID"
+	CodeContainerNotFound   = "CONTAINER_NOT_FOUND"
+	CodeContainerExists     = "CONTAINER_EXISTS"
+	CodeSpecInvalid         = "SPEC_INVALID"
+	CodeRollbackNotFound    = "ROLLBACK_NOT_FOUND"
+	CodeStartFailed         = "START_FAILED"
+	CodeContainerNotRunning = "CONTAINER_NOT_RUNNING"
+	CodeRestoreFailed       = "RESTORE_FAILED"
+	CodeDockerUnavailable   = "DOCKER_UNAVAILABLE"
+	CodeTimeout             = "TIMEOUT"
+	CodeCancelled           = "CANCELLED"
+	CodeInvalidCredentials  = "INVALID_CREDENTIALS"
+	CodePermissionDenied    = "PERMISSION_DENIED"
+	CodeAddressNotAllowed   = "ADDRESS_NOT_ALLOWED"
+	CodeRateLimited         = "RATE_LIMITED"
+	CodeLockedOut           = "LOCKED_OUT"
+	CodeInternal            = "INTERNAL_ERROR"
+)
+
+var codeErrors = map[string]error{
+	CodeBadRequest:          ErrBadRequest,
+	CodeNotFound:            ErrNotFound,
+	CodeImageInvalid:        ErrImageFormatInvalid,
+	CodeContainerNotFound:   ErrContainerNotFound,
+	CodeContainerExists:     ErrContainerExists,
+	CodeSpecInvalid:         ErrSpecInvalid,
+	CodeRollbackNotFound:    ErrRollbackContainerNotFound,
+	CodeStartFailed:         ErrContainerStartFailed,
+	CodeContainerNotRunning: ErrContainerNotRunning,
+	CodeRestoreFailed:       ErrContainerRestoreFailed,
+	CodeDockerUnavailable:   ErrDockerUnavailable,
+	CodeTimeout:             ErrOperationTimedOut,
+	CodeCancelled:           ErrOperationCancelled,
+	CodeInvalidCredentials:  ErrInvalidCredentials,
+	CodePermissionDenied:    ErrPermissionDenied,
+	CodeAddressNotAllowed:   ErrAddressNotAllowed,
+	CodeRateLimited:         ErrRateLimited,
+	CodeLockedOut:           ErrRateLimited,
+	CodeInternal:            ErrInternal,
+}
+
+// APIError is returned when the agent responds with an error.
+type APIError struct {
+	StatusCode  int
+	Code        string                 `json:"code"`
+	Message     string                 `json:"error"`
+	OperationID string                 `json:"operation_id"`
+	Details     map[string]interface{} `json:"details"`
+}
+
+func (e *APIError) Error() string {
+	if e.OperationID != "" {
+		return fmt.Sprintf("%s (%s, operation %s)", e.Message, e.Code, e.OperationID)
+	}
+
+	return fmt.Sprintf("%s (%s)", e.Message, e.Code)
+}
+
+// Unwrap returns the error matching the code, or nil for unknown codes.
+func (e *APIError) Unwrap() error {
+	return codeErrors[e.Code]
+}
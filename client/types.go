@@ -0,0 +1,214 @@
+package client
+
+import "time"
+
+// Container is a container, as returned by GetContainer and ListContainers.
+type Container struct {
+	Name    string `json:"name"`
+	ID      string `json:"id"`
+	Image   string `json:"image"`
+	ImageID string `json:"image_id"`
+	State   string `json:"state"`
+	Status  string `json:"status"`
+}
+
+// ContainerSpec describes the container created by CreateContainer. Only Name and Image are required.
+type ContainerSpec struct {
+	Name  string            `json:"name"`
+	Image string            `json:"image"`
+	Env   map[string]string `json:"env,omitempty"`
+
+	// Ports are published ports in the format of docker run -p, e.g. "8080:80".
+	Ports []string `json:"ports,omitempty"`
+
+	// Volumes are bind mounts and named volumes in the format of docker run -v, e.g. "/srv/www:/var/www:ro".
+	Volumes []string `json:"volumes,omitempty"`
+
+	Networks []string          `json:"networks,omitempty"`
+	Labels   map[string]string `json:"labels,omitempty"`
+
+	// RestartPolicy is a restart policy in the format of docker run --restart, e.g. "unless-stopped".
+	RestartPolicy string `json:"restart_policy,omitempty"`
+
+	Resources Resources `json:"resources"`
+}
+
+// Resources limits the memory and CPU time of a container. Zero values mean no limit.
+type Resources struct {
+	MemoryBytes int64   `json:"memory_bytes,omitempty"`
+	CPUs        float64 `json:"cpus,omitempty"`
+}
+
+// UpdateOptions are the options of UpdateContainer.
+type UpdateOptions struct {
+	Image string `json:"image"`
+
+	// Keep keeps the old container as a rollback container.
+	Keep bool `json:"keep"`
+
+	// Patch changes settings of the container along with the image. Settings which are left out keep their value.
+	Patch *Patch `json:"patch,omitempty"`
+}
+
+// Patch changes settings of a container during an update.
+type Patch struct {
+	Env         map[string]string `json:"env,omitempty"`
+	UnsetEnv    []string          `json:"unset_env,omitempty"`
+	Labels      map[string]string `json:"labels,omitempty"`
+	UnsetLabels []string          `json:"unset_labels,omitempty"`
+
+	// Cmd and Entrypoint replace the ones of the container. An empty, non-nil slice resets them to the image's,
+	// which is why nil is sent as null instead of being left out.
+	Cmd        []string `json:"cmd"`
+	Entrypoint []string `json:"entrypoint"`
+
+	RestartPolicy string          `json:"restart_policy,omitempty"`
+	Resources     *PatchResources `json:"resources,omitempty"`
+}
+
+// PatchResources replaces the limits which aren't nil. 0 removes a limit.
+type PatchResources struct {
+	MemoryBytes *int64   `json:"memory_bytes,omitempty"`
+	CPUs        *float64 `json:"cpus,omitempty"`
+}
+
+// SettingChange is a setting changed by an update. Old is empty if the setting wasn't set, and New if it was
+// removed. The values of environment variables are redacted.
+type SettingChange struct {
+	Setting string `json:"setting"`
+	Old     string `json:"old"`
+	New     string `json:"new"`
+}
+
+// Plan is what an operation would do, as returned by the Plan methods. Nothing is changed by planning an operation.
+type Plan struct {
+	// Operation is create, update, rollback or pull.
+	Operation string `json:"operation"`
+
+	// Container is empty for pulls.
+	Container string       `json:"container"`
+	Image     PlannedImage `json:"image"`
+
+	// Config is the configuration the new container would get. It's nil for pulls.
+	Config *PlannedConfig `json:"config"`
+
+	// Changes lists the image and the settings an update or a rollback would change.
+	Changes []SettingChange `json:"changes"`
+
+	Steps []PlanStep `json:"steps"`
+
+	// Problems lists what would make the operation fail, such as port conflicts or missing networks.
+	Problems []PlanProblem `json:"problems"`
+}
+
+// PlannedImage is the image an operation would use. ID is empty if the image isn't available locally,
+// and Digest if it couldn't be determined.
+type PlannedImage struct {
+	Reference string `json:"reference"`
+	ID        string `json:"id"`
+	Digest    string `json:"digest"`
+	Pull      bool   `json:"pull"`
+}
+
+// PlannedConfig is the configuration of a container which would be created. Env only holds the names of
+// the environment variables.
+type PlannedConfig struct {
+	Image         string            `json:"image"`
+	Cmd           []string          `json:"cmd"`
+	Entrypoint    []string          `json:"entrypoint"`
+	Env           []string          `json:"env"`
+	Labels        map[string]string `json:"labels"`
+	Ports         []string          `json:"ports"`
+	Volumes       []string          `json:"volumes"`
+	Networks      []string          `json:"networks"`
+	RestartPolicy string            `json:"restart_policy"`
+	Resources     Resources         `json:"resources"`
+}
+
+// PlanStep is a step of a planned operation, e.g. rename or create.
+type PlanStep struct {
+	Step        string `json:"step"`
+	Description string `json:"description"`
+}
+
+// PlanProblem is a problem found while planning an operation. Kind is one of port_conflict, volume_missing,
+// network_missing, image_not_pulled, image_not_found and container_exists.
+type PlanProblem struct {
+	Kind    string `json:"kind"`
+	Message string `json:"message"`
+}
+
+// LogsOptions are the options of ContainerLogs.
+type LogsOptions struct {
+	// Follow keeps the stream open and sends new lines as they are written.
+	Follow bool
+
+	// Tail is the number of lines to return from the end of the logs. All lines are returned if it's empty.
+	Tail string
+
+	Timestamps bool
+}
+
+// Info describes an agent, its docker engine and its host.
+type Info struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit"`
+	GoVersion string `json:"go_version"`
+
+	Host struct {
+		OS   string `json:"os"`
+		Arch string `json:"arch"`
+		CPUs int    `json:"cpus"`
+	} `json:"host"`
+
+	// Docker has Error set instead of the engine details if the daemon is unreachable.
+	Docker struct {
+		Version         string `json:"version"`
+		APIVersion      string `json:"api_version"`
+		OperatingSystem string `json:"operating_system"`
+		OSType          string `json:"os_type"`
+		Architecture    string `json:"architecture"`
+		KernelVersion   string `json:"kernel_version"`
+		CPUs            int    `json:"cpus"`
+		MemoryBytes     int64  `json:"memory_bytes"`
+		Error           string `json:"error"`
+	} `json:"docker"`
+
+	Disk struct {
+		Path       string `json:"path"`
+		FreeBytes  uint64 `json:"free_bytes"`
+		TotalBytes uint64 `json:"total_bytes"`
+		Error      string `json:"error"`
+	} `json:"disk"`
+}
+
+// ReconcileStatus is the outcome of the agent's last reconciliation pass over its manifest directory.
+type ReconcileStatus struct {
+	// LastRun is the zero time until the first pass has finished.
+	LastRun time.Time `json:"last_run"`
+
+	// Error is set if the manifests couldn't be read, in which case no container was reconciled.
+	Error      string                `json:"error"`
+	Containers []ReconciledContainer `json:"containers"`
+}
+
+// ReconciledContainer is the state of a container which has a manifest.
+type ReconciledContainer struct {
+	Name     string `json:"name"`
+	Manifest string `json:"manifest"`
+
+	// State is one of in_sync, drifted, missing or failed.
+	State string `json:"state"`
+
+	// Action is what the reconciler did to the container during the pass, if anything: created, updated, recreated or rolled_back.
+	Action string  `json:"action"`
+	Error  string  `json:"error"`
+	Drift  []Drift `json:"drift"`
+}
+
+// Drift is a setting in which a container differs from its manifest.
+type Drift struct {
+	Setting string `json:"setting"`
+	Desired string `json:"desired"`
+	Actual  string `json:"actual"`
+}
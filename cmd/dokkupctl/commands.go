@@ -0,0 +1,165 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/XiovV/dokkup-agent/client"
+	"io"
+	"sync"
+)
+
+func (cmd *command) runUpdate(ctx context.Context, container string, options client.UpdateOptions) int {
+	return cmd.runOnAgents(ctx, func(ctx context.Context, c *client.Client) (string, error) {
+		if err := c.UpdateContainer(ctx, container, options); err != nil {
+			return "", err
+		}
+
+		return fmt.Sprintf("updated %s to %s", container, options.Image), nil
+	})
+}
+
+func (cmd *command) runRollback(ctx context.Context, container string) int {
+	return cmd.runOnAgents(ctx, func(ctx context.Context, c *client.Client) (string, error) {
+		if err := c.RollbackContainer(ctx, container); err != nil {
+			return "", err
+		}
+
+		return fmt.Sprintf("rolled back %s", container), nil
+	})
+}
+
+func (cmd *command) runPull(ctx context.Context, image string) int {
+	return cmd.runOnAgents(ctx, func(ctx context.Context, c *client.Client) (string, error) {
+		if err := c.PullImage(ctx, image); err != nil {
+			return "", err
+		}
+
+		return fmt.Sprintf("pulled %s", image), nil
+	})
+}
+
+// runOnAgents runs an operation on one agent after the other. It stops at the first
+// agent the operation fails on, so a bad update isn't rolled out any further.
+func (cmd *command) runOnAgents(ctx context.Context, operation func(context.Context, *client.Client) (string, error)) int {
+	results := make([]result, 0, len(cmd.agents))
+	code := exitOK
+
+	for _, a := range cmd.agents {
+		if code != exitOK {
+			results = append(results, result{Agent: a.Name, Status: statusSkipped})
+			continue
+		}
+
+		message, err := operation(ctx, cmd.client(a))
+		if err != nil {
+			code = exitCode(err)
+			results = append(results, failedResult(a.Name, err))
+			continue
+		}
+
+		results = append(results, result{Agent: a.Name, Status: statusOK, Message: message})
+	}
+
+	if err := cmd.printResults(results); err != nil {
+		fmt.Fprintln(cmd.stderr, err)
+		return exitError
+	}
+
+	return code
+}
+
+func (cmd *command) runPs(ctx context.Context) int {
+	var rows []containerRow
+	code := exitOK
+
+	for _, a := range cmd.agents {
+		containers, err := cmd.client(a).ListContainers(ctx)
+		if err != nil {
+			fmt.Fprintf(cmd.stderr, "%s: %s\n", a.Name, err)
+			if code == exitOK {
+				code = exitCode(err)
+			}
+			continue
+		}
+
+		for _, container := range containers {
+			rows = append(rows, containerRow{Agent: a.Name, Container: container})
+		}
+	}
+
+	if err := cmd.printContainers(rows); err != nil {
+		fmt.Fprintln(cmd.stderr, err)
+		return exitError
+	}
+
+	return code
+}
+
+// runLogs prints the logs of a container. With more than one agent the logs are read
+// concurrently and every line is prefixed with the agent's name.
+func (cmd *command) runLogs(ctx context.Context, container string, options client.LogsOptions) int {
+	if len(cmd.agents) == 1 {
+		return cmd.copyLogs(ctx, cmd.agents[0], container, options, cmd.stdout)
+	}
+
+	var (
+		mu    sync.Mutex
+		wg    sync.WaitGroup
+		codes = make([]int, len(cmd.agents))
+	)
+
+	for i, a := range cmd.agents {
+		reader, writer := io.Pipe()
+
+		wg.Add(2)
+		go func(i int, a agent) {
+			defer wg.Done()
+
+			codes[i] = cmd.copyLogs(ctx, a, container, options, writer)
+			_ = writer.Close()
+		}(i, a)
+
+		go func(name string) {
+			defer wg.Done()
+
+			scanner := bufio.NewScanner(reader)
+			scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+			for scanner.Scan() {
+				mu.Lock()
+				fmt.Fprintf(cmd.stdout, "%s | %s\n", name, scanner.Text())
+				mu.Unlock()
+			}
+
+			// keep draining, so a line which is too long doesn't block the agent's stream
+			_, _ = io.Copy(io.Discard, reader)
+		}(a.Name)
+	}
+
+	wg.Wait()
+
+	for _, code := range codes {
+		if code != exitOK {
+			return code
+		}
+	}
+
+	return exitOK
+}
+
+func (cmd *command) copyLogs(ctx context.Context, a agent, container string, options client.LogsOptions, w io.Writer) int {
+	logs, err := cmd.client(a).ContainerLogs(ctx, container, options)
+	if err != nil {
+		fmt.Fprintf(cmd.stderr, "%s: %s\n", a.Name, err)
+		return exitCode(err)
+	}
+	defer logs.Close()
+
+	if _, err := io.Copy(w, logs); err != nil && !errors.Is(err, context.Canceled) && ctx.Err() == nil {
+		fmt.Fprintf(cmd.stderr, "%s: %s\n", a.Name, err)
+		return exitError
+	}
+
+	return exitOK
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"errors"
+	"github.com/XiovV/dokkup-agent/client"
+)
+
+// Exit codes. Errors returned by an agent are mapped from their API error codes,
+// so scripts can tell e.g. a missing container from a failed update.
+const (
+	exitOK              = 0
+	exitError           = 1
+	exitUsage           = 2
+	exitNotFound        = 3
+	exitAuth            = 4
+	exitBadRequest      = 5
+	exitOperationFailed = 6
+	exitRestoreFailed   = 7
+	exitUnavailable     = 8
+	exitTimeout         = 9
+)
+
+var codeExitCodes = map[string]int{
+	client.CodeNotFound:            exitNotFound,
+	client.CodeContainerNotFound:   exitNotFound,
+	client.CodeRollbackNotFound:    exitNotFound,
+	client.CodeInvalidCredentials:  exitAuth,
+	client.CodePermissionDenied:    exitAuth,
+	client.CodeAddressNotAllowed:   exitAuth,
+	client.CodeBadRequest:          exitBadRequest,
+	client.CodeImageInvalid:        exitBadRequest,
+	client.CodeStartFailed:         exitOperationFailed,
+	client.CodeContainerNotRunning: exitOperationFailed,
+	client.CodeRestoreFailed:       exitRestoreFailed,
+	client.CodeDockerUnavailable:   exitUnavailable,
+	client.CodeRateLimited:         exitUnavailable,
+	client.CodeLockedOut:           exitUnavailable,
+	client.CodeTimeout:             exitTimeout,
+	client.CodeCancelled:           exitTimeout,
+}
+
+// exitCode returns the exit code for an error returned by the client.
+func exitCode(err error) int {
+	if err == nil {
+		return exitOK
+	}
+
+	var apiErr *client.APIError
+	if errors.As(err, &apiErr) {
+		if code, ok := codeExitCodes[apiErr.Code]; ok {
+			return code
+		}
+	}
+
+	return exitError
+}
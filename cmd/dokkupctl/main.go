@@ -0,0 +1,188 @@
+// Command dokkupctl updates, rolls back and inspects containers on one or more dokkup agents.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/XiovV/dokkup-agent/client"
+	"io"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+const usage = `usage: dokkupctl <command> [flags]
+
+Commands:
+  update <container> --image <image> [--keep]        replaces a container with one running a new image
+  rollback <container>                               replaces a container with its rollback container
+  pull <image>                                       pulls an image
+  ps                                                 lists containers
+  logs <container> [-f] [--tail n] [--timestamps]    prints the logs of a container
+
+Every command runs on all agents of the profile unless --agent is given. Global flags:
+  --config <file>     profile file (default: $DOKKUPCTL_CONFIG or <user config dir>/dokkupctl/config.json)
+  --profile <name>    profile to use (default: $DOKKUPCTL_PROFILE or the file's default_profile)
+  --agent <name>      agent to use, may be repeated or a comma separated list
+  -o table|json       output format (default: table)
+`
+
+func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	code := run(ctx, os.Args[1:], os.Stdout, os.Stderr)
+	stop()
+
+	os.Exit(code)
+}
+
+// options are the flags shared by every command.
+type options struct {
+	config  string
+	profile string
+	agents  agentNames
+	output  string
+}
+
+func (o *options) register(fs *flag.FlagSet) {
+	fs.StringVar(&o.config, "config", "", "profile file")
+	fs.StringVar(&o.profile, "profile", "", "profile to use")
+	fs.Var(&o.agents, "agent", "agent to use")
+	fs.StringVar(&o.output, "o", outputTable, "output format")
+}
+
+// command is a parsed command line, ready to be run against the selected agents.
+type command struct {
+	options
+	args   []string
+	agents []agent
+	stdout io.Writer
+	stderr io.Writer
+}
+
+func (cmd *command) client(a agent) *client.Client {
+	c := client.New(a.URL, a.Key)
+	c.BearerToken = a.Token
+
+	return c
+}
+
+// run runs the command given by args and returns the exit code.
+func run(ctx context.Context, args []string, stdout, stderr io.Writer) int {
+	if len(args) == 0 {
+		fmt.Fprint(stderr, usage)
+		return exitUsage
+	}
+
+	cmd := &command{stdout: stdout, stderr: stderr}
+
+	fs := flag.NewFlagSet("dokkupctl "+args[0], flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	cmd.register(fs)
+
+	var (
+		image                string
+		keep, follow, stamps bool
+		tail                 string
+		exec                 func(context.Context) int
+		positional           int
+	)
+
+	switch args[0] {
+	case "update":
+		fs.StringVar(&image, "image", "", "new image")
+		fs.BoolVar(&keep, "keep", false, "keep the old container as a rollback container")
+		positional = 1
+		exec = func(ctx context.Context) int {
+			if image == "" {
+				fmt.Fprintln(stderr, "update needs an --image")
+				return exitUsage
+			}
+
+			return cmd.runUpdate(ctx, cmd.args[0], client.UpdateOptions{Image: image, Keep: keep})
+		}
+	case "rollback":
+		positional = 1
+		exec = func(ctx context.Context) int { return cmd.runRollback(ctx, cmd.args[0]) }
+	case "pull":
+		positional = 1
+		exec = func(ctx context.Context) int { return cmd.runPull(ctx, cmd.args[0]) }
+	case "ps":
+		exec = cmd.runPs
+	case "logs":
+		fs.BoolVar(&follow, "f", false, "follow the logs")
+		fs.BoolVar(&follow, "follow", false, "follow the logs")
+		fs.StringVar(&tail, "tail", "", "number of lines to show from the end of the logs")
+		fs.BoolVar(&stamps, "timestamps", false, "show timestamps")
+		positional = 1
+		exec = func(ctx context.Context) int {
+			return cmd.runLogs(ctx, cmd.args[0], client.LogsOptions{Follow: follow, Tail: tail, Timestamps: stamps})
+		}
+	case "help", "-h", "--help":
+		fmt.Fprint(stdout, usage)
+		return exitOK
+	default:
+		fmt.Fprintf(stderr, "unknown command %q\n\n%s", args[0], usage)
+		return exitUsage
+	}
+
+	var err error
+	if cmd.args, err = parseInterspersed(fs, args[1:]); err != nil {
+		fmt.Fprintf(stderr, "%s: %s\n", args[0], err)
+		return exitUsage
+	}
+
+	if len(cmd.args) != positional {
+		fmt.Fprintf(stderr, "%s takes %d argument(s), got %d\n\n%s", args[0], positional, len(cmd.args), usage)
+		return exitUsage
+	}
+
+	if cmd.output != outputTable && cmd.output != outputJSON {
+		fmt.Fprintf(stderr, "unknown output format %q\n", cmd.output)
+		return exitUsage
+	}
+
+	path, err := profilePath(cmd.config)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return exitUsage
+	}
+
+	file, err := loadProfileFile(path)
+	if err != nil {
+		fmt.Fprintf(stderr, "couldn't load profiles: %s\n", err)
+		return exitUsage
+	}
+
+	if cmd.agents, err = file.selectAgents(cmd.profile, cmd.options.agents); err != nil {
+		fmt.Fprintln(stderr, err)
+		return exitUsage
+	}
+
+	return exec(ctx)
+}
+
+// parseInterspersed parses flags which may come before, after or between the positional
+// arguments, e.g. "update web --image web:1.1", and returns the positional arguments.
+func parseInterspersed(fs *flag.FlagSet, args []string) ([]string, error) {
+	var positional []string
+
+	for {
+		if err := fs.Parse(args); err != nil {
+			if errors.Is(err, flag.ErrHelp) {
+				return nil, errors.New("see dokkupctl help")
+			}
+
+			return nil, err
+		}
+
+		args = fs.Args()
+		if len(args) == 0 {
+			return positional, nil
+		}
+
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
+}
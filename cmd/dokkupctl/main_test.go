@@ -0,0 +1,179 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+var callsMu sync.Mutex
+
+// newFakeAgent returns an agent which responds to every request with status and body.
+// The paths it was called with are appended to calls.
+func newFakeAgent(t *testing.T, status int, body string, calls *[]string) string {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		callsMu.Lock()
+		*calls = append(*calls, r.Method+" "+r.URL.RequestURI())
+		callsMu.Unlock()
+
+		if r.Header.Get("key") != "secret" {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusForbidden)
+			fmt.Fprint(w, `{"error": "invalid api key", "code": "INVALID_CREDENTIALS"}`)
+			return
+		}
+
+		if strings.HasSuffix(r.URL.Path, "/logs") {
+			w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		} else {
+			w.Header().Set("Content-Type", "application/json")
+		}
+
+		w.WriteHeader(status)
+		fmt.Fprint(w, body)
+	}))
+	t.Cleanup(server.Close)
+
+	return server.URL
+}
+
+func writeProfiles(t *testing.T, profiles map[string]profile) string {
+	data, err := json.Marshal(profileFile{Profiles: profiles})
+	assert.Nil(t, err)
+
+	path := filepath.Join(t.TempDir(), "config.json")
+	assert.Nil(t, os.WriteFile(path, data, 0600))
+
+	return path
+}
+
+func runCommand(args ...string) (int, string, string) {
+	var stdout, stderr bytes.Buffer
+	code := run(context.Background(), args, &stdout, &stderr)
+
+	return code, stdout.String(), stderr.String()
+}
+
+func TestUpdate(t *testing.T) {
+	var calls []string
+	ok := newFakeAgent(t, http.StatusOK, `{"message": "successfully updated container"}`, &calls)
+	missing := newFakeAgent(t, http.StatusNotFound, `{"error": "the container could not be found", "code": "CONTAINER_NOT_FOUND", "operation_id": "abc123"}`, &calls)
+
+	config := writeProfiles(t, map[string]profile{
+		"default": {Agents: []agent{{Name: "web-1", URL: ok, Key: "secret"}, {Name: "web-2", URL: ok, Key: "secret"}}},
+		"broken":  {Agents: []agent{{Name: "web-1", URL: missing, Key: "secret"}, {Name: "web-2", URL: ok, Key: "secret"}}},
+	})
+
+	t.Run("Updates every agent", func(t *testing.T) {
+		calls = nil
+		code, stdout, _ := runCommand("update", "web", "--image", "web:1.4", "--keep", "--config", config)
+
+		assert.Equal(t, exitOK, code)
+		assert.Equal(t, []string{"POST /v2/containers/web/update", "POST /v2/containers/web/update"}, calls)
+		lines := strings.Split(strings.TrimSpace(stdout), "\n")
+		assert.Len(t, lines, 3)
+		assert.Equal(t, []string{"web-2", "ok", "updated", "web", "to", "web:1.4"}, strings.Fields(lines[2]))
+	})
+
+	t.Run("Stops at the first failure", func(t *testing.T) {
+		calls = nil
+		code, stdout, _ := runCommand("update", "--config", config, "--profile", "broken", "-o", "json", "web", "--image", "web:1.4")
+
+		assert.Equal(t, exitNotFound, code)
+		assert.Len(t, calls, 1)
+
+		var results []result
+		assert.Nil(t, json.Unmarshal([]byte(stdout), &results))
+
+		assert.Equal(t, []result{
+			{Agent: "web-1", Status: statusFailed, Message: "the container could not be found", Code: "CONTAINER_NOT_FOUND", OperationID: "abc123"},
+			{Agent: "web-2", Status: statusSkipped},
+		}, results)
+	})
+
+	t.Run("Selected agents", func(t *testing.T) {
+		calls = nil
+		code, _, _ := runCommand("rollback", "web", "--config", config, "--profile", "broken", "--agent", "web-2")
+
+		assert.Equal(t, exitOK, code)
+		assert.Equal(t, []string{"POST /v2/containers/web/rollback"}, calls)
+	})
+
+	t.Run("Without an image", func(t *testing.T) {
+		code, _, stderr := runCommand("update", "web", "--config", config)
+
+		assert.Equal(t, exitUsage, code)
+		assert.Contains(t, stderr, "--image")
+	})
+}
+
+func TestExitCodes(t *testing.T) {
+	var calls []string
+	config := writeProfiles(t, map[string]profile{
+		"default": {Agents: []agent{{Name: "web-1", URL: newFakeAgent(t, http.StatusOK, "{}", &calls), Key: "invalid"}}},
+	})
+
+	code, _, _ := runCommand("pull", "web:1.4", "--config", config)
+	assert.Equal(t, exitAuth, code)
+
+	code, _, _ = runCommand("pull", "--config", config)
+	assert.Equal(t, exitUsage, code)
+
+	code, _, _ = runCommand("pull", "web:1.4", "--config", config, "--agent", "web-3")
+	assert.Equal(t, exitUsage, code)
+
+	code, _, _ = runCommand("deploy", "web")
+	assert.Equal(t, exitUsage, code)
+}
+
+func TestPs(t *testing.T) {
+	var calls []string
+	agentURL := newFakeAgent(t, http.StatusOK, `{"containers": [{"name": "web", "id": "4f1b2c3d4e5f6a7b", "image": "web:1.4", "image_id": "sha256:1", "state": "running", "status": "Up 2 hours"}]}`, &calls)
+	config := writeProfiles(t, map[string]profile{
+		"default": {Agents: []agent{{Name: "web-1", URL: agentURL, Key: "secret"}, {Name: "web-2", URL: agentURL, Key: "secret"}}},
+	})
+
+	code, stdout, _ := runCommand("ps", "--config", config)
+	assert.Equal(t, exitOK, code)
+
+	lines := strings.Split(strings.TrimSpace(stdout), "\n")
+	assert.Len(t, lines, 3)
+	assert.Equal(t, []string{"web-2", "web", "web:1.4", "running", "Up", "2", "hours", "4f1b2c3d4e5f"}, strings.Fields(lines[2]))
+
+	code, stdout, _ = runCommand("ps", "--config", config, "-o", "json")
+	assert.Equal(t, exitOK, code)
+
+	var rows []containerRow
+	assert.Nil(t, json.Unmarshal([]byte(stdout), &rows))
+	assert.Len(t, rows, 2)
+	assert.Equal(t, "web-1", rows[0].Agent)
+	assert.Equal(t, "4f1b2c3d4e5f6a7b", rows[0].ID)
+}
+
+func TestLogs(t *testing.T) {
+	var calls []string
+	agentURL := newFakeAgent(t, http.StatusOK, "line 1\nline 2\n", &calls)
+	config := writeProfiles(t, map[string]profile{
+		"default": {Agents: []agent{{Name: "web-1", URL: agentURL, Key: "secret"}, {Name: "web-2", URL: agentURL, Key: "secret"}}},
+	})
+
+	code, stdout, _ := runCommand("logs", "web", "-f", "--tail", "2", "--config", config, "--agent", "web-1")
+	assert.Equal(t, exitOK, code)
+	assert.Equal(t, "line 1\nline 2\n", stdout)
+	assert.Equal(t, "GET /v2/containers/web/logs?follow=true&tail=2", calls[0])
+
+	code, stdout, _ = runCommand("logs", "web", "--config", config)
+	assert.Equal(t, exitOK, code)
+
+	lines := strings.Split(strings.TrimSpace(stdout), "\n")
+	assert.ElementsMatch(t, []string{"web-1 | line 1", "web-1 | line 2", "web-2 | line 1", "web-2 | line 2"}, lines)
+}
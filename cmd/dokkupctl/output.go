@@ -0,0 +1,98 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/XiovV/dokkup-agent/client"
+	"text/tabwriter"
+)
+
+const (
+	outputTable = "table"
+	outputJSON  = "json"
+)
+
+const (
+	statusOK      = "ok"
+	statusFailed  = "failed"
+	statusSkipped = "skipped"
+)
+
+// result is the outcome of an update, rollback or pull on a single agent.
+type result struct {
+	Agent   string `json:"agent"`
+	Status  string `json:"status"`
+	Message string `json:"message,omitempty"`
+
+	// Code and OperationID are only set if the agent responded with an error.
+	Code        string `json:"code,omitempty"`
+	OperationID string `json:"operation_id,omitempty"`
+}
+
+func failedResult(agentName string, err error) result {
+	r := result{Agent: agentName, Status: statusFailed, Message: err.Error()}
+
+	var apiErr *client.APIError
+	if errors.As(err, &apiErr) {
+		r.Message = apiErr.Message
+		r.Code = apiErr.Code
+		r.OperationID = apiErr.OperationID
+
+		if reason, ok := apiErr.Details["reason"].(string); ok {
+			r.Message += ": " + reason
+		}
+	}
+
+	return r
+}
+
+// containerRow is a container listed by ps, along with the agent it's running on.
+type containerRow struct {
+	Agent string `json:"agent"`
+	client.Container
+}
+
+func (cmd *command) printResults(results []result) error {
+	if cmd.output == outputJSON {
+		return cmd.printJSON(results)
+	}
+
+	w := tabwriter.NewWriter(cmd.stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "AGENT\tSTATUS\tMESSAGE\tCODE\tOPERATION")
+	for _, r := range results {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", r.Agent, r.Status, r.Message, r.Code, r.OperationID)
+	}
+
+	return w.Flush()
+}
+
+func (cmd *command) printContainers(rows []containerRow) error {
+	if cmd.output == outputJSON {
+		if rows == nil {
+			rows = []containerRow{}
+		}
+
+		return cmd.printJSON(rows)
+	}
+
+	w := tabwriter.NewWriter(cmd.stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "AGENT\tNAME\tIMAGE\tSTATE\tSTATUS\tID")
+	for _, row := range rows {
+		id := row.ID
+		if len(id) > 12 {
+			id = id[:12]
+		}
+
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", row.Agent, row.Name, row.Image, row.State, row.Status, id)
+	}
+
+	return w.Flush()
+}
+
+func (cmd *command) printJSON(value interface{}) error {
+	encoder := json.NewEncoder(cmd.stdout)
+	encoder.SetIndent("", "  ")
+
+	return encoder.Encode(value)
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const defaultProfile = "default"
+
+// profileFile is the file dokkupctl reads its agents from:
+//
+//	{
+//		"default_profile": "production",
+//		"profiles": {
+//			"production": {"agents": [{"name": "web-1", "url": "http://10.0.0.2:8080", "key": "..."}]}
+//		}
+//	}
+type profileFile struct {
+	DefaultProfile string             `json:"default_profile"`
+	Profiles       map[string]profile `json:"profiles"`
+}
+
+type profile struct {
+	Agents []agent `json:"agents"`
+}
+
+// agent is a single agent of a profile. Token is sent as a bearer token instead of Key if it's set.
+type agent struct {
+	Name  string `json:"name"`
+	URL   string `json:"url"`
+	Key   string `json:"key"`
+	Token string `json:"token"`
+}
+
+// profilePath returns the profile file given with --config, $DOKKUPCTL_CONFIG or
+// the dokkupctl/config.json file in the user's config directory.
+func profilePath(flagValue string) (string, error) {
+	if flagValue != "" {
+		return flagValue, nil
+	}
+
+	if path := os.Getenv("DOKKUPCTL_CONFIG"); path != "" {
+		return path, nil
+	}
+
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(dir, "dokkupctl", "config.json"), nil
+}
+
+func loadProfileFile(path string) (*profileFile, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var file profileFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("%s is malformed: %w", path, err)
+	}
+
+	return &file, nil
+}
+
+// selectAgents returns the agents of the named profile, or of the default profile if name is empty.
+// If names isn't empty, only the agents with those names are returned, in the order they were given.
+func (f *profileFile) selectAgents(name string, names []string) ([]agent, error) {
+	if name == "" {
+		name = os.Getenv("DOKKUPCTL_PROFILE")
+	}
+
+	if name == "" {
+		name = f.DefaultProfile
+	}
+
+	if name == "" {
+		name = defaultProfile
+	}
+
+	p, ok := f.Profiles[name]
+	if !ok {
+		return nil, fmt.Errorf("profile %q does not exist", name)
+	}
+
+	for _, a := range p.Agents {
+		if a.Name == "" || a.URL == "" {
+			return nil, fmt.Errorf("every agent of profile %q needs a name and a url", name)
+		}
+	}
+
+	if len(names) == 0 {
+		if len(p.Agents) == 0 {
+			return nil, fmt.Errorf("profile %q has no agents", name)
+		}
+
+		return p.Agents, nil
+	}
+
+	selected := make([]agent, 0, len(names))
+	for _, agentName := range names {
+		found := false
+		for _, a := range p.Agents {
+			if a.Name == agentName {
+				selected = append(selected, a)
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			return nil, fmt.Errorf("profile %q has no agent named %q", name, agentName)
+		}
+	}
+
+	return selected, nil
+}
+
+// agentNames is a flag which may be repeated or given a comma separated list.
+type agentNames []string
+
+func (n *agentNames) String() string {
+	return strings.Join(*n, ",")
+}
+
+func (n *agentNames) Set(value string) error {
+	for _, name := range strings.Split(value, ",") {
+		if name = strings.TrimSpace(name); name == "" {
+			return errors.New("agent name can't be empty")
+		}
+
+		*n = append(*n, name)
+	}
+
+	return nil
+}
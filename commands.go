@@ -0,0 +1,73 @@
+package main
+
+import (
+	"fmt"
+	"github.com/XiovV/dokkup-agent/audit"
+	"github.com/XiovV/dokkup-agent/config"
+	"os"
+)
+
+const usage = `usage: dokkup-agent [--config file] [command]
+
+Starts the agent when no command is given.
+
+Options:
+  --config file          the config file, in JSON, YAML or TOML (default: $DOKKUP_CONFIG or config.json)
+  --initial-key-file file
+                         writes the api key generated at first start to file instead of printing it
+                         (default: $DOKKUP_INITIAL_KEY_FILE)
+
+Commands:
+  config validate [file] checks the config file and the DOKKUP_* environment overrides
+  audit verify [file]    checks the hash chain of the audit log (default: the config's audit_log)
+`
+
+// runCommand runs the command given on the command line and returns the exit code.
+func runCommand(args []string, configFile string) int {
+	switch {
+	case len(args) >= 2 && args[0] == "config" && args[1] == "validate":
+		if len(args) > 2 {
+			configFile = args[2]
+		}
+
+		return validateConfig(configFile)
+	case len(args) >= 2 && args[0] == "audit" && args[1] == "verify":
+		if len(args) > 2 {
+			return verifyAuditLog(args[2])
+		}
+
+		// the audit log is the one the agent writes to with the same config and environment
+		cfg, err := config.LoadIfExists(configFile)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "couldn't load config: %s\n", err)
+			return 1
+		}
+
+		return verifyAuditLog(cfg.AuditLog)
+	}
+
+	fmt.Fprint(os.Stderr, usage)
+	return 2
+}
+
+func validateConfig(filename string) int {
+	if _, err := config.Load(filename); err != nil {
+		fmt.Fprintf(os.Stderr, "config is invalid: %s\n", err)
+		return 1
+	}
+
+	fmt.Printf("config file %s is valid\n", filename)
+	return 0
+}
+
+func verifyAuditLog(filename string) int {
+	hash, count, err := audit.Verify(filename)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "audit log %s is invalid after %d valid entries: %s\n", filename, count, err)
+		return 1
+	}
+
+	// the hash and count can be compared with the ones the agent logged, which reveals a truncated log
+	fmt.Printf("audit log %s is valid (%d entries, last hash %s)\n", filename, count, hash)
+	return 0
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"github.com/XiovV/dokkup-agent/audit"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestVerifyAuditLogFromConfig(t *testing.T) {
+	dir := t.TempDir()
+	auditFile := filepath.Join(dir, "audit.jsonl")
+	configFile := filepath.Join(dir, "agent.yaml")
+	assert.Nil(t, ioutil.WriteFile(configFile, []byte("api_key: abc\naudit_log: "+auditFile+"\n"), 0600))
+
+	auditLog, err := audit.Open(auditFile)
+	assert.Nil(t, err)
+	assert.Nil(t, auditLog.Record(audit.Entry{Time: time.Now(), Action: "update", Container: "web"}))
+	assert.Nil(t, auditLog.Close())
+
+	// without a file argument, the audit log of the config is verified
+	assert.Equal(t, 0, runCommand([]string{"audit", "verify"}, configFile))
+
+	assert.Nil(t, ioutil.WriteFile(auditFile, []byte("{\"action\": \"update\"}\n"), 0600))
+	assert.Equal(t, 1, runCommand([]string{"audit", "verify"}, configFile))
+
+	// the file argument still takes precedence
+	assert.Equal(t, 1, runCommand([]string{"audit", "verify", filepath.Join(dir, "missing.jsonl")}, configFile))
+}
@@ -4,66 +4,392 @@ import (
 	"crypto/rand"
 	"crypto/sha256"
 	"encoding/base32"
-	"encoding/json"
 	"errors"
 	"fmt"
-	"io/ioutil"
+	"net"
 	"os"
+	"strings"
+	"time"
 )
 
 type Config struct {
-	APIKey string `json:"api_key"`
+	APIKey    string           `json:"api_key"`
+	JWT       *JWTConfig       `json:"jwt,omitempty"`
+	RateLimit *RateLimitConfig `json:"rate_limit,omitempty"`
+
+	// Listen lists the addresses the REST API is served on, all at the same time.
+	// It defaults to DefaultListenAddress.
+	Listen []ListenConfig `json:"listen,omitempty"`
+
+	// AllowedCIDRs lists the networks which are allowed to call the API.
+	// If it's empty, requests from any address are allowed.
+	AllowedCIDRs []string `json:"allowed_cidrs,omitempty"`
+
+	// TrustedProxies lists the addresses of reverse proxies whose X-Forwarded-For
+	// header is used for finding out the real client IP.
+	TrustedProxies []string `json:"trusted_proxies,omitempty"`
+
+	// AuditLog is the path of the file every mutating API call is recorded to.
+	AuditLog string `json:"audit_log,omitempty"`
+
+	Log LogConfig `json:"log"`
+
+	Tracing *TracingConfig `json:"tracing,omitempty"`
+
+	Timeouts *TimeoutConfig `json:"timeouts,omitempty"`
+
+	// GRPC enables the gRPC API, which is served on its own address.
+	GRPC *GRPCConfig `json:"grpc,omitempty"`
+
+	// TLS enables TLS on both the REST and the gRPC API.
+	TLS *TLSConfig `json:"tls,omitempty"`
+
+	// Reconcile enables converging containers to the manifests found in a directory.
+	Reconcile *ReconcileConfig `json:"reconcile,omitempty"`
+
+	// Registries holds the credentials images are pulled with, keyed by the registry's host,
+	// e.g. "registry.example.com:5000". Images on Docker Hub use the "docker.io" entry.
+	Registries map[string]RegistryAuth `json:"registries,omitempty"`
+}
+
+// RegistryAuth is either a username and password, or an identity token, used for pulling images from a registry.
+type RegistryAuth struct {
+	Username      string `json:"username,omitempty"`
+	Password      string `json:"password,omitempty"`
+	IdentityToken string `json:"identity_token,omitempty"`
+}
+
+// ReconcileConfig configures the reconciler, which converges containers to the manifests in ManifestDir.
+// The containers are reconciled whenever a manifest changes, and every IntervalSeconds to catch drift.
+type ReconcileConfig struct {
+	ManifestDir     string `json:"manifest_dir"`
+	IntervalSeconds int    `json:"interval_seconds,omitempty"`
+}
+
+// DefaultReconcileInterval is used when the reconcile section doesn't set interval_seconds.
+const DefaultReconcileInterval = 60 * time.Second
+
+// Interval returns how often the containers are reconciled.
+func (c *ReconcileConfig) Interval() time.Duration {
+	if c.IntervalSeconds == 0 {
+		return DefaultReconcileInterval
+	}
+
+	return time.Duration(c.IntervalSeconds) * time.Second
+}
+
+// GRPCConfig configures the gRPC API, which listens on its own address, e.g. ":9090".
+type GRPCConfig struct {
+	ListenConfig
+}
+
+// TimeoutConfig limits how long each kind of docker operation may take.
+// A value of 0 disables the limit.
+type TimeoutConfig struct {
+	UpdateSeconds   int `json:"update_seconds"`
+	RollbackSeconds int `json:"rollback_seconds"`
+	PullSeconds     int `json:"pull_seconds"`
+	ReadSeconds     int `json:"read_seconds"`
+
+	// RestoreSeconds limits restoring the old container after an update was
+	// aborted, which keeps running even if the client goes away.
+	RestoreSeconds int `json:"restore_seconds"`
+
+	// ShutdownSeconds is how long the agent waits for operations in progress when it's stopped.
+	// Operations which are still running afterwards are aborted, and updates restore the old container.
+	ShutdownSeconds int `json:"shutdown_seconds"`
+}
+
+// DefaultTimeoutConfig is used when the config file doesn't have a timeouts section.
+var DefaultTimeoutConfig = TimeoutConfig{
+	UpdateSeconds:   300,
+	RollbackSeconds: 120,
+	PullSeconds:     600,
+	ReadSeconds:     30,
+	RestoreSeconds:  60,
+	ShutdownSeconds: 60,
 }
 
+// TracingConfig configures where trace spans are exported to. Exporter is either
+// "otlp", which sends spans over OTLP/gRPC to Endpoint, or "file", which writes
+// them as JSON to File for offline use.
+type TracingConfig struct {
+	Exporter    string  `json:"exporter"`
+	Endpoint    string  `json:"endpoint,omitempty"`
+	Insecure    bool    `json:"insecure,omitempty"`
+	File        string  `json:"file,omitempty"`
+	ServiceName string  `json:"service_name,omitempty"`
+	SampleRatio float64 `json:"sample_ratio,omitempty"`
+}
+
+// LogConfig configures the agent's logger. Format is either "logfmt" or "json",
+// and Level is one of "debug", "info", "warn" or "error".
+type LogConfig struct {
+	Format string `json:"format,omitempty"`
+	Level  string `json:"level,omitempty"`
+}
+
+const DefaultAuditLog = "audit.jsonl"
+
+// ErrConfigMalformed is returned when the config file can't be parsed, or has unknown settings or values of the wrong type.
+var ErrConfigMalformed = errors.New("config file is malformed")
+
+// RateLimitConfig holds the request limits for each route class and
+// the settings for locking out clients which repeatedly send invalid credentials.
+type RateLimitConfig struct {
+	Read    RateLimit     `json:"read"`
+	Mutate  RateLimit     `json:"mutate"`
+	Lockout LockoutConfig `json:"lockout"`
+}
+
+// RateLimit is applied separately to every client IP and every API key.
+// A RequestsPerMinute value of 0 disables the limit.
+type RateLimit struct {
+	RequestsPerMinute int `json:"requests_per_minute"`
+	Burst             int `json:"burst"`
+}
+
+// LockoutConfig configures the exponential lockout of client IPs after Threshold
+// consecutive failed authentication attempts. The lockout starts at BaseSeconds and
+// doubles with every further failure, up to MaxSeconds.
+type LockoutConfig struct {
+	Threshold   int `json:"threshold"`
+	BaseSeconds int `json:"base_seconds"`
+	MaxSeconds  int `json:"max_seconds"`
+}
+
+// DefaultRateLimitConfig is used when the config file doesn't have a rate_limit section.
+var DefaultRateLimitConfig = RateLimitConfig{
+	Read:    RateLimit{RequestsPerMinute: 300, Burst: 60},
+	Mutate:  RateLimit{RequestsPerMinute: 60, Burst: 20},
+	Lockout: LockoutConfig{Threshold: 5, BaseSeconds: 30, MaxSeconds: 3600},
+}
+
+// JWTConfig holds the settings used for validating bearer tokens issued by
+// an external identity provider. Tokens are checked against the keys found in
+// JWKSFile and PublicKeyFiles.
+type JWTConfig struct {
+	JWKSFile       string   `json:"jwks_file,omitempty"`
+	PublicKeyFiles []string `json:"public_key_files,omitempty"`
+	Issuer         string   `json:"issuer,omitempty"`
+	Audience       string   `json:"audience,omitempty"`
+
+	// PermissionsClaim is the name of the claim which holds the caller's permissions.
+	// Its value can either be an array of strings or a space separated string.
+	PermissionsClaim string `json:"permissions_claim,omitempty"`
+
+	// PermissionMap optionally maps values of PermissionsClaim (e.g. group names)
+	// to agent permissions. If it's empty, the claim values are used as permissions directly.
+	PermissionMap map[string][]string `json:"permission_map,omitempty"`
+
+	// LeewaySeconds is the allowed clock skew when checking exp and nbf.
+	LeewaySeconds int64 `json:"leeway_seconds,omitempty"`
+}
+
+// New loads the config file at filename, see Load. If the file doesn't exist, it's created with a newly
+// generated API key, which is returned, unless the API key is set through DOKKUP_API_KEY.
 func New(filename string) (*Config, string, error) {
-	var apiKeyPlaintext string
-	file, err := os.Open(filename)
+	_, err := os.Stat(filename)
+	if err == nil {
+		cfg, err := Load(filename)
+		return cfg, "", err
+	}
 
-	defer func(file *os.File) {
-		_ = file.Close()
-	}(file)
+	if !errors.Is(err, os.ErrNotExist) {
+		return nil, "", err
+	}
 
-	if errors.Is(err, os.ErrNotExist) {
-		_, err := os.Create(filename)
-		if err != nil {
-			return nil, "", err
+	// the agent can be configured through the environment alone
+	if _, ok := lookupEnv(EnvPrefix + "API_KEY"); ok {
+		cfg := &Config{}
+		if err := cfg.finish(); err != nil {
+			return nil, "", fmt.Errorf("environment: %w", err)
 		}
 
-		randomBytes := make([]byte, 16)
+		return cfg, "", nil
+	}
 
-		_, err = rand.Read(randomBytes)
-		if err != nil {
-			return nil, "", err
+	randomBytes := make([]byte, 16)
+
+	_, err = rand.Read(randomBytes)
+	if err != nil {
+		return nil, "", err
+	}
+
+	apiKeyPlaintext := base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(randomBytes)
+
+	cfg := &Config{APIKey: fmt.Sprintf("%x", sha256.Sum256([]byte(apiKeyPlaintext)))}
+
+	// the file only gets the api key, not the defaults or the environment overrides
+	data, err := encode(Format(filename), cfg)
+	if err != nil {
+		return nil, "", err
+	}
+
+	// the overrides are checked before writing, since a config file whose key was never handed over is useless
+	if err := cfg.finish(); err != nil {
+		return nil, "", fmt.Errorf("environment: %w", err)
+	}
+
+	if err := WriteFileAtomic(filename, data); err != nil {
+		return nil, "", err
+	}
+
+	return cfg, apiKeyPlaintext, nil
+}
+
+// validate checks the settings which can't be checked by unmarshalling alone.
+func (c *Config) validate() error {
+	switch c.Log.Format {
+	case "logfmt", "json":
+	default:
+		return fmt.Errorf("log.format: must be logfmt or json, not %q", c.Log.Format)
+	}
+
+	switch c.Log.Level {
+	case "debug", "info", "warn", "warning", "error":
+	default:
+		return fmt.Errorf("log.level: must be debug, info, warn or error, not %q", c.Log.Level)
+	}
+
+	if c.Tracing != nil {
+		switch {
+		case c.Tracing.Exporter != "" && c.Tracing.Exporter != "otlp" && c.Tracing.Exporter != "file":
+			return fmt.Errorf("tracing.exporter: must be otlp or file, not %q", c.Tracing.Exporter)
+		case c.Tracing.Exporter == "file" && c.Tracing.File == "":
+			return errors.New("tracing.file: is required by the file exporter")
+		case c.Tracing.SampleRatio < 0 || c.Tracing.SampleRatio > 1:
+			return errors.New("tracing.sample_ratio: must be between 0 and 1")
 		}
+	}
 
-		apiKeyPlaintext = base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(randomBytes)
+	for _, setting := range []struct {
+		name  string
+		value int
+	}{
+		{"rate_limit.read.requests_per_minute", c.RateLimit.Read.RequestsPerMinute},
+		{"rate_limit.read.burst", c.RateLimit.Read.Burst},
+		{"rate_limit.mutate.requests_per_minute", c.RateLimit.Mutate.RequestsPerMinute},
+		{"rate_limit.mutate.burst", c.RateLimit.Mutate.Burst},
+		{"rate_limit.lockout.threshold", c.RateLimit.Lockout.Threshold},
+		{"rate_limit.lockout.base_seconds", c.RateLimit.Lockout.BaseSeconds},
+		{"rate_limit.lockout.max_seconds", c.RateLimit.Lockout.MaxSeconds},
+		{"timeouts.update_seconds", c.Timeouts.UpdateSeconds},
+		{"timeouts.rollback_seconds", c.Timeouts.RollbackSeconds},
+		{"timeouts.pull_seconds", c.Timeouts.PullSeconds},
+		{"timeouts.read_seconds", c.Timeouts.ReadSeconds},
+		{"timeouts.restore_seconds", c.Timeouts.RestoreSeconds},
+		{"timeouts.shutdown_seconds", c.Timeouts.ShutdownSeconds},
+	} {
+		if setting.value < 0 {
+			return fmt.Errorf("%s: must not be negative", setting.name)
+		}
+	}
 
-		cfg := &Config{APIKey: fmt.Sprintf("%x", sha256.Sum256([]byte(apiKeyPlaintext)))}
+	if _, err := ParseCIDRs(c.AllowedCIDRs); err != nil {
+		return fmt.Errorf("allowed_cidrs: %w", err)
+	}
 
-		data, err := json.MarshalIndent(cfg, "", "	")
-		if err != nil {
-			return nil, "", err
+	if _, err := ParseCIDRs(c.TrustedProxies); err != nil {
+		return fmt.Errorf("trusted_proxies: %w", err)
+	}
+
+	for i, listen := range c.Listen {
+		if err := listen.validate(); err != nil {
+			return fmt.Errorf("listen[%d]: %w", i, err)
 		}
+	}
 
-		err = ioutil.WriteFile(filename, data, 0644)
-		if err != nil {
-			return nil, "", err
+	if c.GRPC != nil {
+		if err := c.GRPC.validate(); err != nil {
+			return fmt.Errorf("grpc: %w", err)
 		}
+	}
 
-		fmt.Println("Your new api key is:", apiKeyPlaintext)
-		return cfg, apiKeyPlaintext, nil
+	if c.TLS != nil {
+		if err := c.TLS.validate(); err != nil {
+			return fmt.Errorf("tls: %w", err)
+		}
 	}
 
-	bytes, err := ioutil.ReadAll(file)
-	if err != nil {
-		panic(err)
+	if c.Reconcile != nil {
+		switch {
+		case c.Reconcile.ManifestDir == "":
+			return errors.New("reconcile.manifest_dir: is required")
+		case c.Reconcile.IntervalSeconds < 0:
+			return errors.New("reconcile.interval_seconds: must not be negative")
+		}
+	}
+
+	for registry, auth := range c.Registries {
+		switch {
+		case registry == "" || strings.ContainsAny(registry, "/ "):
+			return fmt.Errorf("registries: %q is not a registry host", registry)
+		case auth.IdentityToken == "" && (auth.Username == "" || auth.Password == ""):
+			return fmt.Errorf("registries.%s: needs either a username and password, or an identity_token", registry)
+		}
+	}
+
+	return nil
+}
+
+// setDefaults fills in the optional sections which are missing from the config file.
+func (c *Config) setDefaults() {
+	if len(c.Listen) == 0 {
+		c.Listen = []ListenConfig{{Address: DefaultListenAddress}}
+	}
+
+	if c.AuditLog == "" {
+		c.AuditLog = DefaultAuditLog
+	}
+
+	if c.Log.Format == "" {
+		c.Log.Format = "logfmt"
+	}
+
+	if c.Log.Level == "" {
+		c.Log.Level = "info"
 	}
-	var cfg Config
-	if err := json.Unmarshal(bytes, &cfg); err != nil {
-		panic(err)
+
+	if c.RateLimit == nil {
+		rateLimit := DefaultRateLimitConfig
+		c.RateLimit = &rateLimit
+	}
+
+	if c.Timeouts == nil {
+		timeouts := DefaultTimeoutConfig
+		c.Timeouts = &timeouts
+	}
+}
+
+// ParseCIDRs parses a list of networks in CIDR notation. Plain IP addresses
+// are treated as networks containing only that address.
+func ParseCIDRs(values []string) ([]*net.IPNet, error) {
+	networks := make([]*net.IPNet, 0, len(values))
+
+	for _, value := range values {
+		if !strings.Contains(value, "/") {
+			ip := net.ParseIP(value)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid IP address %q", value)
+			}
+
+			if ip.To4() != nil {
+				value += "/32"
+			} else {
+				value += "/128"
+			}
+		}
+
+		_, network, err := net.ParseCIDR(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CIDR %q", value)
+		}
+
+		networks = append(networks, network)
 	}
 
-	return &cfg, "", nil
+	return networks, nil
 }
 
 func (c Config) CompareHash(plaintext string) bool {
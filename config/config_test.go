@@ -1,9 +1,13 @@
 package config
 
 import (
+	"context"
 	"github.com/stretchr/testify/assert"
+	"io/ioutil"
 	"os"
+	"path/filepath"
 	"testing"
+	"time"
 )
 
 const testConfigFilename = "config_test.json"
@@ -34,7 +38,305 @@ func TestNew(t *testing.T) {
 	})
 }
 
+func TestNewInvalidCIDR(t *testing.T) {
+	defer removeConfig(t)
+
+	err := ioutil.WriteFile(testConfigFilename, []byte(`{"api_key": "abc", "allowed_cidrs": ["10.0.0.0/33"]}`), 0600)
+	assert.Nil(t, err)
+
+	_, _, err = New(testConfigFilename)
+	assert.NotNil(t, err)
+}
+
+func TestNewMalformedConfig(t *testing.T) {
+	defer removeConfig(t)
+
+	err := ioutil.WriteFile(testConfigFilename, []byte(`{"api_key": `), 0600)
+	assert.Nil(t, err)
+
+	_, _, err = New(testConfigFilename)
+	assert.ErrorIs(t, err, ErrConfigMalformed)
+}
+
+func TestNewInvalidListeners(t *testing.T) {
+	for _, data := range []string{
+		`{"api_key": "abc", "grpc": {}}`,
+		`{"api_key": "abc", "listen": [{"address": ""}]}`,
+		`{"api_key": "abc", "listen": [{"address": "unix:"}]}`,
+		`{"api_key": "abc", "listen": [{"address": "unix:/run/dokkup.sock", "mode": "0999"}]}`,
+		`{"api_key": "abc", "listen": [{"address": ":8080", "owner": "dokkup"}]}`,
+		`{"api_key": "abc", "tls": {"cert_file": "agent.pem"}}`,
+		`{"api_key": "abc", "tls": {"cert_file": "agent.pem", "key_file": "agent.key", "client_permissions": {"deployer": ["*"]}}}`,
+	} {
+		err := ioutil.WriteFile(testConfigFilename, []byte(data), 0600)
+		assert.Nil(t, err)
+
+		_, _, err = New(testConfigFilename)
+		assert.NotNil(t, err, data)
+
+		removeConfig(t)
+	}
+}
+
+func TestNewListeners(t *testing.T) {
+	defer removeConfig(t)
+
+	cfg, _, err := New(testConfigFilename)
+	assert.Nil(t, err)
+	assert.Equal(t, []ListenConfig{{Address: DefaultListenAddress}}, cfg.Listen)
+
+	err = ioutil.WriteFile(testConfigFilename, []byte(`{"api_key": "abc", "listen": [{"address": "systemd:api"}, {"address": "unix:/run/dokkup.sock", "group": "docker", "mode": "0660"}]}`), 0600)
+	assert.Nil(t, err)
+
+	cfg, _, err = New(testConfigFilename)
+	assert.Nil(t, err)
+
+	assert.Len(t, cfg.Listen, 2)
+	assert.True(t, cfg.Listen[0].IsSystemd())
+	assert.Equal(t, "api", cfg.Listen[0].SystemdName())
+	assert.True(t, cfg.Listen[1].IsUnix())
+
+	mode, err := cfg.Listen[1].FileMode()
+	assert.Nil(t, err)
+	assert.Equal(t, uint32(0660), mode)
+}
+
+func TestParseCIDRs(t *testing.T) {
+	networks, err := ParseCIDRs([]string{"10.0.0.0/8", "192.168.1.1", "::1"})
+	assert.Nil(t, err)
+
+	assert.Equal(t, "10.0.0.0/8", networks[0].String())
+	assert.Equal(t, "192.168.1.1/32", networks[1].String())
+	assert.Equal(t, "::1/128", networks[2].String())
+
+	_, err = ParseCIDRs([]string{"not-an-ip"})
+	assert.NotNil(t, err)
+}
+
 func removeConfig(t *testing.T) {
 	err := os.Remove(testConfigFilename)
 	assert.Nil(t, err)
 }
+
+func TestLoadFormats(t *testing.T) {
+	dir := t.TempDir()
+
+	for filename, data := range map[string]string{
+		"config.json": `{"api_key": "abc", "log": {"level": "debug"}, "timeouts": {"update_seconds": 10}}`,
+		"config.yaml": "api_key: abc\nlog:\n  level: debug\ntimeouts:\n  update_seconds: 10\n",
+		"config.toml": "api_key = \"abc\"\n[log]\nlevel = \"debug\"\n[timeouts]\nupdate_seconds = 10\n",
+	} {
+		path := filepath.Join(dir, filename)
+		assert.Nil(t, ioutil.WriteFile(path, []byte(data), 0600))
+
+		cfg, err := Load(path)
+		if !assert.Nil(t, err, filename) {
+			continue
+		}
+
+		assert.Equal(t, "abc", cfg.APIKey, filename)
+		assert.Equal(t, "debug", cfg.Log.Level, filename)
+		assert.Equal(t, 10, cfg.Timeouts.UpdateSeconds, filename)
+	}
+}
+
+func TestLoadInvalid(t *testing.T) {
+	dir := t.TempDir()
+
+	tests := []struct {
+		filename string
+		data     string
+		message  string
+	}{
+		{"config.json", `{"api_key": "abc", "alowed_cidrs": []}`, `unknown setting "alowed_cidrs"`},
+		{"config.json", "{\n\"api_key\": \"abc\",\n}", "line 3"},
+		{"config.yaml", "api_key: abc\nlisten:\n  - address: 8080\n", "listen[0].address: expected a string, got number"},
+		{"config.toml", "api_key = \"abc\"\n[timeouts]\nupdate_seconds = -1\n", "timeouts.update_seconds: must not be negative"},
+		{"config.yaml", "api_key: abc\nlog:\n  format: xml\n", `log.format: must be logfmt or json, not "xml"`},
+		{"config.yaml", "api_key: abc\nreconcile:\n  interval_seconds: 30\n", "reconcile.manifest_dir: is required"},
+		{"config.yaml", "api_key: abc\nregistries:\n  ghcr.io:\n    username: deploy\n", "registries.ghcr.io: needs either a username and password, or an identity_token"},
+		{"config.json", `{"api_key": "abc", "registries": {"https://ghcr.io/v2": {"identity_token": "abc"}}}`, `registries: "https://ghcr.io/v2" is not a registry host`},
+	}
+
+	for _, test := range tests {
+		path := filepath.Join(dir, test.filename)
+		assert.Nil(t, ioutil.WriteFile(path, []byte(test.data), 0600))
+
+		_, err := Load(path)
+		if assert.NotNil(t, err, test.data) {
+			assert.Contains(t, err.Error(), test.message)
+		}
+	}
+}
+
+// setEnv replaces the environment the config is read from for the duration of a test.
+func setEnv(t *testing.T, env map[string]string) {
+	lookupEnv = func(name string) (string, bool) {
+		value, ok := env[name]
+		return value, ok
+	}
+	t.Cleanup(func() { lookupEnv = os.LookupEnv })
+}
+
+func TestEnvOverrides(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	assert.Nil(t, ioutil.WriteFile(path, []byte("api_key: abc\nallowed_cidrs: [10.0.0.0/8]\n"), 0600))
+
+	setEnv(t, map[string]string{
+		"DOKKUP_API_KEY":                 "def",
+		"DOKKUP_LISTEN":                  ":8080, unix:/run/dokkup.sock",
+		"DOKKUP_ALLOWED_CIDRS":           "192.168.1.0/24,10.0.0.1",
+		"DOKKUP_LOG_LEVEL":               "warn",
+		"DOKKUP_TIMEOUTS_UPDATE_SECONDS": "30",
+		"DOKKUP_GRPC_ADDRESS":            ":9090",
+		"DOKKUP_JWT_PERMISSION_MAP":      `{"deployers": ["containers:update"]}`,
+		"DOKKUP_REGISTRIES":              `{"ghcr.io": {"username": "deploy", "password": "secret"}}`,
+	})
+
+	cfg, err := Load(path)
+	assert.Nil(t, err)
+
+	assert.Equal(t, "def", cfg.APIKey)
+	assert.Equal(t, []ListenConfig{{Address: ":8080"}, {Address: "unix:/run/dokkup.sock"}}, cfg.Listen)
+	assert.Equal(t, []string{"192.168.1.0/24", "10.0.0.1"}, cfg.AllowedCIDRs)
+	assert.Equal(t, "warn", cfg.Log.Level)
+	assert.Equal(t, 30, cfg.Timeouts.UpdateSeconds)
+	assert.Equal(t, DefaultTimeoutConfig.PullSeconds, cfg.Timeouts.PullSeconds)
+	assert.Equal(t, ":9090", cfg.GRPC.Address)
+	assert.Equal(t, []string{"containers:update"}, cfg.JWT.PermissionMap["deployers"])
+	assert.Equal(t, map[string]RegistryAuth{"ghcr.io": {Username: "deploy", Password: "secret"}}, cfg.Registries)
+	assert.Nil(t, cfg.TLS)
+
+	setEnv(t, map[string]string{"DOKKUP_RATE_LIMIT_READ_BURST": "many"})
+
+	_, err = Load(path)
+	assert.EqualError(t, err, path+`: DOKKUP_RATE_LIMIT_READ_BURST: "many" isn't a whole number`)
+}
+
+func TestLoadIfExists(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	setEnv(t, map[string]string{"DOKKUP_AUDIT_LOG": "/var/log/dokkup/audit.jsonl"})
+
+	cfg, err := LoadIfExists(path)
+	assert.Nil(t, err)
+	assert.Equal(t, "/var/log/dokkup/audit.jsonl", cfg.AuditLog)
+	assert.NoFileExists(t, path)
+
+	assert.Nil(t, ioutil.WriteFile(path, []byte("api_key: abc\nlog:\n  level: verbose\n"), 0600))
+
+	_, err = LoadIfExists(path)
+	assert.NotNil(t, err)
+}
+
+func TestNewFromEnvironment(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	setEnv(t, map[string]string{"DOKKUP_API_KEY": "abc"})
+
+	cfg, apiKey, err := New(path)
+	assert.Nil(t, err)
+
+	assert.Equal(t, "abc", cfg.APIKey)
+	assert.Empty(t, apiKey)
+	assert.NoFileExists(t, path)
+}
+
+func TestNewInvalidEnvironment(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	setEnv(t, map[string]string{"DOKKUP_LOG_LEVEL": "verbose"})
+
+	_, apiKey, err := New(path)
+	assert.NotNil(t, err)
+	assert.Empty(t, apiKey)
+
+	// the next start generates a key again, instead of loading one which was never handed over
+	assert.NoFileExists(t, path)
+}
+
+func TestNewYAML(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+
+	cfg, apiKey, err := New(path)
+	assert.Nil(t, err)
+	assert.NotEmpty(t, apiKey)
+
+	loaded, err := Load(path)
+	assert.Nil(t, err)
+	assert.Equal(t, cfg.APIKey, loaded.APIKey)
+}
+
+func TestNewPermissions(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.json")
+
+	_, _, err := New(path)
+	assert.Nil(t, err)
+
+	info, err := os.Stat(path)
+	assert.Nil(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+
+	// the temporary file was renamed over the config file
+	files, err := ioutil.ReadDir(dir)
+	assert.Nil(t, err)
+	assert.Len(t, files, 1)
+}
+
+func TestLoadWorldReadable(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	assert.Nil(t, ioutil.WriteFile(path, []byte(`{"api_key": "abc"}`), 0600))
+	assert.Nil(t, os.Chmod(path, 0644))
+
+	_, err := Load(path)
+	assert.ErrorIs(t, err, ErrConfigWorldReadable)
+	assert.Contains(t, err.Error(), "chmod 600")
+
+	assert.Nil(t, os.Chmod(path, 0640))
+
+	_, err = Load(path)
+	assert.Nil(t, err)
+}
+
+func TestWatch(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.json")
+	assert.Nil(t, ioutil.WriteFile(path, []byte(`{"api_key": "abc"}`), 0600))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	changes := make(chan struct{}, 10)
+	assert.Nil(t, Watch(ctx, path, func() { changes <- struct{}{} }))
+
+	// other files in the directory are ignored
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(dir, "other.json"), nil, 0600))
+
+	// replacing the file by renaming a new one over it, as editors do
+	assert.Nil(t, ioutil.WriteFile(path+".tmp", []byte(`{"api_key": "def"}`), 0600))
+	assert.Nil(t, os.Rename(path+".tmp", path))
+
+	select {
+	case <-changes:
+	case <-time.After(5 * time.Second):
+		t.Fatal("change wasn't reported")
+	}
+
+	select {
+	case <-changes:
+		t.Error("change was reported twice")
+	case <-time.After(2 * watchDebounce):
+	}
+}
+
+func TestRestartRequired(t *testing.T) {
+	current := &Config{APIKey: "abc", Listen: []ListenConfig{{Address: ":8080"}}, TLS: &TLSConfig{CertFile: "a.pem", KeyFile: "a.key"}}
+
+	next := *current
+	next.APIKey = "def"
+	next.AllowedCIDRs = []string{"10.0.0.0/8"}
+	next.TLS = &TLSConfig{CertFile: "a.pem", KeyFile: "a.key", ClientPermissions: map[string][]string{"ci": {"*"}}}
+	assert.Empty(t, current.RestartRequired(&next))
+
+	next.Listen = []ListenConfig{{Address: ":9000"}}
+	next.Log.Level = "debug"
+	assert.Equal(t, []string{"listen", "log"}, current.RestartRequired(&next))
+}
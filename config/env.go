@@ -0,0 +1,154 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// EnvPrefix is the prefix of the environment variables which override the settings of the config file.
+// A setting's variable is named after its path in the file, e.g. DOKKUP_LOG_LEVEL for log.level and
+// DOKKUP_TIMEOUTS_UPDATE_SECONDS for timeouts.update_seconds. Lists of strings are comma separated,
+// DOKKUP_LISTEN takes a comma separated list of addresses, and other lists and maps take JSON.
+const EnvPrefix = "DOKKUP_"
+
+// lookupEnv is replaced in tests.
+var lookupEnv = os.LookupEnv
+
+func (c *Config) applyEnv(lookup func(string) (string, bool)) error {
+	_, err := applyEnvToStruct(reflect.ValueOf(c).Elem(), EnvPrefix, lookup)
+	return err
+}
+
+// applyEnvToStruct applies the variables of every setting of a section and reports whether any of them was set.
+func applyEnvToStruct(section reflect.Value, prefix string, lookup func(string) (string, bool)) (bool, error) {
+	set := false
+
+	for i := 0; i < section.NumField(); i++ {
+		field := section.Type().Field(i)
+		name := strings.Split(field.Tag.Get("json"), ",")[0]
+
+		var fieldSet bool
+		var err error
+
+		switch {
+		case field.Anonymous && name == "":
+			// the settings of embedded structs are inlined
+			fieldSet, err = applyEnvToStruct(section.Field(i), prefix, lookup)
+		case name == "" || name == "-":
+			continue
+		default:
+			fieldSet, err = applyEnvToValue(section.Field(i), prefix+strings.ToUpper(name), lookup)
+		}
+
+		if err != nil {
+			return false, err
+		}
+
+		set = set || fieldSet
+	}
+
+	return set, nil
+}
+
+func applyEnvToValue(value reflect.Value, name string, lookup func(string) (string, bool)) (bool, error) {
+	switch {
+	case value.Kind() == reflect.Struct:
+		return applyEnvToStruct(value, name+"_", lookup)
+	case value.Kind() == reflect.Ptr && value.Type().Elem().Kind() == reflect.Struct:
+		if !value.IsNil() {
+			return applyEnvToStruct(value.Elem(), name+"_", lookup)
+		}
+
+		// optional sections are only enabled if one of their settings is set
+		section := reflect.New(value.Type().Elem())
+		set, err := applyEnvToStruct(section.Elem(), name+"_", lookup)
+		if set && err == nil {
+			value.Set(section)
+		}
+
+		return set, err
+	}
+
+	env, ok := lookup(name)
+	if !ok {
+		return false, nil
+	}
+
+	if err := parseEnv(value, env); err != nil {
+		return false, fmt.Errorf("%s: %w", name, err)
+	}
+
+	return true, nil
+}
+
+func parseEnv(value reflect.Value, env string) error {
+	switch value.Kind() {
+	case reflect.String:
+		value.SetString(env)
+		return nil
+	case reflect.Bool:
+		parsed, err := strconv.ParseBool(env)
+		if err != nil {
+			return fmt.Errorf("%q is neither true nor false", env)
+		}
+
+		value.SetBool(parsed)
+		return nil
+	case reflect.Int, reflect.Int64:
+		parsed, err := strconv.ParseInt(env, 10, 64)
+		if err != nil {
+			return fmt.Errorf("%q isn't a whole number", env)
+		}
+
+		value.SetInt(parsed)
+		return nil
+	case reflect.Float64:
+		parsed, err := strconv.ParseFloat(env, 64)
+		if err != nil {
+			return fmt.Errorf("%q isn't a number", env)
+		}
+
+		value.SetFloat(parsed)
+		return nil
+	}
+
+	if value.Type() == reflect.TypeOf([]string{}) {
+		value.Set(reflect.ValueOf(splitList(env)))
+		return nil
+	}
+
+	if value.Type() == reflect.TypeOf([]ListenConfig{}) && !strings.HasPrefix(strings.TrimSpace(env), "[") {
+		var listen []ListenConfig
+		for _, address := range splitList(env) {
+			listen = append(listen, ListenConfig{Address: address})
+		}
+
+		value.Set(reflect.ValueOf(listen))
+		return nil
+	}
+
+	// lists of sections and maps
+	parsed := reflect.New(value.Type())
+	if err := json.Unmarshal([]byte(env), parsed.Interface()); err != nil {
+		return fmt.Errorf("invalid JSON: %s", strings.TrimPrefix(err.Error(), "json: "))
+	}
+
+	value.Set(parsed.Elem())
+	return nil
+}
+
+// splitList splits a comma separated list, leaving out empty items.
+func splitList(env string) []string {
+	list := []string{}
+	for _, item := range strings.Split(env, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+
+	return list
+}
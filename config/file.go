@@ -0,0 +1,68 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+)
+
+// ErrConfigWorldReadable is returned when other users can read the config file, which holds the API key's hash.
+var ErrConfigWorldReadable = errors.New("config file is readable by other users")
+
+// WriteFileAtomic writes data to a file which only its owner can read and write. The data is written to
+// a temporary file in the same directory first, which then replaces filename, so a failed write
+// never leaves a partial or empty file behind.
+func WriteFileAtomic(filename string, data []byte) (err error) {
+	file, err := ioutil.TempFile(filepath.Dir(filename), "."+filepath.Base(filename)+".*")
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		if err != nil {
+			_ = file.Close()
+			_ = os.Remove(file.Name())
+		}
+	}()
+
+	// TempFile already creates files with 0600, but the mode is set explicitly in case the umask is unusual
+	if err = file.Chmod(0600); err != nil {
+		return err
+	}
+
+	if _, err = file.Write(data); err != nil {
+		return err
+	}
+
+	if err = file.Sync(); err != nil {
+		return err
+	}
+
+	if err = file.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(file.Name(), filename)
+}
+
+// checkPermissions returns ErrConfigWorldReadable if other users can read the file at filename.
+// File modes aren't meaningful on Windows, so the check is skipped there.
+func checkPermissions(filename string) error {
+	if runtime.GOOS == "windows" {
+		return nil
+	}
+
+	info, err := os.Stat(filename)
+	if err != nil {
+		return err
+	}
+
+	if info.Mode().Perm()&0004 != 0 {
+		return fmt.Errorf("%w (mode %04o), run chmod 600 %s", ErrConfigWorldReadable, info.Mode().Perm(), filename)
+	}
+
+	return nil
+}
@@ -0,0 +1,71 @@
+package config
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+)
+
+// DefaultListenAddress is used when the config file doesn't have a listen section.
+const DefaultListenAddress = ":8080"
+
+// Address prefixes of Unix sockets and sockets passed by systemd.
+const (
+	UnixAddressPrefix = "unix:"
+	SystemdAddress    = "systemd"
+)
+
+// ListenConfig is an address an API is served on. Address is either a TCP address (":8080"),
+// a Unix socket ("unix:/run/dokkup/agent.sock"), or "systemd" for every socket passed through
+// systemd socket activation, or "systemd:<name>" for the ones with FileDescriptorName=<name>.
+//
+// Owner, Group and Mode (e.g. "0660") are applied to Unix sockets created by the agent.
+type ListenConfig struct {
+	Address string `json:"address"`
+	Owner   string `json:"owner,omitempty"`
+	Group   string `json:"group,omitempty"`
+	Mode    string `json:"mode,omitempty"`
+}
+
+// IsUnix reports whether the address is a Unix socket created by the agent.
+func (c ListenConfig) IsUnix() bool {
+	return strings.HasPrefix(c.Address, UnixAddressPrefix)
+}
+
+// IsSystemd reports whether the address refers to sockets passed by systemd.
+func (c ListenConfig) IsSystemd() bool {
+	return c.Address == SystemdAddress || strings.HasPrefix(c.Address, SystemdAddress+":")
+}
+
+// SystemdName returns the FileDescriptorName a systemd address refers to, or "" for all sockets.
+func (c ListenConfig) SystemdName() string {
+	return strings.TrimPrefix(strings.TrimPrefix(c.Address, SystemdAddress), ":")
+}
+
+// FileMode parses Mode. It returns 0 if Mode is empty.
+func (c ListenConfig) FileMode() (uint32, error) {
+	if c.Mode == "" {
+		return 0, nil
+	}
+
+	mode, err := strconv.ParseUint(c.Mode, 8, 32)
+	if err != nil || mode > 0777 {
+		return 0, errors.New("mode must be an octal file mode, e.g. 0660")
+	}
+
+	return uint32(mode), nil
+}
+
+func (c ListenConfig) validate() error {
+	switch {
+	case c.Address == "":
+		return errors.New("address is required")
+	case c.IsUnix() && c.Address == UnixAddressPrefix:
+		return errors.New("unix address needs a path")
+	case !c.IsUnix() && (c.Owner != "" || c.Group != "" || c.Mode != ""):
+		return errors.New("owner, group and mode only apply to unix sockets")
+	}
+
+	_, err := c.FileMode()
+	return err
+}
@@ -0,0 +1,207 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// Supported config file formats, chosen by the file's extension. Files with any other extension are read as JSON.
+const (
+	FormatJSON = "json"
+	FormatYAML = "yaml"
+	FormatTOML = "toml"
+)
+
+// Format returns the format of the config file at filename.
+func Format(filename string) string {
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".yaml", ".yml":
+		return FormatYAML
+	case ".toml":
+		return FormatTOML
+	}
+
+	return FormatJSON
+}
+
+// Load reads the config file at filename, fills in the defaults, applies the environment overrides
+// (see EnvPrefix) and validates the result. Unlike New, it never creates the file. Files which
+// other users can read are refused with ErrConfigWorldReadable.
+func Load(filename string) (*Config, error) {
+	if err := checkPermissions(filename); err != nil {
+		return nil, err
+	}
+
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	var cfg Config
+	if err := decode(Format(filename), data, &cfg); err != nil {
+		return nil, fmt.Errorf("%s: %w", filename, err)
+	}
+
+	if err := cfg.finish(); err != nil {
+		return nil, fmt.Errorf("%s: %w", filename, err)
+	}
+
+	return &cfg, nil
+}
+
+// LoadIfExists works like Load, but if the file at filename doesn't exist, it returns the defaults
+// with the environment overrides applied instead. Like Load, it never creates the file.
+func LoadIfExists(filename string) (*Config, error) {
+	cfg, err := Load(filename)
+	if !errors.Is(err, os.ErrNotExist) {
+		return cfg, err
+	}
+
+	cfg = &Config{}
+	if err := cfg.finish(); err != nil {
+		return nil, fmt.Errorf("environment: %w", err)
+	}
+
+	return cfg, nil
+}
+
+// finish fills in the defaults, applies the environment overrides and validates the config.
+func (c *Config) finish() error {
+	c.setDefaults()
+
+	if err := c.applyEnv(lookupEnv); err != nil {
+		return err
+	}
+
+	return c.validate()
+}
+
+// decode unmarshals a config file, see Decode.
+func decode(format string, data []byte, cfg *Config) error {
+	if err := Decode(format, data, cfg); err != nil {
+		return fmt.Errorf("%w: %s", ErrConfigMalformed, err)
+	}
+
+	return nil
+}
+
+// Decode unmarshals a document in one of the config file formats into v. YAML and TOML documents are
+// converted to JSON first, so all formats share the json tags and are equally strict about unknown settings.
+// The errors refer to settings and lines of the document instead of Go types.
+func Decode(format string, data []byte, v interface{}) error {
+	switch format {
+	case FormatYAML:
+		var document map[string]interface{}
+		if err := yaml.Unmarshal(data, &document); err != nil {
+			return errors.New(strings.TrimPrefix(err.Error(), "yaml: "))
+		}
+
+		converted, err := json.Marshal(document)
+		if err != nil {
+			return err
+		}
+		data = converted
+	case FormatTOML:
+		var document map[string]interface{}
+		if _, err := toml.Decode(string(data), &document); err != nil {
+			return errors.New(strings.TrimPrefix(err.Error(), "toml: "))
+		}
+
+		converted, err := json.Marshal(document)
+		if err != nil {
+			return err
+		}
+		data = converted
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+
+	if err := decoder.Decode(v); err != nil {
+		return errors.New(describeDecodeError(format, data, err))
+	}
+
+	return nil
+}
+
+// listIndex matches the indexes in the paths of encoding/json, e.g. the 0 in listen.0.address.
+var listIndex = regexp.MustCompile(`\.(\d+)`)
+
+// describeDecodeError rewords the errors of encoding/json, so they refer to settings
+// and lines of the config file instead of Go types.
+func describeDecodeError(format string, data []byte, err error) string {
+	var syntaxErr *json.SyntaxError
+	if errors.As(err, &syntaxErr) && format == FormatJSON {
+		line := bytes.Count(data[:syntaxErr.Offset], []byte("\n")) + 1
+		return fmt.Sprintf("line %d: %s", line, syntaxErr)
+	}
+
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(err, &typeErr) {
+		if typeErr.Field == "" {
+			return fmt.Sprintf("expected a section, got %s", typeErr.Value)
+		}
+
+		field := listIndex.ReplaceAllString(typeErr.Field, "[$1]")
+		return fmt.Sprintf("%s: expected %s, got %s", field, typeName(typeErr.Type.Kind().String()), typeErr.Value)
+	}
+
+	if errors.Is(err, io.ErrUnexpectedEOF) {
+		return "unexpected end of file"
+	}
+
+	message := strings.TrimPrefix(err.Error(), "json: ")
+	if strings.HasPrefix(message, "unknown field ") {
+		return "unknown setting " + strings.TrimPrefix(message, "unknown field ")
+	}
+
+	return message
+}
+
+// typeName returns the config file's name for a Go kind.
+func typeName(kind string) string {
+	switch {
+	case strings.HasPrefix(kind, "int"), strings.HasPrefix(kind, "uint"), strings.HasPrefix(kind, "float"):
+		return "a number"
+	case kind == "slice":
+		return "a list"
+	case kind == "map", kind == "struct", kind == "ptr":
+		return "a section"
+	case kind == "bool":
+		return "true or false"
+	}
+
+	return "a " + kind
+}
+
+// encode marshals a config file in the given format.
+func encode(format string, cfg *Config) ([]byte, error) {
+	data, err := json.MarshalIndent(cfg, "", "	")
+	if err != nil || format == FormatJSON {
+		return data, err
+	}
+
+	var document map[string]interface{}
+	if err := json.Unmarshal(data, &document); err != nil {
+		return nil, err
+	}
+
+	if format == FormatYAML {
+		return yaml.Marshal(document)
+	}
+
+	var buf bytes.Buffer
+	err = toml.NewEncoder(&buf).Encode(document)
+
+	return buf.Bytes(), err
+}
@@ -0,0 +1,65 @@
+package config
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+)
+
+// TLSConfig holds the agent's certificate. If ClientCAFile is set, clients may authenticate with
+// a certificate signed by one of its CAs instead of an API key (mTLS). Such clients are granted the
+// permissions listed under their certificate's common name in ClientPermissions, or all permissions
+// if ClientPermissions is empty.
+type TLSConfig struct {
+	CertFile          string              `json:"cert_file"`
+	KeyFile           string              `json:"key_file"`
+	ClientCAFile      string              `json:"client_ca_file,omitempty"`
+	ClientPermissions map[string][]string `json:"client_permissions,omitempty"`
+}
+
+func (c *TLSConfig) validate() error {
+	if c.CertFile == "" || c.KeyFile == "" {
+		return errors.New("cert_file and key_file are required")
+	}
+
+	if len(c.ClientPermissions) > 0 && c.ClientCAFile == "" {
+		return errors.New("client_permissions requires a client_ca_file")
+	}
+
+	return nil
+}
+
+// ServerConfig loads the certificate and the client CAs and returns the config both APIs are served with.
+// Client certificates are optional, so clients without one can still authenticate with an API key.
+func (c *TLSConfig) ServerConfig() (*tls.Config, error) {
+	certificate, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't load the certificate: %w", err)
+	}
+
+	tlsConfig := &tls.Config{
+		Certificates: []tls.Certificate{certificate},
+		MinVersion:   tls.VersionTLS12,
+	}
+
+	if c.ClientCAFile == "" {
+		return tlsConfig, nil
+	}
+
+	data, err := ioutil.ReadFile(c.ClientCAFile)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't read the client CAs: %w", err)
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("%s doesn't contain any PEM encoded certificates", c.ClientCAFile)
+	}
+
+	tlsConfig.ClientCAs = pool
+	tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+
+	return tlsConfig, nil
+}
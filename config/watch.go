@@ -0,0 +1,99 @@
+package config
+
+import (
+	"context"
+	"github.com/fsnotify/fsnotify"
+	"path/filepath"
+	"reflect"
+	"time"
+)
+
+// watchDebounce is how long Watch waits for further writes before reporting a change,
+// since editors often save a file in several steps.
+const watchDebounce = 200 * time.Millisecond
+
+// Watch calls changed whenever the file at filename is written, replaced or created, until ctx is done.
+// The file's directory is watched, so files which are replaced by renaming a new file over them
+// (as editors and Kubernetes config maps do) keep being watched.
+func Watch(ctx context.Context, filename string, changed func()) error {
+	name := filepath.Clean(filename)
+
+	return watch(ctx, filepath.Dir(filename), func(event fsnotify.Event) bool {
+		return filepath.Clean(event.Name) == name && event.Op&(fsnotify.Write|fsnotify.Create|fsnotify.Rename) != 0
+	}, changed)
+}
+
+// WatchDir calls changed whenever a file in dir is written, created, renamed or removed, until ctx is done.
+func WatchDir(ctx context.Context, dir string, changed func()) error {
+	return watch(ctx, dir, func(event fsnotify.Event) bool {
+		return event.Op&(fsnotify.Write|fsnotify.Create|fsnotify.Rename|fsnotify.Remove) != 0
+	}, changed)
+}
+
+func watch(ctx context.Context, dir string, matches func(fsnotify.Event) bool, changed func()) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+
+	if err := watcher.Add(dir); err != nil {
+		_ = watcher.Close()
+		return err
+	}
+
+	go func() {
+		defer watcher.Close()
+
+		var debounce <-chan time.Time
+		for {
+			select {
+			case event := <-watcher.Events:
+				if matches(event) {
+					debounce = time.After(watchDebounce)
+				}
+			case <-watcher.Errors:
+			case <-debounce:
+				debounce = nil
+				changed()
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return nil
+}
+
+// RestartRequired returns the settings which differ between c and next, but only take effect after a restart.
+func (c *Config) RestartRequired(next *Config) []string {
+	var settings []string
+
+	for _, setting := range []struct {
+		name          string
+		current, next interface{}
+	}{
+		{"listen", c.Listen, next.Listen},
+		{"grpc", c.GRPC, next.GRPC},
+		{"tls.cert_file/key_file/client_ca_file", tlsFiles(c.TLS), tlsFiles(next.TLS)},
+		{"audit_log", c.AuditLog, next.AuditLog},
+		{"log", c.Log, next.Log},
+		{"tracing", c.Tracing, next.Tracing},
+		{"timeouts", c.Timeouts, next.Timeouts},
+		{"reconcile", c.Reconcile, next.Reconcile},
+	} {
+		if !reflect.DeepEqual(setting.current, setting.next) {
+			settings = append(settings, setting.name)
+		}
+	}
+
+	return settings
+}
+
+// tlsFiles returns the files of a TLS config, which are only loaded at startup.
+func tlsFiles(c *TLSConfig) []string {
+	if c == nil {
+		return nil
+	}
+
+	return []string{c.CertFile, c.KeyFile, c.ClientCAFile}
+}
@@ -0,0 +1,54 @@
+package controller
+
+import (
+	"context"
+	"time"
+)
+
+// Timeouts limits how long each kind of operation may take. A zero value disables the limit.
+type Timeouts struct {
+	Update   time.Duration
+	Rollback time.Duration
+	Pull     time.Duration
+	Read     time.Duration
+
+	// Restore limits the steps which must not be interrupted by cancellation,
+	// such as restoring the old container after an update was abandoned.
+	Restore time.Duration
+}
+
+func withTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+
+	return context.WithTimeout(ctx, timeout)
+}
+
+// detachedContext carries the values of its parent (spans, request ids), but is
+// never cancelled when the parent is.
+type detachedContext struct {
+	parent context.Context
+}
+
+func (detachedContext) Deadline() (time.Time, bool) {
+	return time.Time{}, false
+}
+
+func (detachedContext) Done() <-chan struct{} {
+	return nil
+}
+
+func (detachedContext) Err() error {
+	return nil
+}
+
+func (d detachedContext) Value(key interface{}) interface{} {
+	return d.parent.Value(key)
+}
+
+// detach returns a context which survives the cancellation of ctx and is limited by the restore timeout.
+// It's used for steps which would leave containers in a broken state if they were interrupted.
+func (dc *DockerController) detach(ctx context.Context) (context.Context, context.CancelFunc) {
+	return withTimeout(detachedContext{parent: ctx}, dc.timeouts.Restore)
+}
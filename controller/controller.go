@@ -3,13 +3,22 @@ package controller
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"github.com/XiovV/dokkup-agent/logging"
+	"github.com/XiovV/dokkup-agent/metrics"
 	"github.com/docker/docker/api/types"
 	"github.com/docker/docker/api/types/container"
 	"github.com/docker/docker/client"
+	"github.com/docker/docker/pkg/jsonmessage"
+	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 	"io"
-	"os"
 	"strings"
+	"sync/atomic"
+	"time"
 )
 
 const (
@@ -17,44 +26,135 @@ const (
 )
 
 type ContainerController interface {
-	FindContainerByName(string) (types.Container, bool)
-	FindContainerIDByName(string) (string, bool)
-	PullImage(string) error
-	UpdateContainer(string, string, bool) error
-	RollbackContainer(string) error
+	FindContainerByName(context.Context, string) (types.Container, bool)
+	FindContainerIDByName(context.Context, string) (string, bool)
+	PullImage(context.Context, string) error
+	PullImageWithProgress(context.Context, string, func(PullProgress)) error
+	CreateContainer(context.Context, ContainerSpec) (ContainerChange, error)
+	RecreateContainer(context.Context, ContainerSpec) (ContainerChange, error)
+	UpdateContainer(context.Context, string, string, bool) (ContainerChange, error)
+	UpdateContainerWithPatch(context.Context, string, string, bool, ConfigPatch) (ContainerChange, error)
+	RollbackContainer(context.Context, string) (ContainerChange, error)
+	PlanCreate(context.Context, ContainerSpec) (Plan, error)
+	PlanUpdate(context.Context, string, string, bool, ConfigPatch) (Plan, error)
+	PlanRollback(context.Context, string) (Plan, error)
+	PlanPull(context.Context, string) (Plan, error)
+	Available() bool
+	EngineInfo(context.Context) (EngineInfo, error)
+	ListContainers(context.Context) ([]types.Container, error)
+	InspectContainer(context.Context, string) (types.ContainerJSON, error)
+	ContainerLogs(context.Context, string, LogsOptions) (io.ReadCloser, error)
+	Events(context.Context) (<-chan Event, <-chan error)
+	SetRegistryCredentials(map[string]RegistryCredentials)
+}
+
+// ContainerChange describes which image a container was running before and after
+// an update or a rollback. Fields which couldn't be determined are left empty.
+type ContainerChange struct {
+	ContainerName string
+	OldImage      string
+	OldImageID    string
+	NewImage      string
+	NewImageID    string
+
+	// Settings lists the other settings changed by the ConfigPatch of an update.
+	Settings []SettingChange
 }
 
 // OldContainerConfig holds the configuration settings of a container
 // that's being updated which will then be copied over to the updated container
 type OldContainerConfig struct {
 	ContainerName       string
+	ImageID             string
 	ContainerConfig     *container.Config
 	ContainerHostConfig *container.HostConfig
 }
 
 type DockerController struct {
-	cli *client.Client
-	ctx context.Context
+	cli      client.APIClient
+	log      logrus.FieldLogger
+	timeouts Timeouts
+
+	// available is 1 while the docker daemon is reachable, see WatchDocker.
+	available int32
+
+	// registries holds the map[string]RegistryCredentials set by SetRegistryCredentials.
+	registries atomic.Value
 }
 
-// New returns a pointer to DockerController.
-// Will panic if a new docker client couldn't be established
-func New() *DockerController {
+// New returns a pointer to DockerController. It doesn't connect to the docker daemon,
+// so it only fails if the client settings taken from the environment are invalid.
+// Use Ping or WatchDocker to find out whether the daemon is reachable.
+func New(logger logrus.FieldLogger, timeouts Timeouts) (*DockerController, error) {
 	cli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
 	if err != nil {
-		panic(err)
+		return nil, fmt.Errorf("couldn't create docker client: %w", err)
+	}
+
+	return &DockerController{cli: cli, log: logger, timeouts: timeouts}, nil
+}
+
+// metricsContainer returns the container label of an operation's metrics. Operations which were rejected before
+// the container was found, or because it doesn't exist, are counted under metrics.UnknownContainer, so requests
+// for arbitrary names can't create new series.
+func metricsContainer(containerName string, err error) string {
+	var specErr ErrSpecInvalid
+	if errors.Is(err, ErrContainerNotFound) || errors.Is(err, ErrRollbackContainerNotFound) ||
+		errors.Is(err, ErrImageFormatInvalid) || errors.As(err, &specErr) {
+		return metrics.UnknownContainer
 	}
 
-	return &DockerController{cli: cli, ctx: context.Background()}
+	return containerName
+}
+
+// operationLogger returns a logger whose every line carries the operation id found in ctx (or a new one),
+// the name of the operation and the container it's operating on, along with
+// the request id and trace id found in ctx.
+func (dc *DockerController) operationLogger(ctx context.Context, operation, containerName string) logrus.FieldLogger {
+	operationID := logging.OperationID(ctx)
+	if operationID == "" {
+		operationID = logging.NewID()
+	}
+
+	fields := logrus.Fields{
+		"operation_id": operationID,
+		"operation":    operation,
+		"container":    containerName,
+	}
+
+	if requestID := logging.RequestID(ctx); requestID != "" {
+		fields["request_id"] = requestID
+	}
+
+	if spanContext := trace.SpanContextFromContext(ctx); spanContext.HasTraceID() {
+		fields["trace_id"] = spanContext.TraceID().String()
+	}
+
+	return dc.log.WithFields(fields)
+}
+
+// ListContainers returns both running and stopped containers.
+func (dc *DockerController) ListContainers(ctx context.Context) ([]types.Container, error) {
+	ctx, cancel := withTimeout(ctx, dc.timeouts.Read)
+	defer cancel()
+
+	ctx, done := traceDockerCall(ctx, "container_list")
+	containers, err := dc.cli.ContainerList(ctx, types.ContainerListOptions{All: true})
+
+	return containers, done(err)
 }
 
 // FindContainerByName is used for finding a container by its name.
 // Note: it only searches through running containers.
 // If a container is found, it will return a types.Container and true, signifying that the
 // container has been found
-func (dc *DockerController) FindContainerByName(containerName string) (types.Container, bool) {
-	containers, err := dc.cli.ContainerList(dc.ctx, types.ContainerListOptions{})
-	if err != nil {
+func (dc *DockerController) FindContainerByName(ctx context.Context, containerName string) (types.Container, bool) {
+	ctx, cancel := withTimeout(ctx, dc.timeouts.Read)
+	defer cancel()
+
+	listCtx, done := traceDockerCall(ctx, "container_list")
+	containers, err := dc.cli.ContainerList(listCtx, types.ContainerListOptions{})
+	if done(err) != nil {
 		return types.Container{}, false
 	}
 
@@ -71,70 +171,191 @@ func (dc *DockerController) FindContainerByName(containerName string) (types.Con
 // it will return a string containing the name of the container and true, signifying that
 // the container has been found. Unlike FindContainerByName, this method searches through
 // both running and stopped containers
-func (dc *DockerController) FindContainerIDByName(containerName string) (string, bool) {
-	containers, err := dc.cli.ContainerList(dc.ctx, types.ContainerListOptions{All: true})
+func (dc *DockerController) FindContainerIDByName(ctx context.Context, containerName string) (string, bool) {
+	containerId, ok, _ := dc.findContainerID(ctx, containerName)
+
+	return containerId, ok
+}
+
+// InspectContainer returns the configuration and state of a running or stopped container.
+// It returns ErrContainerNotFound if the container doesn't exist.
+func (dc *DockerController) InspectContainer(ctx context.Context, containerName string) (types.ContainerJSON, error) {
+	ctx, cancel := withTimeout(ctx, dc.timeouts.Read)
+	defer cancel()
+
+	containerId, ok, err := dc.findContainerID(ctx, containerName)
 	if err != nil {
-		return "", false
+		return types.ContainerJSON{}, err
+	}
+	if !ok {
+		return types.ContainerJSON{}, ErrContainerNotFound
+	}
+
+	ctx, done := traceDockerCall(ctx, "container_inspect", attribute.String("container.id", containerId))
+	containerJson, err := dc.cli.ContainerInspect(ctx, containerId)
+
+	return containerJson, done(err)
+}
+
+// findContainerID is like FindContainerIDByName, but it returns the error of listing the containers,
+// so a container which doesn't exist can be told apart from an unreachable docker daemon.
+func (dc *DockerController) findContainerID(ctx context.Context, containerName string) (string, bool, error) {
+	ctx, cancel := withTimeout(ctx, dc.timeouts.Read)
+	defer cancel()
+
+	containers, err := dc.ListContainers(ctx)
+	if err != nil {
+		return "", false, err
 	}
 	for _, container := range containers {
 		if container.Names[0][1:] == containerName {
-			return container.ID, true
+			return container.ID, true, nil
 		}
 	}
 
-	return "", false
+	return "", false, nil
 }
 
 // copyContainerConfig gets a copy of the config for a container with a specific id
-func (dc *DockerController) copyContainerConfig(containerId string) (OldContainerConfig, error) {
-	containerJson, err := dc.cli.ContainerInspect(dc.ctx, containerId)
+func (dc *DockerController) copyContainerConfig(ctx context.Context, containerId string) (OldContainerConfig, error) {
+	ctx, done := traceDockerCall(ctx, "container_inspect", attribute.String("container.id", containerId))
+	containerJson, err := dc.cli.ContainerInspect(ctx, containerId)
 
 	if err != nil {
-		return OldContainerConfig{}, err
+		return OldContainerConfig{}, done(err)
 	}
+	done(nil)
 
 	return OldContainerConfig{
 		ContainerConfig:     containerJson.Config,
 		ContainerHostConfig: containerJson.HostConfig,
 		ContainerName:       containerJson.ContainerJSONBase.Name,
+		ImageID:             containerJson.Image,
 	}, nil
 }
 
 // PullImage pulls a requested image. It will return an ErrImageFormatInvalid
 // if the image is not in this format: imagename:tag. It checks if the requested
 // image already exists, and if it does it returns immediately.
-func (dc *DockerController) PullImage(image string) error {
-	imageParts := strings.Split(image, ":")
+func (dc *DockerController) PullImage(ctx context.Context, image string) error {
+	return dc.PullImageWithProgress(ctx, image, nil)
+}
 
-	if len(imageParts) != 2 || imageParts[0] == "" || imageParts[1] == "" {
+// PullProgress is a progress message sent by the docker daemon while pulling an image.
+// Current and Total are in bytes, and are 0 for messages which don't report a download or extraction.
+type PullProgress struct {
+	LayerID string
+	Status  string
+	Current int64
+	Total   int64
+}
+
+// PullImageWithProgress works like PullImage, but also calls progress with every progress
+// message of the docker daemon. progress may be nil.
+func (dc *DockerController) PullImageWithProgress(ctx context.Context, image string, progress func(PullProgress)) error {
+	ctx, cancel := withTimeout(ctx, dc.timeouts.Pull)
+	defer cancel()
+
+	ctx, span := startOperation(ctx, "pull", attribute.String("image", image))
+	start := time.Now()
+
+	err := dc.pullImage(ctx, image, progress)
+	metrics.ObserveOperation("pull", "", start, err)
+	endSpan(span, err)
+
+	return err
+}
+
+func (dc *DockerController) pullImage(ctx context.Context, image string, progress func(PullProgress)) error {
+	log := dc.operationLogger(ctx, "pull", "").WithField("image", image)
+
+	if !isValidImage(image) {
 		return ErrImageFormatInvalid
 	}
 
-	if dc.doesImageExist(image) {
+	if dc.doesImageExist(ctx, image) {
+		log.WithField("step", "check").Info("image already exists, skipping pull")
+		if progress != nil {
+			progress(PullProgress{Status: "Image is up to date for " + image})
+		}
+
 		return nil
 	}
 
-	reader, err := dc.cli.ImagePull(dc.ctx, image, types.ImagePullOptions{})
+	registryAuth, err := dc.registryAuth(image)
 	if err != nil {
 		return err
 	}
 
-	if _, err = io.Copy(os.Stdout, reader); err != nil {
+	log.WithField("step", "pull").Info("pulling image")
+	pullCtx, done := traceDockerCall(ctx, "image_pull", attribute.String("image", image))
+	reader, err := dc.cli.ImagePull(pullCtx, image, types.ImagePullOptions{RegistryAuth: registryAuth})
+	if err != nil {
+		if ctx.Err() != nil {
+			return ErrOperationAborted{Step: "pull", Reason: done(ctx.Err())}
+		}
+
+		return done(err)
+	}
+	defer reader.Close()
+
+	if err = done(logPullProgress(log.WithField("step", "pull"), reader, progress)); err != nil {
+		if ctx.Err() != nil {
+			return ErrOperationAborted{Step: "pull", Reason: ctx.Err()}
+		}
+
 		return err
 	}
 
+	log.WithField("step", "done").Info("image pulled successfully")
+
 	return nil
 }
 
+// logPullProgress logs the progress messages sent by the docker daemon while pulling
+// an image, passes them on to progress if it isn't nil, and returns the error reported
+// by the daemon, if any.
+func logPullProgress(log logrus.FieldLogger, reader io.Reader, progress func(PullProgress)) error {
+	decoder := json.NewDecoder(reader)
+
+	for {
+		var message jsonmessage.JSONMessage
+		if err := decoder.Decode(&message); err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+
+			return err
+		}
+
+		if message.Error != nil {
+			return message.Error
+		}
+
+		log.WithField("layer", message.ID).Debug(message.Status)
+
+		if progress != nil {
+			update := PullProgress{LayerID: message.ID, Status: message.Status}
+			if message.Progress != nil {
+				update.Current = message.Progress.Current
+				update.Total = message.Progress.Total
+			}
+
+			progress(update)
+		}
+	}
+}
+
 // doesImageExist goes through all images and checks if the requested image exists.
-func (dc *DockerController) doesImageExist(image string) bool {
+func (dc *DockerController) doesImageExist(ctx context.Context, image string) bool {
 	if strings.Split(image, ":")[1] == "latest" {
 		return false
 	}
 
-	images, err := dc.cli.ImageList(dc.ctx, types.ImageListOptions{All: true})
-	if err != nil {
-		fmt.Println("error while fetching images:", err)
+	ctx, done := traceDockerCall(ctx, "image_list")
+	images, err := dc.cli.ImageList(ctx, types.ImageListOptions{All: true})
+	if done(err) != nil {
+		dc.log.WithError(err).Warn("error while fetching images")
 		return false
 	}
 
@@ -151,199 +372,395 @@ func (dc *DockerController) doesImageExist(image string) bool {
 
 // RollbackContainer tries to find a container with the '-rollback' suffix in its name.
 // If it finds one, it will remove the '-rollback' suffix and run it, and it will
-// remove the previous container. It will return ErrContainerNotFound if the requested container
-// doesn't exist, and ErrRollbackContainerNotFound if the requested container doesn't have
-// its own fallback container.
-func (dc *DockerController) RollbackContainer(containerName string) error {
-	rollbackContainerId, ok := dc.FindContainerIDByName(containerName + RollbackContainerSuffix)
+// remove the previous container. As it always has, it returns ErrContainerNotFound if the requested
+// container doesn't have a rollback container, and ErrRollbackContainerNotFound if the rollback container
+// exists, but the requested container doesn't. The returned ContainerChange describes the image of the
+// removed container as the old image, and the image of the rollback container as the new one.
+// A half finished rollback can't be undone, so once the current container is being stopped,
+// the rollback runs to completion even if ctx is cancelled.
+func (dc *DockerController) RollbackContainer(ctx context.Context, containerName string) (ContainerChange, error) {
+	ctx, cancel := withTimeout(ctx, dc.timeouts.Rollback)
+	defer cancel()
+
+	ctx, span := startOperation(ctx, "rollback", attribute.String("container", containerName))
+	start := time.Now()
+
+	change, err := dc.rollbackContainer(ctx, containerName)
+	metrics.ObserveOperation("rollback", metricsContainer(containerName, err), start, err)
+	endSpan(span, err)
+
+	return change, err
+}
+
+func (dc *DockerController) rollbackContainer(ctx context.Context, containerName string) (ContainerChange, error) {
+	log := dc.operationLogger(ctx, "rollback", containerName)
+	change := ContainerChange{ContainerName: containerName}
+
+	rollbackContainerId, ok, err := dc.findContainerID(ctx, containerName+RollbackContainerSuffix)
+	if err != nil {
+		return change, fmt.Errorf("couldn't list containers: %w", err)
+	}
 	if !ok {
-		return ErrContainerNotFound
+		return change, ErrContainerNotFound
 	}
 
-	currentContainerId, ok := dc.FindContainerIDByName(containerName)
+	currentContainerId, ok, err := dc.findContainerID(ctx, containerName)
+	if err != nil {
+		return change, fmt.Errorf("couldn't list containers: %w", err)
+	}
 	if !ok {
-		return ErrRollbackContainerNotFound
+		return change, ErrRollbackContainerNotFound
+	}
+
+	change.OldImage, change.OldImageID = dc.containerImage(ctx, currentContainerId)
+	change.NewImage, change.NewImageID = dc.containerImage(ctx, rollbackContainerId)
+
+	if err := ctx.Err(); err != nil {
+		return change, ErrOperationAborted{Step: "inspect", Reason: err}
 	}
 
-	if err := dc.stopContainer(currentContainerId); err != nil {
-		return fmt.Errorf("couldn't stop container %s: %w", containerName, err)
+	ctx, cancel := dc.detach(ctx)
+	defer cancel()
+
+	log.WithField("step", "stop").Infof("stopping current container (%s)", currentContainerId)
+	if err := dc.stopContainer(ctx, currentContainerId); err != nil {
+		return change, fmt.Errorf("couldn't stop container %s: %w", containerName, err)
 	}
 
-	err := dc.removeContainer(currentContainerId)
+	log.WithField("step", "remove").Infof("removing current container (%s)", currentContainerId)
+	err = dc.removeContainer(ctx, currentContainerId)
 	if err != nil {
-		return fmt.Errorf("couldn't remove container %s: %w", currentContainerId, err)
+		return change, fmt.Errorf("couldn't remove container %s: %w", currentContainerId, err)
 	}
 
-	err = dc.renameContainer(rollbackContainerId, containerName)
+	log.WithField("step", "rename").Infof("renaming rollback container (%s) to %s", rollbackContainerId, containerName)
+	err = dc.renameContainer(ctx, rollbackContainerId, containerName)
 	if err != nil {
-		return fmt.Errorf("couldn't rename container %s: %w", rollbackContainerId, err)
+		return change, fmt.Errorf("couldn't rename container %s: %w", rollbackContainerId, err)
 	}
 
-	err = dc.startContainer(rollbackContainerId)
+	log.WithField("step", "start").Infof("starting rollback container (%s)", rollbackContainerId)
+	err = dc.startContainer(ctx, rollbackContainerId)
 	if err != nil {
-		return ErrContainerStartFailed{ContainerId: rollbackContainerId, Reason: err}
+		return change, ErrContainerStartFailed{ContainerId: rollbackContainerId, Reason: err}
 	}
 
-	if !dc.isContainerRunning(rollbackContainerId) {
-		fmt.Println("rollback container is not running...")
-		return ErrContainerNotRunning
+	running, err := dc.isContainerRunning(ctx, rollbackContainerId)
+	if err != nil {
+		return change, fmt.Errorf("couldn't check if container %s is running: %w", rollbackContainerId, err)
 	}
 
-	return nil
+	if !running {
+		log.WithField("step", "verify").Error("rollback container is not running")
+		return change, ErrContainerNotRunning
+	}
+
+	log.WithField("step", "done").Info("container rolled back successfully")
+
+	return change, nil
 }
 
-func (dc *DockerController) UpdateContainer(containerName, image string, keepContainer bool) error {
-	imageParts := strings.Split(image, ":")
+// UpdateContainer replaces a container with a new container which uses the requested image,
+// but otherwise has the same configuration. The old container is kept as a rollback container
+// if keepContainer is true. The returned ContainerChange describes the old and the new image.
+// If ctx is cancelled or the update times out after the old container has been renamed,
+// the old container is restored and ErrOperationAborted is returned.
+func (dc *DockerController) UpdateContainer(ctx context.Context, containerName, image string, keepContainer bool) (ContainerChange, error) {
+	return dc.UpdateContainerWithPatch(ctx, containerName, image, keepContainer, ConfigPatch{})
+}
 
-	if len(imageParts) != 2 || imageParts[0] == "" || imageParts[1] == "" {
-		return ErrImageFormatInvalid
+// UpdateContainerWithPatch works like UpdateContainer, but also applies patch to the configuration copied from
+// the old container. It returns ErrSpecInvalid if the patch is invalid. The settings which the patch changed
+// are listed in the returned ContainerChange.
+func (dc *DockerController) UpdateContainerWithPatch(ctx context.Context, containerName, image string, keepContainer bool, patch ConfigPatch) (ContainerChange, error) {
+	ctx, cancel := withTimeout(ctx, dc.timeouts.Update)
+	defer cancel()
+
+	ctx, span := startOperation(ctx, "update", attribute.String("container", containerName), attribute.String("image", image))
+	start := time.Now()
+
+	change, err := dc.updateContainer(ctx, containerName, image, keepContainer, patch)
+	metrics.ObserveOperation("update", metricsContainer(containerName, err), start, err)
+	endSpan(span, err)
+
+	return change, err
+}
+
+func (dc *DockerController) updateContainer(ctx context.Context, containerName, image string, keepContainer bool, patch ConfigPatch) (ContainerChange, error) {
+	log := dc.operationLogger(ctx, "update", containerName).WithField("image", image)
+	change := ContainerChange{ContainerName: containerName, NewImage: image}
+	timer := metrics.NewStepTimer()
+
+	if !isValidImage(image) {
+		return change, ErrImageFormatInvalid
+	}
+
+	if err := patch.Validate(); err != nil {
+		return change, err
 	}
 
-	containerId, ok := dc.FindContainerIDByName(containerName)
+	containerId, ok, err := dc.findContainerID(ctx, containerName)
+	if err != nil {
+		return change, fmt.Errorf("couldn't list containers: %w", err)
+	}
 	if !ok {
-		return ErrContainerNotFound
+		return change, ErrContainerNotFound
 	}
 
-	rollbackContainerId, ok := dc.FindContainerIDByName(containerName + RollbackContainerSuffix)
+	rollbackContainerId, ok, err := dc.findContainerID(ctx, containerName+RollbackContainerSuffix)
+	if err != nil {
+		return change, fmt.Errorf("couldn't list containers: %w", err)
+	}
 	if ok {
-		fmt.Println("removing rollback container")
-		err := dc.removeContainer(rollbackContainerId)
+		log.WithField("step", "remove_rollback").Infof("removing previous rollback container (%s)", rollbackContainerId)
+		err := dc.removeContainer(ctx, rollbackContainerId)
 		if err != nil {
-			return fmt.Errorf("could not remove rollback container: %w", err)
+			return change, fmt.Errorf("could not remove rollback container: %w", err)
 		}
+	} else {
+		log.WithField("step", "remove_rollback").Debug("rollback container doesn't exist, continuing")
 	}
-	fmt.Println("rollback container doesn't exist, continuing...")
+	timer.Step("remove_rollback")
 
-	configCopy, err := dc.copyContainerConfig(containerId)
+	configCopy, err := dc.copyContainerConfig(ctx, containerId)
 	if err != nil {
-		return fmt.Errorf("couldn't copy container config: %w", err)
+		return change, fmt.Errorf("couldn't copy container config: %w", err)
+	}
+
+	change.OldImage = configCopy.ContainerConfig.Image
+	change.OldImageID = configCopy.ImageID
+	change.Settings = patch.apply(configCopy.ContainerConfig, configCopy.ContainerHostConfig)
+	for _, setting := range change.Settings {
+		log.WithField("step", "inspect").Infof("changing %s", setting.Setting)
 	}
+	timer.Step("inspect")
 
-	fmt.Printf("renaming %s (%s) to %s-rollback\n", configCopy.ContainerName, containerId, configCopy.ContainerName)
-	if err = dc.renameContainer(containerId, configCopy.ContainerName+RollbackContainerSuffix); err != nil {
-		return fmt.Errorf("couldn't rename container: %w", err)
+	if err := ctx.Err(); err != nil {
+		return change, ErrOperationAborted{Step: "inspect", Reason: err}
 	}
 
-	fmt.Println("creating new container...")
-	newContainerId, err := dc.createContainer(configCopy, image)
+	// an interrupted rename may still have been carried out by the daemon, leaving no container under the
+	// original name, so the rename itself isn't interrupted, and the update is aborted right after it instead
+	log.WithField("step", "rename").Infof("renaming %s (%s) to %s%s", configCopy.ContainerName, containerId, configCopy.ContainerName, RollbackContainerSuffix)
+	renameCtx, cancelRename := dc.detach(ctx)
+	err = dc.renameContainer(renameCtx, containerId, configCopy.ContainerName+RollbackContainerSuffix)
+	cancelRename()
 	if err != nil {
-		fmt.Println("couldn't create new container:", err)
-		if err = dc.restoreContainer(containerId, newContainerId, configCopy.ContainerName); err != nil {
-			return fmt.Errorf("couldn't restore old container: %w", err)
-		}
-		return err
+		return change, fmt.Errorf("couldn't rename container: %w", err)
 	}
+	timer.Step("rename")
 
-	fmt.Println("updated container id:", newContainerId)
+	// from here on the old container has been renamed, so it has to be restored if any of the following steps fail
 
-	fmt.Printf("stopping %s-rollback (%s)\n", configCopy.ContainerName, containerId)
-	if err = dc.stopContainer(containerId); err != nil {
-		return fmt.Errorf("coulnd't stop container %s: %w", configCopy.ContainerName, err)
+	if err := ctx.Err(); err != nil {
+		return change, dc.abortUpdate(ctx, log, "rename", err, containerId, "", configCopy.ContainerName)
 	}
 
-	fmt.Printf("starting new container (%s)\n", newContainerId)
-	if err = dc.startContainer(newContainerId); err != nil {
-		return err
+	log.WithField("step", "create").Info("creating new container")
+	newContainerId, err := dc.createContainer(ctx, configCopy, image)
+	if err != nil {
+		log.WithField("step", "create").WithError(err).Error("couldn't create new container")
+		return change, dc.abortUpdate(ctx, log, "create", err, containerId, newContainerId, configCopy.ContainerName)
 	}
 
-	if !dc.isContainerRunning(newContainerId) {
-		fmt.Println("new container is not running, trying to restore old container...")
-		if err = dc.restoreContainer(containerId, newContainerId, configCopy.ContainerName); err != nil {
-			return ErrContainerRestoreFailed
-		}
+	log.WithField("step", "create").Infof("created new container (%s)", newContainerId)
+	_, change.NewImageID = dc.containerImage(ctx, newContainerId)
+	timer.Step("create")
+
+	log.WithField("step", "stop").Infof("stopping %s%s (%s)", configCopy.ContainerName, RollbackContainerSuffix, containerId)
+	if err = dc.stopContainer(ctx, containerId); err != nil {
+		err = fmt.Errorf("coulnd't stop container %s: %w", configCopy.ContainerName, err)
+		return change, dc.abortUpdate(ctx, log, "stop", err, containerId, newContainerId, configCopy.ContainerName)
+	}
+	timer.Step("stop")
+
+	log.WithField("step", "start").Infof("starting new container (%s)", newContainerId)
+	if err = dc.startContainer(ctx, newContainerId); err != nil {
+		err = ErrContainerStartFailed{ContainerId: newContainerId, Reason: err}
+		return change, dc.abortUpdate(ctx, log, "start", err, containerId, newContainerId, configCopy.ContainerName)
+	}
+	timer.Step("start")
 
-		return ErrContainerNotRunning
+	running, err := dc.isContainerRunning(ctx, newContainerId)
+	if err != nil {
+		err = fmt.Errorf("couldn't check if container %s is running: %w", newContainerId, err)
+		return change, dc.abortUpdate(ctx, log, "verify", err, containerId, newContainerId, configCopy.ContainerName)
+	}
+
+	if !running {
+		log.WithField("step", "verify").Error("new container is not running, trying to restore old container")
+		return change, dc.abortUpdate(ctx, log, "verify", ErrContainerNotRunning, containerId, newContainerId, configCopy.ContainerName)
 	}
+	timer.Step("verify")
+
+	// the new container is running, so the clean up must finish even if ctx is cancelled
+	cleanupCtx, cancel := dc.detach(ctx)
+	defer cancel()
 
 	if !keepContainer {
-		fmt.Printf("removing container %s-rollback (%s)\n", configCopy.ContainerName, containerId)
-		err = dc.removeContainer(containerId)
+		log.WithField("step", "remove").Infof("removing container %s%s (%s)", configCopy.ContainerName, RollbackContainerSuffix, containerId)
+		err = dc.removeContainer(cleanupCtx, containerId)
 		if err != nil {
-			return fmt.Errorf("couldn't remove container %s-rollback: %w", containerId, err)
+			return change, fmt.Errorf("couldn't remove container %s-rollback: %w", containerId, err)
 		}
+		timer.Step("remove")
 	}
 
-	return nil
+	log.WithField("step", "done").Info("container updated successfully")
+
+	return change, nil
+}
+
+// abortUpdate restores the old container after a step of an update has failed or has been
+// interrupted. The restore runs on a detached context, so it finishes even if ctx has been cancelled.
+// It returns ErrOperationAborted if ctx was cancelled, ErrContainerRestoreFailed if the old
+// container couldn't be restored, and cause otherwise.
+func (dc *DockerController) abortUpdate(ctx context.Context, log logrus.FieldLogger, step string, cause error, oldContainerId, newContainerId, originalName string) error {
+	if ctx.Err() != nil {
+		log.WithField("step", step).WithError(ctx.Err()).Warn("update was aborted, restoring old container")
+	}
+
+	restoreCtx, cancel := dc.detach(ctx)
+	defer cancel()
+
+	if err := dc.restoreContainer(restoreCtx, log, oldContainerId, newContainerId, originalName); err != nil {
+		log.WithField("step", "restore").WithError(err).Error("couldn't restore old container")
+		return fmt.Errorf("%w: %s", ErrContainerRestoreFailed, err)
+	}
+
+	if err := ctx.Err(); err != nil {
+		return ErrOperationAborted{Step: step, Reason: err}
+	}
+
+	return cause
+}
+
+// containerImage returns the image reference and the image id used by a container.
+// Empty strings are returned if the container couldn't be inspected.
+func (dc *DockerController) containerImage(ctx context.Context, containerId string) (string, string) {
+	ctx, done := traceDockerCall(ctx, "container_inspect", attribute.String("container.id", containerId))
+	containerJson, err := dc.cli.ContainerInspect(ctx, containerId)
+	if done(err) != nil {
+		return "", ""
+	}
+
+	return containerJson.Config.Image, containerJson.Image
 }
 
-func (dc *DockerController) doesContainerIDExist(containerId string) bool {
-	containers, err := dc.cli.ContainerList(dc.ctx, types.ContainerListOptions{All: true})
+func (dc *DockerController) doesContainerIDExist(ctx context.Context, containerId string) (bool, error) {
+	containers, err := dc.ListContainers(ctx)
 	if err != nil {
-		panic(err)
+		return false, err
 	}
 
 	for _, container := range containers {
 		if container.ID == containerId {
-			return true
+			return true, nil
 		}
 	}
 
-	return false
+	return false, nil
 }
 
-func (dc *DockerController) restoreContainer(oldContainerId, newContainerId, originalName string) error {
-	if dc.doesContainerIDExist(newContainerId) {
-		fmt.Printf("RESTORE: removing newly created container %s\n", newContainerId)
-		if err := dc.removeContainer(newContainerId); err != nil {
+// restoreContainer removes the new container of an update, if it was created, and gives the old container back its
+// original name. An empty newContainerId means the create didn't return, though the daemon may still have created the
+// container, in which case it's found by the original name.
+func (dc *DockerController) restoreContainer(ctx context.Context, log logrus.FieldLogger, oldContainerId, newContainerId, originalName string) error {
+	if newContainerId == "" {
+		id, ok, err := dc.findContainerID(ctx, strings.TrimPrefix(originalName, "/"))
+		if err != nil {
+			return err
+		}
+
+		if ok && id != oldContainerId {
+			newContainerId = id
+		}
+	}
+
+	exists := false
+	if newContainerId != "" {
+		var err error
+		if exists, err = dc.doesContainerIDExist(ctx, newContainerId); err != nil {
+			return err
+		}
+	}
+
+	if exists {
+		// the update may have been interrupted after the new container was started, and running containers can't be removed
+		log.WithField("step", "restore").Warnf("stopping newly created container %s", newContainerId)
+		if err := dc.stopContainer(ctx, newContainerId); err != nil {
+			return err
+		}
+
+		log.WithField("step", "restore").Warnf("removing newly created container %s", newContainerId)
+		if err := dc.removeContainer(ctx, newContainerId); err != nil {
 			return err
 		}
 	}
 
-	fmt.Printf("RESTORE: renaming %s to %s\n", oldContainerId, originalName)
-	if err := dc.renameContainer(oldContainerId, originalName); err != nil {
+	log.WithField("step", "restore").Warnf("renaming %s to %s", oldContainerId, originalName)
+	if err := dc.renameContainer(ctx, oldContainerId, originalName); err != nil {
 		return err
 	}
 
-	fmt.Printf("RESTORE: starting container %s\n", oldContainerId)
-	if err := dc.startContainer(oldContainerId); err != nil {
+	log.WithField("step", "restore").Warnf("starting container %s", oldContainerId)
+	if err := dc.startContainer(ctx, oldContainerId); err != nil {
 		return err
 	}
 
 	return nil
 }
 
-func (dc *DockerController) removeContainer(containerId string) error {
-	return dc.cli.ContainerRemove(dc.ctx, containerId, types.ContainerRemoveOptions{})
+func (dc *DockerController) removeContainer(ctx context.Context, containerId string) error {
+	ctx, done := traceDockerCall(ctx, "container_remove", attribute.String("container.id", containerId))
+	return done(dc.cli.ContainerRemove(ctx, containerId, types.ContainerRemoveOptions{}))
 }
 
-func (dc *DockerController) stopContainer(containerId string) error {
-	return dc.cli.ContainerStop(dc.ctx, containerId, nil)
+func (dc *DockerController) stopContainer(ctx context.Context, containerId string) error {
+	ctx, done := traceDockerCall(ctx, "container_stop", attribute.String("container.id", containerId))
+	return done(dc.cli.ContainerStop(ctx, containerId, nil))
 }
 
-func (dc *DockerController) renameContainer(containerId, newName string) error {
-	return dc.cli.ContainerRename(dc.ctx, containerId, newName)
+func (dc *DockerController) renameContainer(ctx context.Context, containerId, newName string) error {
+	ctx, done := traceDockerCall(ctx, "container_rename", attribute.String("container.id", containerId), attribute.String("container.new_name", newName))
+	return done(dc.cli.ContainerRename(ctx, containerId, newName))
 }
 
-func (dc *DockerController) createContainer(config OldContainerConfig, image string) (string, error) {
+func (dc *DockerController) createContainer(ctx context.Context, config OldContainerConfig, image string) (string, error) {
 	config.ContainerConfig.Image = image
 
-	resp, err := dc.cli.ContainerCreate(dc.ctx, config.ContainerConfig, config.ContainerHostConfig, nil, nil, config.ContainerName)
+	ctx, done := traceDockerCall(ctx, "container_create", attribute.String("image", image))
+	resp, err := dc.cli.ContainerCreate(ctx, config.ContainerConfig, config.ContainerHostConfig, nil, nil, config.ContainerName)
 	if err != nil {
-		return "", err
+		return "", done(err)
 	}
+	done(nil)
 
 	return resp.ID, nil
 }
 
-func (dc *DockerController) startContainer(containerId string) error {
-	if err := dc.cli.ContainerStart(dc.ctx, containerId, types.ContainerStartOptions{}); err != nil {
-		return err
+func (dc *DockerController) startContainer(ctx context.Context, containerId string) error {
+	ctx, done := traceDockerCall(ctx, "container_start", attribute.String("container.id", containerId))
+	if err := dc.cli.ContainerStart(ctx, containerId, types.ContainerStartOptions{}); err != nil {
+		return done(err)
 	}
+	done(nil)
 
 	return nil
 }
 
-func (dc *DockerController) isContainerRunning(containerId string) bool {
-	containers, err := dc.cli.ContainerList(dc.ctx, types.ContainerListOptions{})
-	if err != nil {
-		panic(err)
+func (dc *DockerController) isContainerRunning(ctx context.Context, containerId string) (bool, error) {
+	ctx, done := traceDockerCall(ctx, "container_list")
+	containers, err := dc.cli.ContainerList(ctx, types.ContainerListOptions{})
+	if err = done(err); err != nil {
+		return false, err
 	}
 
 	for _, container := range containers {
 		if container.ID == containerId {
-			return true
+			return true, nil
 		}
 	}
 
-	return false
+	return false, nil
 }
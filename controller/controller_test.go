@@ -0,0 +1,482 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/XiovV/dokkup-agent/metrics"
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/network"
+	"github.com/docker/docker/client"
+	"github.com/docker/docker/errdefs"
+	specs "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeDocker is an in-memory docker daemon implementing the calls made by updates, rollbacks and creates.
+// Calling any other method panics, since the embedded interface is nil.
+type fakeDocker struct {
+	client.APIClient
+
+	mu         sync.Mutex
+	containers []*fakeContainer
+	nextID     int
+
+	// images are the tags which are available without pulling.
+	images []string
+
+	// pulls records the options of every ImagePull call, keyed by image.
+	pulls map[string]types.ImagePullOptions
+
+	// interrupts are run once, when the call they're registered for is made. The call is still
+	// carried out, like the daemon would do for a request whose client has gone away.
+	interrupts map[string]func()
+}
+
+type fakeContainer struct {
+	id         string
+	name       string
+	state      string
+	config     *container.Config
+	hostConfig *container.HostConfig
+}
+
+func newFakeDocker(containers ...*fakeContainer) *fakeDocker {
+	return &fakeDocker{containers: containers, pulls: map[string]types.ImagePullOptions{}, interrupts: map[string]func(){}}
+}
+
+func newTestController(docker *fakeDocker) *DockerController {
+	logger := logrus.New()
+	logger.SetOutput(ioutil.Discard)
+
+	return &DockerController{cli: docker, log: logger, timeouts: Timeouts{Restore: time.Minute}}
+}
+
+func (f *fakeDocker) interrupt(call string) {
+	if interrupt, ok := f.interrupts[call]; ok {
+		delete(f.interrupts, call)
+		interrupt()
+	}
+}
+
+func (f *fakeDocker) find(idOrName string) *fakeContainer {
+	for _, c := range f.containers {
+		if c.id == idOrName || c.name == strings.TrimPrefix(idOrName, "/") {
+			return c
+		}
+	}
+
+	return nil
+}
+
+// byName returns the container named name, or nil.
+func (f *fakeDocker) byName(name string) *fakeContainer {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for _, c := range f.containers {
+		if c.name == name {
+			return c
+		}
+	}
+
+	return nil
+}
+
+func (f *fakeDocker) ContainerList(ctx context.Context, options types.ContainerListOptions) ([]types.Container, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	var containers []types.Container
+	for _, c := range f.containers {
+		if options.All || c.state == "running" {
+			containers = append(containers, types.Container{ID: c.id, Names: []string{"/" + c.name}, Image: c.config.Image, State: c.state})
+		}
+	}
+
+	return containers, nil
+}
+
+func (f *fakeDocker) ImageList(ctx context.Context, options types.ImageListOptions) ([]types.ImageSummary, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var images []types.ImageSummary
+	for _, image := range f.images {
+		images = append(images, types.ImageSummary{ID: "sha256:" + image, RepoTags: []string{image}})
+	}
+
+	return images, nil
+}
+
+func (f *fakeDocker) ImagePull(ctx context.Context, ref string, options types.ImagePullOptions) (io.ReadCloser, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.pulls[ref] = options
+	f.images = append(f.images, ref)
+
+	return ioutil.NopCloser(strings.NewReader(`{"status": "Downloaded newer image for ` + ref + `"}`)), nil
+}
+
+func (f *fakeDocker) ContainerInspect(ctx context.Context, id string) (types.ContainerJSON, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := ctx.Err(); err != nil {
+		return types.ContainerJSON{}, err
+	}
+
+	c := f.find(id)
+	if c == nil {
+		return types.ContainerJSON{}, errdefs.NotFound(fmt.Errorf("no such container: %s", id))
+	}
+
+	config, hostConfig := *c.config, *c.hostConfig
+
+	return types.ContainerJSON{
+		ContainerJSONBase: &types.ContainerJSONBase{
+			ID:         c.id,
+			Name:       "/" + c.name,
+			Image:      "sha256:" + c.config.Image,
+			State:      &types.ContainerState{Status: c.state, Running: c.state == "running"},
+			HostConfig: &hostConfig,
+		},
+		Config: &config,
+	}, nil
+}
+
+func (f *fakeDocker) ContainerCreate(ctx context.Context, config *container.Config, hostConfig *container.HostConfig, _ *network.NetworkingConfig, _ *specs.Platform, name string) (container.ContainerCreateCreatedBody, error) {
+	f.interrupt("container_create")
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.find(name) != nil {
+		return container.ContainerCreateCreatedBody{}, errdefs.Conflict(fmt.Errorf("the container name %s is already in use", name))
+	}
+
+	f.nextID++
+	id := "new" + strconv.Itoa(f.nextID)
+	f.containers = append(f.containers, &fakeContainer{id: id, name: strings.TrimPrefix(name, "/"), state: "created", config: config, hostConfig: hostConfig})
+
+	// the container was created, but the client doesn't learn about it
+	if err := ctx.Err(); err != nil {
+		return container.ContainerCreateCreatedBody{}, err
+	}
+
+	return container.ContainerCreateCreatedBody{ID: id}, nil
+}
+
+func (f *fakeDocker) ContainerRename(ctx context.Context, id, newName string) error {
+	f.interrupt("container_rename")
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	c := f.find(id)
+	if c == nil {
+		return errdefs.NotFound(fmt.Errorf("no such container: %s", id))
+	}
+	if other := f.find(newName); other != nil {
+		return errdefs.Conflict(fmt.Errorf("the container name %s is already in use", newName))
+	}
+
+	c.name = strings.TrimPrefix(newName, "/")
+
+	return ctx.Err()
+}
+
+func (f *fakeDocker) ContainerRemove(ctx context.Context, id string, _ types.ContainerRemoveOptions) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	for i, c := range f.containers {
+		if c.id == id {
+			if c.state == "running" {
+				return errdefs.Conflict(fmt.Errorf("container %s is running", id))
+			}
+
+			f.containers = append(f.containers[:i], f.containers[i+1:]...)
+			return nil
+		}
+	}
+
+	return errdefs.NotFound(fmt.Errorf("no such container: %s", id))
+}
+
+func (f *fakeDocker) ContainerStop(ctx context.Context, id string, _ *time.Duration) error {
+	f.interrupt("container_stop")
+
+	return f.setState(ctx, id, "exited")
+}
+
+func (f *fakeDocker) ContainerStart(ctx context.Context, id string, _ types.ContainerStartOptions) error {
+	f.interrupt("container_start")
+
+	if err := f.setState(context.Background(), id, "running"); err != nil {
+		return err
+	}
+
+	return ctx.Err()
+}
+
+func (f *fakeDocker) setState(ctx context.Context, id, state string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	c := f.find(id)
+	if c == nil {
+		return errdefs.NotFound(fmt.Errorf("no such container: %s", id))
+	}
+	c.state = state
+
+	return nil
+}
+
+func runningContainer(id, name, image string) *fakeContainer {
+	return &fakeContainer{id: id, name: name, state: "running", config: &container.Config{Image: image}, hostConfig: &container.HostConfig{}}
+}
+
+func TestMetricsContainer(t *testing.T) {
+	tests := []struct {
+		name     string
+		err      error
+		expected string
+	}{
+		{"Success", nil, "web"},
+		{"Failure after the container was found", ErrContainerNotRunning, "web"},
+		{"Container not found", ErrContainerNotFound, metrics.UnknownContainer},
+		{"Rollback container not found", ErrRollbackContainerNotFound, metrics.UnknownContainer},
+		{"Invalid image", ErrImageFormatInvalid, metrics.UnknownContainer},
+		{"Invalid patch", ErrSpecInvalid{Setting: "env", Reason: "invalid"}, metrics.UnknownContainer},
+		{"Wrapped error", fmt.Errorf("update: %w", ErrContainerNotFound), metrics.UnknownContainer},
+		{"Other error", errors.New("some error"), "web"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.expected, metricsContainer("web", test.err))
+		})
+	}
+}
+
+func TestUpdateContainerInterrupted(t *testing.T) {
+	for _, call := range []string{"container_rename", "container_create", "container_start"} {
+		t.Run(call, func(t *testing.T) {
+			docker := newFakeDocker(runningContainer("old", "web", "web:1.0"))
+			dc := newTestController(docker)
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			docker.interrupts[call] = cancel
+
+			_, err := dc.UpdateContainer(ctx, "web", "web:1.1", false)
+
+			var aborted ErrOperationAborted
+			assert.True(t, errors.As(err, &aborted), "unexpected error %v", err)
+
+			restored := docker.byName("web")
+			if assert.NotNil(t, restored) {
+				assert.Equal(t, "old", restored.id)
+				assert.Equal(t, "running", restored.state)
+			}
+
+			assert.Nil(t, docker.byName("web"+RollbackContainerSuffix))
+			assert.Len(t, docker.containers, 1)
+		})
+	}
+}
+
+func TestUpdateContainer(t *testing.T) {
+	docker := newFakeDocker(runningContainer("old", "web", "web:1.0"))
+	dc := newTestController(docker)
+
+	change, err := dc.UpdateContainer(context.Background(), "web", "web:1.1", true)
+	assert.Nil(t, err)
+	assert.Equal(t, "web:1.0", change.OldImage)
+
+	updated := docker.byName("web")
+	if assert.NotNil(t, updated) {
+		assert.Equal(t, "web:1.1", updated.config.Image)
+		assert.Equal(t, "running", updated.state)
+	}
+
+	rollback := docker.byName("web" + RollbackContainerSuffix)
+	if assert.NotNil(t, rollback) {
+		assert.Equal(t, "old", rollback.id)
+		assert.Equal(t, "exited", rollback.state)
+	}
+}
+
+func TestCreateContainerInterrupted(t *testing.T) {
+	docker := newFakeDocker()
+	docker.images = []string{"web:1.0"}
+	dc := newTestController(docker)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	docker.interrupts["container_create"] = cancel
+
+	_, err := dc.CreateContainer(ctx, ContainerSpec{Name: "web", Image: "web:1.0"})
+
+	var aborted ErrOperationAborted
+	assert.True(t, errors.As(err, &aborted), "unexpected error %v", err)
+	assert.Equal(t, "create", aborted.Step)
+
+	// the container docker created anyway was removed, so the create can be retried
+	assert.Empty(t, docker.containers)
+
+	_, err = dc.CreateContainer(context.Background(), ContainerSpec{Name: "web", Image: "web:1.0"})
+	assert.Nil(t, err)
+	assert.Equal(t, "running", docker.byName("web").state)
+}
+
+func TestCancelledRequests(t *testing.T) {
+	t.Run("Update cancelled before it started", func(t *testing.T) {
+		docker := newFakeDocker(runningContainer("old", "web", "web:1.0"))
+		dc := newTestController(docker)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		_, err := dc.UpdateContainer(ctx, "web", "web:1.1", false)
+		assert.ErrorIs(t, err, context.Canceled)
+
+		assert.Len(t, docker.containers, 1)
+		assert.Equal(t, "running", docker.byName("web").state)
+	})
+
+	t.Run("Rollback cancelled once it has started", func(t *testing.T) {
+		rollback := runningContainer("old", "web"+RollbackContainerSuffix, "web:1.0")
+		rollback.state = "exited"
+		docker := newFakeDocker(runningContainer("new", "web", "web:1.1"), rollback)
+		dc := newTestController(docker)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		docker.interrupts["container_stop"] = cancel
+
+		change, err := dc.RollbackContainer(ctx, "web")
+		assert.Nil(t, err)
+		assert.Equal(t, "web:1.0", change.NewImage)
+
+		assert.Len(t, docker.containers, 1)
+		assert.Equal(t, "old", docker.byName("web").id)
+		assert.Equal(t, "running", docker.byName("web").state)
+	})
+}
+
+func TestRecreateContainer(t *testing.T) {
+	spec := ContainerSpec{Name: "web", Image: "web:1.0", Ports: []string{"8080:80"}, Volumes: []string{"data:/data"}}
+
+	t.Run("Recreated from the spec", func(t *testing.T) {
+		docker := newFakeDocker(runningContainer("old", "web", "web:1.0"))
+		docker.images = []string{"web:1.0"}
+		dc := newTestController(docker)
+
+		change, err := dc.RecreateContainer(context.Background(), spec)
+		assert.Nil(t, err)
+		assert.Equal(t, "web:1.0", change.OldImage)
+
+		recreated := docker.byName("web")
+		if assert.NotNil(t, recreated) {
+			assert.NotEqual(t, "old", recreated.id)
+			assert.Equal(t, "running", recreated.state)
+			assert.Equal(t, []string{"data:/data"}, recreated.hostConfig.Binds)
+			assert.Equal(t, "8080", recreated.hostConfig.PortBindings["80/tcp"][0].HostPort)
+		}
+
+		// the old container is kept, so the recreate can be rolled back
+		rollback := docker.byName("web" + RollbackContainerSuffix)
+		if assert.NotNil(t, rollback) {
+			assert.Equal(t, "old", rollback.id)
+			assert.Equal(t, "exited", rollback.state)
+		}
+
+		_, err = dc.RollbackContainer(context.Background(), "web")
+		assert.Nil(t, err)
+		assert.Equal(t, "old", docker.byName("web").id)
+		assert.Len(t, docker.containers, 1)
+	})
+
+	t.Run("Missing container", func(t *testing.T) {
+		dc := newTestController(newFakeDocker())
+
+		_, err := dc.RecreateContainer(context.Background(), spec)
+		assert.ErrorIs(t, err, ErrContainerNotFound)
+	})
+
+	for _, call := range []string{"container_rename", "container_create", "container_start"} {
+		t.Run("Interrupted at "+call, func(t *testing.T) {
+			docker := newFakeDocker(runningContainer("old", "web", "web:1.0"))
+			docker.images = []string{"web:1.0"}
+			dc := newTestController(docker)
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			docker.interrupts[call] = cancel
+
+			_, err := dc.RecreateContainer(ctx, spec)
+
+			var aborted ErrOperationAborted
+			assert.True(t, errors.As(err, &aborted), "unexpected error %v", err)
+
+			restored := docker.byName("web")
+			if assert.NotNil(t, restored) {
+				assert.Equal(t, "old", restored.id)
+				assert.Equal(t, "running", restored.state)
+			}
+
+			assert.Len(t, docker.containers, 1)
+		})
+	}
+}
+
+func TestRollbackContainerNotFound(t *testing.T) {
+	rollback := runningContainer("old", "web"+RollbackContainerSuffix, "web:1.0")
+	rollback.state = "exited"
+
+	tests := []struct {
+		name       string
+		containers []*fakeContainer
+		expected   error
+	}{
+		// the errors are the ones rollbacks have always returned
+		{"Without a rollback container", []*fakeContainer{runningContainer("new", "web", "web:1.1")}, ErrContainerNotFound},
+		{"Without the container itself", []*fakeContainer{rollback}, ErrRollbackContainerNotFound},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dc := newTestController(newFakeDocker(test.containers...))
+
+			_, err := dc.RollbackContainer(context.Background(), "web")
+			assert.Equal(t, test.expected, err)
+
+			_, err = dc.PlanRollback(context.Background(), "web")
+			assert.Equal(t, test.expected, err)
+		})
+	}
+}
@@ -0,0 +1,203 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/XiovV/dokkup-agent/metrics"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/network"
+	"github.com/docker/docker/errdefs"
+	"github.com/docker/go-connections/nat"
+	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/attribute"
+	"time"
+)
+
+// CreateContainer pulls the image of spec if it isn't available yet, and creates and starts a new container
+// from spec. It returns ErrSpecInvalid if the spec is invalid, and ErrContainerExists if a container with the
+// same name already exists. If any step after the container was created fails, the container is removed again,
+// so a failed create can simply be retried. The returned ContainerChange only describes the new image.
+// The pull counts towards the update timeout.
+func (dc *DockerController) CreateContainer(ctx context.Context, spec ContainerSpec) (ContainerChange, error) {
+	ctx, cancel := withTimeout(ctx, dc.timeouts.Update)
+	defer cancel()
+
+	ctx, span := startOperation(ctx, "create", attribute.String("container", spec.Name), attribute.String("image", spec.Image))
+	start := time.Now()
+
+	change, err := dc.createContainerFromSpec(ctx, spec)
+
+	// a create which failed for any other reason removed the container again
+	containerName := spec.Name
+	if err != nil && !errors.Is(err, ErrContainerExists) {
+		containerName = metrics.UnknownContainer
+	}
+	metrics.ObserveOperation("create", containerName, start, err)
+	endSpan(span, err)
+
+	return change, err
+}
+
+func (dc *DockerController) createContainerFromSpec(ctx context.Context, spec ContainerSpec) (ContainerChange, error) {
+	log := dc.operationLogger(ctx, "create", spec.Name).WithField("image", spec.Image)
+	change := ContainerChange{ContainerName: spec.Name, NewImage: spec.Image}
+
+	if err := spec.Validate(); err != nil {
+		return change, err
+	}
+
+	_, exists, err := dc.findContainerID(ctx, spec.Name)
+	if err != nil {
+		return change, fmt.Errorf("couldn't list containers: %w", err)
+	}
+	if exists {
+		return change, ErrContainerExists
+	}
+
+	log.WithField("step", "pull").Info("pulling image")
+	if err := dc.pullImage(ctx, spec.Image, nil); err != nil {
+		return change, err
+	}
+
+	if err := ctx.Err(); err != nil {
+		return change, ErrOperationAborted{Step: "pull", Reason: err}
+	}
+
+	config, hostConfig, networkingConfig := spec.containerConfig()
+
+	log.WithField("step", "create").Info("creating container")
+	createCtx, done := traceDockerCall(ctx, "container_create", attribute.String("image", spec.Image))
+	resp, err := dc.cli.ContainerCreate(createCtx, config, hostConfig, networkingConfig, nil, spec.Name)
+	if err = done(err); err != nil {
+		switch {
+		case ctx.Err() != nil:
+			return change, dc.abortInterruptedCreate(ctx, log, spec.Name)
+		case errdefs.IsConflict(err):
+			return change, ErrContainerExists
+		}
+
+		return change, fmt.Errorf("couldn't create container: %w", err)
+	}
+
+	containerId := resp.ID
+	log.WithField("step", "create").Infof("created container (%s)", containerId)
+	_, change.NewImageID = dc.containerImage(ctx, containerId)
+
+	// the first network is set at creation, the others can only be connected to a created container
+	for i := 1; i < len(spec.Networks); i++ {
+		log.WithField("step", "connect").Infof("connecting container to network %s", spec.Networks[i])
+		if err := dc.connectNetwork(ctx, spec.Networks[i], containerId); err != nil {
+			err = fmt.Errorf("couldn't connect container to network %s: %w", spec.Networks[i], err)
+			return change, dc.abortCreate(ctx, log, "connect", err, containerId)
+		}
+	}
+
+	log.WithField("step", "start").Infof("starting container (%s)", containerId)
+	if err := dc.startContainer(ctx, containerId); err != nil {
+		err = ErrContainerStartFailed{ContainerId: containerId, Reason: err}
+		return change, dc.abortCreate(ctx, log, "start", err, containerId)
+	}
+
+	running, err := dc.isContainerRunning(ctx, containerId)
+	if err != nil {
+		err = fmt.Errorf("couldn't check if container %s is running: %w", containerId, err)
+		return change, dc.abortCreate(ctx, log, "verify", err, containerId)
+	}
+
+	if !running {
+		log.WithField("step", "verify").Error("container is not running, removing it")
+		return change, dc.abortCreate(ctx, log, "verify", ErrContainerNotRunning, containerId)
+	}
+
+	log.WithField("step", "done").Info("container created successfully")
+
+	return change, nil
+}
+
+// abortCreate removes a container whose create failed or was interrupted after the container had been created.
+// Like abortUpdate, it runs on a detached context, and returns ErrOperationAborted if ctx was cancelled and cause
+// otherwise. If the container couldn't be removed, it's left behind stopped, and the error says so.
+func (dc *DockerController) abortCreate(ctx context.Context, log logrus.FieldLogger, step string, cause error, containerId string) error {
+	if ctx.Err() != nil {
+		log.WithField("step", step).WithError(ctx.Err()).Warn("create was aborted, removing the new container")
+		cause = ErrOperationAborted{Step: step, Reason: ctx.Err()}
+	}
+
+	cleanupCtx, cancel := dc.detach(ctx)
+	defer cancel()
+
+	log.WithField("step", "cleanup").Warnf("removing container %s", containerId)
+	if err := dc.stopContainer(cleanupCtx, containerId); err != nil {
+		log.WithField("step", "cleanup").WithError(err).Error("couldn't stop the new container")
+		return fmt.Errorf("%w (the new container %s couldn't be stopped: %s)", cause, containerId, err)
+	}
+
+	if err := dc.removeContainer(cleanupCtx, containerId); err != nil {
+		log.WithField("step", "cleanup").WithError(err).Error("couldn't remove the new container")
+		return fmt.Errorf("%w (the new container %s couldn't be removed: %s)", cause, containerId, err)
+	}
+
+	return cause
+}
+
+// abortInterruptedCreate handles ctx being cancelled while the container was being created. Docker may have
+// created it anyway, so it's looked up by name on a detached context and removed with abortCreate.
+func (dc *DockerController) abortInterruptedCreate(ctx context.Context, log logrus.FieldLogger, containerName string) error {
+	lookupCtx, cancel := dc.detach(ctx)
+	defer cancel()
+
+	containerId, exists, err := dc.findContainerID(lookupCtx, containerName)
+	if err != nil {
+		log.WithField("step", "cleanup").WithError(err).Error("couldn't check whether the container was created")
+		return fmt.Errorf("%w (couldn't check whether container %s was created: %s)", ErrOperationAborted{Step: "create", Reason: ctx.Err()}, containerName, err)
+	}
+
+	if !exists {
+		return ErrOperationAborted{Step: "create", Reason: ctx.Err()}
+	}
+
+	return dc.abortCreate(ctx, log, "create", ErrOperationAborted{Step: "create", Reason: ctx.Err()}, containerId)
+}
+
+func (dc *DockerController) connectNetwork(ctx context.Context, networkName, containerId string) error {
+	ctx, done := traceDockerCall(ctx, "network_connect", attribute.String("network", networkName), attribute.String("container.id", containerId))
+	return done(dc.cli.NetworkConnect(ctx, networkName, containerId, nil))
+}
+
+// containerConfig converts a valid spec to the configuration of a new container. Only the
+// first network is part of the configuration; the others must be connected after creating the container.
+func (s ContainerSpec) containerConfig() (*container.Config, *container.HostConfig, *network.NetworkingConfig) {
+	exposedPorts, portBindings, _ := nat.ParsePortSpecs(s.Ports)
+	restartPolicy, _ := parseRestartPolicy(s.RestartPolicy)
+
+	config := &container.Config{
+		Image:        s.Image,
+		Labels:       s.Labels,
+		ExposedPorts: exposedPorts,
+	}
+
+	for _, name := range sortedKeys(s.Env) {
+		config.Env = append(config.Env, name+"="+s.Env[name])
+	}
+
+	hostConfig := &container.HostConfig{
+		Binds:         s.Volumes,
+		PortBindings:  portBindings,
+		RestartPolicy: restartPolicy,
+		Resources: container.Resources{
+			Memory:   s.Resources.MemoryBytes,
+			NanoCPUs: nanoCPUs(s.Resources.CPUs),
+		},
+	}
+
+	var networkingConfig *network.NetworkingConfig
+	if len(s.Networks) > 0 {
+		hostConfig.NetworkMode = container.NetworkMode(s.Networks[0])
+		networkingConfig = &network.NetworkingConfig{
+			EndpointsConfig: map[string]*network.EndpointSettings{s.Networks[0]: {}},
+		}
+	}
+
+	return config, hostConfig, networkingConfig
+}
@@ -9,8 +9,10 @@ var (
 	ErrContainerNotRunning       = errors.New("container is not running")
 	ErrContainerRestoreFailed    = errors.New("couldn't restore container")
 	ErrContainerNotFound         = errors.New("container does not exist")
+	ErrContainerExists           = errors.New("container already exists")
 	ErrRollbackContainerNotFound = errors.New("rollback container does not exist ")
 	ErrImageFormatInvalid        = errors.New("image format is invalid")
+	ErrDockerUnavailable         = errors.New("docker daemon is unreachable")
 )
 
 type ErrContainerStartFailed struct {
@@ -21,3 +23,32 @@ type ErrContainerStartFailed struct {
 func (e ErrContainerStartFailed) Error() string {
 	return fmt.Sprintf("container %s could not be started: %s", e.ContainerId, e.Reason)
 }
+
+func (e ErrContainerStartFailed) Unwrap() error {
+	return e.Reason
+}
+
+// ErrOperationAborted is returned when an operation's context was cancelled or timed out.
+// Reason is either context.Canceled or context.DeadlineExceeded.
+type ErrOperationAborted struct {
+	Step   string
+	Reason error
+}
+
+func (e ErrOperationAborted) Error() string {
+	return fmt.Sprintf("operation was aborted during the %s step: %s", e.Step, e.Reason)
+}
+
+func (e ErrOperationAborted) Unwrap() error {
+	return e.Reason
+}
+
+// ErrSpecInvalid is returned when a setting of a container spec is invalid.
+type ErrSpecInvalid struct {
+	Setting string
+	Reason  string
+}
+
+func (e ErrSpecInvalid) Error() string {
+	return fmt.Sprintf("%s: %s", e.Setting, e.Reason)
+}
@@ -0,0 +1,77 @@
+package controller
+
+import (
+	"context"
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/events"
+	"github.com/docker/docker/api/types/filters"
+	"time"
+)
+
+// Event is a container or image event reported by the docker daemon.
+type Event struct {
+	Time time.Time
+
+	// Type is either events.ContainerEventType or events.ImageEventType.
+	Type   string
+	Action string
+	ID     string
+
+	// Name and Image are only set for container events.
+	Name  string
+	Image string
+}
+
+// Events streams container and image events until ctx is cancelled. The error channel
+// receives a single error if the stream breaks, after which no more events are sent.
+func (dc *DockerController) Events(ctx context.Context) (<-chan Event, <-chan error) {
+	eventsCtx, done := traceDockerCall(ctx, "events")
+	messages, errs := dc.cli.Events(eventsCtx, types.EventsOptions{
+		Filters: filters.NewArgs(
+			filters.Arg("type", events.ContainerEventType),
+			filters.Arg("type", events.ImageEventType),
+		),
+	})
+
+	out := make(chan Event)
+	outErrs := make(chan error, 1)
+
+	go func() {
+		defer close(out)
+
+		for {
+			select {
+			case message := <-messages:
+				event := Event{
+					Time:   time.Unix(0, message.TimeNano),
+					Type:   message.Type,
+					Action: message.Action,
+					ID:     message.Actor.ID,
+				}
+
+				if message.Type == events.ContainerEventType {
+					event.Name = message.Actor.Attributes["name"]
+					event.Image = message.Actor.Attributes["image"]
+				}
+
+				select {
+				case out <- event:
+				case <-ctx.Done():
+					_ = done(nil)
+					return
+				}
+			case err := <-errs:
+				if ctx.Err() != nil {
+					err = nil
+				}
+
+				if err = done(err); err != nil {
+					outErrs <- err
+				}
+				return
+			}
+		}
+	}()
+
+	return out, outErrs
+}
@@ -0,0 +1,100 @@
+package controller
+
+import (
+	"context"
+	"github.com/XiovV/dokkup-agent/metrics"
+	"sync/atomic"
+	"time"
+)
+
+// Ping checks whether the docker daemon is reachable. It returns an error wrapping
+// ErrDockerUnavailable if it isn't.
+func (dc *DockerController) Ping(ctx context.Context) error {
+	ctx, cancel := withTimeout(ctx, dc.timeouts.Read)
+	defer cancel()
+
+	ctx, done := traceDockerCall(ctx, "ping")
+	_, err := dc.cli.Ping(ctx)
+
+	err = done(err)
+	dc.setAvailable(err == nil)
+
+	return err
+}
+
+// Available reports whether the docker daemon was reachable on the last check.
+func (dc *DockerController) Available() bool {
+	return atomic.LoadInt32(&dc.available) == 1
+}
+
+func (dc *DockerController) setAvailable(available bool) {
+	var value int32
+	if available {
+		value = 1
+	}
+
+	if atomic.SwapInt32(&dc.available, value) == value {
+		return
+	}
+
+	if available {
+		metrics.DockerUp.Set(1)
+		dc.log.Info("docker daemon is reachable")
+	} else {
+		metrics.DockerUp.Set(0)
+		dc.log.Warn("docker daemon is unreachable, running in degraded mode")
+	}
+}
+
+// EngineInfo describes the docker engine and the host it's running on.
+type EngineInfo struct {
+	Version         string
+	APIVersion      string
+	OperatingSystem string
+	OSType          string
+	Architecture    string
+	KernelVersion   string
+	CPUs            int
+	MemoryBytes     int64
+}
+
+// EngineInfo returns the docker engine's version and host details. APIVersion is the
+// API version negotiated between the agent and the engine.
+func (dc *DockerController) EngineInfo(ctx context.Context) (EngineInfo, error) {
+	ctx, cancel := withTimeout(ctx, dc.timeouts.Read)
+	defer cancel()
+
+	ctx, done := traceDockerCall(ctx, "info")
+	info, err := dc.cli.Info(ctx)
+	if err = done(err); err != nil {
+		return EngineInfo{}, err
+	}
+
+	return EngineInfo{
+		Version:         info.ServerVersion,
+		APIVersion:      dc.cli.ClientVersion(),
+		OperatingSystem: info.OperatingSystem,
+		OSType:          info.OSType,
+		Architecture:    info.Architecture,
+		KernelVersion:   info.KernelVersion,
+		CPUs:            info.NCPU,
+		MemoryBytes:     info.MemTotal,
+	}, nil
+}
+
+// WatchDocker pings the docker daemon every interval until ctx is cancelled,
+// so Available keeps reflecting whether the daemon can be reached.
+func (dc *DockerController) WatchDocker(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		_ = dc.Ping(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,65 @@
+package controller
+
+import (
+	"context"
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/pkg/stdcopy"
+	"go.opentelemetry.io/otel/attribute"
+	"io"
+)
+
+// LogsOptions selects which log lines ContainerLogs returns.
+type LogsOptions struct {
+	// Follow keeps the stream open and sends new lines as they are written.
+	Follow bool
+
+	// Tail is the number of lines to return from the end of the logs, or "all".
+	Tail string
+
+	Timestamps bool
+}
+
+// ContainerLogs returns the stdout and stderr of a container as a single stream of text.
+// The stream ends when ctx is cancelled, or when the logs end if opts.Follow isn't set.
+// It returns ErrContainerNotFound if there's no container with the given name.
+func (dc *DockerController) ContainerLogs(ctx context.Context, containerName string, opts LogsOptions) (io.ReadCloser, error) {
+	containerId, ok, err := dc.findContainerID(ctx, containerName)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, ErrContainerNotFound
+	}
+
+	inspectCtx, done := traceDockerCall(ctx, "container_inspect", attribute.String("container.id", containerId))
+	containerJson, err := dc.cli.ContainerInspect(inspectCtx, containerId)
+	if err = done(err); err != nil {
+		return nil, err
+	}
+
+	logsCtx, done := traceDockerCall(ctx, "container_logs", attribute.String("container.id", containerId))
+	logs, err := dc.cli.ContainerLogs(logsCtx, containerId, types.ContainerLogsOptions{
+		ShowStdout: true,
+		ShowStderr: true,
+		Follow:     opts.Follow,
+		Tail:       opts.Tail,
+		Timestamps: opts.Timestamps,
+	})
+	if err = done(err); err != nil {
+		return nil, err
+	}
+
+	// containers with a TTY send raw text, the others multiplex stdout and stderr
+	if containerJson.Config.Tty {
+		return logs, nil
+	}
+
+	reader, writer := io.Pipe()
+	go func() {
+		_, err := stdcopy.StdCopy(writer, writer, logs)
+		_ = logs.Close()
+		_ = writer.CloseWithError(err)
+	}()
+
+	return reader, nil
+}
@@ -0,0 +1,228 @@
+package controller
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/strslice"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// redacted replaces the values of environment variables in SettingChange, since they often hold secrets.
+const redacted = "<redacted>"
+
+// ConfigPatch changes settings of a container during an update, on top of the configuration copied from the
+// old container. Settings which are left empty (or nil) keep the value of the old container.
+type ConfigPatch struct {
+	Env      map[string]string
+	UnsetEnv []string
+
+	Labels      map[string]string
+	UnsetLabels []string
+
+	// Cmd and Entrypoint replace the ones of the old container. An empty, non-nil slice resets them to the image's.
+	Cmd        []string
+	Entrypoint []string
+
+	// RestartPolicy is a restart policy in the format of docker run --restart.
+	RestartPolicy string
+
+	// MemoryBytes and CPUs replace the limits of the old container. 0 removes a limit.
+	MemoryBytes *int64
+	CPUs        *float64
+}
+
+// SettingChange is a setting which an update changed. The values of environment variables are redacted.
+type SettingChange struct {
+	Setting string
+	Old     string
+	New     string
+}
+
+// Validate checks the settings of the patch which docker would otherwise reject halfway through an update.
+func (p ConfigPatch) Validate() error {
+	for name := range p.Env {
+		if name == "" || strings.Contains(name, "=") {
+			return ErrSpecInvalid{Setting: "env", Reason: fmt.Sprintf("invalid variable name %q", name)}
+		}
+	}
+
+	for _, name := range p.UnsetEnv {
+		if _, ok := p.Env[name]; ok {
+			return ErrSpecInvalid{Setting: "unset_env", Reason: fmt.Sprintf("%s is also set in env", name)}
+		}
+	}
+
+	for _, name := range p.UnsetLabels {
+		if _, ok := p.Labels[name]; ok {
+			return ErrSpecInvalid{Setting: "unset_labels", Reason: fmt.Sprintf("%s is also set in labels", name)}
+		}
+	}
+
+	if p.RestartPolicy != "" {
+		if _, err := parseRestartPolicy(p.RestartPolicy); err != nil {
+			return ErrSpecInvalid{Setting: "restart_policy", Reason: err.Error()}
+		}
+	}
+
+	if p.MemoryBytes != nil && *p.MemoryBytes < 0 {
+		return ErrSpecInvalid{Setting: "resources.memory_bytes", Reason: "must not be negative"}
+	}
+
+	if p.CPUs != nil && (*p.CPUs < 0 || math.IsNaN(*p.CPUs) || math.IsInf(*p.CPUs, 0)) {
+		return ErrSpecInvalid{Setting: "resources.cpus", Reason: "must be a positive number"}
+	}
+
+	return nil
+}
+
+// IsEmpty reports whether the patch leaves every setting as it is.
+func (p ConfigPatch) IsEmpty() bool {
+	return len(p.Env) == 0 && len(p.UnsetEnv) == 0 && len(p.Labels) == 0 && len(p.UnsetLabels) == 0 &&
+		p.Cmd == nil && p.Entrypoint == nil && p.RestartPolicy == "" && p.MemoryBytes == nil && p.CPUs == nil
+}
+
+// apply changes config and hostConfig according to the patch, which must be valid. It returns the settings
+// which actually changed, sorted by setting; setting a value the container already has isn't a change.
+func (p ConfigPatch) apply(config *container.Config, hostConfig *container.HostConfig) []SettingChange {
+	var changes []SettingChange
+
+	config.Env, changes = patchEnv(config.Env, p.Env, p.UnsetEnv, changes)
+
+	if len(p.Labels) > 0 || len(p.UnsetLabels) > 0 {
+		if config.Labels == nil {
+			config.Labels = map[string]string{}
+		}
+
+		for _, name := range sortedKeys(p.Labels) {
+			if old, ok := config.Labels[name]; !ok || old != p.Labels[name] {
+				changes = append(changes, SettingChange{Setting: "labels." + name, Old: old, New: p.Labels[name]})
+				config.Labels[name] = p.Labels[name]
+			}
+		}
+
+		for _, name := range p.UnsetLabels {
+			if old, ok := config.Labels[name]; ok {
+				changes = append(changes, SettingChange{Setting: "labels." + name, Old: old})
+				delete(config.Labels, name)
+			}
+		}
+	}
+
+	if p.Cmd != nil && formatCommand(config.Cmd) != formatCommand(p.Cmd) {
+		changes = append(changes, SettingChange{Setting: "cmd", Old: formatCommand(config.Cmd), New: formatCommand(p.Cmd)})
+		config.Cmd = strslice.StrSlice(p.Cmd)
+	}
+
+	if p.Entrypoint != nil && formatCommand(config.Entrypoint) != formatCommand(p.Entrypoint) {
+		changes = append(changes, SettingChange{Setting: "entrypoint", Old: formatCommand(config.Entrypoint), New: formatCommand(p.Entrypoint)})
+		config.Entrypoint = strslice.StrSlice(p.Entrypoint)
+	}
+
+	if p.RestartPolicy != "" {
+		policy, _ := parseRestartPolicy(p.RestartPolicy)
+		if old := formatRestartPolicy(hostConfig.RestartPolicy); old != formatRestartPolicy(policy) {
+			changes = append(changes, SettingChange{Setting: "restart_policy", Old: old, New: formatRestartPolicy(policy)})
+			hostConfig.RestartPolicy = policy
+		}
+	}
+
+	if p.MemoryBytes != nil && *p.MemoryBytes != hostConfig.Memory {
+		changes = append(changes, SettingChange{
+			Setting: "resources.memory_bytes",
+			Old:     strconv.FormatInt(hostConfig.Memory, 10),
+			New:     strconv.FormatInt(*p.MemoryBytes, 10),
+		})
+		hostConfig.Memory = *p.MemoryBytes
+
+		// the swap limit was derived from the old memory limit, and docker rejects it if it's below the new one,
+		// so it's reset to docker's default, unless swap was unlimited (-1), which works with any memory limit
+		if hostConfig.MemorySwap != -1 && hostConfig.MemorySwap != 0 {
+			changes = append(changes, SettingChange{
+				Setting: "resources.memory_swap",
+				Old:     strconv.FormatInt(hostConfig.MemorySwap, 10),
+				New:     "0",
+			})
+			hostConfig.MemorySwap = 0
+		}
+	}
+
+	if p.CPUs != nil && nanoCPUs(*p.CPUs) != hostConfig.NanoCPUs {
+		changes = append(changes, SettingChange{
+			Setting: "resources.cpus",
+			Old:     formatCPUs(hostConfig),
+			New:     strconv.FormatFloat(*p.CPUs, 'f', -1, 64),
+		})
+		hostConfig.NanoCPUs = nanoCPUs(*p.CPUs)
+
+		// docker rejects containers which set both NanoCPUs and a CFS quota
+		hostConfig.CPUQuota, hostConfig.CPUPeriod = 0, 0
+	}
+
+	sort.SliceStable(changes, func(i, j int) bool { return changes[i].Setting < changes[j].Setting })
+
+	return changes
+}
+
+// patchEnv sets and unsets variables in env, keeping the order of the variables which remain.
+func patchEnv(env []string, set map[string]string, unset []string, changes []SettingChange) ([]string, []SettingChange) {
+	if len(set) == 0 && len(unset) == 0 {
+		return env, changes
+	}
+
+	unsetNames := map[string]bool{}
+	for _, name := range unset {
+		unsetNames[name] = true
+	}
+
+	patched := make([]string, 0, len(env)+len(set))
+	existing := map[string]bool{}
+
+	for _, variable := range env {
+		name := strings.SplitN(variable, "=", 2)[0]
+		existing[name] = true
+
+		switch value, ok := set[name]; {
+		case unsetNames[name]:
+			changes = append(changes, SettingChange{Setting: "env." + name, Old: redacted})
+		case ok && variable != name+"="+value:
+			changes = append(changes, SettingChange{Setting: "env." + name, Old: redacted, New: redacted})
+			patched = append(patched, name+"="+value)
+		default:
+			patched = append(patched, variable)
+		}
+	}
+
+	for _, name := range sortedKeys(set) {
+		if !existing[name] {
+			changes = append(changes, SettingChange{Setting: "env." + name, New: redacted})
+			patched = append(patched, name+"="+set[name])
+		}
+	}
+
+	return patched, changes
+}
+
+// formatCommand formats a command like the exec form of a Dockerfile, e.g. ["nginx","-g","daemon off;"].
+func formatCommand(command []string) string {
+	if len(command) == 0 {
+		return ""
+	}
+
+	formatted, _ := json.Marshal(command)
+
+	return string(formatted)
+}
+
+// formatCPUs returns the CPU limit of hostConfig, which may have been set as a CFS quota instead of NanoCPUs.
+func formatCPUs(hostConfig *container.HostConfig) string {
+	cpus := float64(hostConfig.NanoCPUs) / 1e9
+	if hostConfig.NanoCPUs == 0 && hostConfig.CPUQuota > 0 && hostConfig.CPUPeriod > 0 {
+		cpus = float64(hostConfig.CPUQuota) / float64(hostConfig.CPUPeriod)
+	}
+
+	return strconv.FormatFloat(cpus, 'f', -1, 64)
+}
@@ -0,0 +1,201 @@
+package controller
+
+import (
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/strslice"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestConfigPatchApply(t *testing.T) {
+	memory := int64(536870912)
+	cpus := 1.5
+
+	tests := []struct {
+		name               string
+		patch              ConfigPatch
+		config             container.Config
+		hostConfig         container.HostConfig
+		expectedConfig     container.Config
+		expectedHostConfig container.HostConfig
+		expectedChanges    []SettingChange
+	}{
+		{
+			name:               "Empty patch",
+			config:             container.Config{Env: []string{"B=2", "A=1"}, Labels: map[string]string{"team": "web"}},
+			expectedConfig:     container.Config{Env: []string{"B=2", "A=1"}, Labels: map[string]string{"team": "web"}},
+			expectedHostConfig: container.HostConfig{},
+		},
+		{
+			name:           "Env keeps the order of the remaining variables",
+			patch:          ConfigPatch{Env: map[string]string{"MODE": "production", "C": "3", "A": "1"}, UnsetEnv: []string{"B"}},
+			config:         container.Config{Env: []string{"PATH=/usr/bin", "MODE=debug", "B=2", "A=1"}},
+			expectedConfig: container.Config{Env: []string{"PATH=/usr/bin", "MODE=production", "A=1", "C=3"}},
+			expectedChanges: []SettingChange{
+				{Setting: "env.B", Old: redacted},
+				{Setting: "env.C", New: redacted},
+				{Setting: "env.MODE", Old: redacted, New: redacted},
+			},
+		},
+		{
+			name:            "Unsetting a variable which isn't set",
+			patch:           ConfigPatch{UnsetEnv: []string{"MISSING"}},
+			config:          container.Config{Env: []string{"A=1"}},
+			expectedConfig:  container.Config{Env: []string{"A=1"}},
+			expectedChanges: nil,
+		},
+		{
+			name:           "Labels are set and removed",
+			patch:          ConfigPatch{Labels: map[string]string{"team": "api", "tier": "backend"}, UnsetLabels: []string{"owner", "missing"}},
+			config:         container.Config{Labels: map[string]string{"team": "web", "owner": "alice", "env": "prod"}},
+			expectedConfig: container.Config{Labels: map[string]string{"team": "api", "tier": "backend", "env": "prod"}},
+			expectedChanges: []SettingChange{
+				{Setting: "labels.owner", Old: "alice"},
+				{Setting: "labels.team", Old: "web", New: "api"},
+				{Setting: "labels.tier", New: "backend"},
+			},
+		},
+		{
+			name:            "Labels are set on a container without labels",
+			patch:           ConfigPatch{Labels: map[string]string{"team": "web"}},
+			expectedConfig:  container.Config{Labels: map[string]string{"team": "web"}},
+			expectedChanges: []SettingChange{{Setting: "labels.team", New: "web"}},
+		},
+		{
+			name:           "Cmd and entrypoint are replaced",
+			patch:          ConfigPatch{Cmd: []string{"nginx", "-g", "daemon off;"}, Entrypoint: []string{"/entrypoint.sh"}},
+			config:         container.Config{Cmd: strslice.StrSlice{"nginx"}},
+			expectedConfig: container.Config{Cmd: strslice.StrSlice{"nginx", "-g", "daemon off;"}, Entrypoint: strslice.StrSlice{"/entrypoint.sh"}},
+			expectedChanges: []SettingChange{
+				{Setting: "cmd", Old: `["nginx"]`, New: `["nginx","-g","daemon off;"]`},
+				{Setting: "entrypoint", New: `["/entrypoint.sh"]`},
+			},
+		},
+		{
+			name:           "Empty cmd and entrypoint reset them to the image's",
+			patch:          ConfigPatch{Cmd: []string{}, Entrypoint: []string{}},
+			config:         container.Config{Cmd: strslice.StrSlice{"sleep", "60"}, Entrypoint: strslice.StrSlice{"/bin/sh", "-c"}},
+			expectedConfig: container.Config{Cmd: strslice.StrSlice{}, Entrypoint: strslice.StrSlice{}},
+			expectedChanges: []SettingChange{
+				{Setting: "cmd", Old: `["sleep","60"]`},
+				{Setting: "entrypoint", Old: `["/bin/sh","-c"]`},
+			},
+		},
+		{
+			name:            "Same cmd isn't a change",
+			patch:           ConfigPatch{Cmd: []string{"nginx"}},
+			config:          container.Config{Cmd: strslice.StrSlice{"nginx"}},
+			expectedConfig:  container.Config{Cmd: strslice.StrSlice{"nginx"}},
+			expectedChanges: nil,
+		},
+		{
+			name:               "Restart policy",
+			patch:              ConfigPatch{RestartPolicy: "on-failure:3"},
+			hostConfig:         container.HostConfig{RestartPolicy: container.RestartPolicy{Name: "always"}},
+			expectedHostConfig: container.HostConfig{RestartPolicy: container.RestartPolicy{Name: "on-failure", MaximumRetryCount: 3}},
+			expectedChanges:    []SettingChange{{Setting: "restart_policy", Old: "always", New: "on-failure:3"}},
+		},
+		{
+			name:               "Memory limit resets the swap limit",
+			patch:              ConfigPatch{MemoryBytes: &memory},
+			hostConfig:         container.HostConfig{Resources: container.Resources{Memory: 268435456, MemorySwap: 536870912}},
+			expectedHostConfig: container.HostConfig{Resources: container.Resources{Memory: 536870912}},
+			expectedChanges: []SettingChange{
+				{Setting: "resources.memory_bytes", Old: "268435456", New: "536870912"},
+				{Setting: "resources.memory_swap", Old: "536870912", New: "0"},
+			},
+		},
+		{
+			name:               "Memory limit keeps unlimited swap",
+			patch:              ConfigPatch{MemoryBytes: &memory},
+			hostConfig:         container.HostConfig{Resources: container.Resources{Memory: 268435456, MemorySwap: -1}},
+			expectedHostConfig: container.HostConfig{Resources: container.Resources{Memory: 536870912, MemorySwap: -1}},
+			expectedChanges:    []SettingChange{{Setting: "resources.memory_bytes", Old: "268435456", New: "536870912"}},
+		},
+		{
+			name:               "Memory limit without a swap limit",
+			patch:              ConfigPatch{MemoryBytes: &memory},
+			hostConfig:         container.HostConfig{Resources: container.Resources{Memory: 268435456}},
+			expectedHostConfig: container.HostConfig{Resources: container.Resources{Memory: 536870912}},
+			expectedChanges:    []SettingChange{{Setting: "resources.memory_bytes", Old: "268435456", New: "536870912"}},
+		},
+		{
+			name:               "Same memory limit keeps the swap limit",
+			patch:              ConfigPatch{MemoryBytes: &memory},
+			hostConfig:         container.HostConfig{Resources: container.Resources{Memory: 536870912, MemorySwap: 1073741824}},
+			expectedHostConfig: container.HostConfig{Resources: container.Resources{Memory: 536870912, MemorySwap: 1073741824}},
+		},
+		{
+			name:               "CPU limit clears the CFS quota",
+			patch:              ConfigPatch{CPUs: &cpus},
+			hostConfig:         container.HostConfig{Resources: container.Resources{CPUQuota: 50000, CPUPeriod: 100000}},
+			expectedHostConfig: container.HostConfig{Resources: container.Resources{NanoCPUs: 1500000000}},
+			expectedChanges:    []SettingChange{{Setting: "resources.cpus", Old: "0.5", New: "1.5"}},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			config, hostConfig := test.config, test.hostConfig
+
+			changes := test.patch.apply(&config, &hostConfig)
+
+			assert.Equal(t, test.expectedConfig, config)
+			assert.Equal(t, test.expectedHostConfig, hostConfig)
+			assert.Equal(t, test.expectedChanges, changes)
+		})
+	}
+}
+
+func TestPatchEnv(t *testing.T) {
+	tests := []struct {
+		name            string
+		env             []string
+		set             map[string]string
+		unset           []string
+		expectedEnv     []string
+		expectedChanges []SettingChange
+	}{
+		{
+			name:        "Nothing to change keeps the slice",
+			env:         []string{"A=1"},
+			expectedEnv: []string{"A=1"},
+		},
+		{
+			name:            "Values containing = are kept whole",
+			env:             []string{"DSN=user=app password=old", "A=1"},
+			set:             map[string]string{"DSN": "user=app password=new"},
+			expectedEnv:     []string{"DSN=user=app password=new", "A=1"},
+			expectedChanges: []SettingChange{{Setting: "env.DSN", Old: redacted, New: redacted}},
+		},
+		{
+			name:        "Setting the same value isn't a change",
+			env:         []string{"A=1", "B=2"},
+			set:         map[string]string{"B": "2"},
+			expectedEnv: []string{"A=1", "B=2"},
+		},
+		{
+			name:            "New variables are appended sorted",
+			env:             []string{"Z=26"},
+			set:             map[string]string{"B": "2", "A": "1"},
+			expectedEnv:     []string{"Z=26", "A=1", "B=2"},
+			expectedChanges: []SettingChange{{Setting: "env.A", New: redacted}, {Setting: "env.B", New: redacted}},
+		},
+		{
+			name:            "Unset",
+			env:             []string{"A=1", "B=2", "C=3"},
+			unset:           []string{"B"},
+			expectedEnv:     []string{"A=1", "C=3"},
+			expectedChanges: []SettingChange{{Setting: "env.B", Old: redacted}},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			env, changes := patchEnv(test.env, test.set, test.unset, nil)
+
+			assert.Equal(t, test.expectedEnv, env)
+			assert.Equal(t, test.expectedChanges, changes)
+		})
+	}
+}
@@ -0,0 +1,569 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/mount"
+	"github.com/docker/docker/client"
+	"github.com/docker/go-connections/nat"
+	"go.opentelemetry.io/otel/attribute"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Kinds of problems a plan can detect, see PlanProblem.
+const (
+	ProblemPortConflict    = "port_conflict"
+	ProblemVolumeMissing   = "volume_missing"
+	ProblemNetworkMissing  = "network_missing"
+	ProblemImageNotPulled  = "image_not_pulled"
+	ProblemImageNotFound   = "image_not_found"
+	ProblemContainerExists = "container_exists"
+)
+
+// Plan is what a create, update, rollback or pull would do, worked out without changing anything.
+type Plan struct {
+	// Operation is create, update, rollback or pull.
+	Operation     string
+	ContainerName string
+	Image         PlannedImage
+
+	// Config is the configuration the new container would get. It's empty for pulls.
+	Config PlannedConfig
+
+	// Change describes the image and the settings an update would change.
+	Change ContainerChange
+
+	Steps    []PlanStep
+	Problems []PlanProblem
+}
+
+// PlannedImage is the image an operation would use. ID is empty if the image isn't available locally, and
+// Digest if it neither has a repo digest locally nor could be resolved in its registry.
+type PlannedImage struct {
+	Reference string
+	ID        string
+	Digest    string
+
+	// Pull is true if the operation would pull the image first.
+	Pull bool
+}
+
+// PlannedConfig is the configuration of a container which would be created. The values of
+// environment variables are left out, since they often hold secrets.
+type PlannedConfig struct {
+	Image         string
+	Cmd           []string
+	Entrypoint    []string
+	EnvNames      []string
+	Labels        map[string]string
+	Ports         []string
+	Volumes       []string
+	Networks      []string
+	RestartPolicy string
+	Resources     Resources
+}
+
+// PlanStep is a step of an operation, named like the step field of the operation's log lines.
+type PlanStep struct {
+	Step        string
+	Description string
+}
+
+// PlanProblem is something which would make the operation fail, or have an unexpected result.
+type PlanProblem struct {
+	Kind    string
+	Message string
+}
+
+// PlanUpdate works out what UpdateContainerWithPatch would do. Like the update, it returns ErrImageFormatInvalid,
+// ErrSpecInvalid and ErrContainerNotFound; everything else that would go wrong is reported as a problem.
+func (dc *DockerController) PlanUpdate(ctx context.Context, containerName, image string, keepContainer bool, patch ConfigPatch) (Plan, error) {
+	ctx, cancel := withTimeout(ctx, dc.timeouts.Read)
+	defer cancel()
+
+	ctx, span := startOperation(ctx, "plan", attribute.String("container", containerName), attribute.String("image", image))
+	plan, err := dc.planUpdate(ctx, containerName, image, keepContainer, patch)
+	endSpan(span, err)
+
+	return plan, err
+}
+
+func (dc *DockerController) planUpdate(ctx context.Context, containerName, image string, keepContainer bool, patch ConfigPatch) (Plan, error) {
+	plan := Plan{Operation: "update", ContainerName: containerName}
+
+	if !isValidImage(image) {
+		return plan, ErrImageFormatInvalid
+	}
+
+	if err := patch.Validate(); err != nil {
+		return plan, err
+	}
+
+	containerId, ok, err := dc.findContainerID(ctx, containerName)
+	if err != nil {
+		return plan, fmt.Errorf("couldn't list containers: %w", err)
+	}
+	if !ok {
+		return plan, ErrContainerNotFound
+	}
+
+	rollbackContainerId, hasRollback, err := dc.findContainerID(ctx, containerName+RollbackContainerSuffix)
+	if err != nil {
+		return plan, fmt.Errorf("couldn't list containers: %w", err)
+	}
+
+	configCopy, err := dc.copyContainerConfig(ctx, containerId)
+	if err != nil {
+		return plan, fmt.Errorf("couldn't copy container config: %w", err)
+	}
+
+	plan.Change = ContainerChange{
+		ContainerName: containerName,
+		OldImage:      configCopy.ContainerConfig.Image,
+		OldImageID:    configCopy.ImageID,
+		NewImage:      image,
+		Settings:      patch.apply(configCopy.ContainerConfig, configCopy.ContainerHostConfig),
+	}
+	configCopy.ContainerConfig.Image = image
+
+	// updates don't pull, the image has to be pulled beforehand
+	if plan.Image, err = dc.resolveImage(ctx, image, false); err != nil {
+		return plan, err
+	}
+	plan.Change.NewImageID = plan.Image.ID
+
+	if plan.Image.ID == "" {
+		plan.Problems = append(plan.Problems, PlanProblem{
+			Kind:    ProblemImageNotPulled,
+			Message: fmt.Sprintf("%s isn't available locally, pull it before updating", image),
+		})
+	}
+
+	rollbackName := containerName + RollbackContainerSuffix
+	if hasRollback {
+		plan.Steps = append(plan.Steps, PlanStep{"remove_rollback", fmt.Sprintf("remove the previous rollback container %s (%s)", rollbackName, shortID(rollbackContainerId))})
+	}
+	plan.Steps = append(plan.Steps,
+		PlanStep{"rename", fmt.Sprintf("rename %s (%s) to %s", containerName, shortID(containerId), rollbackName)},
+		PlanStep{"create", fmt.Sprintf("create %s from %s", containerName, image)},
+		PlanStep{"stop", fmt.Sprintf("stop %s (%s)", rollbackName, shortID(containerId))},
+		PlanStep{"start", fmt.Sprintf("start the new %s", containerName)},
+		PlanStep{"verify", fmt.Sprintf("check that the new %s is running, and restore the old container otherwise", containerName)},
+	)
+	if !keepContainer {
+		plan.Steps = append(plan.Steps, PlanStep{"remove", fmt.Sprintf("remove %s (%s)", rollbackName, shortID(containerId))})
+	}
+
+	var networks []string
+	if network := configCopy.ContainerHostConfig.NetworkMode; network.IsUserDefined() {
+		networks = append(networks, string(network))
+	}
+
+	// the old container is stopped before the new one starts, so its ports are free by then
+	problems, err := dc.detectProblems(ctx, configCopy.ContainerHostConfig, networks, containerId, rollbackContainerId)
+	if err != nil {
+		return plan, err
+	}
+	plan.Problems = append(plan.Problems, problems...)
+	plan.Config = plannedConfig(configCopy.ContainerConfig, configCopy.ContainerHostConfig, networks)
+
+	return plan, nil
+}
+
+// PlanRollback works out what RollbackContainer would do. Like the rollback, it returns ErrContainerNotFound
+// if there's no rollback container, and ErrRollbackContainerNotFound if the requested container doesn't exist.
+func (dc *DockerController) PlanRollback(ctx context.Context, containerName string) (Plan, error) {
+	ctx, cancel := withTimeout(ctx, dc.timeouts.Read)
+	defer cancel()
+
+	ctx, span := startOperation(ctx, "plan", attribute.String("container", containerName))
+	plan, err := dc.planRollback(ctx, containerName)
+	endSpan(span, err)
+
+	return plan, err
+}
+
+func (dc *DockerController) planRollback(ctx context.Context, containerName string) (Plan, error) {
+	plan := Plan{Operation: "rollback", ContainerName: containerName}
+	rollbackName := containerName + RollbackContainerSuffix
+
+	rollbackContainerId, ok, err := dc.findContainerID(ctx, rollbackName)
+	if err != nil {
+		return plan, fmt.Errorf("couldn't list containers: %w", err)
+	}
+	if !ok {
+		return plan, ErrContainerNotFound
+	}
+
+	currentContainerId, ok, err := dc.findContainerID(ctx, containerName)
+	if err != nil {
+		return plan, fmt.Errorf("couldn't list containers: %w", err)
+	}
+	if !ok {
+		return plan, ErrRollbackContainerNotFound
+	}
+
+	rollbackConfig, err := dc.copyContainerConfig(ctx, rollbackContainerId)
+	if err != nil {
+		return plan, fmt.Errorf("couldn't copy container config: %w", err)
+	}
+
+	plan.Change = ContainerChange{ContainerName: containerName, NewImage: rollbackConfig.ContainerConfig.Image, NewImageID: rollbackConfig.ImageID}
+	plan.Change.OldImage, plan.Change.OldImageID = dc.containerImage(ctx, currentContainerId)
+
+	// the rollback container already exists, so its image is never pulled
+	if plan.Image, err = dc.resolveImage(ctx, rollbackConfig.ImageID, false); err != nil {
+		return plan, err
+	}
+	plan.Image.Reference = rollbackConfig.ContainerConfig.Image
+
+	plan.Steps = []PlanStep{
+		{"stop", fmt.Sprintf("stop %s (%s)", containerName, shortID(currentContainerId))},
+		{"remove", fmt.Sprintf("remove %s (%s)", containerName, shortID(currentContainerId))},
+		{"rename", fmt.Sprintf("rename %s (%s) to %s", rollbackName, shortID(rollbackContainerId), containerName)},
+		{"start", fmt.Sprintf("start %s (%s)", containerName, shortID(rollbackContainerId))},
+		{"verify", fmt.Sprintf("check that %s is running", containerName)},
+	}
+
+	var networks []string
+	if network := rollbackConfig.ContainerHostConfig.NetworkMode; network.IsUserDefined() {
+		networks = append(networks, string(network))
+	}
+
+	if plan.Problems, err = dc.detectProblems(ctx, rollbackConfig.ContainerHostConfig, networks, currentContainerId, rollbackContainerId); err != nil {
+		return plan, err
+	}
+	plan.Config = plannedConfig(rollbackConfig.ContainerConfig, rollbackConfig.ContainerHostConfig, networks)
+
+	return plan, nil
+}
+
+// PlanCreate works out what CreateContainer would do. Like the create, it returns ErrSpecInvalid, but
+// an existing container with the same name is reported as a problem.
+func (dc *DockerController) PlanCreate(ctx context.Context, spec ContainerSpec) (Plan, error) {
+	ctx, cancel := withTimeout(ctx, dc.timeouts.Read)
+	defer cancel()
+
+	ctx, span := startOperation(ctx, "plan", attribute.String("container", spec.Name), attribute.String("image", spec.Image))
+	plan, err := dc.planCreate(ctx, spec)
+	endSpan(span, err)
+
+	return plan, err
+}
+
+func (dc *DockerController) planCreate(ctx context.Context, spec ContainerSpec) (Plan, error) {
+	plan := Plan{Operation: "create", ContainerName: spec.Name}
+
+	if err := spec.Validate(); err != nil {
+		return plan, err
+	}
+
+	plan.Change = ContainerChange{ContainerName: spec.Name, NewImage: spec.Image}
+
+	_, exists, err := dc.findContainerID(ctx, spec.Name)
+	if err != nil {
+		return plan, fmt.Errorf("couldn't list containers: %w", err)
+	}
+	if exists {
+		plan.Problems = append(plan.Problems, PlanProblem{
+			Kind:    ProblemContainerExists,
+			Message: fmt.Sprintf("a container named %s already exists", spec.Name),
+		})
+	}
+
+	if plan.Image, err = dc.resolveImage(ctx, spec.Image, true); err != nil {
+		return plan, err
+	}
+	plan.Change.NewImageID = plan.Image.ID
+	plan.Problems = append(plan.Problems, imageProblems(plan.Image)...)
+
+	if plan.Image.Pull {
+		plan.Steps = append(plan.Steps, PlanStep{"pull", fmt.Sprintf("pull %s", spec.Image)})
+	}
+	plan.Steps = append(plan.Steps, PlanStep{"create", fmt.Sprintf("create %s from %s", spec.Name, spec.Image)})
+	for i := 1; i < len(spec.Networks); i++ {
+		plan.Steps = append(plan.Steps, PlanStep{"connect", fmt.Sprintf("connect %s to network %s", spec.Name, spec.Networks[i])})
+	}
+	plan.Steps = append(plan.Steps,
+		PlanStep{"start", fmt.Sprintf("start %s", spec.Name)},
+		PlanStep{"verify", fmt.Sprintf("check that %s is running, and remove it otherwise", spec.Name)},
+	)
+
+	config, hostConfig, _ := spec.containerConfig()
+
+	problems, err := dc.detectProblems(ctx, hostConfig, spec.Networks)
+	if err != nil {
+		return plan, err
+	}
+	plan.Problems = append(plan.Problems, problems...)
+	plan.Config = plannedConfig(config, hostConfig, spec.Networks)
+
+	return plan, nil
+}
+
+// PlanPull works out whether PullImage would pull image, and which digest it would get. Like the pull, it returns ErrImageFormatInvalid.
+func (dc *DockerController) PlanPull(ctx context.Context, image string) (Plan, error) {
+	ctx, cancel := withTimeout(ctx, dc.timeouts.Read)
+	defer cancel()
+
+	ctx, span := startOperation(ctx, "plan", attribute.String("image", image))
+	plan, err := dc.planPull(ctx, image)
+	endSpan(span, err)
+
+	return plan, err
+}
+
+func (dc *DockerController) planPull(ctx context.Context, image string) (Plan, error) {
+	plan := Plan{Operation: "pull"}
+
+	if !isValidImage(image) {
+		return plan, ErrImageFormatInvalid
+	}
+
+	var err error
+	if plan.Image, err = dc.resolveImage(ctx, image, true); err != nil {
+		return plan, err
+	}
+	plan.Problems = imageProblems(plan.Image)
+
+	// an image which already exists isn't pulled again, so the plan has no steps
+	if plan.Image.Pull {
+		plan.Steps = []PlanStep{{"pull", fmt.Sprintf("pull %s", image)}}
+	}
+
+	return plan, nil
+}
+
+// resolveImage looks image up locally and, if the operation would pull it, in its registry.
+// An image which can be found in neither has an empty ID and Digest, see imageProblems.
+func (dc *DockerController) resolveImage(ctx context.Context, image string, pull bool) (PlannedImage, error) {
+	resolved := PlannedImage{Reference: image}
+
+	inspectCtx, done := traceDockerCall(ctx, "image_inspect", attribute.String("image", image))
+	inspect, _, err := dc.cli.ImageInspectWithRaw(inspectCtx, image)
+	switch {
+	case err == nil:
+		done(nil)
+		resolved.ID = inspect.ID
+		if len(inspect.RepoDigests) > 0 {
+			resolved.Digest = digestOf(inspect.RepoDigests[0])
+		}
+	case client.IsErrNotFound(err):
+		done(nil)
+	default:
+		return resolved, fmt.Errorf("couldn't inspect image %s: %w", image, done(err))
+	}
+
+	if !pull {
+		return resolved, nil
+	}
+
+	resolved.Pull = !dc.doesImageExist(ctx, image)
+	if !resolved.Pull {
+		return resolved, nil
+	}
+
+	distributionCtx, done := traceDockerCall(ctx, "distribution_inspect", attribute.String("image", image))
+	distribution, err := dc.cli.DistributionInspect(distributionCtx, image, "")
+	if done(err) == nil {
+		resolved.Digest = string(distribution.Descriptor.Digest)
+	}
+
+	return resolved, nil
+}
+
+// imageProblems reports an image which would have to be pulled, but could neither be found locally nor in its registry.
+func imageProblems(image PlannedImage) []PlanProblem {
+	if !image.Pull || image.ID != "" || image.Digest != "" {
+		return nil
+	}
+
+	return []PlanProblem{{
+		Kind:    ProblemImageNotFound,
+		Message: fmt.Sprintf("%s couldn't be found in its registry", image.Reference),
+	}}
+}
+
+// detectProblems looks for the port conflicts, missing volumes and missing networks which would keep
+// a container with hostConfig from starting. The containers in ignore are expected to have been stopped
+// or removed by the time it starts.
+func (dc *DockerController) detectProblems(ctx context.Context, hostConfig *container.HostConfig, networks []string, ignore ...string) ([]PlanProblem, error) {
+	containers, err := dc.ListContainers(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't list containers: %w", err)
+	}
+
+	problems := portConflicts(hostConfig.PortBindings, containers, ignore)
+
+	for _, name := range namedVolumes(hostConfig) {
+		volumeCtx, done := traceDockerCall(ctx, "volume_inspect", attribute.String("volume", name))
+		_, err := dc.cli.VolumeInspect(volumeCtx, name)
+		if client.IsErrNotFound(err) {
+			done(nil)
+			problems = append(problems, PlanProblem{
+				Kind:    ProblemVolumeMissing,
+				Message: fmt.Sprintf("volume %s doesn't exist, docker would create it empty", name),
+			})
+			continue
+		}
+		if err = done(err); err != nil {
+			return nil, fmt.Errorf("couldn't inspect volume %s: %w", name, err)
+		}
+	}
+
+	for _, name := range networks {
+		networkCtx, done := traceDockerCall(ctx, "network_inspect", attribute.String("network", name))
+		_, err := dc.cli.NetworkInspect(networkCtx, name, types.NetworkInspectOptions{})
+		if client.IsErrNotFound(err) {
+			done(nil)
+			problems = append(problems, PlanProblem{
+				Kind:    ProblemNetworkMissing,
+				Message: fmt.Sprintf("network %s doesn't exist", name),
+			})
+			continue
+		}
+		if err = done(err); err != nil {
+			return nil, fmt.Errorf("couldn't inspect network %s: %w", name, err)
+		}
+	}
+
+	return problems, nil
+}
+
+// portConflicts reports the host ports in bindings which are already published by a running container.
+func portConflicts(bindings nat.PortMap, containers []types.Container, ignore []string) []PlanProblem {
+	ignored := map[string]bool{}
+	for _, id := range ignore {
+		ignored[id] = true
+	}
+
+	var problems []PlanProblem
+
+	for _, port := range sortedPorts(bindings) {
+		for _, binding := range bindings[port] {
+			hostPort, err := strconv.Atoi(binding.HostPort)
+			if err != nil || hostPort == 0 {
+				// a random host port is picked
+				continue
+			}
+
+			for _, other := range containers {
+				if ignored[other.ID] || other.State != "running" {
+					continue
+				}
+
+				for _, published := range other.Ports {
+					if int(published.PublicPort) != hostPort || published.Type != port.Proto() || !hostIPsOverlap(published.IP, binding.HostIP) {
+						continue
+					}
+
+					problems = append(problems, PlanProblem{
+						Kind:    ProblemPortConflict,
+						Message: fmt.Sprintf("host port %d/%s is already published by %s", hostPort, port.Proto(), strings.TrimPrefix(other.Names[0], "/")),
+					})
+				}
+			}
+		}
+	}
+
+	return problems
+}
+
+// hostIPsOverlap reports whether ports published on both addresses would clash. The unspecified addresses overlap with any address.
+func hostIPsOverlap(a, b string) bool {
+	unspecified := func(ip string) bool { return ip == "" || ip == "0.0.0.0" || ip == "::" }
+
+	return unspecified(a) || unspecified(b) || a == b
+}
+
+// namedVolumes returns the names of the volumes mounted by hostConfig, which docker creates when they don't exist.
+func namedVolumes(hostConfig *container.HostConfig) []string {
+	var names []string
+
+	for _, bind := range hostConfig.Binds {
+		parts := strings.Split(bind, ":")
+		if len(parts) >= 2 && !filepath.IsAbs(parts[0]) {
+			names = append(names, parts[0])
+		}
+	}
+
+	for _, m := range hostConfig.Mounts {
+		if m.Type == mount.TypeVolume && m.Source != "" {
+			names = append(names, m.Source)
+		}
+	}
+
+	return names
+}
+
+// plannedConfig summarizes the configuration of a container which is about to be created.
+func plannedConfig(config *container.Config, hostConfig *container.HostConfig, networks []string) PlannedConfig {
+	planned := PlannedConfig{
+		Image:         config.Image,
+		Cmd:           config.Cmd,
+		Entrypoint:    config.Entrypoint,
+		Labels:        config.Labels,
+		Networks:      networks,
+		RestartPolicy: formatRestartPolicy(hostConfig.RestartPolicy),
+		Resources:     Resources{MemoryBytes: hostConfig.Memory},
+	}
+
+	for _, variable := range config.Env {
+		planned.EnvNames = append(planned.EnvNames, strings.SplitN(variable, "=", 2)[0])
+	}
+
+	for _, port := range sortedPorts(hostConfig.PortBindings) {
+		for _, binding := range hostConfig.PortBindings[port] {
+			formatted := string(port)
+			if binding.HostPort != "" {
+				formatted = binding.HostPort + ":" + formatted
+			}
+			if ip := normalizeHostIP(binding.HostIP); ip != "" {
+				formatted = ip + ":" + formatted
+			}
+
+			planned.Ports = append(planned.Ports, formatted)
+		}
+	}
+
+	planned.Volumes = append(planned.Volumes, hostConfig.Binds...)
+	for _, m := range hostConfig.Mounts {
+		planned.Volumes = append(planned.Volumes, formatVolume(volume{Source: m.Source, Destination: m.Target, ReadOnly: m.ReadOnly}))
+	}
+
+	planned.Resources.CPUs, _ = strconv.ParseFloat(formatCPUs(hostConfig), 64)
+
+	return planned
+}
+
+func sortedPorts(bindings nat.PortMap) []nat.Port {
+	ports := make([]nat.Port, 0, len(bindings))
+	for port := range bindings {
+		ports = append(ports, port)
+	}
+	sort.Slice(ports, func(i, j int) bool { return ports[i] < ports[j] })
+
+	return ports
+}
+
+// digestOf returns the digest of a repo digest, e.g. sha256:... for nginx@sha256:...
+func digestOf(repoDigest string) string {
+	if i := strings.LastIndex(repoDigest, "@"); i >= 0 {
+		return repoDigest[i+1:]
+	}
+
+	return repoDigest
+}
+
+func shortID(id string) string {
+	if len(id) > 12 {
+		return id[:12]
+	}
+
+	return id
+}
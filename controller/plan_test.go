@@ -0,0 +1,144 @@
+package controller
+
+import (
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/mount"
+	"github.com/docker/go-connections/nat"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestPortConflicts(t *testing.T) {
+	publishing := func(id, name, state string, ports ...types.Port) types.Container {
+		return types.Container{ID: id, Names: []string{"/" + name}, State: state, Ports: ports}
+	}
+
+	api := publishing("api", "api", "running", types.Port{IP: "0.0.0.0", PrivatePort: 80, PublicPort: 8080, Type: "tcp"})
+	dns := publishing("dns", "dns", "running", types.Port{IP: "127.0.0.1", PrivatePort: 53, PublicPort: 5353, Type: "udp"})
+	stopped := publishing("old", "old", "exited", types.Port{PrivatePort: 80, PublicPort: 9090, Type: "tcp"})
+
+	tests := []struct {
+		name       string
+		ports      []string
+		containers []types.Container
+		ignore     []string
+		expected   []string
+	}{
+		{
+			name:       "Same port on all addresses",
+			ports:      []string{"8080:80"},
+			containers: []types.Container{api},
+			expected:   []string{"host port 8080/tcp is already published by api"},
+		},
+		{
+			name:       "Specific address overlaps with all addresses",
+			ports:      []string{"127.0.0.1:8080:80"},
+			containers: []types.Container{api},
+			expected:   []string{"host port 8080/tcp is already published by api"},
+		},
+		{
+			name:       "Same specific address",
+			ports:      []string{"127.0.0.1:5353:53/udp"},
+			containers: []types.Container{dns},
+			expected:   []string{"host port 5353/udp is already published by dns"},
+		},
+		{
+			name:       "Different specific addresses",
+			ports:      []string{"10.0.0.1:5353:53/udp"},
+			containers: []types.Container{dns},
+		},
+		{
+			name:       "Different protocol",
+			ports:      []string{"8080:80/udp", "5353:53/tcp"},
+			containers: []types.Container{api, dns},
+		},
+		{
+			name:       "Different port",
+			ports:      []string{"8081:80"},
+			containers: []types.Container{api},
+		},
+		{
+			name:       "Ignored container",
+			ports:      []string{"8080:80"},
+			containers: []types.Container{api},
+			ignore:     []string{"api"},
+		},
+		{
+			name:       "Stopped container",
+			ports:      []string{"9090:80"},
+			containers: []types.Container{stopped},
+		},
+		{
+			name:       "Random host port",
+			ports:      []string{"80", "0:8080"},
+			containers: []types.Container{api},
+		},
+		{
+			name:       "Sorted by port",
+			ports:      []string{"5353:53/udp", "8080:80"},
+			containers: []types.Container{dns, api},
+			expected: []string{
+				"host port 5353/udp is already published by dns",
+				"host port 8080/tcp is already published by api",
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, bindings, err := nat.ParsePortSpecs(test.ports)
+			assert.Nil(t, err)
+
+			var messages []string
+			for _, problem := range portConflicts(bindings, test.containers, test.ignore) {
+				assert.Equal(t, ProblemPortConflict, problem.Kind)
+				messages = append(messages, problem.Message)
+			}
+
+			assert.Equal(t, test.expected, messages)
+		})
+	}
+}
+
+func TestNamedVolumes(t *testing.T) {
+	tests := []struct {
+		name       string
+		hostConfig container.HostConfig
+		expected   []string
+	}{
+		{
+			name:       "Bind mounts aren't volumes",
+			hostConfig: container.HostConfig{Binds: []string{"/srv/www:/var/www:ro", "/var/run/docker.sock:/var/run/docker.sock"}},
+		},
+		{
+			name:       "Named volumes",
+			hostConfig: container.HostConfig{Binds: []string{"data:/var/lib/postgresql/data", "/srv/www:/var/www", "cache:/cache:rw"}},
+			expected:   []string{"data", "cache"},
+		},
+		{
+			name: "Mounts",
+			hostConfig: container.HostConfig{Mounts: []mount.Mount{
+				{Type: mount.TypeVolume, Source: "data", Target: "/data"},
+				{Type: mount.TypeVolume, Target: "/anonymous"},
+				{Type: mount.TypeBind, Source: "/srv/www", Target: "/var/www"},
+				{Type: mount.TypeTmpfs, Target: "/tmp"},
+			}},
+			expected: []string{"data"},
+		},
+		{
+			name: "Binds and mounts",
+			hostConfig: container.HostConfig{
+				Binds:  []string{"logs:/var/log"},
+				Mounts: []mount.Mount{{Type: mount.TypeVolume, Source: "data", Target: "/data"}},
+			},
+			expected: []string{"logs", "data"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.expected, namedVolumes(&test.hostConfig))
+		})
+	}
+}
@@ -0,0 +1,79 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+)
+
+// Probe checks whether a container works after it has been updated. Exactly one of HTTP and TCP is set.
+// The checks are made from the agent, so the addresses must be reachable from where the agent runs.
+type Probe struct {
+	// HTTP is a URL which must respond with a 2xx or 3xx status.
+	HTTP string
+
+	// TCP is a host:port address which must accept connections.
+	TCP string
+
+	Timeout  time.Duration
+	Interval time.Duration
+
+	// Retries is how many times a failed check is repeated before the probe fails.
+	Retries int
+}
+
+// Wait runs the probe's check until it succeeds, at most Retries+1 times and Interval apart.
+// It returns the error of the last check if none of them succeeded.
+func (p Probe) Wait(ctx context.Context) error {
+	var err error
+
+	for attempt := 0; attempt <= p.Retries; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-time.After(p.Interval):
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+
+		if err = p.check(ctx); err == nil {
+			return nil
+		}
+	}
+
+	return err
+}
+
+func (p Probe) check(ctx context.Context) error {
+	ctx, cancel := withTimeout(ctx, p.Timeout)
+	defer cancel()
+
+	if p.TCP != "" {
+		var dialer net.Dialer
+		conn, err := dialer.DialContext(ctx, "tcp", p.TCP)
+		if err != nil {
+			return err
+		}
+
+		return conn.Close()
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.HTTP, nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 400 {
+		return fmt.Errorf("%s responded with %s", p.HTTP, resp.Status)
+	}
+
+	return nil
+}
@@ -0,0 +1,410 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/XiovV/dokkup-agent/logging"
+	"github.com/sirupsen/logrus"
+	"strings"
+	"sync"
+	"time"
+)
+
+// States of a container after a reconciliation pass, see ContainerReconcileStatus.
+const (
+	ReconcileInSync  = "in_sync"
+	ReconcileDrifted = "drifted"
+	ReconcileMissing = "missing"
+	ReconcileFailed  = "failed"
+)
+
+// Actions the reconciler takes to converge a container, see ContainerReconcileStatus.
+const (
+	ReconcileActionCreated    = "created"
+	ReconcileActionUpdated    = "updated"
+	ReconcileActionRecreated  = "recreated"
+	ReconcileActionRolledBack = "rolled_back"
+)
+
+// Manifest is the desired state of a container, as read from a file of the manifest directory.
+type Manifest struct {
+	File string
+	Spec ContainerSpec
+
+	// Probe is checked after the container has been created or updated. An update is rolled back if it fails.
+	Probe *Probe
+}
+
+// ContainerReconcileStatus is the outcome of reconciling a single container.
+type ContainerReconcileStatus struct {
+	Name string
+	File string
+
+	// State is one of ReconcileInSync, ReconcileDrifted, ReconcileMissing or ReconcileFailed.
+	State string
+
+	// Drift lists the settings in which the container still differs from its manifest.
+	Drift []Drift
+
+	// Action is what the reconciler did to the container during the pass, if anything.
+	Action string
+	Error  error
+}
+
+// ReconcileStatus is the outcome of the last reconciliation pass.
+type ReconcileStatus struct {
+	// LastRun is the zero time until the first pass has finished.
+	LastRun time.Time
+
+	// Error is set if the manifests couldn't be read, in which case no container was reconciled.
+	Error      error
+	Containers []ContainerReconcileStatus
+}
+
+// OperationRecorder is called when the reconciler starts to create, update, recreate or roll back a container, with
+// the action ("create", "update", "recreate" or "rollback"), the container and the image. The returned function is called with
+// the outcome once the operation has finished.
+type OperationRecorder func(ctx context.Context, action, containerName, image string) func(change ContainerChange, err error)
+
+// Reconciler converges containers to the state described by their manifests. Containers whose image, environment,
+// labels, restart policy or resource limits differ from their manifest are updated, and containers whose ports,
+// volumes or networks differ are recreated from their manifest. Either way the old container is kept, and
+// restored if the new container fails to start, or rolled back to if its probe fails afterwards.
+type Reconciler struct {
+	controller ContainerController
+	manifests  func() ([]Manifest, error)
+	log        logrus.FieldLogger
+	trigger    chan struct{}
+	record     OperationRecorder
+
+	stopping chan struct{}
+	stopOnce sync.Once
+	done     chan struct{}
+
+	// passMu serializes the passes, and guards failed.
+	passMu sync.Mutex
+
+	// failed maps containers to the spec (see specKey) they couldn't be created or updated with,
+	// so the change isn't retried on every pass, but only once the manifest changes.
+	failed map[string]string
+
+	mu     sync.Mutex
+	status ReconcileStatus
+}
+
+// NewReconciler returns a Reconciler which reads the manifests with manifests on every pass.
+func NewReconciler(controller ContainerController, manifests func() ([]Manifest, error), logger logrus.FieldLogger) *Reconciler {
+	return &Reconciler{
+		controller: controller,
+		manifests:  manifests,
+		log:        logger,
+		trigger:    make(chan struct{}, 1),
+		stopping:   make(chan struct{}),
+		done:       make(chan struct{}),
+		failed:     map[string]string{},
+		record: func(context.Context, string, string, string) func(ContainerChange, error) {
+			return func(ContainerChange, error) {}
+		},
+	}
+}
+
+// RecordOperations makes the reconciler report the containers it creates, updates and rolls back to record.
+// It must be called before Run.
+func (r *Reconciler) RecordOperations(record OperationRecorder) {
+	r.record = record
+}
+
+// Trigger makes Run start a pass as soon as the current one has finished, e.g. because a manifest changed.
+func (r *Reconciler) Trigger() {
+	select {
+	case r.trigger <- struct{}{}:
+	default:
+	}
+}
+
+// Run reconciles the containers right away, then every interval and whenever Trigger is called,
+// until Stop is called or ctx is cancelled. Stop lets the pass in progress finish, while an update
+// which is interrupted by cancelling ctx restores the old container. Run may only be called once.
+func (r *Reconciler) Run(ctx context.Context, interval time.Duration) {
+	defer close(r.done)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-r.stopping:
+			return
+		default:
+		}
+
+		r.Reconcile(ctx)
+
+		select {
+		case <-ticker.C:
+		case <-r.trigger:
+		case <-r.stopping:
+			return
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Stop makes Run return once the pass in progress has finished.
+func (r *Reconciler) Stop() {
+	r.stopOnce.Do(func() { close(r.stopping) })
+}
+
+// Done is closed when Run has returned.
+func (r *Reconciler) Done() <-chan struct{} {
+	return r.done
+}
+
+// Reconcile runs a single reconciliation pass over all manifests and returns its outcome,
+// which is also kept for Status.
+func (r *Reconciler) Reconcile(ctx context.Context) ReconcileStatus {
+	r.passMu.Lock()
+	defer r.passMu.Unlock()
+
+	status := ReconcileStatus{}
+
+	manifests, err := r.manifests()
+	if err != nil {
+		r.log.WithError(err).Error("couldn't read the manifests, skipping reconciliation")
+		status.Error = err
+	}
+
+	names := make(map[string]bool, len(manifests))
+	for _, manifest := range manifests {
+		if ctx.Err() != nil {
+			break
+		}
+
+		names[manifest.Spec.Name] = true
+		status.Containers = append(status.Containers, r.reconcileContainer(ctx, manifest))
+	}
+
+	for name := range r.failed {
+		if !names[name] {
+			delete(r.failed, name)
+		}
+	}
+
+	status.LastRun = time.Now()
+
+	r.mu.Lock()
+	r.status = status
+	r.mu.Unlock()
+
+	return status
+}
+
+// Status returns the outcome of the last reconciliation pass.
+func (r *Reconciler) Status() ReconcileStatus {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.status
+}
+
+func (r *Reconciler) reconcileContainer(ctx context.Context, manifest Manifest) ContainerReconcileStatus {
+	spec := manifest.Spec
+	status := ContainerReconcileStatus{Name: spec.Name, File: manifest.File}
+
+	container, err := r.controller.InspectContainer(ctx, spec.Name)
+	if errors.Is(err, ErrContainerNotFound) {
+		return r.createMissing(ctx, manifest, status)
+	}
+	if err != nil {
+		status.State, status.Error = ReconcileFailed, fmt.Errorf("couldn't inspect the container: %w", err)
+		return status
+	}
+
+	status.Drift = spec.Diff(container)
+	if len(status.Drift) == 0 {
+		delete(r.failed, spec.Name)
+		status.State = ReconcileInSync
+		return status
+	}
+
+	if r.failed[spec.Name] == specKey(spec) {
+		status.State = ReconcileFailed
+		status.Error = fmt.Errorf("the update to %s failed before, change the manifest to retry it", spec.Image)
+		return status
+	}
+
+	ctx, log := r.operationLogger(ctx, manifest)
+
+	recreate := needsRecreate(status.Drift)
+	if recreate {
+		log.WithField("drift", driftSettings(status.Drift)).Info("container differs from its manifest, recreating")
+	} else {
+		log.WithField("drift", driftSettings(status.Drift)).Info("container differs from its manifest, updating")
+	}
+
+	status.Action, err = r.update(ctx, log, manifest, recreate)
+	if err != nil {
+		r.failed[spec.Name] = specKey(spec)
+		status.State, status.Error = ReconcileFailed, err
+		return status
+	}
+
+	return r.inspectConverged(ctx, manifest, status)
+}
+
+// createMissing creates a container which doesn't exist yet from its manifest. There's nothing to roll
+// back to if its probe fails, so the new container is left running and reported as failed.
+func (r *Reconciler) createMissing(ctx context.Context, manifest Manifest, status ContainerReconcileStatus) ContainerReconcileStatus {
+	spec := manifest.Spec
+
+	if r.failed[spec.Name] == specKey(spec) {
+		status.State = ReconcileMissing
+		status.Drift = []Drift{{Setting: "image", Desired: spec.Image}}
+		status.Error = fmt.Errorf("creating the container with %s failed before, change the manifest to retry it", spec.Image)
+		return status
+	}
+
+	ctx, log := r.operationLogger(ctx, manifest)
+
+	log.Info("container doesn't exist, creating it")
+	done := r.record(ctx, "create", spec.Name, spec.Image)
+	change, err := r.controller.CreateContainer(ctx, spec)
+	done(change, err)
+	if err != nil {
+		log.WithError(err).Error("couldn't create the container")
+		r.failed[spec.Name] = specKey(spec)
+		status.State = ReconcileMissing
+		status.Drift = []Drift{{Setting: "image", Desired: spec.Image}}
+		status.Error = fmt.Errorf("couldn't create the container: %w", err)
+		return status
+	}
+	status.Action = ReconcileActionCreated
+
+	if manifest.Probe != nil {
+		if err := manifest.Probe.Wait(ctx); err != nil {
+			log.WithError(err).Warn("probe failed after creating the container")
+			r.failed[spec.Name] = specKey(spec)
+			status.State, status.Error = ReconcileFailed, fmt.Errorf("probe failed after creating the container: %w", err)
+			return status
+		}
+	}
+
+	log.Info("container was created")
+
+	return r.inspectConverged(ctx, manifest, status)
+}
+
+// inspectConverged diffs a container which has just been created or updated against its manifest again.
+func (r *Reconciler) inspectConverged(ctx context.Context, manifest Manifest, status ContainerReconcileStatus) ContainerReconcileStatus {
+	container, err := r.controller.InspectContainer(ctx, manifest.Spec.Name)
+	if err != nil {
+		status.State, status.Error = ReconcileFailed, fmt.Errorf("couldn't inspect the container after it was %s: %w", status.Action, err)
+		return status
+	}
+
+	status.Drift = manifest.Spec.Diff(container)
+	status.State = ReconcileInSync
+	if len(status.Drift) > 0 {
+		status.State = ReconcileDrifted
+	}
+
+	return status
+}
+
+// operationLogger gives a change made by the reconciler its own operation id, like the API does for every request.
+func (r *Reconciler) operationLogger(ctx context.Context, manifest Manifest) (context.Context, logrus.FieldLogger) {
+	operationID := logging.NewID()
+	ctx = logging.WithOperationID(ctx, operationID)
+
+	return ctx, r.log.WithFields(logrus.Fields{
+		"operation_id": operationID,
+		"container":    manifest.Spec.Name,
+		"manifest":     manifest.File,
+		"image":        manifest.Spec.Image,
+	})
+}
+
+// update pulls the manifest's image, updates the container to the manifest's image and settings, or recreates it
+// from the manifest if recreate is true, and checks its probe, rolling the change back if the probe fails.
+// It returns the action which was taken, which is empty if the container wasn't changed.
+func (r *Reconciler) update(ctx context.Context, log logrus.FieldLogger, manifest Manifest, recreate bool) (string, error) {
+	spec := manifest.Spec
+
+	if err := r.controller.PullImage(ctx, spec.Image); err != nil {
+		log.WithError(err).Error("couldn't pull the image")
+		return "", fmt.Errorf("couldn't pull %s: %w", spec.Image, err)
+	}
+
+	operation, action := "update", ReconcileActionUpdated
+	if recreate {
+		operation, action = "recreate", ReconcileActionRecreated
+	}
+
+	done := r.record(ctx, operation, spec.Name, spec.Image)
+	var change ContainerChange
+	var err error
+	if recreate {
+		change, err = r.controller.RecreateContainer(ctx, spec)
+	} else {
+		change, err = r.controller.UpdateContainerWithPatch(ctx, spec.Name, spec.Image, true, spec.patch())
+	}
+	done(change, err)
+	if err != nil {
+		log.WithError(err).Errorf("couldn't %s the container", operation)
+		return "", fmt.Errorf("couldn't %s the container: %w", operation, err)
+	}
+
+	if manifest.Probe == nil {
+		log.Infof("container was %s", action)
+		return action, nil
+	}
+
+	probeErr := manifest.Probe.Wait(ctx)
+	if probeErr == nil {
+		log.Infof("container was %s and its probe succeeded", action)
+		return action, nil
+	}
+
+	log.WithError(probeErr).Warnf("probe failed after the %s, rolling back", operation)
+	done = r.record(ctx, "rollback", spec.Name, "")
+	change, err = r.controller.RollbackContainer(ctx, spec.Name)
+	done(change, err)
+	if err != nil {
+		log.WithError(err).Error("couldn't roll back the container")
+		return ReconcileActionRolledBack, fmt.Errorf("probe failed (%s), and the rollback failed: %w", probeErr, err)
+	}
+
+	return ReconcileActionRolledBack, fmt.Errorf("probe failed, the %s was rolled back: %w", operation, probeErr)
+}
+
+// needsRecreate reports whether drift has settings which an update copies from the old container instead of
+// converging them, see ContainerSpec.patch, so the container has to be recreated from its manifest.
+func needsRecreate(drift []Drift) bool {
+	for _, d := range drift {
+		switch {
+		case strings.HasPrefix(d.Setting, "ports."), strings.HasPrefix(d.Setting, "volumes."), strings.HasPrefix(d.Setting, "networks."):
+			return true
+		}
+	}
+
+	return false
+}
+
+func driftSettings(drift []Drift) []string {
+	settings := make([]string, 0, len(drift))
+	for _, d := range drift {
+		settings = append(settings, d.Setting)
+	}
+
+	return settings
+}
+
+// specKey identifies the version of a spec which a create or update failed with.
+func specKey(spec ContainerSpec) string {
+	key, _ := json.Marshal(spec)
+
+	return string(key)
+}
@@ -0,0 +1,133 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"github.com/XiovV/dokkup-agent/metrics"
+	"go.opentelemetry.io/otel/attribute"
+	"time"
+)
+
+// RecreateContainer replaces a container with a new container created from spec, like CreateContainer creates one.
+// Unlike an update, which copies the ports, volumes and networks of the old container, this converges all of
+// the spec's settings. It returns ErrSpecInvalid if the spec is invalid and ErrContainerNotFound if the container
+// doesn't exist. The old container is kept as the rollback container, so the new one can be rolled back with
+// RollbackContainer. If any step fails, or ctx is cancelled, after the old container has been renamed, the old
+// container is restored like it is by UpdateContainer. The pull counts towards the update timeout.
+func (dc *DockerController) RecreateContainer(ctx context.Context, spec ContainerSpec) (ContainerChange, error) {
+	ctx, cancel := withTimeout(ctx, dc.timeouts.Update)
+	defer cancel()
+
+	ctx, span := startOperation(ctx, "recreate", attribute.String("container", spec.Name), attribute.String("image", spec.Image))
+	start := time.Now()
+
+	change, err := dc.recreateContainer(ctx, spec)
+	metrics.ObserveOperation("recreate", metricsContainer(spec.Name, err), start, err)
+	endSpan(span, err)
+
+	return change, err
+}
+
+func (dc *DockerController) recreateContainer(ctx context.Context, spec ContainerSpec) (ContainerChange, error) {
+	log := dc.operationLogger(ctx, "recreate", spec.Name).WithField("image", spec.Image)
+	change := ContainerChange{ContainerName: spec.Name, NewImage: spec.Image}
+
+	if err := spec.Validate(); err != nil {
+		return change, err
+	}
+
+	containerId, ok, err := dc.findContainerID(ctx, spec.Name)
+	if err != nil {
+		return change, fmt.Errorf("couldn't list containers: %w", err)
+	}
+	if !ok {
+		return change, ErrContainerNotFound
+	}
+
+	log.WithField("step", "pull").Info("pulling image")
+	if err := dc.pullImage(ctx, spec.Image, nil); err != nil {
+		return change, err
+	}
+
+	rollbackContainerId, ok, err := dc.findContainerID(ctx, spec.Name+RollbackContainerSuffix)
+	if err != nil {
+		return change, fmt.Errorf("couldn't list containers: %w", err)
+	}
+	if ok {
+		log.WithField("step", "remove_rollback").Infof("removing previous rollback container (%s)", rollbackContainerId)
+		if err := dc.removeContainer(ctx, rollbackContainerId); err != nil {
+			return change, fmt.Errorf("could not remove rollback container: %w", err)
+		}
+	}
+
+	change.OldImage, change.OldImageID = dc.containerImage(ctx, containerId)
+
+	if err := ctx.Err(); err != nil {
+		return change, ErrOperationAborted{Step: "inspect", Reason: err}
+	}
+
+	// like in an update, the rename itself isn't interrupted, see updateContainer
+	log.WithField("step", "rename").Infof("renaming %s (%s) to %s%s", spec.Name, containerId, spec.Name, RollbackContainerSuffix)
+	renameCtx, cancelRename := dc.detach(ctx)
+	err = dc.renameContainer(renameCtx, containerId, spec.Name+RollbackContainerSuffix)
+	cancelRename()
+	if err != nil {
+		return change, fmt.Errorf("couldn't rename container: %w", err)
+	}
+
+	// from here on the old container has been renamed, so it has to be restored if any of the following steps fail
+
+	if err := ctx.Err(); err != nil {
+		return change, dc.abortUpdate(ctx, log, "rename", err, containerId, "", spec.Name)
+	}
+
+	config, hostConfig, networkingConfig := spec.containerConfig()
+
+	log.WithField("step", "create").Info("creating new container")
+	createCtx, done := traceDockerCall(ctx, "container_create", attribute.String("image", spec.Image))
+	resp, err := dc.cli.ContainerCreate(createCtx, config, hostConfig, networkingConfig, nil, spec.Name)
+	if err = done(err); err != nil {
+		log.WithField("step", "create").WithError(err).Error("couldn't create new container")
+		return change, dc.abortUpdate(ctx, log, "create", fmt.Errorf("couldn't create container: %w", err), containerId, "", spec.Name)
+	}
+
+	newContainerId := resp.ID
+	log.WithField("step", "create").Infof("created new container (%s)", newContainerId)
+	_, change.NewImageID = dc.containerImage(ctx, newContainerId)
+
+	// the first network is set at creation, the others can only be connected to a created container
+	for i := 1; i < len(spec.Networks); i++ {
+		log.WithField("step", "connect").Infof("connecting container to network %s", spec.Networks[i])
+		if err := dc.connectNetwork(ctx, spec.Networks[i], newContainerId); err != nil {
+			err = fmt.Errorf("couldn't connect container to network %s: %w", spec.Networks[i], err)
+			return change, dc.abortUpdate(ctx, log, "connect", err, containerId, newContainerId, spec.Name)
+		}
+	}
+
+	log.WithField("step", "stop").Infof("stopping %s%s (%s)", spec.Name, RollbackContainerSuffix, containerId)
+	if err := dc.stopContainer(ctx, containerId); err != nil {
+		err = fmt.Errorf("couldn't stop container %s: %w", spec.Name, err)
+		return change, dc.abortUpdate(ctx, log, "stop", err, containerId, newContainerId, spec.Name)
+	}
+
+	log.WithField("step", "start").Infof("starting new container (%s)", newContainerId)
+	if err := dc.startContainer(ctx, newContainerId); err != nil {
+		err = ErrContainerStartFailed{ContainerId: newContainerId, Reason: err}
+		return change, dc.abortUpdate(ctx, log, "start", err, containerId, newContainerId, spec.Name)
+	}
+
+	running, err := dc.isContainerRunning(ctx, newContainerId)
+	if err != nil {
+		err = fmt.Errorf("couldn't check if container %s is running: %w", newContainerId, err)
+		return change, dc.abortUpdate(ctx, log, "verify", err, containerId, newContainerId, spec.Name)
+	}
+
+	if !running {
+		log.WithField("step", "verify").Error("new container is not running, trying to restore old container")
+		return change, dc.abortUpdate(ctx, log, "verify", ErrContainerNotRunning, containerId, newContainerId, spec.Name)
+	}
+
+	log.WithField("step", "done").Info("container recreated successfully")
+
+	return change, nil
+}
@@ -0,0 +1,69 @@
+package controller
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"github.com/docker/docker/api/types"
+	"strings"
+)
+
+// DefaultRegistry is the host of images whose name doesn't start with one, which are pulled from Docker Hub.
+const DefaultRegistry = "docker.io"
+
+// RegistryCredentials are used for pulling images from a private registry. Either Username
+// and Password, or IdentityToken are set.
+type RegistryCredentials struct {
+	Username      string
+	Password      string
+	IdentityToken string
+}
+
+// SetRegistryCredentials replaces the credentials images are pulled with, keyed by registry host.
+// Pulls which have already started keep using the old credentials.
+func (dc *DockerController) SetRegistryCredentials(credentials map[string]RegistryCredentials) {
+	dc.registries.Store(credentials)
+}
+
+// registryAuth returns the encoded credentials for the registry image is pulled from,
+// or an empty string if there aren't any.
+func (dc *DockerController) registryAuth(image string) (string, error) {
+	registries, _ := dc.registries.Load().(map[string]RegistryCredentials)
+
+	host := registryHost(image)
+	credentials, ok := registries[host]
+	if !ok {
+		return "", nil
+	}
+
+	data, err := json.Marshal(types.AuthConfig{
+		Username:      credentials.Username,
+		Password:      credentials.Password,
+		IdentityToken: credentials.IdentityToken,
+		ServerAddress: host,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return base64.URLEncoding.EncodeToString(data), nil
+}
+
+// registryHost returns the host of the registry image is pulled from. Like docker, the first part of the
+// name is only taken as a host if it contains a "." or a ":", or is "localhost".
+func registryHost(image string) string {
+	i := strings.Index(image, "/")
+	if i == -1 {
+		return DefaultRegistry
+	}
+
+	host := image[:i]
+	if host != "localhost" && !strings.ContainsAny(host, ".:") {
+		return DefaultRegistry
+	}
+
+	if host == "index.docker.io" || host == "registry-1.docker.io" {
+		return DefaultRegistry
+	}
+
+	return host
+}
@@ -0,0 +1,68 @@
+package controller
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"github.com/docker/docker/api/types"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestRegistryHost(t *testing.T) {
+	tests := []struct {
+		image    string
+		expected string
+	}{
+		{"nginx", DefaultRegistry},
+		{"nginx:1.21", DefaultRegistry},
+		{"library/nginx:1.21", DefaultRegistry},
+		{"docker.io/library/nginx", DefaultRegistry},
+		{"index.docker.io/library/nginx", DefaultRegistry},
+		{"ghcr.io/xiovv/web:1.0", "ghcr.io"},
+		{"registry.example.com:5000/web", "registry.example.com:5000"},
+		{"localhost/web", "localhost"},
+		{"localhost:5000/web:1.0", "localhost:5000"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.image, func(t *testing.T) {
+			assert.Equal(t, test.expected, registryHost(test.image))
+		})
+	}
+}
+
+func TestPullImageRegistryCredentials(t *testing.T) {
+	docker := newFakeDocker()
+	dc := newTestController(docker)
+
+	dc.SetRegistryCredentials(map[string]RegistryCredentials{
+		"ghcr.io": {Username: "deploy", Password: "secret"},
+	})
+
+	assert.Nil(t, dc.PullImage(context.Background(), "ghcr.io/xiovv/web:1.0"))
+	assert.Nil(t, dc.PullImage(context.Background(), "nginx:1.21"))
+
+	data, err := base64.URLEncoding.DecodeString(docker.pulls["ghcr.io/xiovv/web:1.0"].RegistryAuth)
+	assert.Nil(t, err)
+
+	var auth types.AuthConfig
+	assert.Nil(t, json.Unmarshal(data, &auth))
+	assert.Equal(t, types.AuthConfig{Username: "deploy", Password: "secret", ServerAddress: "ghcr.io"}, auth)
+
+	assert.Empty(t, docker.pulls["nginx:1.21"].RegistryAuth)
+
+	// pulls after a reload use the new credentials
+	dc.SetRegistryCredentials(map[string]RegistryCredentials{
+		"ghcr.io": {IdentityToken: "token"},
+	})
+
+	assert.Nil(t, dc.PullImage(context.Background(), "ghcr.io/xiovv/web:1.1"))
+
+	data, err = base64.URLEncoding.DecodeString(docker.pulls["ghcr.io/xiovv/web:1.1"].RegistryAuth)
+	assert.Nil(t, err)
+
+	var reloaded types.AuthConfig
+	assert.Nil(t, json.Unmarshal(data, &reloaded))
+	assert.Equal(t, types.AuthConfig{IdentityToken: "token", ServerAddress: "ghcr.io"}, reloaded)
+}
@@ -0,0 +1,386 @@
+package controller
+
+import (
+	"fmt"
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/go-connections/nat"
+	"math"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ContainerSpec describes the desired state of a container. Settings which are left empty aren't checked,
+// so a container may have more environment variables, labels, ports, volumes and networks than its spec.
+type ContainerSpec struct {
+	Name  string
+	Image string
+	Env   map[string]string
+
+	// Ports are published ports in the format of docker run -p, e.g. "8080:80" or "127.0.0.1:53:53/udp".
+	Ports []string
+
+	// Volumes are bind mounts and named volumes in the format of docker run -v, e.g. "/srv/www:/var/www:ro".
+	Volumes []string
+
+	Networks []string
+	Labels   map[string]string
+
+	// RestartPolicy is a restart policy in the format of docker run --restart, e.g. "unless-stopped" or "on-failure:5".
+	RestartPolicy string
+
+	Resources Resources
+}
+
+// Resources limits the memory and CPU time of a container. Zero values mean no limit.
+type Resources struct {
+	MemoryBytes int64
+	CPUs        float64
+}
+
+// Drift is a setting of a container which differs from its spec. The values of environment
+// variables are left out, since they often hold secrets.
+type Drift struct {
+	Setting string
+	Desired string
+	Actual  string
+}
+
+// volume is a parsed entry of ContainerSpec.Volumes.
+type volume struct {
+	Source      string
+	Destination string
+	ReadOnly    bool
+}
+
+// Validate checks the settings of the spec which docker would otherwise reject halfway through an operation.
+func (s ContainerSpec) Validate() error {
+	if s.Name == "" {
+		return ErrSpecInvalid{Setting: "name", Reason: "must not be empty"}
+	}
+
+	if !isValidImage(s.Image) {
+		return ErrSpecInvalid{Setting: "image", Reason: ErrImageFormatInvalid.Error()}
+	}
+
+	for name := range s.Env {
+		if name == "" || strings.Contains(name, "=") {
+			return ErrSpecInvalid{Setting: "env", Reason: fmt.Sprintf("invalid variable name %q", name)}
+		}
+	}
+
+	if _, _, err := nat.ParsePortSpecs(s.Ports); err != nil {
+		return ErrSpecInvalid{Setting: "ports", Reason: err.Error()}
+	}
+
+	for _, spec := range s.Volumes {
+		if _, err := parseVolume(spec); err != nil {
+			return ErrSpecInvalid{Setting: "volumes", Reason: err.Error()}
+		}
+	}
+
+	for _, network := range s.Networks {
+		if network == "" {
+			return ErrSpecInvalid{Setting: "networks", Reason: "network names must not be empty"}
+		}
+	}
+
+	if _, err := parseRestartPolicy(s.RestartPolicy); err != nil {
+		return ErrSpecInvalid{Setting: "restart_policy", Reason: err.Error()}
+	}
+
+	if s.Resources.MemoryBytes < 0 {
+		return ErrSpecInvalid{Setting: "resources.memory_bytes", Reason: "must not be negative"}
+	}
+
+	if s.Resources.CPUs < 0 || math.IsNaN(s.Resources.CPUs) || math.IsInf(s.Resources.CPUs, 0) {
+		return ErrSpecInvalid{Setting: "resources.cpus", Reason: "must be a positive number"}
+	}
+
+	return nil
+}
+
+// Diff returns the settings in which container differs from the spec, sorted by setting.
+// The spec must be valid.
+func (s ContainerSpec) Diff(container types.ContainerJSON) []Drift {
+	var drift []Drift
+
+	if container.Config.Image != s.Image {
+		drift = append(drift, Drift{Setting: "image", Desired: s.Image, Actual: container.Config.Image})
+	}
+
+	env := map[string]string{}
+	for _, variable := range container.Config.Env {
+		parts := strings.SplitN(variable, "=", 2)
+		if len(parts) == 2 {
+			env[parts[0]] = parts[1]
+		}
+	}
+
+	for _, name := range sortedKeys(s.Env) {
+		if value, ok := env[name]; !ok || value != s.Env[name] {
+			drift = append(drift, Drift{Setting: "env." + name})
+		}
+	}
+
+	for _, name := range sortedKeys(s.Labels) {
+		if value, ok := container.Config.Labels[name]; !ok || value != s.Labels[name] {
+			drift = append(drift, Drift{Setting: "labels." + name, Desired: s.Labels[name], Actual: value})
+		}
+	}
+
+	drift = append(drift, diffPorts(s.Ports, container)...)
+	drift = append(drift, diffVolumes(s.Volumes, container)...)
+
+	for _, network := range s.Networks {
+		connected := false
+		if container.NetworkSettings != nil {
+			_, connected = container.NetworkSettings.Networks[network]
+		}
+
+		if !connected {
+			drift = append(drift, Drift{Setting: "networks." + network, Desired: "connected", Actual: "not connected"})
+		}
+	}
+
+	drift = append(drift, s.diffHostConfig(container)...)
+
+	sort.SliceStable(drift, func(i, j int) bool { return drift[i].Setting < drift[j].Setting })
+
+	return drift
+}
+
+// patch returns the ConfigPatch which converges a container's environment, labels, restart policy and resource
+// limits to the spec. Like Diff, it leaves the settings which the spec doesn't set as they are.
+func (s ContainerSpec) patch() ConfigPatch {
+	patch := ConfigPatch{Env: s.Env, Labels: s.Labels, RestartPolicy: s.RestartPolicy}
+
+	if s.Resources.MemoryBytes != 0 {
+		memoryBytes := s.Resources.MemoryBytes
+		patch.MemoryBytes = &memoryBytes
+	}
+
+	if s.Resources.CPUs != 0 {
+		cpus := s.Resources.CPUs
+		patch.CPUs = &cpus
+	}
+
+	return patch
+}
+
+// diffHostConfig compares the restart policy and the resource limits, which are only checked if the spec sets them.
+func (s ContainerSpec) diffHostConfig(containerJson types.ContainerJSON) []Drift {
+	var hostConfig container.HostConfig
+	if containerJson.HostConfig != nil {
+		hostConfig = *containerJson.HostConfig
+	}
+
+	var drift []Drift
+
+	if s.RestartPolicy != "" {
+		desired, _ := parseRestartPolicy(s.RestartPolicy)
+		if formatted := formatRestartPolicy(desired); formatted != formatRestartPolicy(hostConfig.RestartPolicy) {
+			drift = append(drift, Drift{Setting: "restart_policy", Desired: formatted, Actual: formatRestartPolicy(hostConfig.RestartPolicy)})
+		}
+	}
+
+	if s.Resources.MemoryBytes != 0 && s.Resources.MemoryBytes != hostConfig.Memory {
+		drift = append(drift, Drift{
+			Setting: "resources.memory_bytes",
+			Desired: strconv.FormatInt(s.Resources.MemoryBytes, 10),
+			Actual:  strconv.FormatInt(hostConfig.Memory, 10),
+		})
+	}
+
+	if s.Resources.CPUs != 0 && nanoCPUs(s.Resources.CPUs) != hostConfig.NanoCPUs {
+		drift = append(drift, Drift{
+			Setting: "resources.cpus",
+			Desired: strconv.FormatFloat(s.Resources.CPUs, 'f', -1, 64),
+			Actual:  strconv.FormatFloat(float64(hostConfig.NanoCPUs)/1e9, 'f', -1, 64),
+		})
+	}
+
+	return drift
+}
+
+func diffPorts(ports []string, container types.ContainerJSON) []Drift {
+	_, bindings, _ := nat.ParsePortSpecs(ports)
+
+	var drift []Drift
+	for port, desired := range bindings {
+		var actual []nat.PortBinding
+		if container.HostConfig != nil {
+			actual = container.HostConfig.PortBindings[port]
+		}
+
+		for _, binding := range desired {
+			if !hasPortBinding(actual, binding) {
+				drift = append(drift, Drift{Setting: "ports." + string(port), Desired: formatPortBindings(desired), Actual: formatPortBindings(actual)})
+				break
+			}
+		}
+	}
+
+	return drift
+}
+
+func hasPortBinding(bindings []nat.PortBinding, binding nat.PortBinding) bool {
+	for _, candidate := range bindings {
+		if normalizeHostIP(candidate.HostIP) == normalizeHostIP(binding.HostIP) && candidate.HostPort == binding.HostPort {
+			return true
+		}
+	}
+
+	return false
+}
+
+// normalizeHostIP treats the unspecified address like an empty host IP, since both publish on all interfaces.
+func normalizeHostIP(ip string) string {
+	if ip == "0.0.0.0" {
+		return ""
+	}
+
+	return ip
+}
+
+func formatPortBindings(bindings []nat.PortBinding) string {
+	formatted := make([]string, 0, len(bindings))
+	for _, binding := range bindings {
+		if ip := normalizeHostIP(binding.HostIP); ip != "" {
+			formatted = append(formatted, ip+":"+binding.HostPort)
+		} else {
+			formatted = append(formatted, binding.HostPort)
+		}
+	}
+
+	return strings.Join(formatted, ",")
+}
+
+func diffVolumes(volumes []string, container types.ContainerJSON) []Drift {
+	var drift []Drift
+
+	for _, spec := range volumes {
+		desired, _ := parseVolume(spec)
+		actual := "not mounted"
+
+		for _, mount := range container.Mounts {
+			if mount.Destination != desired.Destination {
+				continue
+			}
+
+			mounted := volume{Source: mount.Source, Destination: mount.Destination, ReadOnly: !mount.RW}
+			if mount.Name != "" && !filepath.IsAbs(desired.Source) {
+				mounted.Source = mount.Name
+			}
+
+			if mounted == desired {
+				actual = ""
+			} else {
+				actual = formatVolume(mounted)
+			}
+			break
+		}
+
+		if actual != "" {
+			drift = append(drift, Drift{Setting: "volumes." + desired.Destination, Desired: formatVolume(desired), Actual: actual})
+		}
+	}
+
+	return drift
+}
+
+// parseVolume parses a volume in the format of docker run -v: source:destination, optionally followed by :ro or :rw.
+func parseVolume(spec string) (volume, error) {
+	parts := strings.Split(spec, ":")
+	if len(parts) < 2 || len(parts) > 3 || parts[0] == "" || parts[1] == "" {
+		return volume{}, fmt.Errorf("%q must be source:destination, optionally followed by :ro or :rw", spec)
+	}
+
+	if !filepath.IsAbs(parts[1]) {
+		return volume{}, fmt.Errorf("destination of %q must be an absolute path", spec)
+	}
+
+	parsed := volume{Source: parts[0], Destination: parts[1]}
+	if len(parts) == 3 {
+		switch parts[2] {
+		case "ro":
+			parsed.ReadOnly = true
+		case "rw":
+		default:
+			return volume{}, fmt.Errorf("mode of %q must be ro or rw", spec)
+		}
+	}
+
+	return parsed, nil
+}
+
+// parseRestartPolicy parses a restart policy in the format of docker run --restart. An empty policy is docker's default, "no".
+func parseRestartPolicy(policy string) (container.RestartPolicy, error) {
+	parts := strings.SplitN(policy, ":", 2)
+
+	switch parts[0] {
+	case "", "no":
+		parts[0] = "no"
+	case "always", "unless-stopped":
+	case "on-failure":
+		if len(parts) == 2 {
+			retries, err := strconv.Atoi(parts[1])
+			if err != nil || retries < 0 {
+				return container.RestartPolicy{}, fmt.Errorf("maximum retry count of %q must be a positive number", policy)
+			}
+
+			return container.RestartPolicy{Name: parts[0], MaximumRetryCount: retries}, nil
+		}
+	default:
+		return container.RestartPolicy{}, fmt.Errorf("%q must be one of no, always, unless-stopped or on-failure[:max-retries]", policy)
+	}
+
+	if len(parts) == 2 {
+		return container.RestartPolicy{}, fmt.Errorf("only the on-failure policy of %q takes a maximum retry count", policy)
+	}
+
+	return container.RestartPolicy{Name: parts[0]}, nil
+}
+
+func formatRestartPolicy(policy container.RestartPolicy) string {
+	switch {
+	case policy.Name == "":
+		return "no"
+	case policy.MaximumRetryCount > 0:
+		return policy.Name + ":" + strconv.Itoa(policy.MaximumRetryCount)
+	}
+
+	return policy.Name
+}
+
+// nanoCPUs converts a number of CPUs to the unit of HostConfig.NanoCPUs.
+func nanoCPUs(cpus float64) int64 {
+	return int64(math.Round(cpus * 1e9))
+}
+
+func formatVolume(v volume) string {
+	if v.ReadOnly {
+		return v.Source + ":" + v.Destination + ":ro"
+	}
+
+	return v.Source + ":" + v.Destination
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
+
+// isValidImage reports whether image is in the name:tag format required by pulls and updates.
+func isValidImage(image string) bool {
+	imageParts := strings.Split(image, ":")
+
+	return len(imageParts) == 2 && imageParts[0] != "" && imageParts[1] != ""
+}
@@ -0,0 +1,50 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"github.com/XiovV/dokkup-agent/metrics"
+	"github.com/docker/docker/client"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var tracer = otel.Tracer("github.com/XiovV/dokkup-agent/controller")
+
+// startOperation starts the span covering a whole update, rollback or pull.
+func startOperation(ctx context.Context, operation string, attributes ...attribute.KeyValue) (context.Context, trace.Span) {
+	return tracer.Start(ctx, "controller."+operation, trace.WithAttributes(attributes...))
+}
+
+// endSpan records err on the span, if any, and ends it.
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+
+	span.End()
+}
+
+// traceDockerCall starts a child span for a call to the docker API. The returned function
+// must be called with the result of the call; it ends the span, counts failed calls and
+// returns the error, wrapped with ErrDockerUnavailable if the daemon couldn't be reached.
+func traceDockerCall(ctx context.Context, call string, attributes ...attribute.KeyValue) (context.Context, func(error) error) {
+	ctx, span := tracer.Start(ctx, "docker."+call, trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(attributes...))
+
+	return ctx, func(err error) error {
+		if err != nil {
+			metrics.DockerAPIErrors.WithLabelValues(call).Inc()
+		}
+
+		if client.IsErrConnectionFailed(err) {
+			err = fmt.Errorf("%w: %s", ErrDockerUnavailable, err)
+		}
+
+		endSpan(span, err)
+
+		return err
+	}
+}
@@ -0,0 +1,220 @@
+// Package listen opens the listeners the APIs are served on: TCP addresses,
+// Unix sockets and sockets passed by systemd through socket activation.
+package listen
+
+import (
+	"errors"
+	"fmt"
+	"github.com/XiovV/dokkup-agent/config"
+	"net"
+	"os"
+	"os/user"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// listenFDsStart is the first file descriptor passed by systemd.
+const listenFDsStart = 3
+
+// Sockets are the sockets passed by systemd. Every socket can only be taken by a single listener.
+type Sockets struct {
+	files []*os.File
+	names []string
+	taken []bool
+}
+
+// SystemdSockets returns the sockets passed through LISTEN_PID, LISTEN_FDS and LISTEN_FDNAMES,
+// or no sockets if the agent wasn't socket activated. The variables are unset afterwards,
+// so they aren't inherited by child processes.
+func SystemdSockets() (*Sockets, error) {
+	defer func() {
+		_ = os.Unsetenv("LISTEN_PID")
+		_ = os.Unsetenv("LISTEN_FDS")
+		_ = os.Unsetenv("LISTEN_FDNAMES")
+	}()
+
+	sockets := &Sockets{}
+
+	pid, err := strconv.Atoi(os.Getenv("LISTEN_PID"))
+	if err != nil || pid != os.Getpid() {
+		return sockets, nil
+	}
+
+	count, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || count < 0 {
+		return nil, fmt.Errorf("invalid LISTEN_FDS %q", os.Getenv("LISTEN_FDS"))
+	}
+
+	names := strings.Split(os.Getenv("LISTEN_FDNAMES"), ":")
+
+	for i := 0; i < count; i++ {
+		fd := listenFDsStart + i
+		syscall.CloseOnExec(fd)
+
+		name := ""
+		if i < len(names) {
+			name = names[i]
+		}
+
+		sockets.files = append(sockets.files, os.NewFile(uintptr(fd), name))
+		sockets.names = append(sockets.names, name)
+		sockets.taken = append(sockets.taken, false)
+	}
+
+	return sockets, nil
+}
+
+// Untaken returns the names of the sockets which no listener has taken.
+func (s *Sockets) Untaken() []string {
+	var names []string
+	for i, taken := range s.taken {
+		if !taken {
+			names = append(names, s.names[i])
+		}
+	}
+
+	return names
+}
+
+// take returns listeners for the sockets with the given FileDescriptorName, or for all sockets
+// which haven't been taken yet if name is empty.
+func (s *Sockets) take(name string) ([]net.Listener, error) {
+	var listeners []net.Listener
+
+	for i, file := range s.files {
+		if s.taken[i] || (name != "" && s.names[i] != name) {
+			continue
+		}
+
+		listener, err := net.FileListener(file)
+		if err != nil {
+			return nil, fmt.Errorf("systemd socket %d (%s) isn't a listening socket: %w", listenFDsStart+i, s.names[i], err)
+		}
+
+		// net.FileListener duplicates the descriptor
+		_ = file.Close()
+		s.taken[i] = true
+
+		listeners = append(listeners, listener)
+	}
+
+	if len(listeners) == 0 {
+		if name == "" {
+			return nil, errors.New("no sockets were passed by systemd")
+		}
+
+		return nil, fmt.Errorf("no socket named %q was passed by systemd", name)
+	}
+
+	return listeners, nil
+}
+
+// Open opens the listeners for cfg. A systemd address may return more than one listener.
+func Open(cfg config.ListenConfig, sockets *Sockets) ([]net.Listener, error) {
+	switch {
+	case cfg.IsSystemd():
+		return sockets.take(cfg.SystemdName())
+	case cfg.IsUnix():
+		listener, err := openUnix(cfg)
+		if err != nil {
+			return nil, err
+		}
+
+		return []net.Listener{listener}, nil
+	}
+
+	listener, err := net.Listen("tcp", cfg.Address)
+	if err != nil {
+		return nil, err
+	}
+
+	return []net.Listener{listener}, nil
+}
+
+// openUnix creates a Unix socket, replacing a socket left behind by a previous run,
+// and applies the configured owner, group and mode to it.
+func openUnix(cfg config.ListenConfig) (net.Listener, error) {
+	path := strings.TrimPrefix(cfg.Address, config.UnixAddressPrefix)
+
+	if info, err := os.Lstat(path); err == nil {
+		if info.Mode()&os.ModeSocket == 0 {
+			return nil, fmt.Errorf("%s exists and isn't a socket", path)
+		}
+
+		if err := os.Remove(path); err != nil {
+			return nil, err
+		}
+	}
+
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := applyOwnership(path, cfg); err != nil {
+		_ = listener.Close()
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	return listener, nil
+}
+
+func applyOwnership(path string, cfg config.ListenConfig) error {
+	mode, err := cfg.FileMode()
+	if err != nil {
+		return err
+	}
+
+	if mode != 0 {
+		if err := os.Chmod(path, os.FileMode(mode)); err != nil {
+			return err
+		}
+	}
+
+	if cfg.Owner == "" && cfg.Group == "" {
+		return nil
+	}
+
+	uid, gid := -1, -1
+
+	if cfg.Owner != "" {
+		if uid, err = lookupID(cfg.Owner, func(name string) (string, error) {
+			u, err := user.Lookup(name)
+			if err != nil {
+				return "", err
+			}
+			return u.Uid, nil
+		}); err != nil {
+			return fmt.Errorf("unknown owner %q: %w", cfg.Owner, err)
+		}
+	}
+
+	if cfg.Group != "" {
+		if gid, err = lookupID(cfg.Group, func(name string) (string, error) {
+			g, err := user.LookupGroup(name)
+			if err != nil {
+				return "", err
+			}
+			return g.Gid, nil
+		}); err != nil {
+			return fmt.Errorf("unknown group %q: %w", cfg.Group, err)
+		}
+	}
+
+	return os.Chown(path, uid, gid)
+}
+
+// lookupID returns value if it's a numeric id, or looks up the id of the name otherwise.
+func lookupID(value string, lookup func(string) (string, error)) (int, error) {
+	if id, err := strconv.Atoi(value); err == nil {
+		return id, nil
+	}
+
+	id, err := lookup(value)
+	if err != nil {
+		return 0, err
+	}
+
+	return strconv.Atoi(id)
+}
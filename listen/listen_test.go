@@ -0,0 +1,99 @@
+package listen
+
+import (
+	"github.com/XiovV/dokkup-agent/config"
+	"github.com/stretchr/testify/assert"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+)
+
+func TestOpenUnix(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "agent.sock")
+	cfg := config.ListenConfig{Address: config.UnixAddressPrefix + path, Mode: "0660", Owner: strconv.Itoa(os.Getuid())}
+
+	// a socket left behind by a previous run is replaced
+	stale, err := net.Listen("unix", path)
+	assert.Nil(t, err)
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	assert.Nil(t, stale.Close())
+
+	listeners, err := Open(cfg, &Sockets{})
+	assert.Nil(t, err)
+	assert.Len(t, listeners, 1)
+	defer listeners[0].Close()
+
+	info, err := os.Stat(path)
+	assert.Nil(t, err)
+	assert.Equal(t, os.FileMode(0660), info.Mode().Perm())
+
+	conn, err := net.Dial("unix", path)
+	assert.Nil(t, err)
+	conn.Close()
+}
+
+func TestOpenUnixNotASocket(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "agent.sock")
+	assert.Nil(t, os.WriteFile(path, nil, 0600))
+
+	_, err := Open(config.ListenConfig{Address: config.UnixAddressPrefix + path}, &Sockets{})
+	assert.NotNil(t, err)
+}
+
+func TestSystemdSockets(t *testing.T) {
+	t.Run("Not socket activated", func(t *testing.T) {
+		t.Setenv("LISTEN_PID", strconv.Itoa(os.Getpid()+1))
+		t.Setenv("LISTEN_FDS", "2")
+
+		sockets, err := SystemdSockets()
+		assert.Nil(t, err)
+		assert.Empty(t, sockets.files)
+
+		_, set := os.LookupEnv("LISTEN_FDS")
+		assert.False(t, set)
+	})
+
+	t.Run("Invalid LISTEN_FDS", func(t *testing.T) {
+		t.Setenv("LISTEN_PID", strconv.Itoa(os.Getpid()))
+		t.Setenv("LISTEN_FDS", "two")
+
+		_, err := SystemdSockets()
+		assert.NotNil(t, err)
+	})
+}
+
+// fakeSystemdSocket returns the file of a listening socket, like the ones passed by systemd.
+func fakeSystemdSocket(t *testing.T) *os.File {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	defer listener.Close()
+
+	file, err := listener.(*net.TCPListener).File()
+	assert.Nil(t, err)
+
+	return file
+}
+
+func TestOpenSystemd(t *testing.T) {
+	sockets := &Sockets{
+		files: []*os.File{fakeSystemdSocket(t), fakeSystemdSocket(t), fakeSystemdSocket(t)},
+		names: []string{"api", "grpc", "api"},
+		taken: make([]bool, 3),
+	}
+
+	listeners, err := Open(config.ListenConfig{Address: "systemd:api"}, sockets)
+	assert.Nil(t, err)
+	assert.Len(t, listeners, 2)
+
+	_, err = Open(config.ListenConfig{Address: "systemd:api"}, sockets)
+	assert.NotNil(t, err)
+
+	assert.Equal(t, []string{"grpc"}, sockets.Untaken())
+
+	listeners, err = Open(config.ListenConfig{Address: "systemd"}, sockets)
+	assert.Nil(t, err)
+	assert.Len(t, listeners, 1)
+	assert.Empty(t, sockets.Untaken())
+}
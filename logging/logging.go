@@ -0,0 +1,80 @@
+// Package logging builds the structured logger shared by the agent's packages.
+package logging
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"io"
+)
+
+const (
+	FormatJSON   = "json"
+	FormatLogfmt = "logfmt"
+)
+
+// New returns a logger which writes to out in the requested format ("json" or "logfmt")
+// and discards everything below level.
+func New(out io.Writer, format, level string) (*logrus.Logger, error) {
+	logger := logrus.New()
+	logger.SetOutput(out)
+
+	switch format {
+	case FormatJSON:
+		logger.SetFormatter(&logrus.JSONFormatter{})
+	case FormatLogfmt, "":
+		logger.SetFormatter(&logrus.TextFormatter{DisableColors: true, FullTimestamp: true})
+	default:
+		return nil, fmt.Errorf("unknown log format %q", format)
+	}
+
+	if level == "" {
+		level = logrus.InfoLevel.String()
+	}
+
+	parsedLevel, err := logrus.ParseLevel(level)
+	if err != nil {
+		return nil, err
+	}
+	logger.SetLevel(parsedLevel)
+
+	return logger, nil
+}
+
+type requestIDKey struct{}
+
+type operationIDKey struct{}
+
+// WithRequestID returns a copy of ctx carrying the request's correlation id.
+func WithRequestID(ctx context.Context, requestID string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, requestID)
+}
+
+// RequestID returns the correlation id stored in ctx, or an empty string if there isn't one.
+func RequestID(ctx context.Context) string {
+	requestID, _ := ctx.Value(requestIDKey{}).(string)
+	return requestID
+}
+
+// WithOperationID returns a copy of ctx carrying the id of the operation started by the request.
+func WithOperationID(ctx context.Context, operationID string) context.Context {
+	return context.WithValue(ctx, operationIDKey{}, operationID)
+}
+
+// OperationID returns the operation id stored in ctx, or an empty string if there isn't one.
+func OperationID(ctx context.Context) string {
+	operationID, _ := ctx.Value(operationIDKey{}).(string)
+	return operationID
+}
+
+// NewID returns a random identifier used for correlating log lines of a single request or operation.
+func NewID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "unknown"
+	}
+
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,65 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+)
+
+func TestNew(t *testing.T) {
+	t.Run("JSON format", func(t *testing.T) {
+		var out bytes.Buffer
+
+		logger, err := New(&out, FormatJSON, "info")
+		assert.Nil(t, err)
+
+		logger.WithField("container", "web").Info("updating")
+
+		var line map[string]interface{}
+		err = json.Unmarshal(out.Bytes(), &line)
+		assert.Nil(t, err)
+
+		assert.Equal(t, "web", line["container"])
+		assert.Equal(t, "updating", line["msg"])
+	})
+
+	t.Run("Logfmt format", func(t *testing.T) {
+		var out bytes.Buffer
+
+		logger, err := New(&out, FormatLogfmt, "info")
+		assert.Nil(t, err)
+
+		logger.WithField("container", "web").Info("updating")
+
+		assert.True(t, strings.Contains(out.String(), "container=web"))
+		assert.True(t, strings.Contains(out.String(), "msg=updating"))
+	})
+
+	t.Run("Lines below the level are discarded", func(t *testing.T) {
+		var out bytes.Buffer
+
+		logger, err := New(&out, FormatLogfmt, "warn")
+		assert.Nil(t, err)
+
+		logger.Info("not logged")
+
+		assert.Empty(t, out.String())
+	})
+
+	t.Run("Invalid format", func(t *testing.T) {
+		_, err := New(&bytes.Buffer{}, "xml", "info")
+		assert.NotNil(t, err)
+	})
+
+	t.Run("Invalid level", func(t *testing.T) {
+		_, err := New(&bytes.Buffer{}, FormatJSON, "loud")
+		assert.NotNil(t, err)
+	})
+}
+
+func TestNewID(t *testing.T) {
+	assert.Len(t, NewID(), 16)
+	assert.NotEqual(t, NewID(), NewID())
+}
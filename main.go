@@ -1,32 +1,267 @@
 package main
 
 import (
+	"context"
+	"crypto/tls"
+	"flag"
 	"fmt"
 	"github.com/XiovV/dokkup-agent/app"
+	"github.com/XiovV/dokkup-agent/audit"
+	"github.com/XiovV/dokkup-agent/auth"
 	"github.com/XiovV/dokkup-agent/config"
 	"github.com/XiovV/dokkup-agent/controller"
+	"github.com/XiovV/dokkup-agent/listen"
+	"github.com/XiovV/dokkup-agent/logging"
+	"github.com/XiovV/dokkup-agent/metrics"
+	"github.com/XiovV/dokkup-agent/tracing"
 	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
 	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 )
 
+// dockerWatchInterval is how often the docker daemon is pinged to detect whether it's reachable.
+const dockerWatchInterval = 10 * time.Second
+
+// defaultConfigFile is used when neither --config nor DOKKUP_CONFIG are given.
+const defaultConfigFile = "config.json"
+
 func main() {
+	configFile := defaultConfigFile
+	if env, ok := os.LookupEnv(config.EnvPrefix + "CONFIG"); ok {
+		configFile = env
+	}
+
+	initialKeyFile := os.Getenv(config.EnvPrefix + "INITIAL_KEY_FILE")
+	bootstrapToken := os.Getenv(config.EnvPrefix + "BOOTSTRAP_TOKEN")
+
+	flags := flag.NewFlagSet("dokkup-agent", flag.ExitOnError)
+	flags.StringVar(&configFile, "config", configFile, "")
+	flags.StringVar(&initialKeyFile, "initial-key-file", initialKeyFile, "")
+	flags.Usage = func() { fmt.Fprint(os.Stderr, usage) }
+	_ = flags.Parse(os.Args[1:])
+
+	if flags.NArg() > 0 {
+		os.Exit(runCommand(flags.Args(), configFile))
+	}
+
 	gin.SetMode(gin.ReleaseMode)
 
-	dockerController := controller.New()
+	if bootstrapToken != "" && len(bootstrapToken) < minBootstrapTokenLength {
+		log.Fatalf("%sBOOTSTRAP_TOKEN must be at least %d characters long", config.EnvPrefix, minBootstrapTokenLength)
+	}
+
+	cfg, apiKey, err := config.New(configFile)
+	if err != nil {
+		log.Fatal(fmt.Errorf("couldn't load config: %w", err))
+	}
+
+	logger, err := logging.New(os.Stdout, cfg.Log.Format, cfg.Log.Level)
+	if err != nil {
+		log.Fatal(fmt.Errorf("invalid log config: %w", err))
+	}
+
+	// the api key is only returned when the config file was just created
+	pendingFile := bootstrapKeyFile(configFile)
+	if apiKey != "" {
+		if err := handOverAPIKey(apiKey, initialKeyFile, pendingFile, bootstrapToken, logger); err != nil {
+			// a config file whose key nobody has is useless, so the next start creates a new one
+			_ = os.Remove(configFile)
+			logger.WithError(err).Fatal("couldn't hand over the new api key")
+		}
+	}
+
+	pendingKey, err := pendingAPIKey(pendingFile, bootstrapToken)
+	if err != nil {
+		logger.WithError(err).Fatal("couldn't hand over the new api key")
+	}
+
+	logger.Info("successfully loaded config")
+
+	shutdownTracing, err := tracing.Setup(context.Background(), cfg.Tracing)
+	if err != nil {
+		logger.WithError(err).Fatal("couldn't set up tracing")
+	}
+	defer func() {
+		if err := shutdownTracing(context.Background()); err != nil {
+			logger.WithError(err).Error("couldn't flush traces")
+		}
+	}()
+
+	dockerController, err := controller.New(logger, controller.Timeouts{
+		Update:   time.Duration(cfg.Timeouts.UpdateSeconds) * time.Second,
+		Rollback: time.Duration(cfg.Timeouts.RollbackSeconds) * time.Second,
+		Pull:     time.Duration(cfg.Timeouts.PullSeconds) * time.Second,
+		Read:     time.Duration(cfg.Timeouts.ReadSeconds) * time.Second,
+		Restore:  time.Duration(cfg.Timeouts.RestoreSeconds) * time.Second,
+	})
+	if err != nil {
+		logger.WithError(err).Fatal("couldn't set up the docker controller")
+	}
+
+	// the agent starts even if docker is unreachable, and serves 503s until it comes back
+	if err := dockerController.Ping(context.Background()); err != nil {
+		logger.WithError(err).Warn("docker daemon is unreachable, starting in degraded mode")
+	}
+	go dockerController.WatchDocker(context.Background(), dockerWatchInterval)
+
+	if err := metrics.RegisterContainerStates(dockerController); err != nil {
+		logger.WithError(err).Fatal("couldn't register container metrics")
+	}
+
+	var jwtVerifier *auth.JWTVerifier
+	if cfg.JWT != nil {
+		jwtVerifier, err = auth.NewJWTVerifier(*cfg.JWT)
+		if err != nil {
+			logger.WithError(err).Fatal("couldn't set up JWT authentication")
+		}
+
+		logger.Info("JWT authentication is enabled")
+	}
+
+	auditLog, err := audit.Open(cfg.AuditLog)
+	if err != nil {
+		logger.WithError(err).Fatal("couldn't open the audit log")
+	}
+	defer auditLog.Close()
+
+	// the chain can't tell that entries were cut off its end, so its head is logged to be compared against later
+	auditHash, auditEntries := auditLog.Head()
+	logger.WithFields(logrus.Fields{"audit_hash": auditHash, "audit_entries": auditEntries}).Info("opened the audit log")
+	auditLog.OnRecord(func(hash string, entries int) {
+		logger.WithFields(logrus.Fields{"audit_hash": hash, "audit_entries": entries}).Info("recorded audit entry")
+	})
+
+	var tlsConfig *tls.Config
+	if cfg.TLS != nil {
+		tlsConfig, err = cfg.TLS.ServerConfig()
+		if err != nil {
+			logger.WithError(err).Fatal("couldn't set up TLS")
+		}
+
+		logger.Info("TLS is enabled")
+	}
+
+	app := app.New(dockerController, cfg, jwtVerifier, auditLog, logger)
+
+	if pendingKey != "" {
+		app.EnableBootstrap(bootstrapToken, pendingKey, func() {
+			if err := os.Remove(pendingFile); err != nil {
+				logger.WithError(err).Errorf("couldn't remove %s, delete it to keep the claimed api key from being claimed again", pendingFile)
+			}
+		})
+		logger.Info("the new api key can be claimed once with the bootstrap token through POST /v1/bootstrap")
+	}
 
-	cfg, _, err := config.New("config.json")
+	reloadCtx, stopReloading := context.WithCancel(context.Background())
+	defer stopReloading()
+	go reloadConfig(reloadCtx, app, configFile, cfg, logger)
+
+	sockets, err := listen.SystemdSockets()
 	if err != nil {
-		panic(err)
+		logger.WithError(err).Fatal("couldn't read the sockets passed by systemd")
+	}
+
+	var grpcListeners []net.Listener
+	if cfg.GRPC != nil {
+		grpcListeners = openListeners([]config.ListenConfig{cfg.GRPC.ListenConfig}, sockets, logger)
 	}
 
-	fmt.Println("Successfully loaded config")
+	listeners := openListeners(cfg.Listen, sockets, logger)
+
+	if untaken := sockets.Untaken(); len(untaken) > 0 {
+		logger.WithField("sockets", untaken).Warn("some sockets passed by systemd aren't used by any listener")
+	}
 
-	app := app.New(dockerController, cfg)
+	// the requests' contexts are derived from abortCtx, so cancelling it aborts the requests which
+	// are still in progress when the shutdown deadline is reached
+	abortCtx, abort := context.WithCancel(context.Background())
+	defer abort()
+
+	var reconciler *controller.Reconciler
+	if cfg.Reconcile != nil {
+		reconciler = startReconciler(abortCtx, dockerController, cfg.Reconcile, app.RecordReconcilerOperation, logger)
+		app.EnableReconciler(reconciler)
+	}
+
+	server := &http.Server{
+		Handler:     app.Router(),
+		TLSConfig:   tlsConfig,
+		BaseContext: func(net.Listener) context.Context { return abortCtx },
+	}
 
-	router := app.Router()
+	errs := make(chan error, len(listeners)+len(grpcListeners))
+	for _, listener := range listeners {
+		go func(listener net.Listener) {
+			logger.Infof("agent is listening on %s", listenerAddress(listener))
 
-	fmt.Println("agent is listening on :8080")
-	if err := router.Run(":8080"); err != nil {
-		log.Fatal(err)
+			// Unix sockets are local-only and protected by their file mode, so they're served without TLS
+			if tlsConfig != nil && listener.Addr().Network() != "unix" {
+				errs <- server.ServeTLS(listener, "", "")
+			} else {
+				errs <- server.Serve(listener)
+			}
+		}(listener)
 	}
+
+	var grpcServer *grpc.Server
+	if cfg.GRPC != nil {
+		var opts []grpc.ServerOption
+		if tlsConfig != nil {
+			opts = append(opts, grpc.Creds(credentials.NewTLS(tlsConfig)))
+		}
+
+		grpcServer = app.GRPCServer(opts...)
+		for _, listener := range grpcListeners {
+			go func(listener net.Listener) {
+				logger.Infof("gRPC API is listening on %s", listenerAddress(listener))
+				errs <- grpcServer.Serve(listener)
+			}(listener)
+		}
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, os.Interrupt)
+
+	select {
+	case err := <-errs:
+		logger.WithError(err).Fatal("server stopped")
+	case sig := <-signals:
+		logger.WithField("signal", sig.String()).Info("shutting down")
+	}
+
+	stopReloading()
+	shutdown(app, server, grpcServer, reconciler, abort, cfg.Timeouts, logger)
+}
+
+// openListeners opens the listeners of every config, exiting if any of them can't be opened.
+func openListeners(configs []config.ListenConfig, sockets *listen.Sockets, logger logrus.FieldLogger) []net.Listener {
+	var listeners []net.Listener
+
+	for _, cfg := range configs {
+		opened, err := listen.Open(cfg, sockets)
+		if err != nil {
+			logger.WithError(err).WithField("address", cfg.Address).Fatal("couldn't listen")
+		}
+
+		listeners = append(listeners, opened...)
+	}
+
+	return listeners
+}
+
+// listenerAddress returns the address of listener the way it's written in the config.
+func listenerAddress(listener net.Listener) string {
+	if listener.Addr().Network() == "unix" {
+		return config.UnixAddressPrefix + listener.Addr().String()
+	}
+
+	return listener.Addr().String()
 }
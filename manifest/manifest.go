@@ -0,0 +1,190 @@
+// Package manifest reads the files which describe the desired state of containers, see controller.Reconciler.
+package manifest
+
+import (
+	"errors"
+	"fmt"
+	"github.com/XiovV/dokkup-agent/config"
+	"github.com/XiovV/dokkup-agent/controller"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Defaults of the probe settings which are left out of a manifest.
+const (
+	DefaultProbeTimeout  = 5 * time.Second
+	DefaultProbeInterval = 5 * time.Second
+	DefaultProbeRetries  = 3
+)
+
+// ErrManifestInvalid is returned when a manifest can't be parsed, or has unknown or invalid settings.
+var ErrManifestInvalid = errors.New("manifest is invalid")
+
+// manifest is the format of a manifest file. Like config files, manifests can be written in JSON, YAML or TOML.
+type manifest struct {
+	Name     string            `json:"name"`
+	Image    string            `json:"image"`
+	Env      map[string]string `json:"env,omitempty"`
+	Ports    []string          `json:"ports,omitempty"`
+	Volumes  []string          `json:"volumes,omitempty"`
+	Networks []string          `json:"networks,omitempty"`
+	Labels   map[string]string `json:"labels,omitempty"`
+
+	RestartPolicy string     `json:"restart_policy,omitempty"`
+	Resources     *resources `json:"resources,omitempty"`
+
+	Probe *probe `json:"probe,omitempty"`
+}
+
+type resources struct {
+	MemoryBytes int64   `json:"memory_bytes,omitempty"`
+	CPUs        float64 `json:"cpus,omitempty"`
+}
+
+type probe struct {
+	HTTP            string `json:"http,omitempty"`
+	TCP             string `json:"tcp,omitempty"`
+	TimeoutSeconds  int    `json:"timeout_seconds,omitempty"`
+	IntervalSeconds int    `json:"interval_seconds,omitempty"`
+	Retries         int    `json:"retries,omitempty"`
+}
+
+// Load reads the manifest file at filename.
+func Load(filename string) (controller.Manifest, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return controller.Manifest{}, err
+	}
+
+	var m manifest
+	if err := config.Decode(config.Format(filename), data, &m); err != nil {
+		return controller.Manifest{}, fmt.Errorf("%s: %w: %s", filename, ErrManifestInvalid, err)
+	}
+
+	parsed := controller.Manifest{
+		File: filename,
+		Spec: controller.ContainerSpec{
+			Name:          m.Name,
+			Image:         m.Image,
+			Env:           m.Env,
+			Ports:         m.Ports,
+			Volumes:       m.Volumes,
+			Networks:      m.Networks,
+			Labels:        m.Labels,
+			RestartPolicy: m.RestartPolicy,
+		},
+	}
+
+	if m.Resources != nil {
+		parsed.Spec.Resources = controller.Resources{MemoryBytes: m.Resources.MemoryBytes, CPUs: m.Resources.CPUs}
+	}
+
+	if err := parsed.Spec.Validate(); err != nil {
+		return controller.Manifest{}, fmt.Errorf("%s: %w: %s", filename, ErrManifestInvalid, err)
+	}
+
+	if m.Probe != nil {
+		if parsed.Probe, err = m.Probe.parse(); err != nil {
+			return controller.Manifest{}, fmt.Errorf("%s: %w: probe.%s", filename, ErrManifestInvalid, err)
+		}
+	}
+
+	return parsed, nil
+}
+
+// LoadDir reads the manifests in dir, sorted by file name. Only files with the extensions of
+// the supported formats are read, and hidden files (such as editors' swap files) are skipped.
+// Every container may only have a single manifest.
+func LoadDir(dir string) ([]controller.Manifest, error) {
+	// ReadDir sorts the files by name
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var manifests []controller.Manifest
+	described := map[string]string{}
+
+	for _, file := range files {
+		if file.IsDir() || strings.HasPrefix(file.Name(), ".") || !isManifestFile(file.Name()) {
+			continue
+		}
+
+		filename := filepath.Join(dir, file.Name())
+
+		m, err := Load(filename)
+		if err != nil {
+			return nil, err
+		}
+
+		if other, ok := described[m.Spec.Name]; ok {
+			return nil, fmt.Errorf("%s: %w: container %s is already described by %s", filename, ErrManifestInvalid, m.Spec.Name, other)
+		}
+		described[m.Spec.Name] = filename
+
+		manifests = append(manifests, m)
+	}
+
+	return manifests, nil
+}
+
+func isManifestFile(name string) bool {
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".json", ".yaml", ".yml", ".toml":
+		return true
+	}
+
+	return false
+}
+
+func (p *probe) parse() (*controller.Probe, error) {
+	switch {
+	case (p.HTTP == "") == (p.TCP == ""):
+		return nil, errors.New("http or tcp: exactly one of them must be set")
+	case p.TimeoutSeconds < 0:
+		return nil, errors.New("timeout_seconds: must not be negative")
+	case p.IntervalSeconds < 0:
+		return nil, errors.New("interval_seconds: must not be negative")
+	case p.Retries < 0:
+		return nil, errors.New("retries: must not be negative")
+	}
+
+	if p.HTTP != "" {
+		parsed, err := url.Parse(p.HTTP)
+		if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+			return nil, fmt.Errorf("http: %q is not an http or https URL", p.HTTP)
+		}
+	}
+
+	if p.TCP != "" {
+		if _, _, err := net.SplitHostPort(p.TCP); err != nil {
+			return nil, fmt.Errorf("tcp: %q is not a host:port address", p.TCP)
+		}
+	}
+
+	parsed := &controller.Probe{
+		HTTP:     p.HTTP,
+		TCP:      p.TCP,
+		Timeout:  time.Duration(p.TimeoutSeconds) * time.Second,
+		Interval: time.Duration(p.IntervalSeconds) * time.Second,
+		Retries:  p.Retries,
+	}
+
+	if parsed.Timeout == 0 {
+		parsed.Timeout = DefaultProbeTimeout
+	}
+
+	if parsed.Interval == 0 {
+		parsed.Interval = DefaultProbeInterval
+	}
+
+	if parsed.Retries == 0 {
+		parsed.Retries = DefaultProbeRetries
+	}
+
+	return parsed, nil
+}
@@ -0,0 +1,106 @@
+package manifest
+
+import (
+	"github.com/XiovV/dokkup-agent/controller"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func writeManifest(t *testing.T, dir, name, data string) {
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(dir, name), []byte(data), 0600))
+}
+
+func TestLoadDir(t *testing.T) {
+	dir := t.TempDir()
+
+	writeManifest(t, dir, "web.yaml", `
+name: web
+image: nginx:1.21
+env:
+  MODE: production
+ports: ["8080:80"]
+volumes: ["/srv/www:/usr/share/nginx/html:ro"]
+networks: [frontend]
+labels:
+  team: web
+restart_policy: unless-stopped
+resources:
+  memory_bytes: 268435456
+  cpus: 0.5
+probe:
+  http: http://127.0.0.1:8080/
+  retries: 5
+`)
+	writeManifest(t, dir, "api.json", `{"name": "api", "image": "api:2.0", "probe": {"tcp": "127.0.0.1:9000"}}`)
+	writeManifest(t, dir, "worker.toml", "name = \"worker\"\nimage = \"worker:1.0\"\n")
+	writeManifest(t, dir, "README.md", "not a manifest")
+	writeManifest(t, dir, ".web.yaml.swp", "not a manifest either")
+
+	manifests, err := LoadDir(dir)
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	assert.Len(t, manifests, 3)
+	assert.Equal(t, "api", manifests[0].Spec.Name)
+	assert.Equal(t, &controller.Probe{TCP: "127.0.0.1:9000", Timeout: DefaultProbeTimeout, Interval: DefaultProbeInterval, Retries: DefaultProbeRetries}, manifests[0].Probe)
+
+	assert.Equal(t, controller.ContainerSpec{
+		Name:          "web",
+		Image:         "nginx:1.21",
+		Env:           map[string]string{"MODE": "production"},
+		Ports:         []string{"8080:80"},
+		Volumes:       []string{"/srv/www:/usr/share/nginx/html:ro"},
+		Networks:      []string{"frontend"},
+		Labels:        map[string]string{"team": "web"},
+		RestartPolicy: "unless-stopped",
+		Resources:     controller.Resources{MemoryBytes: 268435456, CPUs: 0.5},
+	}, manifests[1].Spec)
+	assert.Equal(t, filepath.Join(dir, "web.yaml"), manifests[1].File)
+	assert.Equal(t, 5, manifests[1].Probe.Retries)
+	assert.Equal(t, 5*time.Second, manifests[1].Probe.Timeout)
+
+	assert.Equal(t, "worker", manifests[2].Spec.Name)
+	assert.Nil(t, manifests[2].Probe)
+}
+
+func TestLoadDirDuplicateContainer(t *testing.T) {
+	dir := t.TempDir()
+
+	writeManifest(t, dir, "a.json", `{"name": "web", "image": "nginx:1.21"}`)
+	writeManifest(t, dir, "b.json", `{"name": "web", "image": "nginx:1.22"}`)
+
+	_, err := LoadDir(dir)
+	assert.ErrorIs(t, err, ErrManifestInvalid)
+	assert.Contains(t, err.Error(), "already described by")
+}
+
+func TestLoadInvalid(t *testing.T) {
+	dir := t.TempDir()
+
+	for data, message := range map[string]string{
+		`{"name": "web", "image": "nginx"}`:                                      "image: image format is invalid",
+		`{"image": "nginx:1.21"}`:                                                "name: must not be empty",
+		`{"name": "web", "image": "nginx:1.21", "port": ["80"]}`:                 `unknown setting "port"`,
+		`{"name": "web", "image": "nginx:1.21", "ports": ["http"]}`:              "ports:",
+		`{"name": "web", "image": "nginx:1.21", "volumes": ["/srv/www"]}`:        "volumes:",
+		`{"name": "web", "image": "nginx:1.21", "volumes": ["www:data"]}`:        "must be an absolute path",
+		`{"name": "web", "image": "nginx:1.21", "restart_policy": "sometimes"}`:  "restart_policy:",
+		`{"name": "web", "image": "nginx:1.21", "restart_policy": "always:3"}`:   "maximum retry count",
+		`{"name": "web", "image": "nginx:1.21", "resources": {"cpus": -1}}`:      "resources.cpus:",
+		`{"name": "web", "image": "nginx:1.21", "probe": {}}`:                    "probe.http or tcp",
+		`{"name": "web", "image": "nginx:1.21", "probe": {"http": "localhost"}}`: "probe.http:",
+		`{"name": "web", "image": "nginx:1.21", "probe": {"tcp": "localhost"}}`:  "probe.tcp:",
+	} {
+		filename := filepath.Join(dir, "web.json")
+		writeManifest(t, dir, "web.json", data)
+
+		_, err := Load(filename)
+		if assert.ErrorIs(t, err, ErrManifestInvalid, data) {
+			assert.Contains(t, err.Error(), message, data)
+		}
+	}
+}
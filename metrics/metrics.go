@@ -0,0 +1,169 @@
+// Package metrics defines the Prometheus metrics exported by the agent.
+package metrics
+
+import (
+	"context"
+	"github.com/docker/docker/api/types"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const namespace = "dokkup"
+
+// collectTimeout limits how long listing containers can take during a scrape.
+const collectTimeout = 5 * time.Second
+
+const (
+	OutcomeSuccess = "success"
+	OutcomeFailure = "failure"
+)
+
+// UnknownContainer is the container label of operations on containers which don't exist.
+const UnknownContainer = "unknown"
+
+var (
+	Operations = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "operations_total",
+		Help:      "Number of creates, updates, rollbacks and pulls by container and outcome.",
+	}, []string{"operation", "container", "outcome"})
+
+	OperationDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "operation_duration_seconds",
+		Help:      "Duration of creates, updates, rollbacks and pulls.",
+		Buckets:   []float64{0.5, 1, 2.5, 5, 10, 30, 60, 120, 300, 600},
+	}, []string{"operation", "outcome"})
+
+	UpdateStepDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "update_step_duration_seconds",
+		Help:      "Duration of the individual steps of a container update.",
+		Buckets:   []float64{0.01, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30},
+	}, []string{"step"})
+
+	DockerAPIErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "docker_api_errors_total",
+		Help:      "Number of failed calls to the docker API.",
+	}, []string{"call"})
+
+	HTTPRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "Latency of HTTP requests by route.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method", "route", "status"})
+
+	DockerUp = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "docker_up",
+		Help:      "Whether the docker daemon was reachable on the last check (1) or not (0).",
+	})
+
+	AuditEntries = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "audit_entries",
+		Help:      "Number of entries in the audit log. It never decreases unless the log was truncated or rewritten.",
+	})
+)
+
+// Registry holds all of the agent's metrics along with the Go runtime and process metrics.
+var Registry = prometheus.NewRegistry()
+
+func init() {
+	Registry.MustRegister(
+		prometheus.NewGoCollector(),
+		prometheus.NewProcessCollector(prometheus.ProcessCollectorOpts{}),
+		Operations,
+		OperationDuration,
+		UpdateStepDuration,
+		DockerAPIErrors,
+		HTTPRequestDuration,
+		DockerUp,
+		AuditEntries,
+	)
+}
+
+// Handler returns the handler serving the metrics in the Prometheus exposition format.
+func Handler() http.Handler {
+	return promhttp.HandlerFor(Registry, promhttp.HandlerOpts{})
+}
+
+// ObserveOperation records the outcome and the duration of an operation which started at start. The container
+// label comes from requests, so callers pass UnknownContainer unless the container is known to exist.
+func ObserveOperation(operation, container string, start time.Time, err error) {
+	outcome := OutcomeSuccess
+	if err != nil {
+		outcome = OutcomeFailure
+	}
+
+	Operations.WithLabelValues(operation, container, outcome).Inc()
+	OperationDuration.WithLabelValues(operation, outcome).Observe(time.Since(start).Seconds())
+}
+
+// StepTimer measures the duration of consecutive steps of an update.
+type StepTimer struct {
+	last time.Time
+}
+
+func NewStepTimer() *StepTimer {
+	return &StepTimer{last: time.Now()}
+}
+
+// Step records the time elapsed since the previous step (or since the timer was created) under step.
+func (t *StepTimer) Step(step string) {
+	now := time.Now()
+	UpdateStepDuration.WithLabelValues(step).Observe(now.Sub(t.last).Seconds())
+	t.last = now
+}
+
+// ContainerLister is implemented by controllers which can list all containers.
+type ContainerLister interface {
+	ListContainers(context.Context) ([]types.Container, error)
+}
+
+// containerStateCollector exports the state of every container every time it's scraped.
+type containerStateCollector struct {
+	lister ContainerLister
+	state  *prometheus.Desc
+}
+
+// RegisterContainerStates registers a collector exporting the state of the containers returned by lister.
+func RegisterContainerStates(lister ContainerLister) error {
+	return Registry.Register(&containerStateCollector{
+		lister: lister,
+		state: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "container_state"),
+			"State of a container, the value is always 1.",
+			[]string{"container", "image", "state"}, nil,
+		),
+	})
+}
+
+func (c *containerStateCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.state
+}
+
+func (c *containerStateCollector) Collect(ch chan<- prometheus.Metric) {
+	ctx, cancel := context.WithTimeout(context.Background(), collectTimeout)
+	defer cancel()
+
+	containers, err := c.lister.ListContainers(ctx)
+	if err != nil {
+		ch <- prometheus.NewInvalidMetric(c.state, err)
+		return
+	}
+
+	for _, container := range containers {
+		name := container.ID
+		if len(container.Names) > 0 {
+			name = strings.TrimPrefix(container.Names[0], "/")
+		}
+
+		ch <- prometheus.MustNewConstMetric(c.state, prometheus.GaugeValue, 1, name, container.Image, container.State)
+	}
+}
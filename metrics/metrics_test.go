@@ -0,0 +1,51 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"github.com/docker/docker/api/types"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+	"time"
+)
+
+type fakeLister struct {
+	containers []types.Container
+	err        error
+}
+
+func (f fakeLister) ListContainers(context.Context) ([]types.Container, error) {
+	return f.containers, f.err
+}
+
+func TestObserveOperation(t *testing.T) {
+	ObserveOperation("update", "web", time.Now(), nil)
+	ObserveOperation("update", "web", time.Now(), errors.New("failed"))
+	ObserveOperation("update", "web", time.Now(), errors.New("failed"))
+
+	assert.Equal(t, float64(1), testutil.ToFloat64(Operations.WithLabelValues("update", "web", OutcomeSuccess)))
+	assert.Equal(t, float64(2), testutil.ToFloat64(Operations.WithLabelValues("update", "web", OutcomeFailure)))
+}
+
+func TestContainerStateCollector(t *testing.T) {
+	collector := &containerStateCollector{
+		lister: fakeLister{containers: []types.Container{
+			{Names: []string{"/web"}, Image: "web:1.0", State: "running"},
+			{Names: []string{"/web-rollback"}, Image: "web:0.9", State: "exited"},
+		}},
+		state: prometheus.NewDesc("dokkup_container_state", "State of a container, the value is always 1.", []string{"container", "image", "state"}, nil),
+	}
+
+	expected := `
+# HELP dokkup_container_state State of a container, the value is always 1.
+# TYPE dokkup_container_state gauge
+dokkup_container_state{container="web",image="web:1.0",state="running"} 1
+dokkup_container_state{container="web-rollback",image="web:0.9",state="exited"} 1
+`
+
+	err := testutil.CollectAndCompare(collector, strings.NewReader(expected))
+	assert.Nil(t, err)
+}
@@ -0,0 +1,181 @@
+// Package ratelimit implements keyed token bucket rate limiting and
+// exponential lockouts used for protecting the API from brute-force attempts.
+package ratelimit
+
+import (
+	"golang.org/x/time/rate"
+	"math"
+	"sync"
+	"time"
+)
+
+// idleTimeout is how long an entry can go unused before it's removed.
+const idleTimeout = 10 * time.Minute
+
+type bucket struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+// Limiter keeps a separate token bucket for every key (e.g. a client IP or an API key name).
+type Limiter struct {
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	limit     rate.Limit
+	burst     int
+	lastSweep time.Time
+	now       func() time.Time
+}
+
+// NewLimiter returns a Limiter which allows requestsPerMinute requests per key,
+// with bursts of up to burst requests. A limiter with requestsPerMinute <= 0 allows everything.
+func NewLimiter(requestsPerMinute, burst int) *Limiter {
+	limit := rate.Inf
+	if requestsPerMinute > 0 {
+		limit = rate.Limit(float64(requestsPerMinute) / 60)
+	}
+
+	if burst <= 0 {
+		burst = 1
+	}
+
+	return &Limiter{buckets: make(map[string]*bucket), limit: limit, burst: burst, now: time.Now}
+}
+
+// Allow consumes a token from key's bucket. If the bucket is empty, it returns false
+// and how long the caller has to wait before a token becomes available.
+func (l *Limiter) Allow(key string) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	l.sweep(now)
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{limiter: rate.NewLimiter(l.limit, l.burst)}
+		l.buckets[key] = b
+	}
+	b.lastSeen = now
+
+	reservation := b.limiter.ReserveN(now, 1)
+	if delay := reservation.DelayFrom(now); delay > 0 {
+		reservation.CancelAt(now)
+		return false, delay
+	}
+
+	return true, 0
+}
+
+func (l *Limiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < idleTimeout {
+		return
+	}
+
+	for key, b := range l.buckets {
+		if now.Sub(b.lastSeen) > idleTimeout {
+			delete(l.buckets, key)
+		}
+	}
+
+	l.lastSweep = now
+}
+
+type failures struct {
+	count       int
+	lockedUntil time.Time
+	lastSeen    time.Time
+}
+
+// Lockout tracks consecutive failed authentication attempts per key and locks
+// the key out for an exponentially growing duration once threshold is reached.
+type Lockout struct {
+	mu        sync.Mutex
+	failures  map[string]*failures
+	threshold int
+	base      time.Duration
+	max       time.Duration
+	lastSweep time.Time
+	now       func() time.Time
+}
+
+// NewLockout returns a Lockout which starts locking keys out after threshold
+// consecutive failures. The first lockout lasts base, and every further failure doubles
+// it, up to max. A threshold <= 0 disables lockouts.
+func NewLockout(threshold int, base, max time.Duration) *Lockout {
+	return &Lockout{failures: make(map[string]*failures), threshold: threshold, base: base, max: max, now: time.Now}
+}
+
+// LockedFor returns how long key remains locked out. It returns 0 if the key isn't locked out.
+func (l *Lockout) LockedFor(key string) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	f, ok := l.failures[key]
+	if !ok {
+		return 0
+	}
+
+	if remaining := f.lockedUntil.Sub(l.now()); remaining > 0 {
+		return remaining
+	}
+
+	return 0
+}
+
+// Fail records a failed attempt and returns the lockout duration it caused, if any.
+func (l *Lockout) Fail(key string) time.Duration {
+	if l.threshold <= 0 {
+		return 0
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	l.sweep(now)
+
+	f, ok := l.failures[key]
+	if !ok {
+		f = &failures{}
+		l.failures[key] = f
+	}
+
+	f.count++
+	f.lastSeen = now
+
+	if f.count < l.threshold {
+		return 0
+	}
+
+	duration := time.Duration(float64(l.base) * math.Pow(2, float64(f.count-l.threshold)))
+	if duration > l.max || duration <= 0 {
+		duration = l.max
+	}
+
+	f.lockedUntil = now.Add(duration)
+
+	return duration
+}
+
+// Succeed resets the failure count of key.
+func (l *Lockout) Succeed(key string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	delete(l.failures, key)
+}
+
+func (l *Lockout) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < idleTimeout {
+		return
+	}
+
+	for key, f := range l.failures {
+		if now.After(f.lockedUntil) && now.Sub(f.lastSeen) > l.max+idleTimeout {
+			delete(l.failures, key)
+		}
+	}
+
+	l.lastSweep = now
+}
@@ -0,0 +1,93 @@
+package ratelimit
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+type fakeClock struct {
+	now time.Time
+}
+
+func (f *fakeClock) Now() time.Time {
+	return f.now
+}
+
+func TestLimiter(t *testing.T) {
+	clock := &fakeClock{now: time.Now()}
+
+	limiter := NewLimiter(60, 2)
+	limiter.now = clock.Now
+
+	t.Run("Allows bursts", func(t *testing.T) {
+		ok, _ := limiter.Allow("client")
+		assert.True(t, ok)
+
+		ok, _ = limiter.Allow("client")
+		assert.True(t, ok)
+	})
+
+	t.Run("Rejects requests over the limit", func(t *testing.T) {
+		ok, retryAfter := limiter.Allow("client")
+		assert.False(t, ok)
+
+		assert.Equal(t, time.Second, retryAfter)
+	})
+
+	t.Run("Keys have separate buckets", func(t *testing.T) {
+		ok, _ := limiter.Allow("another client")
+		assert.True(t, ok)
+	})
+
+	t.Run("Refills over time", func(t *testing.T) {
+		clock.now = clock.now.Add(time.Second)
+
+		ok, _ := limiter.Allow("client")
+		assert.True(t, ok)
+	})
+
+	t.Run("Disabled limit", func(t *testing.T) {
+		unlimited := NewLimiter(0, 0)
+
+		for i := 0; i < 100; i++ {
+			ok, _ := unlimited.Allow("client")
+			assert.True(t, ok)
+		}
+	})
+}
+
+func TestLockout(t *testing.T) {
+	clock := &fakeClock{now: time.Now()}
+
+	lockout := NewLockout(3, 10*time.Second, 30*time.Second)
+	lockout.now = clock.Now
+
+	t.Run("Below threshold", func(t *testing.T) {
+		assert.Equal(t, time.Duration(0), lockout.Fail("client"))
+		assert.Equal(t, time.Duration(0), lockout.Fail("client"))
+
+		assert.Equal(t, time.Duration(0), lockout.LockedFor("client"))
+	})
+
+	t.Run("Lockout grows exponentially", func(t *testing.T) {
+		assert.Equal(t, 10*time.Second, lockout.Fail("client"))
+		assert.Equal(t, 10*time.Second, lockout.LockedFor("client"))
+
+		assert.Equal(t, 20*time.Second, lockout.Fail("client"))
+		assert.Equal(t, 30*time.Second, lockout.Fail("client"))
+		assert.Equal(t, 30*time.Second, lockout.Fail("client"))
+	})
+
+	t.Run("Lockout expires", func(t *testing.T) {
+		clock.now = clock.now.Add(31 * time.Second)
+
+		assert.Equal(t, time.Duration(0), lockout.LockedFor("client"))
+	})
+
+	t.Run("Success resets failures", func(t *testing.T) {
+		lockout.Succeed("client")
+
+		assert.Equal(t, time.Duration(0), lockout.Fail("client"))
+	})
+}
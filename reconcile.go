@@ -0,0 +1,31 @@
+package main
+
+import (
+	"context"
+	"github.com/XiovV/dokkup-agent/config"
+	"github.com/XiovV/dokkup-agent/controller"
+	"github.com/XiovV/dokkup-agent/manifest"
+	"github.com/sirupsen/logrus"
+)
+
+// startReconciler starts converging the containers to the manifests in cfg.ManifestDir. The manifests are
+// reconciled whenever a file in the directory changes, and every cfg.Interval() to catch drift.
+// The containers it creates, updates and rolls back are reported to record.
+// Cancelling ctx aborts the pass in progress, see controller.Reconciler.Run.
+func startReconciler(ctx context.Context, containerController controller.ContainerController, cfg *config.ReconcileConfig, record controller.OperationRecorder, logger logrus.FieldLogger) *controller.Reconciler {
+	log := logger.WithField("manifest_dir", cfg.ManifestDir)
+
+	reconciler := controller.NewReconciler(containerController, func() ([]controller.Manifest, error) {
+		return manifest.LoadDir(cfg.ManifestDir)
+	}, log)
+	reconciler.RecordOperations(record)
+
+	if err := config.WatchDir(ctx, cfg.ManifestDir, reconciler.Trigger); err != nil {
+		log.WithError(err).Warn("couldn't watch the manifest directory, it's only reconciled periodically")
+	}
+
+	go reconciler.Run(ctx, cfg.Interval())
+	log.WithField("interval", cfg.Interval().String()).Info("reconciling containers to their manifests")
+
+	return reconciler
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"github.com/XiovV/dokkup-agent/app"
+	"github.com/XiovV/dokkup-agent/config"
+	"github.com/sirupsen/logrus"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// reloadConfig reloads the config file whenever it changes or the agent receives SIGHUP, and swaps
+// the new config into agent. Invalid configs are rejected, and the current config stays in effect.
+func reloadConfig(ctx context.Context, agent *app.App, filename string, current *config.Config, logger logrus.FieldLogger) {
+	reloads := make(chan string, 1)
+	request := func(reason string) {
+		select {
+		case reloads <- reason:
+		default:
+		}
+	}
+
+	hangups := make(chan os.Signal, 1)
+	signal.Notify(hangups, syscall.SIGHUP)
+
+	if _, err := os.Stat(filename); err == nil {
+		if err := config.Watch(ctx, filename, func() { request("file changed") }); err != nil {
+			logger.WithError(err).Warn("couldn't watch the config file, it's only reloaded on SIGHUP")
+		}
+	}
+
+	for {
+		select {
+		case <-hangups:
+			request("SIGHUP")
+		case reason := <-reloads:
+			log := logger.WithField("reason", reason).WithField("file", filename)
+
+			next, err := config.Load(filename)
+			if err != nil {
+				log.WithError(err).Error("rejected the new config, keeping the current one")
+				continue
+			}
+
+			if err := agent.Reload(next); err != nil {
+				log.WithError(err).Error("rejected the new config, keeping the current one")
+				continue
+			}
+
+			if settings := current.RestartRequired(next); len(settings) > 0 {
+				log.WithField("settings", settings).Warn("some changed settings only take effect after a restart")
+			}
+
+			current = next
+			log.Info("reloaded config")
+		case <-ctx.Done():
+			signal.Stop(hangups)
+			return
+		}
+	}
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"github.com/XiovV/dokkup-agent/app"
+	"github.com/XiovV/dokkup-agent/config"
+	"github.com/XiovV/dokkup-agent/controller"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+	"net/http"
+	"time"
+)
+
+// shutdown stops both APIs from accepting requests and the reconciler from starting new passes, and waits
+// up to timeouts.ShutdownSeconds for the operations and the reconciliation pass in progress to finish.
+// Afterwards, the requests and the pass which are still in progress are aborted through abort, which makes
+// interrupted updates restore the old container, and the restores are given timeouts.RestoreSeconds to
+// finish. grpcServer and reconciler may be nil if the gRPC API or reconciliation aren't enabled.
+func shutdown(agent *app.App, server *http.Server, grpcServer *grpc.Server, reconciler *controller.Reconciler, abort context.CancelFunc, timeouts *config.TimeoutConfig, logger logrus.FieldLogger) {
+	ctx, cancel := withTimeout(context.Background(), time.Duration(timeouts.ShutdownSeconds)*time.Second)
+	defer cancel()
+
+	// followed logs and events would otherwise keep their connections open until the deadline
+	agent.Stop()
+
+	var reconciled <-chan struct{}
+	if reconciler != nil {
+		reconciler.Stop()
+		reconciled = reconciler.Done()
+	} else {
+		done := make(chan struct{})
+		close(done)
+		reconciled = done
+	}
+
+	grpcStopped := make(chan struct{})
+	go func() {
+		if grpcServer != nil {
+			grpcServer.GracefulStop()
+		}
+		close(grpcStopped)
+	}()
+
+	if err := server.Shutdown(ctx); err != nil && !errors.Is(err, context.DeadlineExceeded) {
+		logger.WithError(err).Warn("couldn't shut down the REST API")
+	}
+
+	running := agent.WaitForOperations(ctx)
+
+	select {
+	case <-grpcStopped:
+	case <-ctx.Done():
+	}
+
+	reconciling := false
+	select {
+	case <-reconciled:
+	case <-ctx.Done():
+		reconciling = true
+		logger.Warn("aborting the reconciliation pass which didn't finish before the shutdown deadline")
+	}
+
+	for _, operation := range running {
+		logger.WithFields(logrus.Fields{
+			"operation_id":   operation.ID,
+			"action":         operation.Action,
+			"target":         operation.Target,
+			"running_for_ms": time.Since(operation.Start).Milliseconds(),
+		}).Warn("aborting operation which didn't finish before the shutdown deadline")
+	}
+
+	abort()
+	if grpcServer != nil {
+		grpcServer.Stop()
+	}
+
+	if len(running) == 0 && !reconciling {
+		logger.Info("agent stopped")
+		return
+	}
+
+	restoreCtx, cancelRestore := withTimeout(context.Background(), time.Duration(timeouts.RestoreSeconds)*time.Second)
+	defer cancelRestore()
+
+	select {
+	case <-reconciled:
+	case <-restoreCtx.Done():
+		logger.Error("the reconciliation pass was still running when the agent stopped")
+	}
+
+	unfinished := agent.WaitForOperations(restoreCtx)
+	for _, operation := range unfinished {
+		logger.WithFields(logrus.Fields{
+			"operation_id": operation.ID,
+			"action":       operation.Action,
+			"target":       operation.Target,
+		}).Error("operation was still running when the agent stopped")
+	}
+
+	logger.WithField("aborted", len(running)).Warn("agent stopped after aborting operations")
+}
+
+// withTimeout is like context.WithTimeout, but a timeout of 0 disables the limit.
+func withTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+
+	return context.WithTimeout(ctx, timeout)
+}
@@ -0,0 +1,45 @@
+package tracing
+
+import (
+	"context"
+	"github.com/XiovV/dokkup-agent/config"
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSetupFileExporter(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "traces.json")
+
+	shutdown, err := Setup(context.Background(), &config.TracingConfig{Exporter: ExporterFile, File: filename})
+	assert.Nil(t, err)
+
+	_, span := otel.Tracer("test").Start(context.Background(), "test-span")
+	span.End()
+
+	assert.Nil(t, shutdown(context.Background()))
+
+	data, err := ioutil.ReadFile(filename)
+	assert.Nil(t, err)
+
+	assert.True(t, strings.Contains(string(data), "test-span"))
+	assert.True(t, strings.Contains(string(data), defaultServiceName))
+}
+
+func TestSetupInvalidExporter(t *testing.T) {
+	_, err := Setup(context.Background(), &config.TracingConfig{Exporter: "zipkin"})
+	assert.NotNil(t, err)
+
+	_, err = Setup(context.Background(), &config.TracingConfig{Exporter: ExporterFile})
+	assert.NotNil(t, err)
+}
+
+func TestSetupDisabled(t *testing.T) {
+	shutdown, err := Setup(context.Background(), nil)
+	assert.Nil(t, err)
+
+	assert.Nil(t, shutdown(context.Background()))
+}
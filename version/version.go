@@ -0,0 +1,9 @@
+// Package version holds the agent's build information. The values are set at build time:
+//
+//	go build -ldflags "-X github.com/XiovV/dokkup-agent/version.Version=v1.2.0 -X github.com/XiovV/dokkup-agent/version.Commit=$(git rev-parse HEAD)"
+package version
+
+var (
+	Version = "dev"
+	Commit  = "unknown"
+)